@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AppendOptions controls Append.
+type AppendOptions struct {
+	// Platform selects the manifest of multi-platform images, linux/amd64
+	// when empty.
+	Platform Platform
+	// Prefix is the directory of the image the content is added to, the
+	// root when empty.
+	Prefix string
+	// Comment is recorded in the history entry of the new layer.
+	Comment string
+}
+
+// Append adds the content of source, a local directory or tar archive
+// optionally compressed with gzip or zstd, as a new layer on top of the
+// image repo:ref and pushes the result under tag. Ownership is reset to
+// root. For multi-platform images only the selected manifest is extended
+// and tag then points at a single-platform image.
+func (c *Client) Append(ctx context.Context, repo, ref, tag, source string, opts AppendOptions) (Descriptor, error) {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	img, err := c.loadImage(ctx, repo, ref, opts.Platform)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	prefix := strings.TrimPrefix(path.Clean("/"+opts.Prefix), "/")
+	l, err := newLayerFile(img.layerMediaType(), func(tw *tar.Writer) error {
+		if fi.IsDir() {
+			return writeDir(tw, source, prefix)
+		}
+		return writeArchive(tw, source, prefix)
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer l.remove()
+	if err := c.pushLayer(ctx, img.name, l); err != nil {
+		return Descriptor{}, err
+	}
+	c.Info("append layer.", "repo", img.name, "ref", ref, "digest", l.desc.Digest, "size", l.desc.Size)
+
+	if len(img.history) > 0 {
+		now := time.Now().UTC()
+		img.history = append(img.history, history{Created: &now, CreatedBy: "registry append " + filepath.Base(source), Comment: opts.Comment})
+	}
+	img.manifest.Layers = append(img.manifest.Layers, l.desc)
+	img.diffIDs = append(img.diffIDs, l.diffID)
+	return c.pushImage(ctx, img, tag)
+}
+
+// writeDir adds the files below dir to tw, under prefix.
+func writeDir(tw *tar.Writer, dir, prefix string) error {
+	return filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		name := path.Join(prefix, filepath.ToSlash(rel))
+		if name == "." || name == "" {
+			return nil
+		}
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		resetOwner(hdr)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// writeArchive copies the entries of the tar archive file to tw, under
+// prefix.
+func writeArchive(tw *tar.Writer, file, prefix string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := Decompress("", f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		hdr.Name = path.Join(prefix, strings.TrimPrefix(path.Clean("/"+hdr.Name), "/"))
+		if hdr.Name == "." {
+			continue
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Name += "/"
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = path.Join(prefix, strings.TrimPrefix(path.Clean("/"+hdr.Linkname), "/"))
+		}
+		resetOwner(hdr)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// resetOwner makes hdr owned by root.
+func resetOwner(hdr *tar.Header) {
+	hdr.Uid, hdr.Gid = 0, 0
+	hdr.Uname, hdr.Gname = "", ""
+}
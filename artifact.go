@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// The empty config of artifacts without one, as recommended by OCI 1.1.
+const (
+	MediaTypeOCIEmpty = "application/vnd.oci.empty.v1+json"
+	emptyJSON         = "{}"
+)
+
+// Artifact is an OCI artifact pushed by PushArtifact, such as a report or
+// custom metadata attached to an image.
+type Artifact struct {
+	// ArtifactType is the type of the artifact, such as
+	// application/vnd.example.report.v1+json.
+	ArtifactType string
+	// Config is the config blob, of ConfigMediaType. The empty descriptor is
+	// used when it is nil.
+	Config          []byte
+	ConfigMediaType string
+	Layers          []ArtifactLayer
+	Annotations     map[string]string
+	// Subject is the manifest the artifact refers to. Only its digest is
+	// needed; the media type and size are looked up when unset.
+	Subject *Descriptor
+	// Tag tags the artifact, which is pushed by digest when empty.
+	Tag string
+}
+
+// ArtifactLayer is a blob of an artifact.
+type ArtifactLayer struct {
+	MediaType   string
+	Data        []byte
+	Annotations map[string]string
+}
+
+// PushArtifact pushes the blobs and manifest of the artifact a to repo and
+// returns the descriptor of its manifest. When a has a subject and the
+// registry does not process subject fields, the artifact is added to the
+// referrers tag schema index of the subject instead, so Referrers finds it
+// either way.
+func (c *Client) PushArtifact(ctx context.Context, repo string, a Artifact) (Descriptor, error) {
+	name := c.repoName(repo)
+	if a.Tag != "" {
+		if err := ValidateTag(a.Tag); err != nil {
+			return Descriptor{}, err
+		}
+	}
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		ArtifactType:  a.ArtifactType,
+		Layers:        []Descriptor{},
+		Annotations:   a.Annotations,
+	}
+	config, configType := a.Config, a.ConfigMediaType
+	if config == nil {
+		config, configType = []byte(emptyJSON), MediaTypeOCIEmpty
+	}
+	var err error
+	if manifest.Config, err = c.pushArtifactBlob(ctx, name, configType, config, nil); err != nil {
+		return Descriptor{}, errors.Wrap(err, "push config")
+	}
+	for _, layer := range a.Layers {
+		desc, err := c.pushArtifactBlob(ctx, name, layer.MediaType, layer.Data, layer.Annotations)
+		if err != nil {
+			return Descriptor{}, errors.Wrap(err, "push layer")
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+	if a.Subject != nil {
+		subject := *a.Subject
+		if subject.MediaType == "" || subject.Size == 0 {
+			resolved, err := c.resolve(ctx, name, subject.Digest)
+			if err != nil {
+				return Descriptor{}, errors.Wrap(err, "resolve subject")
+			}
+			subject.MediaType, subject.Size = resolved.MediaType, resolved.Size
+		}
+		manifest.Subject = &Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size}
+	}
+
+	body, err := marshalJSON(manifest)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	desc := Descriptor{
+		MediaType:    MediaTypeOCIManifest,
+		ArtifactType: a.ArtifactType,
+		Digest:       fmt.Sprintf("sha256:%x", sha256.Sum256(body)),
+		Size:         int64(len(body)),
+		Annotations:  a.Annotations,
+	}
+	ref := a.Tag
+	if ref == "" {
+		ref = desc.Digest
+	}
+	processed, err := c.putManifest(ctx, name, ref, desc.MediaType, body)
+	if err != nil {
+		return Descriptor{}, errors.Wrap(err, "push manifest")
+	}
+	if manifest.Subject != nil && !processed {
+		// The registry lacks the referrers API, maintain the referrers tag
+		// schema instead.
+		if err := c.addReferrerTag(ctx, name, manifest.Subject.Digest, desc); err != nil {
+			return Descriptor{}, errors.Wrap(err, "update referrers tag")
+		}
+	}
+	c.Info("push artifact.", "repo", repo, "ref", ref, "digest", desc.Digest, "type", a.ArtifactType)
+	return desc, nil
+}
+
+// pushArtifactBlob uploads b unless repository name already has it.
+func (c *Client) pushArtifactBlob(ctx context.Context, name, mediaType string, b []byte, annotations map[string]string) (Descriptor, error) {
+	desc := Descriptor{MediaType: mediaType, Digest: fmt.Sprintf("sha256:%x", sha256.Sum256(b)), Size: int64(len(b)), Annotations: annotations}
+	return desc, c.pushBlob(ctx, name, desc, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}, "")
+}
@@ -0,0 +1,432 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	mediaTypeInToto = "application/vnd.in-toto+json"
+
+	PredicateTypeSLSAProvenance02 = "https://slsa.dev/provenance/v0.2"
+	PredicateTypeSLSAProvenance1  = "https://slsa.dev/provenance/v1"
+	PredicateTypeVuln             = "https://cosign.sigstore.dev/attestation/vuln/v1"
+
+	predicateTypeAnnotation       = "predicateType"
+	inTotoPredicateTypeAnnotation = "in-toto.io/predicate-type"
+)
+
+// Attestation is an in-toto statement attached to an image, such as SLSA
+// provenance or vulnerability scan results.
+type Attestation struct {
+	// Digest is the digest of the manifest the attestation is about.
+	Digest string
+	// Manifest is the digest of the manifest carrying the attestation.
+	Manifest      string
+	PredicateType string
+	Subjects      []AttestationSubject
+	// Predicate is the predicate as JSON.
+	Predicate []byte
+	// Envelope is the DSSE envelope of signed attestations, nil for bare
+	// statements.
+	Envelope *DSSEEnvelope
+	// Annotations are the annotations of the layer holding the attestation,
+	// such as the signing certificate of keyless attestations.
+	Annotations map[string]string
+}
+
+// AttestationSubject is an artifact an in-toto statement applies to.
+type AttestationSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// DSSEEnvelope is a DSSE envelope holding a signed in-toto statement.
+type DSSEEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     []byte          `json:"payload"`
+	Signatures  []DSSESignature `json:"signatures"`
+}
+
+// DSSESignature is a signature of a DSSE envelope.
+type DSSESignature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// Attestations returns the attestations attached to the image repo:ref as
+// cosign ".att" tags, as referrers, and for indexes built by BuildKit as
+// attestation manifests of the index. With predicate types, only the
+// attestations of these types are returned.
+func (c *Client) Attestations(ctx context.Context, repo, ref string, predicateTypes ...string) ([]Attestation, error) {
+	name := c.repoName(repo)
+	body, desc, err := c.getManifest(ctx, name, ref)
+	if err != nil {
+		return nil, err
+	}
+	type source struct{ digest, manifest string }
+	var sources []source
+	if isIndex(desc.MediaType) {
+		var index Index
+		if err := unmarshalJSON(body, &index); err != nil {
+			return nil, err
+		}
+		for _, m := range index.Manifests {
+			if m.Annotations["vnd.docker.reference.type"] == "attestation-manifest" {
+				sources = append(sources, source{m.Annotations["vnd.docker.reference.digest"], m.Digest})
+			}
+		}
+	}
+	referrers, err := c.Referrers(ctx, repo, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	for _, referrer := range referrers {
+		if strings.Contains(referrer.ArtifactType, "in-toto") || referrer.ArtifactType == mediaTypeDSSEEnvelope {
+			sources = append(sources, source{desc.Digest, referrer.Digest})
+		}
+	}
+	sources = append(sources, source{desc.Digest, strings.Replace(desc.Digest, ":", "-", 1) + ".att"})
+
+	var attestations []Attestation
+	for _, s := range sources {
+		body, mdesc, err := c.getManifest(ctx, name, s.manifest)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var manifest Manifest
+		if err := unmarshalJSON(body, &manifest); err != nil {
+			return nil, err
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != mediaTypeDSSEEnvelope && layer.MediaType != mediaTypeInToto {
+				continue
+			}
+			predicateType := layer.Annotations[predicateTypeAnnotation]
+			if predicateType == "" {
+				predicateType = layer.Annotations[inTotoPredicateTypeAnnotation]
+			}
+			if predicateType != "" && len(predicateTypes) > 0 && !contains(predicateTypes, predicateType) {
+				continue
+			}
+			r, _, err := c.openBlob(ctx, name, layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+			b, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+			a, err := ParseAttestation(layer.MediaType, b)
+			if err != nil {
+				return nil, fmt.Errorf("%s@%s: %v", repo, layer.Digest, err)
+			}
+			if len(predicateTypes) > 0 && !contains(predicateTypes, a.PredicateType) {
+				continue
+			}
+			a.Digest, a.Manifest, a.Annotations = s.digest, mdesc.Digest, layer.Annotations
+			attestations = append(attestations, *a)
+		}
+	}
+	return attestations, nil
+}
+
+// ParseAttestation parses an in-toto statement, signed in a DSSE envelope
+// when mediaType is application/vnd.dsse.envelope.v1+json.
+func ParseAttestation(mediaType string, b []byte) (*Attestation, error) {
+	a := &Attestation{}
+	if mediaType == mediaTypeDSSEEnvelope {
+		a.Envelope = &DSSEEnvelope{}
+		if err := unmarshalJSON(b, a.Envelope); err != nil {
+			return nil, err
+		}
+		b = a.Envelope.Payload
+	}
+	var statement struct {
+		PredicateType string               `json:"predicateType"`
+		Subject       []AttestationSubject `json:"subject"`
+		Predicate     json.RawMessage      `json:"predicate"`
+	}
+	if err := unmarshalJSON(b, &statement); err != nil {
+		return nil, err
+	}
+	a.PredicateType, a.Subjects, a.Predicate = statement.PredicateType, statement.Subject, statement.Predicate
+	return a, nil
+}
+
+// Verify checks that one of the signatures of the DSSE envelope of a is
+// accepted by verifier.
+func (a *Attestation) Verify(verifier SignatureVerifier) error {
+	if a.Envelope == nil || len(a.Envelope.Signatures) == 0 {
+		return errors.New("unsigned attestation")
+	}
+	// The signatures cover the pre-authentication encoding of the payload.
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(a.Envelope.PayloadType), a.Envelope.PayloadType, len(a.Envelope.Payload), a.Envelope.Payload)
+	var err error
+	for _, sig := range a.Envelope.Signatures {
+		if err = verifier.Verify([]byte(pae), sig.Sig, a.Annotations); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// SLSAProvenance is the SLSA provenance of an image, reduced to the fields
+// common to versions 0.2 and 1.
+type SLSAProvenance struct {
+	BuilderID string
+	BuildType string
+	// Source is the URI of the build configuration, like the repository and
+	// workflow of a CI run.
+	Source    string
+	Materials []SLSAMaterial
+	// StartedOn and FinishedOn are zero when the provenance omits them.
+	StartedOn  time.Time
+	FinishedOn time.Time
+}
+
+// SLSAMaterial is an artifact a build used, such as the source repository.
+type SLSAMaterial struct {
+	URI    string
+	Digest map[string]string
+}
+
+// SLSAProvenance parses the predicate of a SLSA provenance attestation.
+func (a *Attestation) SLSAProvenance() (*SLSAProvenance, error) {
+	p := &SLSAProvenance{}
+	switch a.PredicateType {
+	case PredicateTypeSLSAProvenance02:
+		var predicate struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+			BuildType  string `json:"buildType"`
+			Invocation struct {
+				ConfigSource struct {
+					URI        string `json:"uri"`
+					EntryPoint string `json:"entryPoint"`
+				} `json:"configSource"`
+			} `json:"invocation"`
+			Metadata struct {
+				BuildStartedOn  time.Time `json:"buildStartedOn"`
+				BuildFinishedOn time.Time `json:"buildFinishedOn"`
+			} `json:"metadata"`
+			Materials []struct {
+				URI    string            `json:"uri"`
+				Digest map[string]string `json:"digest"`
+			} `json:"materials"`
+		}
+		if err := unmarshalJSON(a.Predicate, &predicate); err != nil {
+			return nil, err
+		}
+		p.BuilderID, p.BuildType = predicate.Builder.ID, predicate.BuildType
+		p.Source = predicate.Invocation.ConfigSource.URI
+		if entryPoint := predicate.Invocation.ConfigSource.EntryPoint; entryPoint != "" && p.Source != "" {
+			p.Source += "#" + entryPoint
+		}
+		p.StartedOn, p.FinishedOn = predicate.Metadata.BuildStartedOn, predicate.Metadata.BuildFinishedOn
+		for _, m := range predicate.Materials {
+			p.Materials = append(p.Materials, SLSAMaterial{URI: m.URI, Digest: m.Digest})
+		}
+	case PredicateTypeSLSAProvenance1:
+		var predicate struct {
+			BuildDefinition struct {
+				BuildType          string          `json:"buildType"`
+				ExternalParameters json.RawMessage `json:"externalParameters"`
+				ResolvedDeps       []struct {
+					URI    string            `json:"uri"`
+					Digest map[string]string `json:"digest"`
+				} `json:"resolvedDependencies"`
+			} `json:"buildDefinition"`
+			RunDetails struct {
+				Builder struct {
+					ID string `json:"id"`
+				} `json:"builder"`
+				Metadata struct {
+					StartedOn  time.Time `json:"startedOn"`
+					FinishedOn time.Time `json:"finishedOn"`
+				} `json:"metadata"`
+			} `json:"runDetails"`
+		}
+		if err := unmarshalJSON(a.Predicate, &predicate); err != nil {
+			return nil, err
+		}
+		p.BuilderID, p.BuildType = predicate.RunDetails.Builder.ID, predicate.BuildDefinition.BuildType
+		// The external parameters depend on the build type, GitHub Actions
+		// name the workflow repository and path.
+		var params struct {
+			Workflow struct {
+				Repository string `json:"repository"`
+				Path       string `json:"path"`
+			} `json:"workflow"`
+			Source json.RawMessage `json:"source"`
+		}
+		if b := predicate.BuildDefinition.ExternalParameters; len(b) > 0 {
+			if err := unmarshalJSON(b, &params); err != nil {
+				return nil, fmt.Errorf("invalid external parameters: %v", err)
+			}
+		}
+		var source string
+		if len(params.Source) > 0 {
+			// Other build types may describe their source with an object.
+			unmarshalJSON(params.Source, &source)
+		}
+		if repository := params.Workflow.Repository; repository != "" {
+			p.Source = repository
+			if path := params.Workflow.Path; path != "" {
+				p.Source += "#" + path
+			}
+		} else if source != "" {
+			p.Source = source
+		}
+		p.StartedOn, p.FinishedOn = predicate.RunDetails.Metadata.StartedOn, predicate.RunDetails.Metadata.FinishedOn
+		for _, d := range predicate.BuildDefinition.ResolvedDeps {
+			p.Materials = append(p.Materials, SLSAMaterial{URI: d.URI, Digest: d.Digest})
+		}
+	default:
+		return nil, fmt.Errorf("not a SLSA provenance: %s", a.PredicateType)
+	}
+	return p, nil
+}
+
+// VulnerabilityReport is the predicate of a cosign vulnerability attestation.
+type VulnerabilityReport struct {
+	Scanner         string
+	ScannerVersion  string
+	FinishedOn      time.Time
+	Vulnerabilities []Vulnerability
+}
+
+// Vulnerability is a vulnerability found in a package of an image.
+type Vulnerability struct {
+	ID           string
+	Package      string
+	Version      string
+	FixedVersion string
+	Severity     Severity
+}
+
+// Highest returns the highest severity of the vulnerabilities of r.
+func (r *VulnerabilityReport) Highest() Severity {
+	var highest Severity
+	for _, v := range r.Vulnerabilities {
+		if v.Severity > highest {
+			highest = v.Severity
+		}
+	}
+	return highest
+}
+
+// Vulnerabilities parses the predicate of a cosign vulnerability
+// attestation. The vulnerabilities are read from Trivy and Grype results;
+// those of other scanners are left empty.
+func (a *Attestation) Vulnerabilities() (*VulnerabilityReport, error) {
+	if a.PredicateType != PredicateTypeVuln {
+		return nil, fmt.Errorf("not a vulnerability attestation: %s", a.PredicateType)
+	}
+	var predicate struct {
+		Scanner struct {
+			URI     string          `json:"uri"`
+			Version string          `json:"version"`
+			Result  json.RawMessage `json:"result"`
+		} `json:"scanner"`
+		Metadata struct {
+			ScanFinishedOn time.Time `json:"scanFinishedOn"`
+		} `json:"metadata"`
+	}
+	if err := unmarshalJSON(a.Predicate, &predicate); err != nil {
+		return nil, err
+	}
+	r := &VulnerabilityReport{
+		Scanner:        predicate.Scanner.URI,
+		ScannerVersion: predicate.Scanner.Version,
+		FinishedOn:     predicate.Metadata.ScanFinishedOn,
+	}
+	var result struct {
+		// Trivy.
+		Results []struct {
+			Vulnerabilities []struct {
+				VulnerabilityID  string `json:"VulnerabilityID"`
+				PkgName          string `json:"PkgName"`
+				InstalledVersion string `json:"InstalledVersion"`
+				FixedVersion     string `json:"FixedVersion"`
+				Severity         string `json:"Severity"`
+			} `json:"Vulnerabilities"`
+		} `json:"Results"`
+		// Grype.
+		Matches []struct {
+			Vulnerability struct {
+				ID       string `json:"id"`
+				Severity string `json:"severity"`
+				Fix      struct {
+					Versions []string `json:"versions"`
+				} `json:"fix"`
+			} `json:"vulnerability"`
+			Artifact struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"artifact"`
+		} `json:"matches"`
+	}
+	if len(predicate.Scanner.Result) > 0 {
+		if err := unmarshalJSON(predicate.Scanner.Result, &result); err != nil {
+			return nil, err
+		}
+	}
+	for _, res := range result.Results {
+		for _, v := range res.Vulnerabilities {
+			r.Vulnerabilities = append(r.Vulnerabilities, Vulnerability{
+				ID:           v.VulnerabilityID,
+				Package:      v.PkgName,
+				Version:      v.InstalledVersion,
+				FixedVersion: v.FixedVersion,
+				Severity:     ParseSeverity(v.Severity),
+			})
+		}
+	}
+	for _, m := range result.Matches {
+		r.Vulnerabilities = append(r.Vulnerabilities, Vulnerability{
+			ID:           m.Vulnerability.ID,
+			Package:      m.Artifact.Name,
+			Version:      m.Artifact.Version,
+			FixedVersion: strings.Join(m.Vulnerability.Fix.Versions, ", "),
+			Severity:     ParseSeverity(m.Vulnerability.Severity),
+		})
+	}
+	return r, nil
+}
+
+// BuiltBy reports whether the image repo:ref has a SLSA provenance
+// attestation naming builderID, signed in a way verifier accepts. A nil
+// verifier accepts unsigned attestations too.
+func (c *Client) BuiltBy(ctx context.Context, repo, ref, builderID string, verifier SignatureVerifier) (bool, error) {
+	attestations, err := c.Attestations(ctx, repo, ref, PredicateTypeSLSAProvenance02, PredicateTypeSLSAProvenance1)
+	if err != nil {
+		return false, err
+	}
+	for i := range attestations {
+		a := &attestations[i]
+		p, err := a.SLSAProvenance()
+		if err != nil || p.BuilderID != builderID {
+			continue
+		}
+		if verifier != nil {
+			if err := a.Verify(verifier); err != nil {
+				c.Debug("reject attestation.", "repo", repo, "manifest", a.Manifest, "error", err)
+				continue
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
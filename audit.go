@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord describes a destructive operation, written as a JSON line to
+// the audit log.
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	Operation  string    `json:"operation"`
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"`
+	Digest     string    `json:"digest,omitempty"`
+	// Size is the size of the image, including its config and layers.
+	Size int64 `json:"size,omitempty"`
+	// Policy and Rules name the policy and the rules that selected the
+	// image, if it was deleted by Clean.
+	Policy  string   `json:"policy,omitempty"`
+	Rules   []string `json:"rules,omitempty"`
+	Outcome string   `json:"outcome"`
+	Error   string   `json:"error,omitempty"`
+	// RequestID is the correlation ID of the requests of the deletion.
+	RequestID string `json:"requestId,omitempty"`
+}
+
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (a *auditLog) write(record AuditRecord) error {
+	b, err := marshalJSON(record)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.w.Write(append(b, '\n'))
+	return err
+}
+
+// WithAuditLog writes an audit record to w for every deletion.
+func WithAuditLog(w io.Writer) Option {
+	return func(c *Client) {
+		c.audit = &auditLog{w: w}
+	}
+}
+
+// OpenAuditLog opens the audit log file path for appending, creating it if
+// needed.
+func OpenAuditLog(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
+
+// rules describes the rules of the policy selecting images for deletion.
+func (p Policy) rules() []string {
+	var rules []string
+	if p.OlderThan > 0 {
+		rules = append(rules, "olderThan="+p.OlderThan.String())
+	}
+	if len(p.Kinds) > 0 {
+		var kinds []string
+		for _, kind := range p.Kinds {
+			kinds = append(kinds, string(kind))
+		}
+		rules = append(rules, "kinds="+strings.Join(kinds, ","))
+	}
+	if p.ProtectSigned != nil {
+		rules = append(rules, "protectSigned")
+	}
+	if p.OnlyUnsigned {
+		rules = append(rules, "onlyUnsigned")
+	}
+	if p.Scanner != nil && p.MinSeverity > SeverityUnknown {
+		rules = append(rules, "minSeverity="+p.MinSeverity.String())
+	}
+	if p.UntaggedOnly {
+		rules = append(rules, "untaggedOnly")
+	}
+	if len(rules) == 0 {
+		rules = append(rules, "unprotected")
+	}
+	if p.Quarantine != "" {
+		rules = append(rules, "quarantine="+p.Quarantine)
+	}
+	return rules
+}
+
+// auditDelete records the deletion of the image digest through repo:tag by
+// policy, if any.
+func (c *Client) auditDelete(ctx context.Context, repo, tag, digest string, size int64, policy *Policy, err error) {
+	record := AuditRecord{
+		Time:       time.Now(),
+		Operation:  "delete",
+		Repository: repo,
+		Tag:        tag,
+		Digest:     digest,
+		Size:       size,
+		Outcome:    "deleted",
+		RequestID:  c.contextRequestID(ctx),
+	}
+	if policy != nil {
+		record.Policy = policy.Name
+		record.Rules = policy.rules()
+	}
+	if err != nil {
+		record.Outcome = "failed"
+		record.Error = err.Error()
+	}
+	if werr := c.audit.write(record); werr != nil {
+		c.Error("fail to write audit record.", "repo", repo, "tag", tag, "error", werr)
+	}
+}
+
+// auditTarget returns the digest and size of the image about to be deleted.
+func (c *Client) auditTarget(ctx context.Context, repo, tag string) (string, int64) {
+	info, err := c.inspect(ctx, c.repoName(repo), tag)
+	if err != nil {
+		c.Warn("fail to inspect image for audit.", "repo", repo, "tag", tag, "error", err)
+		return "", 0
+	}
+	return info.Digest, info.Size
+}
@@ -0,0 +1,226 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BackupStore stores the backups made before deletions and full backups,
+// under slash separated keys.
+type BackupStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	// List returns the keys starting with prefix.
+	List(prefix string) ([]string, error)
+}
+
+// StreamStore is implemented by backup stores able to keep content too
+// large to hold in memory, such as the blobs of full backups.
+type StreamStore interface {
+	BackupStore
+	PutStream(key string, r io.Reader, size int64) error
+	// Open returns ErrNotFound for missing keys.
+	Open(key string) (io.ReadCloser, error)
+	Exists(key string) (bool, error)
+}
+
+// putStream stores the content of r under key, buffering it when store is
+// no StreamStore.
+func putStream(store BackupStore, key string, r io.Reader, size int64) error {
+	if s, ok := store.(StreamStore); ok {
+		return s.PutStream(key, r, size)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return store.Put(key, b)
+}
+
+// openKey reads the content stored under key.
+func openKey(store BackupStore, key string) (io.ReadCloser, error) {
+	if s, ok := store.(StreamStore); ok {
+		return s.Open(key)
+	}
+	b, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// hasKey reports whether content is stored under key.
+func hasKey(store BackupStore, key string) (bool, error) {
+	if s, ok := store.(StreamStore); ok {
+		return s.Exists(key)
+	}
+	keys, err := store.List(key)
+	if err != nil {
+		return false, err
+	}
+	return contains(keys, key), nil
+}
+
+// DirStore is a StreamStore keeping backups as files below a directory.
+type DirStore string
+
+func (d DirStore) Put(key string, data []byte) error {
+	path := filepath.Join(string(d), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func (d DirStore) PutStream(key string, r io.Reader, size int64) error {
+	path := filepath.Join(string(d), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (d DirStore) Open(key string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(string(d), filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (d DirStore) Exists(key string) (bool, error) {
+	_, err := os.Stat(filepath.Join(string(d), filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d DirStore) Get(key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(string(d), filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+func (d DirStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(string(d), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == string(d) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(string(d), path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// BackupRecord describes a manifest backed up before its deletion.
+type BackupRecord struct {
+	Repository string    `json:"repository"`
+	Digest     string    `json:"digest"`
+	MediaType  string    `json:"mediaType"`
+	Tags       []string  `json:"tags"`
+	Time       time.Time `json:"time"`
+	// Config is the digest of the config blob, if it was backed up too.
+	Config string `json:"config,omitempty"`
+}
+
+// Backup file names, below the directory of a backed up manifest.
+const (
+	backupRecordFile   = "record.json"
+	backupManifestFile = "manifest"
+	backupConfigFile   = "config"
+)
+
+// backupKey returns the key of file in the backup of repo@digest.
+func backupKey(repo, digest, file string) string {
+	return repo + "/" + strings.Replace(digest, ":", "-", 1) + "/" + file
+}
+
+// WithBackup makes the client save every manifest to store before deleting
+// it, along with its config blob if configs is set, so it can be restored
+// with Restore while its layers still exist.
+func WithBackup(store BackupStore, configs bool) Option {
+	return func(c *Client) {
+		c.backup = store
+		c.backupConfigs = configs
+	}
+}
+
+// backupManifest saves the manifest ref of repo, known by tags, to the
+// backup store.
+func (c *Client) backupManifest(ctx context.Context, repo, ref string, tags []string) error {
+	name := c.repoName(repo)
+	body, desc, err := c.getManifest(ctx, name, ref)
+	if err != nil {
+		return err
+	}
+	record := BackupRecord{
+		Repository: repo,
+		Digest:     desc.Digest,
+		MediaType:  desc.MediaType,
+		Tags:       tags,
+		Time:       time.Now(),
+	}
+	if err := c.backup.Put(backupKey(repo, desc.Digest, backupManifestFile), body); err != nil {
+		return err
+	}
+	if c.backupConfigs && !isIndex(desc.MediaType) {
+		var manifest Manifest
+		if err := unmarshalJSON(body, &manifest); err != nil {
+			return err
+		}
+		if manifest.Config.Digest != "" {
+			config, err := c.getConfig(ctx, name, manifest.Config.Digest)
+			if err != nil {
+				return err
+			}
+			if err := c.backup.Put(backupKey(repo, desc.Digest, backupConfigFile), config); err != nil {
+				return err
+			}
+			record.Config = manifest.Config.Digest
+		}
+	}
+	b, err := marshalJSON(record)
+	if err != nil {
+		return err
+	}
+	c.Debug("back up manifest.", "repo", repo, "digest", desc.Digest)
+	return c.backup.Put(backupKey(repo, desc.Digest, backupRecordFile), b)
+}
@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlobCache remembers blobs known to exist in repositories, so copies of
+// images sharing layers check each blob only once. Copies use the one of
+// their options, and clients the one of their Cache. It is safe for
+// concurrent use.
+type BlobCache struct {
+	// maxAge is how long blobs are known to exist, forever when zero.
+	maxAge time.Duration
+
+	mu    sync.Mutex
+	blobs map[string]time.Time
+	dirty bool
+}
+
+// NewBlobCache returns an empty cache.
+func NewBlobCache() *BlobCache {
+	return &BlobCache{blobs: make(map[string]time.Time)}
+}
+
+// LoadBlobCache reads a cache saved with Save, dropping entries older than
+// maxAge since registries may garbage collect blobs in the meantime. A
+// missing file yields an empty cache.
+func LoadBlobCache(path string, maxAge time.Duration) (*BlobCache, error) {
+	cache := NewBlobCache()
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var blobs map[string]time.Time
+	if err := unmarshalJSON(b, &blobs); err != nil {
+		return nil, err
+	}
+	cache.maxAge = maxAge
+	for k, t := range blobs {
+		if maxAge <= 0 || time.Since(t) < maxAge {
+			cache.blobs[k] = t
+		}
+	}
+	return cache, nil
+}
+
+// Save writes the cache to path.
+func (b *BlobCache) Save(path string) error {
+	b.mu.Lock()
+	data, err := marshalJSON(b.blobs)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (b *BlobCache) has(key string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.blobs[key]
+	return ok && (b.maxAge <= 0 || time.Since(t) < b.maxAge)
+}
+
+func (b *BlobCache) add(key string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.blobs[key] = time.Now()
+	b.dirty = true
+}
+
+// forget drops the blobs whose key starts with prefix.
+func (b *BlobCache) forget(prefix string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key := range b.blobs {
+		if strings.HasPrefix(key, prefix) {
+			delete(b.blobs, key)
+			b.dirty = true
+		}
+	}
+}
+
+// snapshot returns a copy of the blobs and whether they changed since the
+// last snapshot.
+func (b *BlobCache) snapshot() (map[string]time.Time, bool) {
+	if b == nil {
+		return nil, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	blobs := make(map[string]time.Time, len(b.blobs))
+	for key, t := range b.blobs {
+		blobs[key] = t
+	}
+	changed := b.dirty
+	b.dirty = false
+	return blobs, changed
+}
+
+func blobKey(c *Client, name, digest string) string {
+	return c.host() + "/" + name + "@" + digest
+}
@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// maxResumes is how many times an interrupted blob download is resumed.
+const maxResumes = 3
+
+// OpenBlobRange downloads length bytes of the blob identified by digest,
+// starting at offset, or the rest of the blob when length is negative. The
+// caller must close the returned reader.
+func (c *Client) OpenBlobRange(ctx context.Context, repo, digest string, offset, length int64) (io.ReadCloser, error) {
+	return c.openBlobRange(ctx, c.repoName(repo), digest, offset, length)
+}
+
+func (c *Client) openBlobRange(ctx context.Context, name, digest string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return ioutil.NopCloser(&io.LimitedReader{}), nil
+	}
+	header := http.Header{}
+	if length < 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	} else {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	resp, err := c.send(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", name, digest), fmt.Sprintf("repository:%s:*", name), header, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The registry ignored the range, skip to it.
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, statusError(resp, b)
+	}
+	if length < 0 {
+		return resp.Body, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, length), resp.Body}, nil
+}
+
+// OpenBlob downloads the blob identified by digest, returning its size. If
+// the connection breaks, the download resumes where it stopped with a range
+// request. The caller must close the returned reader.
+func (c *Client) OpenBlob(ctx context.Context, repo, digest string) (io.ReadCloser, int64, error) {
+	name := c.repoName(repo)
+	r, size, err := c.openBlob(ctx, name, digest)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &resumingReader{c: c, ctx: ctx, name: name, digest: digest, size: size, r: r}, size, nil
+}
+
+type resumingReader struct {
+	c       *Client
+	ctx     context.Context
+	name    string
+	digest  string
+	size    int64
+	offset  int64
+	resumes int
+	r       io.ReadCloser
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.r.Read(p)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF && (r.size < 0 || r.offset >= r.size) {
+			return n, err
+		}
+		if n > 0 {
+			// Report the data, the error comes back on the next read.
+			return n, nil
+		}
+		if r.resumes >= maxResumes || r.ctx.Err() != nil || r.size >= 0 && r.offset >= r.size {
+			return 0, err
+		}
+		r.resumes++
+		r.c.Warn("resume blob download.", "digest", r.digest, "offset", r.offset, "error", err)
+		r.r.Close()
+		next, rerr := r.c.openBlobRange(r.ctx, r.name, r.digest, r.offset, -1)
+		if rerr != nil {
+			return 0, rerr
+		}
+		r.r = next
+	}
+}
+
+func (r *resumingReader) Close() error {
+	return r.r.Close()
+}
@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen is returned without contacting the registry while its
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerStats describes a circuit breaker for monitoring.
+type BreakerStats struct {
+	State BreakerState `json:"state"`
+	// Failures is the number of consecutive failures.
+	Failures int `json:"failures"`
+	// Trips is how many times the breaker has opened.
+	Trips    int       `json:"trips"`
+	OpenedAt time.Time `json:"openedAt,omitempty"`
+}
+
+// breaker fails requests fast after threshold consecutive failures, until
+// cooldown has passed. A single trial request is then let through, closing
+// the breaker again on success.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	trips     int
+	openedAt  time.Time
+	trial     bool
+}
+
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if b.trial || time.Since(b.openedAt) < b.cooldown {
+		return ErrCircuitOpen
+	}
+	b.trial = true
+	return nil
+}
+
+func (b *breaker) record(ok bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	trial := b.trial
+	b.trial = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold || trial {
+			b.trips++
+		}
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *breaker) stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := BreakerStats{State: BreakerClosed, Failures: b.failures, Trips: b.trips}
+	if b.failures >= b.threshold {
+		stats.State = BreakerOpen
+		stats.OpenedAt = b.openedAt
+		if b.trial || time.Since(b.openedAt) >= b.cooldown {
+			stats.State = BreakerHalfOpen
+		}
+	}
+	return stats
+}
+
+// WithCircuitBreaker makes the client fail fast with ErrCircuitOpen for
+// cooldown after threshold consecutive network errors or 5xx responses. The
+// registry and its token service have separate breakers.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.breakers = map[string]*breaker{
+			"registry": {threshold: threshold, cooldown: cooldown},
+			"auth":     {threshold: threshold, cooldown: cooldown},
+		}
+	}
+}
+
+// Breakers returns the state of the circuit breakers by endpoint, "registry"
+// and "auth". It is empty unless WithCircuitBreaker is used.
+func (c *Client) Breakers() map[string]BreakerStats {
+	stats := make(map[string]BreakerStats)
+	for endpoint, b := range c.breakers {
+		stats[endpoint] = b.stats()
+	}
+	return stats
+}
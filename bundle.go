@@ -0,0 +1,180 @@
+package registry
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// bundleWriter writes an OCI image layout to a tar stream. Content is
+// written as it is pushed, the index once all images are added.
+type bundleWriter struct {
+	tw      *tar.Writer
+	written map[string]bool
+	index   Index
+	now     time.Time
+}
+
+func (b *bundleWriter) Exists(ctx context.Context, desc Descriptor) (bool, error) {
+	return b.written[desc.Digest], nil
+}
+
+// Push writes the content desc, verifying it matches desc.
+func (b *bundleWriter) Push(ctx context.Context, desc Descriptor, r io.Reader) error {
+	h, err := newDigester(desc.Digest)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     "blobs/" + strings.Replace(desc.Digest, ":", "/", 1),
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     desc.Size,
+		ModTime:  b.now,
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.MultiWriter(b.tw, h), r, desc.Size); err != nil {
+		return err
+	}
+	if got := digestString(desc.Digest, h); got != desc.Digest {
+		return fmt.Errorf("digest mismatch: got %s, expected %s", got, desc.Digest)
+	}
+	b.written[desc.Digest] = true
+	return nil
+}
+
+func (b *bundleWriter) Tag(ctx context.Context, desc Descriptor, ref string) error {
+	tagged := desc
+	tagged.Annotations = map[string]string{annotationRefName: ref}
+	b.index.Manifests = append(b.index.Manifests, tagged)
+	return nil
+}
+
+func (b *bundleWriter) writeFile(name string, data []byte) error {
+	if err := b.tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data)), ModTime: b.now}); err != nil {
+		return err
+	}
+	_, err := b.tw.Write(data)
+	return err
+}
+
+// Bundle writes the images refs, with all of their platforms, to w as a
+// single tar archive holding an OCI image layout, for transfer to
+// registries without network access to c. Images are named repo:tag in the
+// layout index. Bundles are pushed to a registry with LoadBundle.
+func (c *Client) Bundle(ctx context.Context, w io.Writer, refs ...TagRef) error {
+	b := &bundleWriter{
+		tw:      tar.NewWriter(w),
+		written: make(map[string]bool),
+		index:   Index{SchemaVersion: 2, MediaType: MediaTypeOCIIndex},
+		now:     time.Now(),
+	}
+	for _, ref := range refs {
+		if _, err := CopyRef(ctx, c.Repository(ref.Repository), ref.Tag, b, ref.String()); err != nil {
+			return fmt.Errorf("%s: %v", ref, err)
+		}
+		c.Info("bundle image.", "ref", ref)
+	}
+	index, err := marshalJSON(b.index)
+	if err != nil {
+		return err
+	}
+	if err := b.writeFile("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return err
+	}
+	if err := b.writeFile("index.json", index); err != nil {
+		return err
+	}
+	return b.tw.Close()
+}
+
+// LoadBundleResult summarizes a LoadBundle run.
+type LoadBundleResult struct {
+	Tags   []TagRef `json:"tags"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// LoadBundle pushes the images of the bundle r, a tar archive written by
+// Bundle or holding any OCI image layout whose images are named repo:tag,
+// to c. The content is staged in a temporary directory first, as the index
+// comes last in the archive. Failures to push an image are collected in
+// the result and do not stop the run.
+func (c *Client) LoadBundle(ctx context.Context, r io.Reader) (*LoadBundleResult, error) {
+	dir, err := ioutil.TempDir("", "bundle-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	layout, err := NewOCILayout(dir)
+	if err != nil {
+		return nil, err
+	}
+	var index []byte
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		switch {
+		case name == "index.json":
+			if index, err = ioutil.ReadAll(tr); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(name, "blobs/"):
+			parts := strings.Split(name, "/")
+			if len(parts) != 3 {
+				continue
+			}
+			desc := Descriptor{Digest: parts[1] + ":" + parts[2], Size: hdr.Size}
+			if err := layout.Push(ctx, desc, tr); err != nil {
+				return nil, fmt.Errorf("%s: %v", hdr.Name, err)
+			}
+		}
+	}
+	if index == nil {
+		return nil, fmt.Errorf("no index.json in bundle")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+		return nil, err
+	}
+	manifests, err := layout.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LoadBundleResult{}
+	for _, m := range manifests.Manifests {
+		name := m.Annotations[annotationRefName]
+		repo, tag := splitTag(name)
+		if repo == "" || tag == "" {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: image not named repo:tag", m.Digest))
+			continue
+		}
+		ref := TagRef{repo, tag}
+		if _, err := CopyRef(ctx, layout, m.Digest, c.Repository(repo), tag); err != nil {
+			c.Warn("fail to load image.", "ref", ref, "error", err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", ref, err))
+			continue
+		}
+		c.Info("load image.", "ref", ref, "digest", m.Digest)
+		result.Tags = append(result.Tags, ref)
+	}
+	return result, nil
+}
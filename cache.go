@@ -0,0 +1,223 @@
+package registry
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheOptions sets how long Cache entries are used.
+type CacheOptions struct {
+	// TagTTL is how long the digests of tags are cached, 10 minutes when
+	// unset.
+	TagTTL time.Duration
+	// BlobTTL is how long blobs are known to exist, 24 hours when unset.
+	BlobTTL time.Duration
+	// CleanTTL is how long incremental Clean runs skip unchanged
+	// repositories, 7 days when unset, so images aging past OlderThan are
+	// eventually deleted.
+	CleanTTL time.Duration
+}
+
+// Cache is an on-disk cache of registry lookups, speeding up repeated runs
+// against slow registries: the digests tags point at and the blobs known to
+// exist, kept in a BlobCache. Image configs are kept by a ConfigCache. Clean
+// runs never rely on cached tags, so images re-tagged meanwhile are not
+// deleted by mistake, and deleting a manifest drops its tags and the blobs
+// known in its repository. It is safe for concurrent use; use Save to write
+// it back.
+type Cache struct {
+	path  string
+	opts  CacheOptions
+	blobs *BlobCache
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	dirty   bool
+}
+
+type cacheEntry struct {
+	Value []byte    `json:"v,omitempty"`
+	Time  time.Time `json:"t"`
+}
+
+// Cache key prefixes. Blobs are written with the other entries but kept in
+// the BlobCache of the cache.
+const (
+	cacheTag   = "tag:"
+	cacheBlob  = "blob:"
+	cacheClean = "clean:"
+)
+
+// OpenCache reads the cache file path, dropping expired entries and the
+// image configs of former versions. A missing file yields an empty cache.
+func OpenCache(path string, opts CacheOptions) (*Cache, error) {
+	if opts.TagTTL <= 0 {
+		opts.TagTTL = 10 * time.Minute
+	}
+	if opts.BlobTTL <= 0 {
+		opts.BlobTTL = 24 * time.Hour
+	}
+	if opts.CleanTTL <= 0 {
+		opts.CleanTTL = 7 * 24 * time.Hour
+	}
+	blobs := NewBlobCache()
+	blobs.maxAge = opts.BlobTTL
+	cache := &Cache{path: path, opts: opts, blobs: blobs, entries: make(map[string]cacheEntry)}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]cacheEntry
+	if err := unmarshalJSON(b, &entries); err != nil {
+		return nil, err
+	}
+	for key, e := range entries {
+		switch {
+		case time.Since(e.Time) >= cache.ttl(key):
+			cache.dirty = true
+		case strings.HasPrefix(key, cacheBlob):
+			blobs.blobs[strings.TrimPrefix(key, cacheBlob)] = e.Time
+		default:
+			cache.entries[key] = e
+		}
+	}
+	return cache, nil
+}
+
+// WithCache makes the client look up tags and blobs in cache before asking
+// the registry.
+func WithCache(cache *Cache) Option {
+	return func(c *Client) {
+		c.cache = cache
+		c.blobs = nil
+		if cache != nil {
+			c.blobs = cache.blobs
+		}
+	}
+}
+
+// Save writes the cache back to its file if it changed.
+func (c *Cache) Save() error {
+	blobs, changed := c.blobs.snapshot()
+	c.mu.Lock()
+	if !c.dirty && !changed {
+		c.mu.Unlock()
+		return nil
+	}
+	all := make(map[string]cacheEntry, len(c.entries)+len(blobs))
+	for key, e := range c.entries {
+		all[key] = e
+	}
+	for key, t := range blobs {
+		all[cacheBlob+key] = cacheEntry{Time: t}
+	}
+	data, err := marshalJSON(all)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+func (c *Cache) ttl(key string) time.Duration {
+	switch {
+	case strings.HasPrefix(key, cacheTag):
+		return c.opts.TagTTL
+	case strings.HasPrefix(key, cacheBlob):
+		return c.opts.BlobTTL
+	case strings.HasPrefix(key, cacheClean):
+		return c.opts.CleanTTL
+	}
+	return 0
+}
+
+func (c *Cache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.Time) >= c.ttl(key) {
+		return nil, false
+	}
+	return e.Value, true
+}
+
+func (c *Cache) put(key string, value []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = cacheEntry{Value: value, Time: time.Now()}
+	c.dirty = true
+}
+
+func (c *Cache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		delete(c.entries, key)
+		c.dirty = true
+	}
+}
+
+// removeTags drops the tags whose key starts with prefix pointing at digest.
+func (c *Cache) removeTags(prefix, digest string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if strings.HasPrefix(key, prefix) && string(e.Value) == digest {
+			delete(c.entries, key)
+			c.dirty = true
+		}
+	}
+}
+
+func tagCacheKey(c *Client, name, tag string) string {
+	return cacheTag + c.host() + "/" + name + ":" + tag
+}
+
+// forgetManifest drops what the caches of the client know of the manifest
+// digest deleted from the registry repository name: the tags pointing at it,
+// and the blobs known to exist in the repository, which garbage collection
+// may now remove.
+func (c *Client) forgetManifest(name, digest string) {
+	c.cache.removeTags(tagCacheKey(c, name, ""), digest)
+	c.blobs.forget(blobKey(c, name, ""))
+}
+
+// getConfig downloads the config blob digest, or reads it from the config
+// cache.
+func (c *Client) getConfig(ctx context.Context, name, digest string) ([]byte, error) {
+	if b, ok := c.configs.get(digest); ok {
+		return b, nil
+	}
+	b, err := c.getBlob(ctx, name, digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.configs.put(digest, b); err != nil {
+		c.Warn("fail to cache config.", "digest", digest, "error", err)
+	}
+	return b, nil
+}
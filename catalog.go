@@ -0,0 +1,242 @@
+package registry
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cursorSaveInterval is how often walks record their progress in their
+// cursor, besides when they stop.
+const cursorSaveInterval = time.Second
+
+// CatalogRange is the part of the catalog named from From, included, to To,
+// excluded. An empty bound leaves that side open.
+type CatalogRange struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// Contains reports whether the repository repo is in the range.
+func (r CatalogRange) Contains(repo string) bool {
+	return repo >= r.From && (r.To == "" || repo < r.To)
+}
+
+// intersect returns the part of the catalog in both r and other.
+func (r CatalogRange) intersect(other CatalogRange) CatalogRange {
+	if other.From > r.From {
+		r.From = other.From
+	}
+	if other.To != "" && (r.To == "" || other.To < r.To) {
+		r.To = other.To
+	}
+	return r
+}
+
+// SplitCatalog splits the catalog into the ranges between the given names,
+// such as SplitCatalog("g", "n", "t") for four ranges, so that several
+// workers can each walk a part of it.
+func SplitCatalog(bounds ...string) []CatalogRange {
+	sorted := append([]string(nil), bounds...)
+	sort.Strings(sorted)
+	var ranges []CatalogRange
+	from := ""
+	for _, b := range sorted {
+		if b == from {
+			continue
+		}
+		ranges = append(ranges, CatalogRange{From: from, To: b})
+		from = b
+	}
+	return append(ranges, CatalogRange{From: from})
+}
+
+// CatalogOptions controls WalkCatalog.
+type CatalogOptions struct {
+	Range CatalogRange
+	// Cursor, when set, resumes the walk after the last repository it
+	// records and records the progress of the walk, so a walk interrupted
+	// goes on where it stopped. It is cleared once the walk reaches the end
+	// of the range.
+	Cursor *CatalogCursor
+	// PageSize is the number of names asked for with every page, as many as
+	// the registry lists by default when unset.
+	PageSize int
+}
+
+// WalkCatalog calls fn with the repositories of the range of the catalog,
+// in order, paging through the catalog from its start. Clients with a shard
+// walk the part of the range in the shard. The walk stops at the
+// first error of fn, or when ctx ends, and returns it; with a cursor, the
+// repository fn failed on is walked again next time.
+func (c *Client) WalkCatalog(ctx context.Context, opts CatalogOptions, fn func(repo string) error) error {
+	r := opts.Range.intersect(c.shardRange())
+	from, to := c.catalogName(r.From), ""
+	if r.To != "" {
+		to = c.catalogName(r.To)
+	}
+	last := catalogBefore(from)
+	cursor := opts.Cursor
+	if cursor != nil {
+		if resumed := cursor.begin(c.host(), opts.Range); resumed > last {
+			c.Info("resume catalog walk.", "after", resumed)
+			last = resumed
+		}
+	}
+	var werr error
+	saved := time.Now()
+	err := c.catalog(ctx, last, opts.PageSize, func(name string) bool {
+		if to != "" && name >= to {
+			return false
+		}
+		if name < from || name <= last {
+			return true
+		}
+		if werr = ctx.Err(); werr != nil {
+			return false
+		}
+		if c.prefix != "" && !strings.HasPrefix(name, c.prefix+"/") {
+			// The names of the prefix are listed together.
+			return false
+		}
+		if repo := strings.TrimPrefix(name, c.prefix+"/"); c.inShard(repo) {
+			if werr = fn(repo); werr != nil {
+				return false
+			}
+		}
+		cursor.advance(name)
+		if time.Since(saved) >= cursorSaveInterval {
+			saved = time.Now()
+			if err := cursor.save(); err != nil {
+				c.Warn("fail to save catalog cursor.", "error", err)
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = werr
+	}
+	if cursor == nil {
+		return err
+	}
+	if err != nil {
+		if serr := cursor.save(); serr != nil {
+			c.Warn("fail to save catalog cursor.", "error", serr)
+		}
+		return err
+	}
+	return cursor.clear()
+}
+
+// catalogBefore returns the name to list the catalog after to start right
+// before name, as the catalog lists the names after the last one given.
+func catalogBefore(name string) string {
+	if n := len(name); n > 0 && name[n-1] > 0 {
+		return name[:n-1] + string([]byte{name[n-1] - 1})
+	}
+	return ""
+}
+
+// catalogName returns the name repo has in the catalog.
+func (c *Client) catalogName(repo string) string {
+	if c.prefix == "" {
+		return repo
+	}
+	return c.prefix + "/" + repo
+}
+
+// CatalogCursor records in a file how far a walk of the catalog got. A
+// cursor follows the walk of one range of one registry; walks of others
+// start over.
+type CatalogCursor struct {
+	path string
+
+	mu    sync.Mutex
+	state cursorFile
+}
+
+type cursorFile struct {
+	Registry string       `json:"registry"`
+	Range    CatalogRange `json:"range"`
+	// Last is the last name of the catalog walked.
+	Last    string    `json:"last"`
+	Updated time.Time `json:"updated"`
+}
+
+// OpenCatalogCursor reads the cursor saved in path. A missing file yields a
+// cursor starting walks at the start of their range.
+func OpenCatalogCursor(path string) (*CatalogCursor, error) {
+	cursor := &CatalogCursor{path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cursor, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := unmarshalJSON(b, &cursor.state); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// Last returns the last name of the catalog walked, empty unless a walk was
+// interrupted.
+func (c *CatalogCursor) Last() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.Last
+}
+
+// begin starts a walk of r in registry, returning the name to resume
+// after.
+func (c *CatalogCursor) begin(registry string, r CatalogRange) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state.Registry != registry || c.state.Range != r {
+		c.state = cursorFile{Registry: registry, Range: r}
+	}
+	return c.state.Last
+}
+
+func (c *CatalogCursor) advance(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Last = name
+}
+
+func (c *CatalogCursor) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Updated = time.Now()
+	data, err := marshalJSON(c.state)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// clear forgets the walk, which reached the end of its range.
+func (c *CatalogCursor) clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Last = ""
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
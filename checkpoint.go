@@ -0,0 +1,181 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Checkpoint records in a file how far a Mirror or Clean run got, so that a
+// run interrupted and started again resumes where it stopped instead of
+// going through the catalog from the start. A checkpoint follows one job at
+// a time: a run with other options starts over, and a run that completes
+// clears it. It is safe for concurrent use.
+type Checkpoint struct {
+	path string
+
+	mu    sync.Mutex
+	job   string
+	repos map[string]bool
+	tags  map[TagRef]bool
+}
+
+// checkpointFile is the content of a checkpoint file.
+type checkpointFile struct {
+	Job string `json:"job"`
+	// Repositories are the repositories the job is done with, and Tags the
+	// tags it is done with in the others.
+	Repositories []string  `json:"repositories,omitempty"`
+	Tags         []TagRef  `json:"tags,omitempty"`
+	Updated      time.Time `json:"updated"`
+}
+
+// OpenCheckpoint reads the checkpoint saved in path. A missing file yields
+// an empty checkpoint.
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	cp := &Checkpoint{path: path, repos: make(map[string]bool), tags: make(map[TagRef]bool)}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f checkpointFile
+	if err := unmarshalJSON(b, &f); err != nil {
+		return nil, err
+	}
+	cp.job = f.Job
+	for _, repo := range f.Repositories {
+		cp.repos[repo] = true
+	}
+	for _, t := range f.Tags {
+		cp.tags[t] = true
+	}
+	return cp, nil
+}
+
+// Pending reports whether an interrupted run left progress to resume.
+func (cp *Checkpoint) Pending() bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.job != ""
+}
+
+// begin starts the run of job, keeping the progress recorded for it and
+// dropping that of other jobs. It returns the number of repositories the
+// job is done with.
+func (cp *Checkpoint) begin(job string) int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.job != job {
+		cp.job = job
+		cp.repos = make(map[string]bool)
+		cp.tags = make(map[TagRef]bool)
+	}
+	return len(cp.repos)
+}
+
+// repoDone reports whether the run is done with repo. Nil checkpoints know
+// of nothing done.
+func (cp *Checkpoint) repoDone(repo string) bool {
+	if cp == nil {
+		return false
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.repos[repo]
+}
+
+// tagDone reports whether the run is done with the tag t.
+func (cp *Checkpoint) tagDone(t TagRef) bool {
+	if cp == nil {
+		return false
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.tags[t]
+}
+
+// doneTag records the run done with the tag t.
+func (cp *Checkpoint) doneTag(t TagRef) error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.tags[t] = true
+	return cp.save()
+}
+
+// doneRepos records the run done with repos, which need no record of their
+// tags anymore.
+func (cp *Checkpoint) doneRepos(repos ...string) error {
+	if cp == nil || len(repos) == 0 {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	for _, repo := range repos {
+		cp.repos[repo] = true
+	}
+	for t := range cp.tags {
+		if cp.repos[t.Repository] {
+			delete(cp.tags, t)
+		}
+	}
+	return cp.save()
+}
+
+// finish clears the checkpoint of the completed run.
+func (cp *Checkpoint) finish() error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.job = ""
+	cp.repos = make(map[string]bool)
+	cp.tags = make(map[TagRef]bool)
+	if err := os.Remove(cp.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// checkpointJob identifies the job of kind run with the options v.
+func checkpointJob(kind string, v interface{}) (string, error) {
+	b, err := marshalJSON(v)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %x", kind, sha256.Sum256(b)), nil
+}
+
+// save writes the checkpoint to its file. cp.mu must be held.
+func (cp *Checkpoint) save() error {
+	f := checkpointFile{Job: cp.job, Updated: time.Now()}
+	for repo := range cp.repos {
+		f.Repositories = append(f.Repositories, repo)
+	}
+	sort.Strings(f.Repositories)
+	for t := range cp.tags {
+		f.Tags = append(f.Tags, t)
+	}
+	sort.Slice(f.Tags, func(i, j int) bool {
+		return f.Tags[i].String() < f.Tags[j].String()
+	})
+	data, err := marshalJSON(f)
+	if err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
@@ -1,43 +1,105 @@
 package registry
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
-
-	jsoniter "github.com/json-iterator/go"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/inconshreveable/log15"
-	"github.com/parnurzeal/gorequest"
 )
 
+const userAgent = "caeret-registry-client/1.0"
+
 type Client struct {
 	log15.Logger
-	url      string
-	authURL  string
-	username string
-	password string
-	request  *gorequest.SuperAgent
-	tokens   map[string]string
+	url       string
+	authURL   string
+	username  string
+	password  string
+	prefix    string
+	basicAuth bool
+	client    *http.Client
+	mu        sync.Mutex
+	tokens    map[string]string
+	flight    flightGroup
+	breakers  map[string]*breaker
+	userAgent string
+	header    http.Header
+	hooks     []responseHook
+	audit     *auditLog
+	locker    Locker
+	cache     *Cache
+	blobs     *BlobCache
+	configs   *ConfigCache
+	notifiers []Notifier
+	allowHTTP bool
+
+	backup        BackupStore
+	backupConfigs bool
+
+	idempotentDeletes bool
+
+	shard *Shard
+
+	// requestID is the correlation ID of the client, and logger the logger
+	// it was given, without the ID.
+	requestID string
+	logger    log15.Logger
 }
 
-func NewClient(url, username, password string, logger log15.Logger) (*Client, error) {
+// NewClient connects to the registry at url. Bare host names such as
+// registry.example.com are reached over HTTPS, then plain HTTP with
+// WithAllowHTTP.
+func NewClient(url, username, password string, logger log15.Logger, opts ...Option) (*Client, error) {
+	base, err := normalizeURL(url)
+	if err != nil {
+		return nil, err
+	}
 	c := &Client{
-		Logger:   logger,
-		url:      url,
-		username: username,
-		password: password,
-		request:  gorequest.New().Set("User-Agent", "caeret-registry-client/1.0"),
-		tokens:   make(map[string]string),
+		Logger:    logger,
+		url:       base,
+		username:  username,
+		password:  password,
+		client:    &http.Client{},
+		tokens:    make(map[string]string),
+		userAgent: userAgent,
+		header:    http.Header{},
+		configs:   NewConfigCache(0, ""),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.shard != nil {
+		if err := c.shard.validate(); err != nil {
+			return nil, fmt.Errorf("invalid shard %s: %v", c.shard, err)
+		}
+	}
+	if c.requestID == "" {
+		c.requestID = NewRequestID()
+	}
+	// Credentials are kept out of every log line of the client.
+	c.logger = logger.New()
+	c.logger.SetHandler(RedactHandler(c.logger.GetHandler(), password))
+	c.Logger = c.logger.New("request", c.requestID)
+	resp, err := c.send(context.Background(), http.MethodGet, "/v2/", "", nil, nil)
+	if err != nil && c.allowHTTP && strings.HasPrefix(c.url, "https://") {
+		c.Warn("fall back to plain HTTP.", "url", c.url, "error", err)
+		c.url = "http://" + strings.TrimPrefix(c.url, "https://")
+		resp, err = c.send(context.Background(), http.MethodGet, "/v2/", "", nil, nil)
 	}
-	resp, _, errs := c.request.Get(c.url + "/v2/").End()
-	if len(errs) > 0 {
-		return nil, errs[0]
+	if err != nil {
+		return nil, err
 	}
+	resp.Body.Close()
 	switch resp.StatusCode {
 	case http.StatusOK:
 		return c, nil
@@ -52,7 +114,7 @@ func NewClient(url, username, password string, logger log15.Logger) (*Client, er
 				return nil, errors.New("no auth service")
 			}
 		} else if strings.HasPrefix(strings.ToLower(auth), "basic") {
-			c.request = c.request.SetBasicAuth(c.username, c.password)
+			c.basicAuth = true
 			c.Debug("set basic auth.")
 		} else {
 			return nil, errors.New("no auth service")
@@ -63,141 +125,543 @@ func NewClient(url, username, password string, logger log15.Logger) (*Client, er
 	return c, nil
 }
 
+// normalizeURL returns the base URL of the registry at s, which may lack a
+// scheme or end with the /v2/ path users copy from API calls.
+func normalizeURL(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", errors.New("no registry url")
+	}
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid registry url %q: %v", s, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid registry url %q: scheme must be http or https", s)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid registry url %q: no host", s)
+	}
+	if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("invalid registry url %q: only a scheme, host and path are allowed", s)
+	}
+	path := strings.TrimRight(u.Path, "/")
+	path = strings.TrimRight(strings.TrimSuffix(path, "/v2"), "/")
+	return u.Scheme + "://" + u.Host + path, nil
+}
+
+// Ping checks that the registry answers the version check of its API. With
+// bearer tokens the check goes without one, so a 401 response passes too.
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.send(ctx, http.MethodGet, "/v2/", "", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnauthorized && c.authURL != "" {
+		return nil
+	}
+	return statusError(resp, nil)
+}
+
 func (c *Client) QueryRepositories() ([]string, error) {
-	resp, err := c.call("/v2/_catalog", "registry:catalog:*", 2)
+	return c.queryRepositories(context.Background())
+}
+
+func (c *Client) queryRepositories(ctx context.Context) ([]string, error) {
+	var last, to string
+	if r := c.shardRange(); r != (CatalogRange{}) {
+		last = catalogBefore(c.catalogName(r.From))
+		if r.To != "" {
+			to = c.catalogName(r.To)
+		}
+	}
+	var repositories []string
+	err := c.catalog(ctx, last, 0, func(name string) bool {
+		if to != "" && name >= to {
+			return false
+		}
+		repositories = append(repositories, name)
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
-	b, _ := ioutil.ReadAll(resp.Body)
-	var repositories []string
-	jsoniter.Get(b, "repositories").ToVal(&repositories)
-	return repositories, nil
+	var stripped []string
+	for _, repo := range repositories {
+		if c.prefix != "" {
+			if !strings.HasPrefix(repo, c.prefix+"/") {
+				continue
+			}
+			repo = strings.TrimPrefix(repo, c.prefix+"/")
+		}
+		if c.inShard(repo) {
+			stripped = append(stripped, repo)
+		}
+	}
+	return stripped, nil
 }
 
+// catalogPage is a page of the catalog.
+type catalogPage struct {
+	Repositories []string `json:"repositories"`
+}
+
+// catalog calls fn with the names of the catalog after last, following its
+// pages of n names, or as many as the registry lists when 0, until fn
+// returns false.
+func (c *Client) catalog(ctx context.Context, last string, n int, fn func(name string) bool) error {
+	return c.listAll(ctx, "/v2/_catalog", "registry:catalog:*", PageOptions{Last: last, N: n}, func(page *Page) bool {
+		for _, name := range page.Names {
+			if !fn(name) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// QueryTags returns the tags of repo, following the pages of the list.
 func (c *Client) QueryTags(repo string) ([]string, error) {
-	resp, err := c.call(fmt.Sprintf("/v2/%s/tags/list", repo), fmt.Sprintf("repository:%s:*", repo), 2)
+	return c.queryTags(context.Background(), repo)
+}
+
+func (c *Client) queryTags(ctx context.Context, repo string) ([]string, error) {
+	name := c.repoName(repo)
+	var tags []string
+	err := c.listAll(ctx, fmt.Sprintf("/v2/%s/tags/list", name), fmt.Sprintf("repository:%s:*", name), PageOptions{}, func(page *Page) bool {
+		tags = append(tags, page.Names...)
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
-	b, _ := ioutil.ReadAll(resp.Body)
-	var tags []string
-	if n := jsoniter.Get(b, "tags"); n.ValueType() != jsoniter.NilValue {
-		jsoniter.Get(b, "tags").ToVal(&tags)
-	}
 	return tags, nil
 }
 
 func (c *Client) TagInfo(repo, tag string) (digist string) {
-	scope := fmt.Sprintf("repository:%s:*", repo)
-	resp, err := c.call(fmt.Sprintf("/v2/%s/manifests/%s", repo, tag), scope, 2, false)
+	digist, err := c.tagInfo(context.Background(), repo, tag, true)
 	if err != nil {
 		c.Error("fail to get tag info.", "repo", repo, "tag", tag, "error", err)
-		return
 	}
-	digist = resp.Header.Get("Docker-Content-Digest")
-	return
+	return digist
+}
+
+// tagInfo returns the digest tag points at, from the cache if cached is set,
+// or ErrNotFound if the tag does not exist.
+func (c *Client) tagInfo(ctx context.Context, repo, tag string, cached bool) (string, error) {
+	key := tagCacheKey(c, c.repoName(repo), tag)
+	if b, ok := c.cache.get(key); ok && cached {
+		return string(b), nil
+	}
+	scope := fmt.Sprintf("repository:%s:*", c.repoName(repo))
+	resp, err := c.call(ctx, fmt.Sprintf("/v2/%s/manifests/%s", c.repoName(repo), tag), scope, 2)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	digest := manifestDigest(resp, tag, b)
+	c.cache.put(key, []byte(digest))
+	return digest, nil
 }
 
 func (c *Client) DeleteTag(repo, tag string) {
-	scope := fmt.Sprintf("repository:%s:*", repo)
-	c.call(fmt.Sprintf("/v2/%s/manifests/%s", repo, tag), scope, 2, true)
+	c.deleteTag(context.Background(), repo, tag, nil, nil)
 }
 
-func (c *Client) Clean(keepTags ...string) error {
-	repos, err := c.QueryRepositories()
-	if err != nil {
-		return err
-	}
-	m := make(map[string][]struct{ repo, tag string })
-	for _, repo := range repos {
-		logger := c.New("repo", repo)
-		tags, err := c.QueryTags(repo)
-		if err != nil {
-			logger.Warn("fail to query tags.", "repo", repo)
-			continue
+// deleteTag deletes the manifest tagged tag, auditing the deletion as made
+// by policy if it is set. The manifest is backed up first if backups are
+// enabled, along with the tags it is known by. The deletion goes on if ctx
+// ends, which only gives it its correlation ID.
+func (c *Client) deleteTag(ctx context.Context, repo, tag string, policy *Policy, tags []string) error {
+	ctx = detachContext(ctx)
+	if c.backup != nil {
+		if tags == nil && !strings.Contains(tag, ":") {
+			tags = []string{tag}
 		}
-		for _, tag := range tags {
-			m[c.TagInfo(repo, tag)] = append(m[c.TagInfo(repo, tag)], struct{ repo, tag string }{repo, tag})
+		err := c.backupManifest(ctx, repo, tag, tags)
+		if err != nil && !(c.idempotentDeletes && errors.Cause(err) == ErrNotFound) {
+			return errors.Wrap(err, "back up manifest")
 		}
 	}
+	var digest string
+	var size int64
+	if c.audit != nil {
+		digest, size = c.auditTarget(ctx, repo, tag)
+	}
+	err := c.deleteManifest(ctx, c.repoName(repo), tag)
+	c.cache.remove(tagCacheKey(c, c.repoName(repo), tag))
+	if c.audit != nil {
+		c.auditDelete(ctx, repo, tag, digest, size, policy, err)
+	}
+	return err
+}
+
+// ErrDeleteDisabled is the cause of the errors of deletions refused by
+// registries configured without deletion.
+var ErrDeleteDisabled = errors.New("registry has deletion disabled, enable it with storage.delete.enabled in its configuration or REGISTRY_STORAGE_DELETE_ENABLED=true")
+
+// DeleteDisabledError is the error of deleting from a registry that answers
+// 405 Method Not Allowed, as registries do when deletion is disabled. Its
+// cause is ErrDeleteDisabled.
+type DeleteDisabledError struct {
+	Registry string
+	// Ref is the manifest refused, empty when deletion was found disabled
+	// before deleting any.
+	Ref string
+}
+
+func (e *DeleteDisabledError) Error() string {
+	if e.Ref == "" {
+		return fmt.Sprintf("%s: %v", e.Registry, ErrDeleteDisabled)
+	}
+	return fmt.Sprintf("delete %s from %s: %v", e.Ref, e.Registry, ErrDeleteDisabled)
+}
+
+// Cause returns ErrDeleteDisabled, for errors.Cause.
+func (e *DeleteDisabledError) Cause() error {
+	return ErrDeleteDisabled
+}
 
-	var regs []*regexp.Regexp
-	for _, tag := range keepTags {
-		reg, err := regexp.Compile(tag)
+// responseExcerpt is the length of the start of bodies quoted in
+// ResponseError.
+const responseExcerpt = 200
+
+// ResponseError is the error of a response whose body does not parse, such
+// as a body cut short or the HTML error page of a proxy in front of the
+// registry served with status 200.
+type ResponseError struct {
+	// Path is the path of the request, ContentType the type of the body
+	// served.
+	Path        string
+	ContentType string
+	// Body is the start of the body, with its credentials redacted.
+	Body string
+	Err  error
+	// RequestID is the correlation ID of the request.
+	RequestID string
+}
+
+func (e *ResponseError) Error() string {
+	msg := fmt.Sprintf("invalid response to %s (%s): %v: %q", e.Path, e.ContentType, e.Err, e.Body)
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (request %s)", e.RequestID)
+	}
+	return msg
+}
+
+// decodeResponse decodes the JSON body of resp into v, returning a
+// ResponseError if it does not parse.
+func decodeResponse(resp *http.Response, body []byte, v interface{}) error {
+	err := unmarshalJSON(body, v)
+	if err == nil {
+		return nil
+	}
+	e := &ResponseError{ContentType: resp.Header.Get("Content-Type"), Body: Redact(string(body)), Err: err}
+	if resp.Request != nil {
+		e.Path, e.RequestID = resp.Request.URL.Path, resp.Request.Header.Get(RequestIDHeader)
+	}
+	if len(e.Body) > responseExcerpt {
+		e.Body = e.Body[:responseExcerpt] + "..."
+	}
+	return e
+}
+
+// checkDelete returns a DeleteDisabledError if the registry refuses to
+// delete from repository name. It asks to delete a manifest no registry has,
+// which registries allowing deletion answer with 404. Other failures are left
+// to the deletions to report.
+func (c *Client) checkDelete(ctx context.Context, name string) error {
+	digest := "sha256:" + strings.Repeat("0", 64)
+	resp, err := c.send(ctx, http.MethodDelete, fmt.Sprintf("/v2/%s/manifests/%s", name, digest), fmt.Sprintf("repository:%s:*", name), nil, nil)
+	if err != nil {
+		c.Warn("fail to check deletion.", "repo", name, "error", err)
+		return nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		return &DeleteDisabledError{Registry: c.host()}
+	}
+	return nil
+}
+
+// deleteManifest deletes the manifest tag or digest ref of the registry
+// repository name, by the digest the registry reports for tags. Manifests
+// already gone are reported as ErrNotFound unless deletes are idempotent.
+func (c *Client) deleteManifest(ctx context.Context, name, ref string) error {
+	digest := ref
+	if !strings.Contains(ref, ":") {
+		desc, err := c.resolve(ctx, name, ref)
+		if err == ErrNotFound {
+			return c.manifestGone(name, ref)
+		}
 		if err != nil {
 			return err
 		}
-		regs = append(regs, reg)
+		digest = desc.Digest
+	}
+	scope := fmt.Sprintf("repository:%s:*", name)
+	resp, err := c.send(ctx, http.MethodDelete, fmt.Sprintf("/v2/%s/manifests/%s", name, digest), scope, nil, nil)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNoContent:
+		c.contextLogger(ctx).Info("delete tag.", "repo", name, "tag", ref, "digest", digest)
+		c.forgetManifest(name, digest)
+		return nil
+	case http.StatusNotFound:
+		return c.manifestGone(name, ref)
+	case http.StatusMethodNotAllowed:
+		return &DeleteDisabledError{Registry: c.host(), Ref: manifestRef(name, ref)}
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("delete %s: %v", manifestRef(name, ref), statusError(resp, body))
+}
 
-	for _, v := range m {
-		var del = true
-	outer:
-		for _, e := range v {
-			for _, reg := range regs {
-				if reg.MatchString(e.tag) {
-					del = false
-					break outer
-				}
-			}
-		}
-		if del {
-			c.DeleteTag(v[0].repo, v[0].tag)
-		}
+// manifestGone returns the error of deleting the manifest ref found gone.
+func (c *Client) manifestGone(name, ref string) error {
+	if c.idempotentDeletes {
+		c.Info("skip manifest already deleted.", "repo", name, "ref", ref)
+		return nil
 	}
+	return errors.Wrapf(ErrNotFound, "delete %s", manifestRef(name, ref))
+}
 
-	return nil
+// manifestRef returns the reference to the tag or digest ref of repository
+// name.
+func manifestRef(name, ref string) string {
+	if strings.Contains(ref, ":") {
+		return name + "@" + ref
+	}
+	return name + ":" + ref
 }
 
-func (c *Client) call(path, scope string, manifest int, delete ...bool) (gorequest.Response, error) {
-	request := c.request.Clone().Set("Accept", fmt.Sprintf("application/vnd.docker.distribution.manifest.v%d+json", manifest))
-	if c.authURL != "" {
-		request.Set("Authorization", fmt.Sprintf("Bearer %s", c.getToken(scope)))
+func (c *Client) Clean(keepTags ...string) error {
+	_, err := c.CleanWithPolicy(context.Background(), Policy{KeepTags: keepTags})
+	return err
+}
+
+// repoName returns the repository name as seen by the registry, including
+// the configured path prefix.
+func (c *Client) repoName(repo string) string {
+	if c.prefix == "" {
+		return repo
 	}
-	resp, body, errs := request.Get(c.url + path).End()
-	if len(errs) > 0 {
-		return nil, errs[0]
+	return c.prefix + "/" + repo
+}
+
+// call issues a GET request for path, returning ErrNotFound for a 404. The
+// returned response body has been fully read and can be read again.
+func (c *Client) call(ctx context.Context, path, scope string, manifest int) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("Accept", fmt.Sprintf("application/vnd.docker.distribution.manifest.v%d+json", manifest))
+	resp, body, err := c.fetchWithHeader(ctx, path, scope, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
 	}
-	c.Info("call registry.", "path", path, "status", resp.StatusCode)
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("invalid response %d:%s", resp.StatusCode, body)
-	}
-	if len(delete) > 0 && delete[0] {
-		digest := resp.Header.Get("Docker-Content-Digest")
-		parts := strings.Split(path, "/manifests/")
-		path = parts[0] + "/manifests/" + digest
-		resp, _, errs := request.Delete(c.url + path).End()
-		if len(errs) > 0 {
-			return nil, errs[0]
-		} else {
-			// Returns 202 on success.
-			c.Info("delete tag.", "tag", parts[1], "status", resp.StatusCode)
+		return nil, statusError(resp, body)
+	}
+	return resp, nil
+}
+
+// fetch issues a GET request accepting the given media types and returns the
+// response regardless of its status code.
+func (c *Client) fetch(ctx context.Context, path, scope string, accept ...string) (*http.Response, []byte, error) {
+	header := http.Header{}
+	if len(accept) > 0 {
+		header.Set("Accept", strings.Join(accept, ", "))
+	}
+	return c.fetchWithHeader(ctx, path, scope, header)
+}
+
+// fetchWithHeader issues a GET request and reads the whole response body.
+// Identical requests running concurrently are sent only once.
+func (c *Client) fetchWithHeader(ctx context.Context, path, scope string, header http.Header) (*http.Response, []byte, error) {
+	key := flightKey(http.MethodGet, path, scope, c.contextRequestID(ctx), header)
+	return c.flight.do(ctx, key, func() (*http.Response, []byte, error) {
+		resp, err := c.send(ctx, http.MethodGet, path, scope, header, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, nil, err
+		}
+		return resp, body, nil
+	})
+}
+
+// head issues a HEAD request, sharing the round trip with identical
+// requests running concurrently.
+func (c *Client) head(ctx context.Context, path, scope string, header http.Header) (*http.Response, error) {
+	key := flightKey(http.MethodHead, path, scope, c.contextRequestID(ctx), header)
+	resp, _, err := c.flight.do(ctx, key, func() (*http.Response, []byte, error) {
+		resp, err := c.send(ctx, http.MethodHead, path, scope, header, nil)
+		if err != nil {
+			return nil, nil, err
 		}
-		return resp, nil
+		resp.Body.Close()
+		return resp, nil, nil
+	})
+	return resp, err
+}
+
+// send issues a request against the registry, authorizing it for scope. The
+// path may also be an absolute URL, as returned in Location headers. The
+// caller must close the response body.
+func (c *Client) send(ctx context.Context, method, path, scope string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return c.do(req, scope)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	target := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		if err := validatePath(path); err != nil {
+			return nil, err
+		}
+		target = c.url + path
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set(RequestIDHeader, c.contextRequestID(ctx))
+	return req, nil
+}
 
+func (c *Client) do(req *http.Request, scope string) (*http.Response, error) {
+	c.authorize(req, scope)
+	resp, err := c.roundTrip(req, "registry")
+	if err != nil {
+		return nil, err
 	}
+	c.contextLogger(req.Context()).Info("call registry.", "method", req.Method, "path", req.URL.Path, "status", resp.StatusCode)
 	return resp, nil
 }
 
-func (c *Client) getToken(scope string) string {
-	if token, ok := c.tokens[scope]; ok {
-		resp, _, _ := c.request.Clone().Get(c.url+"/v2/").Set("Authorization", fmt.Sprintf("Bearer %s", token)).End()
-		if resp != nil && resp.StatusCode == 200 {
-			return token
+// authorize sets the credentials of req, obtaining a token for scope when
+// the registry uses bearer tokens.
+func (c *Client) authorize(req *http.Request, scope string) {
+	if c.basicAuth {
+		req.SetBasicAuth(c.username, c.password)
+	} else if c.authURL != "" && scope != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.getToken(req.Context(), scope)))
+	}
+}
+
+// roundTrip sends req through the breaker of endpoint and reports it to the
+// response hooks.
+func (c *Client) roundTrip(req *http.Request, endpoint string) (*http.Response, error) {
+	return c.guard(req, endpoint, c.client.Do)
+}
+
+func (c *Client) guard(req *http.Request, endpoint string, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	b := c.breakers[endpoint]
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	resp, err := send(req)
+	if err != nil {
+		err = redactError(err)
+	}
+	c.runHooks(req, resp, err, started)
+	if req.Context().Err() != nil {
+		// Cancelled requests say nothing about the endpoint.
+		b.record(true)
+		return resp, err
+	}
+	b.record(err == nil && resp.StatusCode < 500)
+	return resp, err
+}
+
+// getToken returns a bearer token for scope. Several scopes may be requested
+// at once by separating them with spaces. The requests for it are made with
+// ctx, the context of the request to authorize, and carry its correlation
+// ID.
+func (c *Client) getToken(ctx context.Context, scope string) string {
+	logger := c.contextLogger(ctx)
+	c.mu.Lock()
+	token, ok := c.tokens[scope]
+	c.mu.Unlock()
+	if ok {
+		req, err := c.newRequest(ctx, http.MethodGet, "/v2/", nil)
+		if err == nil {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+			if resp, err := c.roundTrip(req, "registry"); err == nil {
+				resp.Body.Close()
+				if resp.StatusCode == 200 {
+					return token
+				}
+			}
 		}
 	}
 
-	resp, data, errs := c.request.Clone().Get(fmt.Sprintf("%s&scope=%s", c.authURL, scope)).SetBasicAuth(c.username, c.password).EndBytes()
-	if len(errs) > 0 {
-		c.Error("failed to get token.", "error", errs[0])
+	target := c.authURL
+	for _, s := range strings.Fields(scope) {
+		target += "&scope=" + url.QueryEscape(s)
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		logger.Error("failed to get token.", "error", err)
+		return ""
+	}
+	req.SetBasicAuth(c.username, c.password)
+	resp, err := c.roundTrip(req, "auth")
+	if err != nil {
+		logger.Error("failed to get token.", "error", err)
+		return ""
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("failed to get token.", "error", err)
 		return ""
 	}
 	if resp.StatusCode != 200 {
-		c.Error("failed to get token for scope.", "scope", scope, "resp", string(data))
+		logger.Error("failed to get token for scope.", "scope", scope, "resp", string(data))
 		return ""
 	}
 
-	c.tokens[scope] = jsoniter.Get(data, "token").ToString()
-	c.Info("received new token for scope.", "scope", scope)
-	return c.tokens[scope]
+	var t struct {
+		Token string `json:"token"`
+	}
+	if err := decodeResponse(resp, data, &t); err != nil {
+		logger.Error("failed to decode token.", "scope", scope, "error", err)
+		return ""
+	}
+	token = t.Token
+	c.mu.Lock()
+	c.tokens[scope] = token
+	c.mu.Unlock()
+	logger.Info("received new token for scope.", "scope", scope)
+	return token
 }
@@ -0,0 +1,36 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/inconshreveable/log15"
+)
+
+// newTestClient returns a client of the registry at url logging nowhere.
+func newTestClient(t *testing.T, url string, opts ...Option) *Client {
+	t.Helper()
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	c, err := NewClient(url, "", "", logger, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// failingServer serves h, failing the requests of method to path with 502
+// Bad Gateway.
+func failingServer(t *testing.T, h http.Handler, method, path string) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == method && r.URL.Path == path {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/caeret/registry"
+)
+
+func runAppend(args []string) error {
+	fs := flag.NewFlagSet("append", flag.ExitOnError)
+	connect := clientFlags(fs)
+	platform := fs.String("platform", "linux/amd64", "`os/arch[/variant]` to extend in multi-platform images")
+	prefix := fs.String("prefix", "", "image `directory` to add the content to")
+	comment := fs.String("comment", "", "history comment of the new layer")
+	fs.Parse(args)
+	repo, ref, rest, err := imageArgs(fs, fs.Args())
+	if err != nil {
+		return err
+	}
+	if repo == "" || len(rest) != 2 {
+		return fmt.Errorf("usage: registryctl append [flags] repo ref|repo:tag|repo@digest tag dir|tarball")
+	}
+	opts := registry.AppendOptions{Prefix: *prefix, Comment: *comment}
+	if opts.Platform, err = parsePlatform(*platform); err != nil {
+		return err
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	desc, err := c.Append(context.Background(), repo, ref, rest[0], rest[1], opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(desc.Digest)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/caeret/registry"
+)
+
+func runAttach(args []string) error {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	connect := clientFlags(fs)
+	artifactType := fs.String("type", "", "artifact `type`, such as application/vnd.example.report.v1+json")
+	mediaType := fs.String("media-type", "application/octet-stream", "media `type` of the files")
+	tag := fs.String("tag", "", "tag the artifact, pushed by digest otherwise")
+	var annotations stringsFlag
+	fs.Var(&annotations, "annotation", "`key=value` annotation of the artifact, may be repeated")
+	fs.Parse(args)
+	repo, ref, files, err := imageArgs(fs, fs.Args())
+	if err != nil {
+		return err
+	}
+	if repo == "" || len(files) == 0 || *artifactType == "" {
+		return fmt.Errorf("usage: registryctl attach -type type [flags] repo ref|repo:tag|repo@digest file...")
+	}
+	a := registry.Artifact{ArtifactType: *artifactType, Tag: *tag}
+	for _, s := range annotations {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid annotation %q", s)
+		}
+		if a.Annotations == nil {
+			a.Annotations = make(map[string]string)
+		}
+		a.Annotations[parts[0]] = parts[1]
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		a.Layers = append(a.Layers, registry.ArtifactLayer{
+			MediaType:   *mediaType,
+			Data:        b,
+			Annotations: map[string]string{"org.opencontainers.image.title": filepath.Base(file)},
+		})
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	subject, err := c.Repository(repo).Resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	a.Subject = &subject
+	desc, err := c.PushArtifact(ctx, repo, a)
+	if err != nil {
+		return err
+	}
+	fmt.Println(desc.Digest)
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/caeret/registry"
+)
+
+func runAttestations(args []string) error {
+	fs := flag.NewFlagSet("attestations", flag.ExitOnError)
+	connect := clientFlags(fs)
+	var types stringsFlag
+	fs.Var(&types, "type", "only the attestations of predicate `type`, may be repeated")
+	raw := fs.Bool("raw", false, "print the predicates as JSON, one per line")
+	fs.Parse(args)
+	repo, ref, rest, err := imageArgs(fs, fs.Args())
+	if err != nil {
+		return err
+	}
+	if repo == "" || len(rest) > 0 {
+		return fmt.Errorf("usage: registryctl attestations [flags] repo ref|repo:tag|repo@digest")
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	attestations, err := c.Attestations(context.Background(), repo, ref, types...)
+	if err != nil {
+		return err
+	}
+	if *raw {
+		for _, a := range attestations {
+			fmt.Println(string(a.Predicate))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DIGEST\tTYPE\tSIGNED\tSUMMARY")
+	for i := range attestations {
+		a := &attestations[i]
+		summary := "-"
+		switch a.PredicateType {
+		case registry.PredicateTypeSLSAProvenance02, registry.PredicateTypeSLSAProvenance1:
+			if p, err := a.SLSAProvenance(); err == nil {
+				summary = "built by " + p.BuilderID
+				if p.Source != "" {
+					summary += " from " + p.Source
+				}
+			}
+		case registry.PredicateTypeVuln:
+			if r, err := a.Vulnerabilities(); err == nil {
+				summary = strconv.Itoa(len(r.Vulnerabilities)) + " vulnerabilities, highest " + r.Highest().String()
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", a.Digest, a.PredicateType, a.Envelope != nil && len(a.Envelope.Signatures) > 0, summary)
+	}
+	return w.Flush()
+}
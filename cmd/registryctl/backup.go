@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/caeret/registry"
+)
+
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	connect := clientFlags(fs)
+	to := fs.String("to", "", "backup `location`: a directory or s3://bucket/prefix")
+	store := storeFlags(fs)
+	filter := filterFlag(fs)
+	fs.Parse(args)
+	if *to == "" {
+		return fmt.Errorf("no -to location given")
+	}
+	s, err := store(*to)
+	if err != nil {
+		return err
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	repos, err := selectRepos(c, *filter, fs.Args())
+	if err != nil {
+		return err
+	}
+	result, err := c.Backup(context.Background(), s, registry.BackupOptions{Repositories: repos})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d repositories, %d tags, %d blobs stored (%d bytes), %d already stored\n",
+		len(result.Repositories), result.Tags, result.Blobs, result.Bytes, result.Skipped)
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d repositories failed: %s", len(result.Errors), strings.Join(result.Errors, "; "))
+	}
+	return nil
+}
+
+// storeFlags registers the flags configuring S3 backup stores on fs and
+// returns a function opening the store at a location once they are parsed.
+// S3 credentials are read from the usual AWS environment variables.
+func storeFlags(fs *flag.FlagSet) func(location string) (registry.BackupStore, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := fs.String("s3-endpoint", os.Getenv("S3_ENDPOINT"), "S3 service `url`, AWS in $AWS_REGION when empty")
+	fs.StringVar(&region, "s3-region", region, "S3 `region`")
+	return func(location string) (registry.BackupStore, error) {
+		if !strings.HasPrefix(location, "s3://") {
+			return registry.DirStore(location), nil
+		}
+		bucket := strings.TrimPrefix(location, "s3://")
+		var prefix string
+		if i := strings.Index(bucket, "/"); i >= 0 {
+			bucket, prefix = bucket[:i], strings.TrimSuffix(bucket[i+1:], "/")+"/"
+		}
+		if bucket == "" {
+			return nil, fmt.Errorf("no bucket in %s", location)
+		}
+		s := &registry.S3Store{
+			Endpoint:  *endpoint,
+			Bucket:    bucket,
+			Prefix:    prefix,
+			Region:    region,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}
+		if s.Endpoint == "" {
+			s.Endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+		return s, nil
+	}
+}
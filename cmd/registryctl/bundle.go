@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/caeret/registry"
+)
+
+func runBundle(args []string) error {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	connect := clientFlags(fs)
+	out := fs.String("o", "", "write the bundle to `file` instead of stdout")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: registryctl bundle [flags] repo:tag...")
+	}
+	var refs []registry.TagRef
+	for _, arg := range fs.Args() {
+		image := parseImageRef(arg)
+		if err := useHost(fs, image.Host); err != nil {
+			return err
+		}
+		refs = append(refs, registry.TagRef{Repository: image.Repository, Tag: image.ref()})
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return c.Bundle(context.Background(), w, refs...)
+}
+
+func runLoad(args []string) error {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	connect := clientFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: registryctl load [flags] bundle.tar")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	result, err := c.LoadBundle(context.Background(), f)
+	if err != nil {
+		return err
+	}
+	for _, tag := range result.Tags {
+		fmt.Printf("%s loaded\n", tag)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d images failed: %s", len(result.Errors), strings.Join(result.Errors, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const bashCompletion = `_registryctl() {
+	local line=${COMP_LINE:0:COMP_POINT}
+	local words
+	read -ra words <<< "$line"
+	[[ $line == *' ' ]] && words+=('')
+	local IFS=$'\n'
+	COMPREPLY=($(registryctl __complete "${words[@]:1}" 2>/dev/null))
+	# Candidates hold the colons bash splits words on.
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	local prefix=${words[-1]%"$cur"}
+	[[ -n $prefix ]] && COMPREPLY=("${COMPREPLY[@]#"$prefix"}")
+}
+complete -F _registryctl registryctl
+`
+
+const zshCompletion = `#compdef registryctl
+_registryctl() {
+	local -a candidates
+	candidates=(${(f)"$(registryctl __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+	compadd -Q -- $candidates
+}
+compdef _registryctl registryctl
+`
+
+const fishCompletion = `complete -c registryctl -f -a '(registryctl __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`
+
+// subcommands are the subcommands of the commands having some.
+var subcommands = map[string][]string{
+	"report":     {"age", "duplicates", "dangling", "drift", "trend"},
+	"policy":     {"eval", "plan", "apply"},
+	"multi":      {"repos", "age", "clean"},
+	"completion": {"bash", "zsh", "fish"},
+}
+
+func runCompletion(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: registryctl completion bash|zsh|fish")
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		return fmt.Errorf("unknown shell %q", args[0])
+	}
+	return nil
+}
+
+// runComplete prints the candidates completing the last of args, the words
+// of the command line after registryctl. Repositories and tags are queried
+// from the registry given by the flags on the line, or the environment.
+func runComplete(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	cur := args[len(args)-1]
+	if len(args) == 1 {
+		printMatches(commandNames(), cur, "")
+		return nil
+	}
+	cmd, words := args[0], args[1:len(args)-1]
+	if subs, ok := subcommands[cmd]; ok {
+		if len(words) == 0 {
+			printMatches(subs, cur, "")
+			return nil
+		}
+		cmd, words = cmd+" "+words[0], words[1:]
+	}
+	if strings.HasPrefix(cur, "-") || cmd == "completion" || cmd == "load" || cmd == "serve" {
+		return nil
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	connect := clientFlags(fs)
+	// Flags of the command itself stop parsing, keeping those given before.
+	fs.Parse(words)
+	image := parseImageRef(cur)
+	if err := useHost(fs, image.Host); err != nil {
+		return err
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	var prefix string
+	if image.Host != "" {
+		prefix = image.Host + "/"
+	}
+	if i := strings.LastIndex(cur, ":"); i >= 0 && !strings.Contains(cur[i:], "/") {
+		tags, err := c.QueryTags(image.Repository)
+		if err != nil {
+			return err
+		}
+		printMatches(tags, image.Tag, prefix+image.Repository+":")
+		return nil
+	}
+	repos, err := c.QueryRepositories()
+	if err != nil {
+		return err
+	}
+	printMatches(repos, image.Repository, prefix)
+	return nil
+}
+
+// commandNames returns the commands listed in the usage.
+func commandNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(usage, "\n") {
+		if !strings.HasPrefix(line, "  ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 && !seen[fields[0]] {
+			seen[fields[0]] = true
+			names = append(names, fields[0])
+		}
+	}
+	return names
+}
+
+// printMatches prints the candidates starting with cur, prefixed by prefix.
+func printMatches(candidates []string, cur, prefix string) {
+	for _, s := range candidates {
+		if strings.HasPrefix(s, cur) {
+			fmt.Println(prefix + s)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/caeret/registry"
+)
+
+func runExtract(args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	connect := clientFlags(fs)
+	platform := fs.String("platform", "linux/amd64", "`os/arch[/variant]` to extract from multi-platform images")
+	fs.Parse(args)
+	repo, ref, rest, err := imageArgs(fs, fs.Args())
+	if err != nil {
+		return err
+	}
+	if repo == "" || len(rest) != 1 {
+		return fmt.Errorf("usage: registryctl extract [flags] repo ref|repo:tag|repo@digest dir")
+	}
+	p, err := parsePlatform(*platform)
+	if err != nil {
+		return err
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	return c.ExtractImage(context.Background(), repo, ref, rest[0], p)
+}
+
+// parsePlatform parses a platform written as os/arch[/variant].
+func parsePlatform(s string) (registry.Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return registry.Platform{}, fmt.Errorf("invalid platform %q", s)
+	}
+	p := registry.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
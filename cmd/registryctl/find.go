@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"regexp"
+
+	"github.com/caeret/registry"
+)
+
+func runFind(args []string) error {
+	fs := flag.NewFlagSet("find", flag.ExitOnError)
+	connect := clientFlags(fs)
+	platform := fs.String("platform", "linux/amd64", "`os/arch[/variant]` to search in multi-platform images")
+	grep := fs.String("grep", "", "only list files with content matching `regexp`")
+	all := fs.Bool("all", false, "include files hidden by later layers")
+	fs.Parse(args)
+	repo, ref, rest, err := imageArgs(fs, fs.Args())
+	if err != nil {
+		return err
+	}
+	if repo == "" {
+		return fmt.Errorf("usage: registryctl find [flags] repo ref|repo:tag|repo@digest [pattern...]")
+	}
+	opts := registry.FindOptions{Paths: rest}
+	if opts.Platform, err = parsePlatform(*platform); err != nil {
+		return err
+	}
+	if *grep != "" {
+		if opts.Content, err = regexp.Compile(*grep); err != nil {
+			return err
+		}
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	matches, err := c.FindFiles(context.Background(), repo, ref, opts)
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		if m.Hidden && !*all {
+			continue
+		}
+		hidden := ""
+		if m.Hidden {
+			hidden = " (hidden)"
+		}
+		fmt.Printf("%s\t%d\t%s%s\n", m.Path, m.Size, m.Layer, hidden)
+		for _, line := range m.Lines {
+			fmt.Printf("\t%s\n", line)
+		}
+	}
+	return nil
+}
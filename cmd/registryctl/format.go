@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// formatFlag registers the flag selecting the output format of a report.
+func formatFlag(fs *flag.FlagSet) *string {
+	return fs.String("format", "table", "output `format`: table, json or csv")
+}
+
+// csvReport is implemented by the reports of the registry package.
+type csvReport interface {
+	WriteCSV(w io.Writer) error
+}
+
+// writeReport prints report to stdout in format, calling table to print it
+// as a table.
+func writeReport(format string, report csvReport, table func() error) error {
+	switch format {
+	case "", "table":
+		return table()
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "csv":
+		return report.WriteCSV(os.Stdout)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/caeret/registry"
+)
+
+func runInventory(args []string) error {
+	fs := flag.NewFlagSet("inventory", flag.ExitOnError)
+	connect := clientFlags(fs)
+	to := fs.String("to", "", "save the inventory in `location`, a directory or s3://bucket/prefix")
+	output := fs.String("o", "", "write the inventory to `file` instead of the standard output")
+	store := storeFlags(fs)
+	filter := filterFlag(fs)
+	annotations := annotationsFlag(fs)
+	fs.Parse(args)
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	repos, err := selectRepos(c, *filter, fs.Args())
+	if err != nil {
+		return err
+	}
+	opts := registry.InventoryOptions{Repositories: repos, Images: true, Cached: true}
+	for _, k := range *annotations {
+		opts.Annotations = append(opts.Annotations, annotationKey(k))
+	}
+	inv, err := c.Inventory(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	if *to != "" {
+		st, err := store(*to)
+		if err != nil {
+			return err
+		}
+		key, err := registry.SaveInventory(st, inv)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "saved", key)
+	}
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		err = inv.WriteNDJSON(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	} else if *to == "" {
+		if err := inv.WriteNDJSON(os.Stdout); err != nil {
+			return err
+		}
+	}
+	if len(inv.Errors) > 0 {
+		return fmt.Errorf("%d failures: %s", len(inv.Errors), strings.Join(inv.Errors, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Command registryctl inspects and maintains Docker registries.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/inconshreveable/log15"
+
+	"github.com/caeret/registry"
+)
+
+const usage = `usage: registryctl <command> [flags] [args]
+
+commands:
+  repos [-filter pattern]       list the repositories of the registry
+  tags [-sort order] repo       list the tags of a repository
+  report age [repo...]          list tags by image creation date
+  report duplicates [repo...]   list tags pointing at the same image
+  report dangling [repo...]     list or -delete referrers of deleted images
+  report drift [repo...]        check that latest points at the highest release
+  report trend [repo...]        compare the storage of saved snapshots
+  snapshot [repo...]            record the tags and storage of repositories
+  inventory [repo...]           export every tag and image as NDJSON
+  simulate                      show what a policy deletes from a snapshot
+  policy eval [repo...]         show the verdict of a policy on every tag
+  policy plan [repo...]         save what a policy deletes for review
+  policy apply plan.json        delete the images of a reviewed plan
+  mirror [repo...]              copy repositories to another registry
+  promote repo ref dst:tag      promote an image to another tag or registry
+  backup [repo...]              copy repositories to a directory or S3 bucket
+  restore [repo...]             re-push manifests from deletion or full backups
+  bundle repo:tag...            write images to a tar archive
+  load bundle.tar               push the images of a bundle
+  extract repo ref dir          unpack the file system of an image
+  find repo ref [pattern...]    list or grep the files of an image
+  squash repo ref tag           merge the layers of an image
+  append repo ref tag path      add a directory or tarball as a new layer
+  rebase repo ref tag           move an image onto a new base image
+  attach repo ref file...       push files as an artifact referring to an image
+  sign -key key repo ref        sign an image with a key file or KMS key
+  verify repo ref               check the cosign or Notation signatures of an image
+  attestations repo ref         list the provenance and attestations of an image
+  probe [-repo scratch]         check which parts of the registry API are supported
+  multi repos|age|clean         run across the registries of a -config file
+  serve                         run the HTTP API
+  completion bash|zsh|fish      print a shell completion script
+
+images are given as repo ref, or as full references such as
+registry.example.com/app/api:v1.2.3 or app@sha256:..., naming the registry
+instead of -url.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "repos":
+		err = runRepos(os.Args[2:])
+	case "tags":
+		err = runTags(os.Args[2:])
+	case "report":
+		err = runReport(os.Args[2:])
+	case "snapshot":
+		err = runSnapshot(os.Args[2:])
+	case "inventory":
+		err = runInventory(os.Args[2:])
+	case "simulate":
+		err = runSimulate(os.Args[2:])
+	case "policy":
+		err = runPolicy(os.Args[2:])
+	case "mirror":
+		err = runMirror(os.Args[2:])
+	case "promote":
+		err = runPromote(os.Args[2:])
+	case "backup":
+		err = runBackup(os.Args[2:])
+	case "restore":
+		err = runRestore(os.Args[2:])
+	case "bundle":
+		err = runBundle(os.Args[2:])
+	case "load":
+		err = runLoad(os.Args[2:])
+	case "extract":
+		err = runExtract(os.Args[2:])
+	case "find":
+		err = runFind(os.Args[2:])
+	case "squash":
+		err = runSquash(os.Args[2:])
+	case "append":
+		err = runAppend(os.Args[2:])
+	case "rebase":
+		err = runRebase(os.Args[2:])
+	case "attach":
+		err = runAttach(os.Args[2:])
+	case "sign":
+		err = runSign(os.Args[2:])
+	case "verify":
+		err = runVerify(os.Args[2:])
+	case "attestations":
+		err = runAttestations(os.Args[2:])
+	case "probe":
+		err = runProbe(os.Args[2:])
+	case "multi":
+		err = runMulti(os.Args[2:])
+	case "serve":
+		err = runServe(os.Args[2:])
+	case "completion":
+		err = runCompletion(os.Args[2:])
+	case "__complete":
+		err = runComplete(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	for _, c := range caches {
+		if serr := c.Save(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "registryctl:", err)
+		os.Exit(1)
+	}
+}
+
+// caches are the caches opened with -cache, saved before exiting.
+var caches []*registry.Cache
+
+// clientFlags registers the flags selecting the registry on fs and returns a
+// function connecting to it once the flags are parsed.
+func clientFlags(fs *flag.FlagSet) func() (*registry.Client, error) {
+	verbose := fs.Bool("v", false, "log registry calls")
+	return registryFlags(fs, "", "REGISTRY_", verbose)
+}
+
+// registryFlags registers flags named with prefix selecting a registry,
+// defaulting to environment variables named with envPrefix.
+func registryFlags(fs *flag.FlagSet, prefix, envPrefix string, verbose *bool) func() (*registry.Client, error) {
+	url := fs.String(prefix+"url", os.Getenv(envPrefix+"URL"), "registry `url`")
+	username := fs.String(prefix+"username", os.Getenv(envPrefix+"USERNAME"), "registry user")
+	password := fs.String(prefix+"password", os.Getenv(envPrefix+"PASSWORD"), "registry password")
+	pathPrefix := fs.String(prefix+"prefix", "", "repository path `prefix`")
+	insecure := fs.Bool(prefix+"insecure", os.Getenv(envPrefix+"INSECURE") != "", "fall back to plain HTTP if the registry does not answer HTTPS")
+	maxConns := fs.Int(prefix+"max-conns", 0, "maximum number of connections to the registry")
+	auditLog := fs.String(prefix+"audit-log", "", "append a record of every deletion to `file`")
+	backupDir := fs.String(prefix+"backup-dir", "", "back up manifests and configs to `dir` before deleting them")
+	cache := fs.String(prefix+"cache", "", "cache tag digests and known blobs in `file` between runs, and image configs in file.configs unless -config-cache is set")
+	configCache := fs.String(prefix+"config-cache", "", "keep image configs in `dir` between runs, one file per digest")
+	lock := fs.String(prefix+"lock", "", "hold `lock` while cleaning, so one process cleans at a time: a file, or tag:repo:tag for a tag of the registry")
+	slack := fs.String(prefix+"notify-slack", os.Getenv("SLACK_WEBHOOK_URL"), "post a summary of clean runs to the Slack webhook `url`")
+	webhook := fs.String(prefix+"notify-webhook", "", "post the results of clean runs as JSON to `url`")
+	var mailTo stringsFlag
+	fs.Var(&mailTo, prefix+"notify-email", "mail the results of clean runs to `address` through $SMTP_ADDR, may be repeated")
+	var headers stringsFlag
+	fs.Var(&headers, prefix+"header", "`header` sent with every request, as \"Name: value\" (repeatable)")
+	requestID := fs.String(prefix+"request-id", os.Getenv(envPrefix+"REQUEST_ID"), "correlation `id` sent in the X-Request-ID header of every request and included in logs, random when empty")
+	shard := fs.String(prefix+"shard", os.Getenv(envPrefix+"SHARD"), "work on the `shard` of the repositories only: i/n for those hashing to i of n, from:to for a range of names, or both separated by a comma")
+	return func() (*registry.Client, error) {
+		if *url == "" {
+			return nil, fmt.Errorf("no -%surl given", prefix)
+		}
+		logger := log15.New()
+		if *verbose {
+			logger.SetHandler(log15.StderrHandler)
+		} else {
+			logger.SetHandler(log15.LvlFilterHandler(log15.LvlWarn, log15.StderrHandler))
+		}
+		var opts []registry.Option
+		if *pathPrefix != "" {
+			opts = append(opts, registry.WithPathPrefix(*pathPrefix))
+		}
+		if *insecure {
+			opts = append(opts, registry.WithAllowHTTP())
+		}
+		if *requestID != "" {
+			opts = append(opts, registry.WithRequestID(*requestID))
+		}
+		if *shard != "" {
+			s, err := registry.ParseShard(*shard)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, registry.WithShard(s))
+		}
+		if *maxConns > 0 {
+			opts = append(opts, registry.WithMaxConnsPerHost(*maxConns), registry.WithMaxIdleConnsPerHost(*maxConns))
+		}
+		if *auditLog != "" {
+			f, err := registry.OpenAuditLog(*auditLog)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, registry.WithAuditLog(f))
+		}
+		if *backupDir != "" {
+			opts = append(opts, registry.WithBackup(registry.DirStore(*backupDir), true))
+		}
+		if *cache != "" {
+			c, err := registry.OpenCache(*cache, registry.CacheOptions{})
+			if err != nil {
+				return nil, err
+			}
+			caches = append(caches, c)
+			opts = append(opts, registry.WithCache(c))
+		}
+		configDir := *configCache
+		if configDir == "" && *cache != "" {
+			configDir = *cache + ".configs"
+		}
+		if configDir != "" {
+			opts = append(opts, registry.WithConfigCache(registry.NewConfigCache(0, configDir)))
+		}
+		if strings.HasPrefix(*lock, "tag:") {
+			ref := parseTagRef(strings.TrimPrefix(*lock, "tag:"))
+			opts = append(opts, registry.WithTagLock(ref.Repository, ref.Tag))
+		} else if *lock != "" {
+			opts = append(opts, registry.WithLock(&registry.FileLock{Path: *lock}))
+		}
+		if *slack != "" {
+			opts = append(opts, registry.WithNotifier(&registry.SlackNotifier{WebhookURL: *slack}))
+		}
+		if *webhook != "" {
+			opts = append(opts, registry.WithNotifier(&registry.WebhookNotifier{URL: *webhook}))
+		}
+		if len(mailTo) > 0 {
+			opts = append(opts, registry.WithNotifier(&registry.SMTPNotifier{
+				Addr:     os.Getenv("SMTP_ADDR"),
+				Username: os.Getenv("SMTP_USERNAME"),
+				Password: os.Getenv("SMTP_PASSWORD"),
+				From:     os.Getenv("SMTP_FROM"),
+				To:       mailTo,
+			}))
+		}
+		for _, h := range headers {
+			parts := strings.SplitN(h, ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid header %q", registry.Redact(h))
+			}
+			opts = append(opts, registry.WithHeader(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])))
+		}
+		return registry.NewClient(*url, *username, *password, logger, opts...)
+	}
+}
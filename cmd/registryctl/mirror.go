@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/caeret/registry"
+)
+
+// stringsFlag collects the values of a repeated flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func runMirror(args []string) error {
+	fs := flag.NewFlagSet("mirror", flag.ExitOnError)
+	connect := clientFlags(fs)
+	connectDst := registryFlags(fs, "dst-", "REGISTRY_DST_", new(bool))
+	referrers := fs.Bool("referrers", false, "also copy signatures, SBOMs and attestations")
+	cacheFile := fs.String("blob-cache", "", "`file` remembering destination blobs between runs")
+	sign := fs.String("sign", "", "sign the copies with the private key `file` or KMS URI")
+	cacheAge := fs.Duration("blob-cache-age", 24*time.Hour, "maximum `age` of remembered blobs")
+	progress := fs.Duration("progress", 0, "total the transfer first and report its progress every `interval`")
+	checkpointFile := fs.String("checkpoint", "", "`file` recording the mirrored tags, so an interrupted run resumes")
+	var rules stringsFlag
+	fs.Var(&rules, "rule", "mapping `rule` such as 'team-a/(.*) -> mirror/a/$1', may be repeated")
+	filter := filterFlag(fs)
+	fs.Parse(args)
+
+	opts := registry.MirrorOptions{
+		CopyOptions:  registry.CopyOptions{Referrers: *referrers},
+		Repositories: fs.Args(),
+	}
+	if *sign != "" {
+		signer, err := newSigner(*sign)
+		if err != nil {
+			return err
+		}
+		opts.Signer = signer
+	}
+	for _, r := range rules {
+		rule, err := registry.ParseMappingRule(r)
+		if err != nil {
+			return err
+		}
+		opts.Rules = append(opts.Rules, rule)
+	}
+	if *cacheFile != "" {
+		cache, err := registry.LoadBlobCache(*cacheFile, *cacheAge)
+		if err != nil {
+			return err
+		}
+		opts.BlobCache = cache
+		defer func() {
+			if err := cache.Save(*cacheFile); err != nil {
+				fmt.Fprintln(os.Stderr, "registryctl: fail to save blob cache:", err)
+			}
+		}()
+	}
+	src, err := connect()
+	if err != nil {
+		return err
+	}
+	dst, err := connectDst()
+	if err != nil {
+		return err
+	}
+	if opts.Repositories, err = selectRepos(src, *filter, opts.Repositories); err != nil {
+		return err
+	}
+	if *checkpointFile != "" {
+		cp, err := registry.OpenCheckpoint(*checkpointFile)
+		if err != nil {
+			return err
+		}
+		opts.Checkpoint = cp
+	}
+	if *progress > 0 {
+		opts.Progress = registry.NewProgress()
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(*progress)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					fmt.Fprintln(os.Stderr, opts.Progress.Report())
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	// Interrupting stops the run, which the checkpoint lets resume.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			fmt.Fprintln(os.Stderr, "registryctl: interrupted, stopping the mirror")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	result, err := src.Mirror(ctx, dst, opts)
+	if result == nil {
+		return err
+	}
+	if opts.Progress != nil {
+		fmt.Fprintln(os.Stderr, opts.Progress.Report())
+	}
+	for _, t := range result.Tags {
+		status := "copied"
+		if t.Skipped {
+			status = "up to date"
+		}
+		fmt.Printf("%s -> %s %s\n", t.Source, t.Destination, status)
+	}
+	if err != nil {
+		return err
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d tags failed: %s", len(result.Errors), strings.Join(result.Errors, "; "))
+	}
+	return nil
+}
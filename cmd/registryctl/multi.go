@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/inconshreveable/log15"
+
+	"github.com/caeret/registry"
+)
+
+func runMulti(args []string) error {
+	fs := flag.NewFlagSet("multi", flag.ExitOnError)
+	configFile := fs.String("config", os.Getenv("REGISTRY_CONFIG"), "registries configuration `file`")
+	verbose := fs.Bool("v", false, "log registry calls")
+	var keepTags stringsFlag
+	fs.Var(&keepTags, "keep", "clean: `regexp` of tags kept in registries without a policy, may be repeated")
+	force := fs.Bool("force", false, "clean: delete even more than the maxRepositoryPercent and maxRegistryPercent of the policies")
+	parallel := fs.Int("parallel", 4, "clean: `number` of registries cleaned at once, 0 for all")
+	format := formatFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: registryctl multi -config file repos|age|clean")
+	}
+	if *configFile == "" {
+		return fmt.Errorf("no -config file given")
+	}
+	config, err := registry.LoadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	logger := log15.New()
+	if *verbose {
+		logger.SetHandler(log15.StderrHandler)
+	} else {
+		logger.SetHandler(log15.LvlFilterHandler(log15.LvlWarn, log15.StderrHandler))
+	}
+	m, err := registry.NewMultiClient(config, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	switch fs.Arg(0) {
+	case "repos":
+		repos, qerr := m.QueryRepositories(ctx)
+		fmt.Fprintln(w, "REGISTRY\tREPOSITORY")
+		for _, name := range m.Names() {
+			for _, repo := range repos[name] {
+				fmt.Fprintf(w, "%s\t%s\n", name, repo)
+			}
+		}
+		err = qerr
+	case "age":
+		reports, rerr := m.AgeReport(ctx)
+		fmt.Fprintln(w, "REGISTRY\tREPOSITORY\tTAG\tCREATED\tDAYS")
+		for _, name := range m.Names() {
+			report, ok := reports[name]
+			if !ok {
+				continue
+			}
+			for _, t := range report.Tags {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", name, t.Repository, t.Tag, t.Created.Format(time.RFC3339), t.DaysSinceCreated)
+			}
+		}
+		err = rerr
+	case "clean":
+		var policy *registry.Policy
+		if len(keepTags) > 0 {
+			policy = &registry.Policy{KeepTags: keepTags, Force: *force}
+		}
+		if *force {
+			for _, r := range config.Registries {
+				if r.Policy != nil {
+					r.Policy.Force = true
+				}
+			}
+		}
+		// Interrupting stops the run after the deletions in flight.
+		cctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sigs)
+		go func() {
+			select {
+			case <-sigs:
+				fmt.Fprintln(os.Stderr, "registryctl: interrupted, waiting for deletions in flight")
+				cancel()
+			case <-cctx.Done():
+			}
+		}()
+		report, cerr := m.Clean(cctx, registry.MultiCleanOptions{Policy: policy, Parallelism: *parallel})
+		if report == nil {
+			return cerr
+		}
+		err = writeReport(*format, report, func() error {
+			fmt.Fprintln(w, "REGISTRY\tPOLICY\tDELETED\tKEPT\tRECLAIMABLE\tERRORS\tCANCELLED")
+			for _, run := range report.Registries {
+				switch {
+				case run.Skipped:
+					fmt.Fprintf(w, "%s\t-\t\t\t\t\t\n", run.Registry)
+				case run.Result == nil:
+					fmt.Fprintf(w, "%s\t%s\t\t\t\t%s\t\n", run.Registry, run.Policy, run.Error)
+				default:
+					r := run.Result
+					fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%t\n", run.Registry, run.Policy, len(r.Deleted), r.Kept, r.Reclaimable, len(r.Errors), r.Cancelled)
+				}
+			}
+			fmt.Fprintf(w, "TOTAL\t\t%d\t%d\t%d\t\t\n", report.Deleted, report.Kept, report.Reclaimable)
+			return nil
+		})
+		if cerr != nil {
+			err = cerr
+		}
+	default:
+		return fmt.Errorf("unknown multi command %q", fs.Arg(0))
+	}
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/caeret/registry"
+)
+
+func runPolicy(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: registryctl policy eval|plan|apply [flags] [args]")
+	}
+	switch args[0] {
+	case "eval":
+		return runPolicyEval(args[1:])
+	case "plan":
+		return runPolicyPlan(args[1:])
+	case "apply":
+		return runPolicyApply(args[1:])
+	default:
+		return fmt.Errorf("unknown policy command %q", args[0])
+	}
+}
+
+func runPolicyEval(args []string) error {
+	fs := flag.NewFlagSet("policy eval", flag.ExitOnError)
+	connect := clientFlags(fs)
+	policyFile := fs.String("policy", "", "JSON policy `file`")
+	var repos stringsFlag
+	fs.Var(&repos, "repo", "evaluate the policy on `repo` only, may be repeated")
+	format := formatFlag(fs)
+	filter := filterFlag(fs)
+	fs.Parse(args)
+	if *policyFile == "" {
+		return fmt.Errorf("no -policy file given")
+	}
+	var policy registry.Policy
+	if err := readJSON(*policyFile, &policy); err != nil {
+		return err
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	selected, err := selectRepos(c, *filter, append(repos, fs.Args()...))
+	if err != nil {
+		return err
+	}
+	eval, err := c.EvaluatePolicy(context.Background(), policy, selected...)
+	if err != nil {
+		return err
+	}
+	err = writeReport(*format, eval, func() error {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tTAG\tVERDICT\tRULE\tDIGEST")
+		for _, d := range eval.Decisions {
+			verdict := "keep"
+			if d.Delete {
+				verdict = "delete"
+			}
+			for _, t := range d.Tags {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.Repository, t.Tag, verdict, d.Rule, d.Digest)
+			}
+		}
+		fmt.Fprintf(w, "\n%d images deleted, %d kept\n", eval.Deleted, eval.Kept)
+		return w.Flush()
+	})
+	if err == nil && len(eval.Errors) > 0 {
+		err = fmt.Errorf("%d images failed: %s", len(eval.Errors), strings.Join(eval.Errors, "; "))
+	}
+	return err
+}
+
+func runPolicyPlan(args []string) error {
+	fs := flag.NewFlagSet("policy plan", flag.ExitOnError)
+	connect := clientFlags(fs)
+	policyFile := fs.String("policy", "", "JSON policy `file`")
+	output := fs.String("o", "", "write the plan as JSON to `file`, for policy apply")
+	expires := fs.Duration("expires", registry.DefaultPlanExpiry, "refuse to apply the plan after this `duration`, never when 0")
+	var repos stringsFlag
+	fs.Var(&repos, "repo", "plan the deletions of `repo` only, may be repeated")
+	format := formatFlag(fs)
+	filter := filterFlag(fs)
+	fs.Parse(args)
+	if *policyFile == "" {
+		return fmt.Errorf("no -policy file given")
+	}
+	var policy registry.Policy
+	if err := readJSON(*policyFile, &policy); err != nil {
+		return err
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	selected, err := selectRepos(c, *filter, append(repos, fs.Args()...))
+	if err != nil {
+		return err
+	}
+	plan, err := c.Plan(context.Background(), policy, selected...)
+	if err != nil {
+		return err
+	}
+	plan.Expires = time.Time{}
+	if *expires > 0 {
+		plan.Expires = plan.Time.Add(*expires)
+	}
+	if *output != "" {
+		b, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*output, b, 0644); err != nil {
+			return err
+		}
+	}
+	err = writeReport(*format, plan, func() error {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tTAGS\tRULE\tDIGEST")
+		for _, d := range plan.Decisions {
+			if !d.Delete {
+				continue
+			}
+			var tags []string
+			for _, t := range d.Tags {
+				if t.Repository == d.Repository {
+					tags = append(tags, t.Tag)
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Repository, strings.Join(tags, ","), d.Rule, d.Digest)
+		}
+		fmt.Fprintf(w, "\n%d images to delete, %d kept\n", plan.Deleted, plan.Kept)
+		return w.Flush()
+	})
+	if err == nil && len(plan.Errors) > 0 {
+		err = fmt.Errorf("%d images failed: %s", len(plan.Errors), strings.Join(plan.Errors, "; "))
+	}
+	return err
+}
+
+func runPolicyApply(args []string) error {
+	fs := flag.NewFlagSet("policy apply", flag.ExitOnError)
+	connect := clientFlags(fs)
+	format := formatFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: registryctl policy apply [flags] plan.json")
+	}
+	var plan registry.Plan
+	if err := readJSON(fs.Arg(0), &plan); err != nil {
+		return err
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	result, err := c.Apply(context.Background(), &plan)
+	if err != nil {
+		return err
+	}
+	err = writeReport(*format, result, func() error {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tTAG\tRECLAIMABLE\tDIGEST")
+		for _, d := range result.Deleted {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", d.Repository, d.Tag, d.Reclaimable, d.Digest)
+		}
+		fmt.Fprintf(w, "\n%d images deleted, %d bytes reclaimable, %d changed since planned\n", len(result.Deleted), result.Reclaimable, result.Changed)
+		return w.Flush()
+	})
+	if err == nil && len(result.Errors) > 0 {
+		err = fmt.Errorf("%d images failed: %s", len(result.Errors), strings.Join(result.Errors, "; "))
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/caeret/registry"
+)
+
+func runProbe(args []string) error {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	connect := clientFlags(fs)
+	repo := fs.String("repo", "", "scratch `repository` to check uploads, referrers and deletion in")
+	format := formatFlag(fs)
+	fs.Parse(args)
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	report, err := c.Probe(context.Background(), registry.ProbeOptions{Repository: *repo})
+	if err != nil {
+		return err
+	}
+	return writeReport(*format, report, func() error {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CHECK\tRESULT\tDETAIL")
+		for _, check := range report.Checks {
+			result := "no"
+			switch {
+			case check.Skipped:
+				result = "skipped"
+			case check.Supported:
+				result = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", check.Name, result, check.Detail)
+		}
+		return w.Flush()
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/caeret/registry"
+)
+
+func runPromote(args []string) error {
+	fs := flag.NewFlagSet("promote", flag.ExitOnError)
+	connect := clientFlags(fs)
+	connectDst := registryFlags(fs, "dst-", "REGISTRY_DST_", new(bool))
+	referrers := fs.Bool("referrers", false, "also copy signatures, SBOMs and attestations")
+	by := fs.String("by", "", "record `name` as who promoted the image, like a CI job")
+	plain := fs.Bool("plain", false, "keep the manifest and its digest as they are, without annotations")
+	sign := fs.String("sign", "", "sign the promoted image with the private key `file` or KMS URI")
+	var annotations stringsFlag
+	fs.Var(&annotations, "annotation", "`key=value` annotation of the promoted image, may be repeated")
+	fs.Parse(args)
+	repo, ref, rest, err := imageArgs(fs, fs.Args())
+	if err != nil {
+		return err
+	}
+	if repo == "" || len(rest) != 1 {
+		return fmt.Errorf("usage: registryctl promote [flags] repo ref|repo:tag|repo@digest dst-repo:tag")
+	}
+	target := parseImageRef(rest[0])
+	if target.Tag == "" || target.Digest != "" {
+		return fmt.Errorf("promote to a tag, not %s", rest[0])
+	}
+	if target.Host != "" && fs.Lookup("dst-url").Value.String() == "" {
+		fs.Set("dst-url", "https://"+target.Host)
+	}
+
+	opts := registry.PromoteOptions{
+		CopyOptions: registry.CopyOptions{Referrers: *referrers},
+		PromotedBy:  *by,
+		Plain:       *plain,
+	}
+	for _, s := range annotations {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid annotation %q", s)
+		}
+		if opts.Annotations == nil {
+			opts.Annotations = make(map[string]string)
+		}
+		opts.Annotations[parts[0]] = parts[1]
+	}
+	if *sign != "" {
+		if opts.Signer, err = newSigner(*sign); err != nil {
+			return err
+		}
+	}
+	src, err := connect()
+	if err != nil {
+		return err
+	}
+	dst := src
+	if fs.Lookup("dst-url").Value.String() != "" {
+		if dst, err = connectDst(); err != nil {
+			return err
+		}
+	}
+	result, err := src.Promote(context.Background(), repo, ref, dst, target.Repository, target.Tag, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s -> %s\n", result.Source, result.Destination)
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/caeret/registry"
+)
+
+func runRebase(args []string) error {
+	fs := flag.NewFlagSet("rebase", flag.ExitOnError)
+	connect := clientFlags(fs)
+	platform := fs.String("platform", "linux/amd64", "`os/arch[/variant]` to rebase in multi-platform images")
+	oldBase := fs.String("old", "", "current base `repo:tag` of the image")
+	newBase := fs.String("new", "", "new base `repo:tag` of the image")
+	fs.Parse(args)
+	repo, ref, rest, err := imageArgs(fs, fs.Args())
+	if err != nil {
+		return err
+	}
+	if repo == "" || len(rest) != 1 || *oldBase == "" || *newBase == "" {
+		return fmt.Errorf("usage: registryctl rebase -old repo:tag -new repo:tag [flags] repo ref|repo:tag|repo@digest tag")
+	}
+	opts := registry.RebaseOptions{OldBase: parseTagRef(*oldBase), NewBase: parseTagRef(*newBase)}
+	if opts.Platform, err = parsePlatform(*platform); err != nil {
+		return err
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	desc, err := c.Rebase(context.Background(), repo, ref, rest[0], opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(desc.Digest)
+	return nil
+}
+
+// parseTagRef parses repo:tag or repo@digest, defaulting to the latest tag.
+func parseTagRef(s string) registry.TagRef {
+	if i := strings.Index(s, "@"); i >= 0 {
+		return registry.TagRef{Repository: s[:i], Tag: s[i+1:]}
+	}
+	if i := strings.LastIndex(s, ":"); i >= 0 && !strings.Contains(s[i:], "/") {
+		return registry.TagRef{Repository: s[:i], Tag: s[i+1:]}
+	}
+	return registry.TagRef{Repository: s, Tag: "latest"}
+}
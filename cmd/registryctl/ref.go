@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// imageRef is an image reference given on the command line, with the
+// registry only when named explicitly.
+type imageRef struct {
+	Host       string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseImageRef splits a reference such as registry.example.com/app/api:v1.2.3
+// or app@sha256:.... Unlike registry.ParseReference, references without a
+// registry are left without one, to be looked up in the registry of -url.
+func parseImageRef(s string) imageRef {
+	var ref imageRef
+	if i := strings.Index(s, "@"); i >= 0 {
+		s, ref.Digest = s[:i], s[i+1:]
+	}
+	if i := strings.LastIndex(s, ":"); i >= 0 && !strings.Contains(s[i:], "/") {
+		s, ref.Tag = s[:i], s[i+1:]
+	}
+	if i := strings.Index(s, "/"); i >= 0 && (strings.ContainsAny(s[:i], ".:") || s[:i] == "localhost") {
+		ref.Host, s = s[:i], s[i+1:]
+	}
+	ref.Repository = s
+	return ref
+}
+
+// ref returns the digest of the reference, or its tag, latest when unset.
+func (r imageRef) ref() string {
+	switch {
+	case r.Digest != "":
+		return r.Digest
+	case r.Tag != "":
+		return r.Tag
+	}
+	return "latest"
+}
+
+// imageArgs parses the image named at the start of the arguments of a
+// command, either as a repository followed by a tag or digest, or as a single
+// full reference. It returns the repository, the tag or digest and the
+// remaining arguments, or an empty repository if no image is given.
+func imageArgs(fs *flag.FlagSet, args []string) (repo, ref string, rest []string, err error) {
+	if len(args) == 0 {
+		return "", "", nil, nil
+	}
+	image := parseImageRef(args[0])
+	if err := useHost(fs, image.Host); err != nil {
+		return "", "", nil, err
+	}
+	if image.Tag == "" && image.Digest == "" {
+		if len(args) < 2 {
+			return "", "", nil, nil
+		}
+		return image.Repository, args[1], args[2:], nil
+	}
+	return image.Repository, image.ref(), args[1:], nil
+}
+
+// useHost makes host the -url of fs, unless another registry was given.
+func useHost(fs *flag.FlagSet, host string) error {
+	if host == "" {
+		return nil
+	}
+	url := fs.Lookup("url").Value.String()
+	if url == "" {
+		return fs.Set("url", "https://"+host)
+	}
+	current := url
+	if i := strings.Index(current, "://"); i >= 0 {
+		current = current[i+3:]
+	}
+	if !strings.EqualFold(strings.TrimSuffix(current, "/"), host) {
+		return fmt.Errorf("image of %s does not belong to -url %s", host, url)
+	}
+	return nil
+}
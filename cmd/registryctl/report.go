@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/caeret/registry"
+)
+
+func runReport(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: registryctl report age|duplicates|dangling|drift|trend [flags] [repo...]")
+	}
+	switch args[0] {
+	case "age":
+		return runAgeReport(args[1:])
+	case "duplicates":
+		return runDuplicatesReport(args[1:])
+	case "dangling":
+		return runDanglingReport(args[1:])
+	case "drift":
+		return runDriftReport(args[1:])
+	case "trend":
+		return runTrendReport(args[1:])
+	default:
+		return fmt.Errorf("unknown report %q", args[0])
+	}
+}
+
+func runAgeReport(args []string) error {
+	fs := flag.NewFlagSet("report age", flag.ExitOnError)
+	connect := clientFlags(fs)
+	format := formatFlag(fs)
+	filter := filterFlag(fs)
+	annotations := annotationsFlag(fs)
+	fs.Parse(args)
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	repos, err := selectRepos(c, *filter, fs.Args())
+	if err != nil {
+		return err
+	}
+	opts := registry.InventoryOptions{Repositories: repos, Images: true, Cached: true}
+	for _, k := range *annotations {
+		opts.Annotations = append(opts.Annotations, annotationKey(k))
+	}
+	inv, err := c.Inventory(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	report := inv.AgeReport()
+	return writeReport(*format, report, func() error {
+		return printAgeReport(report)
+	})
+}
+
+func printAgeReport(report *registry.AgeReport) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	keys := report.Annotations
+	fmt.Fprint(w, "REPOSITORY\tTAG\tKIND\tCREATED\tDAYS\tCHART\tDIGEST")
+	for _, k := range keys {
+		fmt.Fprint(w, "\t"+strings.ToUpper(strings.TrimPrefix(k, "org.opencontainers.image.")))
+	}
+	fmt.Fprintln(w)
+	for _, t := range report.Tags {
+		chart := "-"
+		if t.Chart != nil {
+			chart = t.Chart.Name + "-" + t.Chart.Version
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s", t.Repository, t.Tag, t.Kind, t.Created.Format(time.RFC3339), t.DaysSinceCreated, chart, t.Digest)
+		for _, k := range keys {
+			v := t.Annotations[k]
+			if v == "" {
+				v = "-"
+			}
+			fmt.Fprint(w, "\t"+v)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "REPOSITORY\tTAGS\tOLDEST\tNEWEST")
+	for _, r := range report.Repositories {
+		fmt.Fprintf(w, "%s\t%d\t%s (%dd)\t%s (%dd)\n", r.Repository, r.Tags,
+			r.Oldest.Tag, r.Oldest.DaysSinceCreated, r.Newest.Tag, r.Newest.DaysSinceCreated)
+	}
+	return w.Flush()
+}
+
+func runDuplicatesReport(args []string) error {
+	fs := flag.NewFlagSet("report duplicates", flag.ExitOnError)
+	connect := clientFlags(fs)
+	format := formatFlag(fs)
+	filter := filterFlag(fs)
+	fs.Parse(args)
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	repos, err := selectRepos(c, *filter, fs.Args())
+	if err != nil {
+		return err
+	}
+	report, err := c.Duplicates(context.Background(), repos...)
+	if err != nil {
+		return err
+	}
+	if err := writeReport(*format, report, func() error {
+		return printDuplicatesReport(report)
+	}); err != nil {
+		return err
+	}
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("%d failures: %s", len(report.Errors), strings.Join(report.Errors, "; "))
+	}
+	return nil
+}
+
+func printDuplicatesReport(report *registry.DuplicateReport) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DIGEST\tREPOSITORIES\tTAGS")
+	for _, g := range report.Groups {
+		var tags []string
+		for _, t := range g.Tags {
+			tags = append(tags, t.String())
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", g.Digest, g.Repositories, strings.Join(tags, " "))
+	}
+	return w.Flush()
+}
+
+func runDanglingReport(args []string) error {
+	fs := flag.NewFlagSet("report dangling", flag.ExitOnError)
+	connect := clientFlags(fs)
+	format := formatFlag(fs)
+	filter := filterFlag(fs)
+	del := fs.Bool("delete", false, "delete the dangling referrers found")
+	fs.Parse(args)
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	repos, err := selectRepos(c, *filter, fs.Args())
+	if err != nil {
+		return err
+	}
+	report, err := c.DanglingReferrers(context.Background(), registry.DanglingOptions{Repositories: repos, Delete: *del})
+	if err != nil {
+		return err
+	}
+	err = writeReport(*format, report, func() error {
+		return printDanglingReport(report)
+	})
+	if err == nil && len(report.Errors) > 0 {
+		err = fmt.Errorf("%d failures: %s", len(report.Errors), strings.Join(report.Errors, "; "))
+	}
+	return err
+}
+
+func printDanglingReport(report *registry.DanglingReport) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tTAG\tDIGEST\tSUBJECT\tDELETED")
+	for _, r := range report.Referrers {
+		tag := r.Tag
+		if tag == "" {
+			tag = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", r.Repository, tag, r.Digest, r.Subject, r.Deleted)
+	}
+	return w.Flush()
+}
+
+func runDriftReport(args []string) error {
+	fs := flag.NewFlagSet("report drift", flag.ExitOnError)
+	connect := clientFlags(fs)
+	format := formatFlag(fs)
+	filter := filterFlag(fs)
+	tag := fs.String("tag", "latest", "floating `tag` to check")
+	versions := fs.String("versions", "", "only the releases in `range`, such as '>=1.0 <2.0'")
+	prerelease := fs.Bool("prerelease", false, "count prerelease versions as releases")
+	fs.Parse(args)
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	repos, err := selectRepos(c, *filter, fs.Args())
+	if err != nil {
+		return err
+	}
+	report, err := c.Drift(context.Background(), registry.DriftOptions{Repositories: repos, Tag: *tag, Versions: *versions, Prerelease: *prerelease})
+	if err != nil {
+		return err
+	}
+	err = writeReport(*format, report, func() error {
+		return printDriftReport(report)
+	})
+	if err == nil && len(report.Errors) > 0 {
+		err = fmt.Errorf("%d failures: %s", len(report.Errors), strings.Join(report.Errors, "; "))
+	}
+	if err == nil && report.Drifted() > 0 {
+		err = fmt.Errorf("%d drifted tags", report.Drifted())
+	}
+	return err
+}
+
+func printDriftReport(report *registry.DriftReport) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tTAG\tRELEASE\tDRIFTED\tPOINTS AT")
+	for _, t := range report.Tags {
+		at := strings.Join(t.Versions, ", ")
+		if at == "" {
+			at = t.Digest
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\n", t.Repository, t.Tag, t.Release, t.Drifted, at)
+	}
+	return w.Flush()
+}
+
+func runTrendReport(args []string) error {
+	fs := flag.NewFlagSet("report trend", flag.ExitOnError)
+	from := fs.String("from", "", "`location` the snapshots were saved to with snapshot -to")
+	format := formatFlag(fs)
+	since := fs.Duration("since", 0, "compare the latest snapshot with the first taken within this `duration`, the previous one when unset")
+	store := storeFlags(fs)
+	fs.Parse(args)
+	if *from == "" {
+		return fmt.Errorf("no -from location given")
+	}
+	s, err := store(*from)
+	if err != nil {
+		return err
+	}
+	var start time.Time
+	if *since > 0 {
+		start = time.Now().Add(-*since)
+	}
+	snapshots, err := registry.LoadSnapshots(s, start)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) < 2 {
+		return fmt.Errorf("%d snapshots in %s, need at least 2", len(snapshots), *from)
+	}
+	first := snapshots[0]
+	if *since == 0 {
+		first = snapshots[len(snapshots)-2]
+	}
+	delta := registry.CompareSnapshots(first, snapshots[len(snapshots)-1])
+	if fs.NArg() > 0 {
+		repos := make(map[string]bool)
+		for _, repo := range fs.Args() {
+			repos[repo] = true
+		}
+		var filtered []registry.RepositoryDelta
+		for _, r := range delta.Repositories {
+			if repos[r.Repository] {
+				filtered = append(filtered, r)
+			}
+		}
+		delta.Repositories = filtered
+	}
+	return writeReport(*format, delta, func() error {
+		return printTrendReport(delta)
+	})
+}
+
+func printTrendReport(delta *registry.SnapshotDelta) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s to %s: %d to %d bytes (%+d, %+d/day)\n\n",
+		delta.From.Format(time.RFC3339), delta.To.Format(time.RFC3339), delta.OldSize, delta.NewSize, delta.Delta, delta.PerDay)
+	fmt.Fprintln(w, "REPOSITORY\tTAGS\tSIZE\tDELTA\tPER DAY\tADDED\tREMOVED")
+	for _, r := range delta.Repositories {
+		fmt.Fprintf(w, "%s\t%d (%+d)\t%d\t%+d\t%+d\t%d\t%d\n", r.Repository, r.NewTags, r.NewTags-r.OldTags,
+			r.NewSize, r.Delta, r.PerDay, r.Added, r.Removed)
+	}
+	return w.Flush()
+}
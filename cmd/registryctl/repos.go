@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/caeret/registry"
+)
+
+func runRepos(args []string) error {
+	fs := flag.NewFlagSet("repos", flag.ExitOnError)
+	connect := clientFlags(fs)
+	filter := filterFlag(fs)
+	from := fs.String("from", "", "walk the catalog from the repository `name`, included")
+	to := fs.String("to", "", "walk the catalog up to the repository `name`, excluded")
+	cursorFile := fs.String("cursor", "", "`file` recording the progress of the walk, so an interrupted walk resumes")
+	pageSize := fs.Int("page-size", 0, "`number` of repositories asked for with every catalog page")
+	fs.Parse(args)
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	if *from != "" || *to != "" || *cursorFile != "" || *pageSize > 0 {
+		if *filter != "" {
+			return fmt.Errorf("-filter cannot be combined with -from, -to, -cursor or -page-size")
+		}
+		opts := registry.CatalogOptions{Range: registry.CatalogRange{From: *from, To: *to}, PageSize: *pageSize}
+		if *cursorFile != "" {
+			if opts.Cursor, err = registry.OpenCatalogCursor(*cursorFile); err != nil {
+				return err
+			}
+		}
+		return c.WalkCatalog(context.Background(), opts, func(repo string) error {
+			_, err := fmt.Println(repo)
+			return err
+		})
+	}
+	var repos []string
+	if *filter != "" {
+		repos, err = c.SearchRepositories(context.Background(), *filter)
+	} else {
+		repos, err = c.QueryRepositories()
+	}
+	if err != nil {
+		return err
+	}
+	for _, repo := range repos {
+		fmt.Println(repo)
+	}
+	return nil
+}
+
+// filterFlag registers the flag selecting repositories by name.
+func filterFlag(fs *flag.FlagSet) *string {
+	return fs.String("filter", "", "only the repositories matching `pattern`, a glob such as prod/* or a regular expression such as .*-cache$")
+}
+
+// selectRepos returns the repositories args, along with those matching
+// filter if set.
+func selectRepos(c *registry.Client, filter string, args []string) ([]string, error) {
+	if filter == "" {
+		return args, nil
+	}
+	repos, err := c.SearchRepositories(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(repos) == 0 {
+		return nil, fmt.Errorf("no repository matches %q", filter)
+	}
+	return append(args, repos...), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/caeret/registry"
+)
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	connect := clientFlags(fs)
+	from := fs.String("from", "", "backup `location` written with -backup-dir or backup: a directory or s3://bucket/prefix")
+	full := fs.Bool("full", false, "restore the tags of a full backup made with backup")
+	store := storeFlags(fs)
+	since := fs.Duration("since", 0, "only restore manifests deleted within this `duration`")
+	var digests stringsFlag
+	fs.Var(&digests, "digest", "only restore the manifest with this `digest`, may be repeated")
+	fs.Parse(args)
+	if *from == "" {
+		return fmt.Errorf("no -from location given")
+	}
+	s, err := store(*from)
+	if err != nil {
+		return err
+	}
+
+	opts := registry.RestoreOptions{Repositories: fs.Args(), Digests: digests}
+	if *since > 0 {
+		opts.Since = time.Now().Add(-*since)
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	if *full {
+		return restoreBackup(c, s, fs.Args())
+	}
+	result, err := c.Restore(context.Background(), s, opts)
+	if err != nil {
+		return err
+	}
+	for _, r := range result.Restored {
+		fmt.Printf("%s@%s restored %s\n", r.Repository, r.Digest, strings.Join(r.Tags, ","))
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d manifests failed: %s", len(result.Errors), strings.Join(result.Errors, "; "))
+	}
+	return nil
+}
+
+func restoreBackup(c *registry.Client, s registry.BackupStore, repos []string) error {
+	result, err := c.RestoreBackup(context.Background(), s, registry.RestoreBackupOptions{Repositories: repos})
+	if err != nil {
+		return err
+	}
+	for _, tag := range result.Tags {
+		fmt.Printf("%s restored\n", tag)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d tags failed: %s", len(result.Errors), strings.Join(result.Errors, "; "))
+	}
+	return nil
+}
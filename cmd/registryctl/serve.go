@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/caeret/registry"
+	"github.com/caeret/registry/server"
+)
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	connect := clientFlags(fs)
+	listen := fs.String("listen", ":8080", "listen `address`")
+	token := fs.String("token", os.Getenv("REGISTRYCTL_TOKEN"), "API `token` clients must present")
+	policyFile := fs.String("policy", "", "JSON policy `file` run on the repositories registry notifications report pushes to")
+	checkpointFile := fs.String("checkpoint", "", "`file` recording the progress of clean runs, resumed on restart")
+	grace := fs.Duration("shutdown-timeout", 30*time.Second, "maximum `duration` to wait for the running clean on shutdown")
+	fs.Parse(args)
+	if *token == "" {
+		return fmt.Errorf("no API token given")
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	s := server.New(c, *token)
+	if *policyFile != "" {
+		var policy registry.Policy
+		if err := readJSON(*policyFile, &policy); err != nil {
+			return err
+		}
+		s.SetPolicy(policy)
+	}
+	if *checkpointFile != "" {
+		cp, err := registry.OpenCheckpoint(*checkpointFile)
+		if err != nil {
+			return err
+		}
+		s.SetCheckpoint(cp)
+		s.Resume()
+	}
+
+	srv := &http.Server{Addr: *listen, Handler: s}
+	stopped := make(chan error, 1)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		c.Info("shut down.")
+		ctx, cancel := context.WithTimeout(context.Background(), *grace)
+		defer cancel()
+		err := srv.Shutdown(ctx)
+		if serr := s.Shutdown(ctx); err == nil {
+			err = serr
+		}
+		stopped <- err
+	}()
+	c.Info("listen.", "address", *listen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return <-stopped
+}
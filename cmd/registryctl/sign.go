@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/caeret/registry"
+)
+
+func runSign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	connect := clientFlags(fs)
+	key := fs.String("key", "", "private key `file`, awskms://[endpoint]/key-id or hashivault://key")
+	fs.Parse(args)
+	repo, ref, rest, err := imageArgs(fs, fs.Args())
+	if err != nil {
+		return err
+	}
+	if repo == "" || len(rest) > 0 || *key == "" {
+		return fmt.Errorf("usage: registryctl sign -key key [flags] repo ref|repo:tag|repo@digest")
+	}
+	signer, err := newSigner(*key)
+	if err != nil {
+		return err
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	desc, err := c.Repository(repo).Resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if err := c.SignImage(ctx, repo, desc.Digest, signer); err != nil {
+		return err
+	}
+	fmt.Println(desc.Digest)
+	return nil
+}
+
+// newSigner returns the signer of key, a private key file or a cosign KMS
+// URI. Credentials are read from the usual AWS and Vault environment
+// variables.
+func newSigner(key string) (registry.Signer, error) {
+	switch {
+	case strings.HasPrefix(key, "awskms://"):
+		endpoint := strings.TrimPrefix(key, "awskms://")
+		i := strings.Index(endpoint, "/")
+		if i < 0 || i == len(endpoint)-1 {
+			return nil, fmt.Errorf("no key in %s", key)
+		}
+		s := &registry.AWSKMSSigner{
+			KeyID:     endpoint[i+1:],
+			Region:    os.Getenv("AWS_REGION"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}
+		if i > 0 {
+			s.Endpoint = "https://" + endpoint[:i]
+		}
+		if s.Region == "" {
+			s.Region = "us-east-1"
+		}
+		return s, nil
+	case strings.HasPrefix(key, "hashivault://"):
+		return &registry.VaultSigner{
+			Address: os.Getenv("VAULT_ADDR"),
+			Token:   os.Getenv("VAULT_TOKEN"),
+			Key:     strings.TrimPrefix(key, "hashivault://"),
+		}, nil
+	}
+	b, err := ioutil.ReadFile(key)
+	if err != nil {
+		return nil, err
+	}
+	return registry.NewPrivateKeySigner(b)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/caeret/registry"
+)
+
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	snapshot := fs.String("snapshot", "", "snapshot `file` written with snapshot -o")
+	policyFile := fs.String("policy", "", "JSON policy `file`")
+	format := formatFlag(fs)
+	all := fs.Bool("all", false, "list the kept images too")
+	fs.Parse(args)
+	if *snapshot == "" || *policyFile == "" {
+		return fmt.Errorf("usage: registryctl simulate -snapshot file -policy file")
+	}
+	var s registry.Snapshot
+	if err := readJSON(*snapshot, &s); err != nil {
+		return err
+	}
+	var policy registry.Policy
+	if err := readJSON(*policyFile, &policy); err != nil {
+		return err
+	}
+	sim, err := registry.SimulatePolicy(context.Background(), &s, policy)
+	if err != nil {
+		return err
+	}
+	return writeReport(*format, sim, func() error {
+		return printSimulation(sim, *all)
+	})
+}
+
+func printSimulation(sim *registry.PolicySimulation, all bool) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERDICT\tREPOSITORY\tTAGS\tRULE\tRECLAIMABLE\tDIGEST")
+	for _, d := range sim.Decisions {
+		verdict := "keep"
+		if d.Delete {
+			verdict = "delete"
+		} else if !all {
+			continue
+		}
+		var tags []string
+		for _, t := range d.Tags {
+			if t.Repository == d.Repository {
+				tags = append(tags, t.Tag)
+			} else {
+				tags = append(tags, t.String())
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", verdict, d.Repository, strings.Join(tags, ","), d.Rule, d.Reclaimable, d.Digest)
+	}
+	fmt.Fprintf(w, "\n%d images deleted, %d kept, %d bytes reclaimable\n", sim.Deleted, sim.Kept, sim.Reclaimable)
+	return w.Flush()
+}
+
+// readJSON decodes the JSON file path into v.
+func readJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
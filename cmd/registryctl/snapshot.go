@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/caeret/registry"
+)
+
+func runSnapshot(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	connect := clientFlags(fs)
+	to := fs.String("to", "", "save the snapshot in `location`, a directory or s3://bucket/prefix, for report trend")
+	output := fs.String("o", "", "write the snapshot as JSON to `file`")
+	store := storeFlags(fs)
+	format := formatFlag(fs)
+	filter := filterFlag(fs)
+	fs.Parse(args)
+	if *to == "" && *output == "" {
+		return fmt.Errorf("no -to location or -o file given")
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	repos, err := selectRepos(c, *filter, fs.Args())
+	if err != nil {
+		return err
+	}
+	s, err := c.Snapshot(context.Background(), repos...)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		b, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*output, b, 0644); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		st, err := store(*to)
+		if err != nil {
+			return err
+		}
+		if err := registry.SaveSnapshot(st, s); err != nil {
+			return err
+		}
+	}
+	return writeReport(*format, s, func() error {
+		_, err := fmt.Printf("%d repositories, %d bytes\n", len(s.Repositories), s.Size)
+		return err
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/caeret/registry"
+)
+
+func runSquash(args []string) error {
+	fs := flag.NewFlagSet("squash", flag.ExitOnError)
+	connect := clientFlags(fs)
+	platform := fs.String("platform", "linux/amd64", "`os/arch[/variant]` to squash in multi-platform images")
+	layers := fs.Int("layers", 0, "merge the top `n` layers, all when 0")
+	comment := fs.String("comment", "", "history comment of the merged layer")
+	fs.Parse(args)
+	repo, ref, rest, err := imageArgs(fs, fs.Args())
+	if err != nil {
+		return err
+	}
+	if repo == "" || len(rest) != 1 {
+		return fmt.Errorf("usage: registryctl squash [flags] repo ref|repo:tag|repo@digest tag")
+	}
+	opts := registry.SquashOptions{Layers: *layers, Comment: *comment}
+	if opts.Platform, err = parsePlatform(*platform); err != nil {
+		return err
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	desc, err := c.Squash(context.Background(), repo, ref, rest[0], opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(desc.Digest)
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/caeret/registry"
+)
+
+func runTags(args []string) error {
+	fs := flag.NewFlagSet("tags", flag.ExitOnError)
+	connect := clientFlags(fs)
+	order := fs.String("sort", "", "sort by `order`: semver, created or number, newest first")
+	filter := fs.String("filter", "", "only the tags matching `pattern`, a glob such as v1.* or a regular expression")
+	versions := fs.String("versions", "", "only the tags holding a semantic version in `range`, such as '>=1.2 <2.0'")
+	annotations := annotationsFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: registryctl tags [flags] repo")
+	}
+	image := parseImageRef(fs.Arg(0))
+	if err := useHost(fs, image.Host); err != nil {
+		return err
+	}
+	repo := image.Repository
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	tags, err := c.SearchTags(repo, *filter, *versions)
+	if err != nil {
+		return err
+	}
+	switch *order {
+	case "":
+	case "semver":
+		registry.SortTagsBySemver(tags)
+	case "number":
+		registry.SortTagsByBuildNumber(tags)
+	case "created":
+		if err := c.SortTagsByCreated(context.Background(), repo, tags); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown sort order %q", *order)
+	}
+	if len(*annotations) > 0 {
+		return printTagAnnotations(c, repo, tags, *annotations)
+	}
+	for _, tag := range tags {
+		fmt.Println(tag)
+	}
+	return nil
+}
+
+// annotationsFlag adds the repeated -annotation flag. Keys without a dot are
+// short for org.opencontainers.image.<key>, like revision or source.
+func annotationsFlag(fs *flag.FlagSet) *stringsFlag {
+	var keys stringsFlag
+	fs.Var(&keys, "annotation", "show the annotation or image label `key`, such as revision, source or url (repeatable)")
+	return &keys
+}
+
+func annotationKey(key string) string {
+	if strings.Contains(key, ".") {
+		return key
+	}
+	return "org.opencontainers.image." + key
+}
+
+// printTagAnnotations prints tags in order with their digest and annotations.
+func printTagAnnotations(c *registry.Client, repo string, tags, keys []string) error {
+	listed := make(map[string]bool)
+	for _, tag := range tags {
+		listed[tag] = true
+	}
+	var annotations []string
+	for _, k := range keys {
+		annotations = append(annotations, annotationKey(k))
+	}
+	inv, err := c.Inventory(context.Background(), registry.InventoryOptions{
+		Repositories: []string{repo},
+		Annotations:  annotations,
+		Cached:       true,
+		SkipTag: func(repo, tag string) bool {
+			return !listed[tag]
+		},
+	})
+	if err != nil {
+		return err
+	}
+	images := make(map[string]registry.InventoryTag)
+	if r := inv.Repository(repo); r != nil {
+		for _, t := range r.Tags {
+			images[t.Tag] = t
+		}
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	header := []string{"TAG", "DIGEST"}
+	for _, k := range annotations {
+		header = append(header, strings.ToUpper(strings.TrimPrefix(k, "org.opencontainers.image.")))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, tag := range tags {
+		t, ok := images[tag]
+		if !ok {
+			continue
+		}
+		fields := []string{tag, t.Digest}
+		for _, k := range annotations {
+			v := "-"
+			if t.Image != nil && t.Image.Annotations[k] != "" {
+				v = t.Image.Annotations[k]
+			}
+			fields = append(fields, v)
+		}
+		fmt.Fprintln(w, strings.Join(fields, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(inv.Errors) > 0 {
+		return fmt.Errorf("%d failures: %s", len(inv.Errors), strings.Join(inv.Errors, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/caeret/registry"
+)
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	connect := clientFlags(fs)
+	format := formatFlag(fs)
+	key := fs.String("key", "", "cosign public key `file`")
+	identity := fs.String("identity", "", "accept keyless cosign signatures of `identity`, an email address or URI")
+	issuer := fs.String("issuer", "", "OIDC `issuer` of keyless signatures")
+	roots := fs.String("roots", "", "Fulcio root and intermediate CA certificates `file` (PEM), required by -identity")
+	rekor := fs.String("rekor", "", "require keyless signatures to be in the Rekor log at `url`, such as https://rekor.sigstore.dev")
+	rekorKey := fs.String("rekor-key", "", "public key `file` of the Rekor log, required by -rekor to check signed entry timestamps")
+	trustPolicy := fs.String("trust-policy", "", "Notation trust policy `file`")
+	trustStore := fs.String("trust-store", "", "Notation trust store `directory`, ~/.config/notation/truststore when empty")
+	fs.Parse(args)
+	repo, ref, rest, err := imageArgs(fs, fs.Args())
+	if err != nil {
+		return err
+	}
+	if repo == "" || len(rest) > 0 {
+		return fmt.Errorf("usage: registryctl verify -key file|-identity id|-trust-policy file [flags] repo ref|repo:tag|repo@digest")
+	}
+	var verifier registry.SignatureVerifier
+	switch {
+	case *key != "" && *identity == "" && *trustPolicy == "":
+		b, err := ioutil.ReadFile(*key)
+		if err != nil {
+			return err
+		}
+		if verifier, err = registry.NewPublicKeyVerifier(b); err != nil {
+			return err
+		}
+	case *identity != "" && *key == "" && *trustPolicy == "":
+		if *roots == "" {
+			return fmt.Errorf("-identity requires -roots, the Fulcio CA certificates the signing certificate must chain up to")
+		}
+		b, err := ioutil.ReadFile(*roots)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("%s: no PEM certificates", *roots)
+		}
+		v := &registry.KeylessVerifier{Identity: *identity, Issuer: *issuer, Roots: pool}
+		if *rekor != "" {
+			if *rekorKey == "" {
+				return fmt.Errorf("-rekor requires -rekor-key, the public key of the log served at %s/api/v1/log/publicKey", strings.TrimSuffix(*rekor, "/"))
+			}
+			v.Rekor = &registry.RekorLog{URL: *rekor}
+			b, err := ioutil.ReadFile(*rekorKey)
+			if err != nil {
+				return err
+			}
+			if v.Rekor.PublicKey, err = registry.NewRekorPublicKey(b); err != nil {
+				return err
+			}
+		}
+		verifier = v
+	case *trustPolicy != "" && *key == "" && *identity == "":
+		v := &registry.NotationVerifier{}
+		b, err := ioutil.ReadFile(*trustPolicy)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &v.Policy); err != nil {
+			return fmt.Errorf("%s: %v", *trustPolicy, err)
+		}
+		dir := *trustStore
+		if dir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return err
+			}
+			dir = filepath.Join(home, ".config", "notation", "truststore")
+		}
+		if v.TrustStores, err = registry.LoadNotationTrustStores(dir); err != nil {
+			return err
+		}
+		verifier = v
+	default:
+		return fmt.Errorf("give one of -key, -identity and -trust-policy")
+	}
+	c, err := connect()
+	if err != nil {
+		return err
+	}
+	result, err := c.VerifySignature(context.Background(), repo, ref, verifier)
+	if err != nil {
+		return err
+	}
+	err = writeReport(*format, result, func() error {
+		return printVerificationResult(result)
+	})
+	if err == nil && !result.Verified {
+		err = fmt.Errorf("no valid signature of %s@%s", repo, result.Digest)
+	}
+	return err
+}
+
+func printVerificationResult(result *registry.VerificationResult) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FORMAT\tMANIFEST\tIDENTITY\tVERIFIED\tREKOR\tERROR")
+	for _, s := range result.Signatures {
+		identity, rekor, reason := s.Identity, "-", s.Error
+		if identity == "" {
+			identity = "-"
+		}
+		if s.Rekor != nil {
+			rekor = fmt.Sprintf("%d@%s", s.Rekor.LogIndex, s.Rekor.IntegratedTime.Format(time.RFC3339))
+		}
+		if reason == "" {
+			reason = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\n", s.Format, s.Manifest, identity, s.Verified, rekor, reason)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,67 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// JSONCodec encodes and decodes the JSON the package reads and writes:
+// registry responses, manifests it builds, and its files such as caches and
+// checkpoints. The API of jsoniter satisfies it, so
+//
+//	registry.SetJSONCodec(jsoniter.ConfigCompatibleWithStandardLibrary)
+//
+// opts in to jsoniter without the package depending on it.
+type JSONCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// StdJSON is the codec of encoding/json, used unless SetJSONCodec replaces
+// it.
+var StdJSON JSONCodec = stdJSON{}
+
+var jsonCodec = StdJSON
+
+// SetJSONCodec makes the package use c for all its JSON, StdJSON when nil.
+// It is meant to be called once, before using the package.
+func SetJSONCodec(c JSONCodec) {
+	if c == nil {
+		c = StdJSON
+	}
+	jsonCodec = c
+}
+
+type stdJSON struct{}
+
+func (stdJSON) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (stdJSON) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// marshalJSON encodes v with the codec of the package.
+func marshalJSON(v interface{}) ([]byte, error) {
+	return jsonCodec.Marshal(v)
+}
+
+// marshalIndentJSON encodes v with the codec of the package, indented as by
+// json.MarshalIndent.
+func marshalIndentJSON(v interface{}, prefix, indent string) ([]byte, error) {
+	b, err := jsonCodec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, prefix, indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalJSON decodes data into v with the codec of the package.
+func unmarshalJSON(data []byte, v interface{}) error {
+	return jsonCodec.Unmarshal(data, v)
+}
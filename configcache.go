@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultConfigCacheSize is the memory clients keep image configs in unless
+// given another ConfigCache.
+const DefaultConfigCacheSize = 16 << 20
+
+// ConfigCache keeps image configs by digest in memory and optionally in a
+// directory, so age and label policies evaluated over and over fetch every
+// config once. Configs are addressed by digest and never stale; past the
+// size limit the least recently used ones are dropped from memory. It is safe
+// for concurrent use and may be shared by clients of several registries.
+type ConfigCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	configs map[string]*list.Element
+}
+
+type cachedConfig struct {
+	digest string
+	data   []byte
+}
+
+// NewConfigCache returns a cache holding up to maxBytes of configs in memory,
+// DefaultConfigCacheSize when maxBytes is 0 and none when it is negative. When
+// dir is set configs are also written there, one file per digest, and reused
+// by later runs.
+func NewConfigCache(maxBytes int64, dir string) *ConfigCache {
+	if maxBytes == 0 {
+		maxBytes = DefaultConfigCacheSize
+	}
+	return &ConfigCache{dir: dir, maxBytes: maxBytes, lru: list.New(), configs: make(map[string]*list.Element)}
+}
+
+// WithConfigCache makes the client keep image configs in cache instead of a
+// cache of its own, such as one shared with other clients or backed by a
+// directory. A nil cache turns config caching off.
+func WithConfigCache(cache *ConfigCache) Option {
+	return func(c *Client) {
+		c.configs = cache
+	}
+}
+
+// Len returns the number of configs held in memory.
+func (c *ConfigCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *ConfigCache) get(digest string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	if e, ok := c.configs[digest]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*cachedConfig).data, true
+	}
+	c.mu.Unlock()
+	path := c.path(digest)
+	if path == "" {
+		return nil, false
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	if verifyDigest(digest, b) != nil {
+		os.Remove(path)
+		return nil, false
+	}
+	c.add(digest, b)
+	return b, true
+}
+
+// put caches the config digest, returning the error of writing it to the
+// directory of the cache.
+func (c *ConfigCache) put(digest string, b []byte) error {
+	if c == nil {
+		return nil
+	}
+	c.add(digest, b)
+	path := c.path(digest)
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (c *ConfigCache) add(digest string, b []byte) {
+	if int64(len(b)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.configs[digest]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.configs[digest] = c.lru.PushFront(&cachedConfig{digest, b})
+	c.size += int64(len(b))
+	for c.size > c.maxBytes {
+		e := c.lru.Back()
+		config := c.lru.Remove(e).(*cachedConfig)
+		delete(c.configs, config.digest)
+		c.size -= int64(len(config.data))
+	}
+}
+
+// remove drops the config digest from memory and the directory of the cache.
+func (c *ConfigCache) remove(digest string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if e, ok := c.configs[digest]; ok {
+		config := c.lru.Remove(e).(*cachedConfig)
+		delete(c.configs, digest)
+		c.size -= int64(len(config.data))
+	}
+	c.mu.Unlock()
+	if path := c.path(digest); path != "" {
+		os.Remove(path)
+	}
+}
+
+// path returns the file of digest in the directory of the cache, "" without
+// a directory or for invalid digests.
+func (c *ConfigCache) path(digest string) string {
+	if c.dir == "" || ValidateDigest(digest) != nil {
+		return ""
+	}
+	parts := strings.SplitN(digest, ":", 2)
+	return filepath.Join(c.dir, parts[0], parts[1])
+}
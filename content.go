@@ -0,0 +1,284 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned when the requested manifest or blob does not exist.
+var ErrNotFound = errors.New("not found")
+
+// manifestAccept lists the manifest media types the client understands.
+var manifestAccept = []string{
+	MediaTypeOCIManifest,
+	MediaTypeOCIIndex,
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+}
+
+func isIndex(mediaType string) bool {
+	return mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerManifestList
+}
+
+// getManifest fetches the manifest identified by ref from the registry
+// repository name, returning its raw bytes as served.
+func (c *Client) getManifest(ctx context.Context, name, ref string) ([]byte, Descriptor, error) {
+	header := http.Header{}
+	header.Set("Accept", strings.Join(manifestAccept, ", "))
+	resp, body, err := c.fetchWithHeader(ctx, fmt.Sprintf("/v2/%s/manifests/%s", name, ref), fmt.Sprintf("repository:%s:*", name), header)
+	if err != nil {
+		return nil, Descriptor{}, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, Descriptor{}, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, Descriptor{}, statusError(resp, body)
+	}
+	desc := Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    manifestDigest(resp, ref, body),
+		Size:      int64(len(body)),
+	}
+	if strings.Contains(ref, ":") {
+		if err := verifyDigest(ref, body); err != nil {
+			return nil, Descriptor{}, err
+		}
+	}
+	var m struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := decodeResponse(resp, body, &m); err != nil {
+		return nil, Descriptor{}, err
+	}
+	desc.MediaType = strings.TrimSpace(strings.Split(desc.MediaType, ";")[0])
+	if desc.MediaType == "" || desc.MediaType == "application/json" {
+		desc.MediaType = m.MediaType
+	}
+	return body, desc, nil
+}
+
+// manifestDigest returns the digest of the manifest body served in resp for
+// ref, as reported in the Docker-Content-Digest header, or computed from body
+// with the algorithm of ref for registries and proxies omitting the header.
+func manifestDigest(resp *http.Response, ref string, body []byte) string {
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest
+	}
+	return computeDigest(ref, body)
+}
+
+// GetManifestRaw fetches the manifest identified by the tag or digest ref
+// exactly as stored, returning its bytes, media type and digest. The digest
+// is computed locally, with the algorithm of ref or else of the digest
+// reported by the registry, and checked against both.
+func (c *Client) GetManifestRaw(repo, ref string) ([]byte, string, string, error) {
+	body, desc, err := c.getManifest(context.Background(), c.repoName(repo), ref)
+	if err != nil {
+		return nil, "", "", err
+	}
+	like := desc.Digest
+	if strings.Contains(ref, ":") {
+		like = ref
+	}
+	digest := computeDigest(like, body)
+	if _, err := newDigester(desc.Digest); err == nil {
+		if got := computeDigest(desc.Digest, body); got != desc.Digest {
+			return nil, "", "", fmt.Errorf("manifest digest %s does not match reported digest %s", got, desc.Digest)
+		}
+	}
+	if strings.Contains(ref, ":") && ref != digest {
+		return nil, "", "", fmt.Errorf("manifest digest %s does not match %s", digest, ref)
+	}
+	return body, desc.MediaType, digest, nil
+}
+
+// resolve returns the descriptor of the manifest identified by ref, using a
+// HEAD request unless the registry omits the digest header.
+func (c *Client) resolve(ctx context.Context, name, ref string) (Descriptor, error) {
+	header := http.Header{}
+	header.Set("Accept", strings.Join(manifestAccept, ", "))
+	resp, err := c.head(ctx, fmt.Sprintf("/v2/%s/manifests/%s", name, ref), fmt.Sprintf("repository:%s:*", name), header)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return Descriptor{}, ErrNotFound
+	default:
+		return Descriptor{}, statusError(resp, nil)
+	}
+	desc := Descriptor{
+		MediaType: strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]),
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+		Size:      resp.ContentLength,
+	}
+	if desc.Digest == "" {
+		_, desc, err = c.getManifest(ctx, name, ref)
+	}
+	return desc, err
+}
+
+// putManifest pushes a manifest under ref and reports whether the registry
+// processed its subject field, as announced by the OCI-Subject header.
+func (c *Client) putManifest(ctx context.Context, name, ref, mediaType string, body []byte) (bool, error) {
+	header := http.Header{}
+	header.Set("Content-Type", mediaType)
+	resp, err := c.send(ctx, http.MethodPut, fmt.Sprintf("/v2/%s/manifests/%s", name, ref), fmt.Sprintf("repository:%s:*", name), header, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if !strings.Contains(ref, ":") {
+		c.cache.remove(tagCacheKey(c, name, ref))
+	}
+	if resp.StatusCode != http.StatusCreated {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return false, statusError(resp, b)
+	}
+	return resp.Header.Get("OCI-Subject") != "", nil
+}
+
+// StatBlob reports whether the blob identified by digest exists in repo and
+// its size, without downloading it.
+func (c *Client) StatBlob(repo, digest string) (int64, bool, error) {
+	return c.statBlob(context.Background(), c.repoName(repo), digest)
+}
+
+func (c *Client) statBlob(ctx context.Context, name, digest string) (int64, bool, error) {
+	resp, err := c.head(ctx, fmt.Sprintf("/v2/%s/blobs/%s", name, digest), fmt.Sprintf("repository:%s:*", name), http.Header{})
+	if err != nil {
+		return 0, false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, true, nil
+	case http.StatusNotFound:
+		return 0, false, nil
+	default:
+		return 0, false, statusError(resp, nil)
+	}
+}
+
+// blobExists checks whether the blob identified by digest exists in the
+// registry repository name.
+func (c *Client) blobExists(ctx context.Context, name, digest string) (bool, error) {
+	key := blobKey(c, name, digest)
+	if c.blobs.has(key) {
+		return true, nil
+	}
+	_, exists, err := c.statBlob(ctx, name, digest)
+	if exists {
+		c.blobs.add(key)
+	}
+	return exists, err
+}
+
+// openBlob starts downloading the blob identified by digest. The caller must
+// close the returned reader.
+func (c *Client) openBlob(ctx context.Context, name, digest string) (io.ReadCloser, int64, error) {
+	resp, err := c.send(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", name, digest), fmt.Sprintf("repository:%s:*", name), nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, 0, ErrNotFound
+		}
+		return nil, 0, statusError(resp, b)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// pushBlob uploads the blob described by desc in a single request, reading it
+// from open. Blobs the repository name already has are skipped without
+// opening them. When from names a repository of the same registry the blob is
+// mounted from there instead, falling back to an upload if the registry
+// refuses to mount.
+func (c *Client) pushBlob(ctx context.Context, name string, desc Descriptor, open func() (io.ReadCloser, error), from string) error {
+	exists, err := c.blobExists(ctx, name, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		c.Debug("skip existing blob.", "repo", name, "digest", desc.Digest)
+		return nil
+	}
+	scope := fmt.Sprintf("repository:%s:*", name)
+	path := fmt.Sprintf("/v2/%s/blobs/uploads/", name)
+	if from != "" {
+		scope += fmt.Sprintf(" repository:%s:pull", from)
+		path += fmt.Sprintf("?mount=%s&from=%s", url.QueryEscape(desc.Digest), url.QueryEscape(from))
+	}
+	resp, err := c.send(ctx, http.MethodPost, path, scope, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		c.Debug("mount blob.", "repo", name, "from", from, "digest", desc.Digest)
+		c.blobs.add(blobKey(c, name, desc.Digest))
+		return nil
+	case http.StatusAccepted:
+	default:
+		return statusError(resp, nil)
+	}
+	location, err := c.uploadLocation(resp)
+	if err != nil {
+		return err
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest)
+	location.RawQuery = query.Encode()
+
+	r, err := open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	req, err := c.newRequest(ctx, http.MethodPut, location.String(), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = desc.Size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = c.do(req, scope)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return statusError(resp, b)
+	}
+	c.blobs.add(blobKey(c, name, desc.Digest))
+	return nil
+}
+
+func (c *Client) uploadLocation(resp *http.Response) (*url.URL, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return nil, fmt.Errorf("no upload location")
+	}
+	base, err := url.Parse(c.url)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	return base.ResolveReference(ref), nil
+}
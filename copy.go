@@ -0,0 +1,200 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// cosignSuffixes are the tag suffixes cosign uses to attach signatures,
+// attestations and SBOMs to an image.
+var cosignSuffixes = []string{".sig", ".att", ".sbom"}
+
+// CopyOptions controls how images are copied between registries.
+type CopyOptions struct {
+	// Referrers also copies the signatures, SBOMs and attestations attached
+	// to the image, both referrers and cosign-style tags, so the copy stays
+	// verifiable.
+	Referrers bool
+	// BlobCache records destination blobs known to exist. Mirror uses a
+	// fresh cache for every run when none is given.
+	BlobCache *BlobCache
+	// Signer signs the copied images in the destination with cosign
+	// signatures, after their referrers were copied.
+	Signer Signer
+	// Progress, when set, has the run total the blobs to transfer before
+	// copying and tracks the transfer.
+	Progress *Progress
+}
+
+// Copy copies the image srcRepo:srcRef to dstRepo:dstRef in the registry of
+// dst, which may be c itself. Indexes are copied along with all their
+// manifests. An empty dstRef keeps the source reference.
+func (c *Client) Copy(ctx context.Context, srcRepo, srcRef string, dst *Client, dstRepo, dstRef string, opts CopyOptions) error {
+	if dstRef == "" {
+		dstRef = srcRef
+	}
+	if !opts.Progress.isStarted() {
+		if opts.BlobCache == nil {
+			opts.BlobCache = NewBlobCache()
+		}
+		if err := c.planCopy(ctx, srcRepo, srcRef, dst, dstRepo, opts); err != nil {
+			return err
+		}
+		opts.Progress.start()
+	}
+	desc, err := c.copyManifest(ctx, srcRepo, srcRef, dst, dstRepo, dstRef, opts)
+	if err != nil {
+		return err
+	}
+	c.Info("copy image.", "src", srcRepo+":"+srcRef, "dst", dstRepo+":"+dstRef, "digest", desc.Digest)
+	if opts.Referrers {
+		if err := c.copyReferrers(ctx, srcRepo, desc.Digest, dst, dstRepo, opts); err != nil {
+			return err
+		}
+	}
+	if opts.Signer != nil {
+		return dst.SignImage(ctx, dstRepo, desc.Digest, opts.Signer)
+	}
+	return nil
+}
+
+// copyReferrers copies everything attached to the manifest identified by
+// digest, recursing into artifacts that have attachments themselves.
+func (c *Client) copyReferrers(ctx context.Context, srcRepo, digest string, dst *Client, dstRepo string, opts CopyOptions) error {
+	referrers, err := c.Referrers(ctx, srcRepo, digest)
+	if err != nil {
+		return err
+	}
+	for _, referrer := range referrers {
+		if _, err := c.copyManifest(ctx, srcRepo, referrer.Digest, dst, dstRepo, referrer.Digest, opts); err != nil {
+			return err
+		}
+		c.Info("copy referrer.", "subject", digest, "digest", referrer.Digest, "artifactType", referrer.ArtifactType)
+		if err := c.copyReferrers(ctx, srcRepo, referrer.Digest, dst, dstRepo, opts); err != nil {
+			return err
+		}
+	}
+
+	for _, suffix := range cosignSuffixes {
+		tag := strings.Replace(digest, ":", "-", 1) + suffix
+		desc, err := c.copyManifest(ctx, srcRepo, tag, dst, dstRepo, tag, opts)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.Info("copy cosign artifact.", "subject", digest, "tag", tag, "digest", desc.Digest)
+	}
+	return nil
+}
+
+func (c *Client) copyManifest(ctx context.Context, srcRepo, srcRef string, dst *Client, dstRepo, dstRef string, opts CopyOptions) (Descriptor, error) {
+	srcName, dstName := c.repoName(srcRepo), dst.repoName(dstRepo)
+	body, desc, err := c.getManifest(ctx, srcName, srcRef)
+	if err != nil {
+		return desc, err
+	}
+
+	var subject *Descriptor
+	if isIndex(desc.MediaType) {
+		var index Index
+		if err := unmarshalJSON(body, &index); err != nil {
+			return desc, err
+		}
+		for _, m := range index.Manifests {
+			if _, err := c.copyManifest(ctx, srcRepo, m.Digest, dst, dstRepo, m.Digest, opts); err != nil {
+				return desc, err
+			}
+		}
+		subject = index.Subject
+		desc.ArtifactType = index.ArtifactType
+		desc.Annotations = index.Annotations
+	} else {
+		var manifest Manifest
+		if err := unmarshalJSON(body, &manifest); err != nil {
+			return desc, err
+		}
+		for _, blob := range append([]Descriptor{manifest.Config}, manifest.Layers...) {
+			if err := c.copyBlob(ctx, srcName, blob, dst, dstName, opts); err != nil {
+				return desc, err
+			}
+		}
+		subject = manifest.Subject
+		desc.ArtifactType = manifest.ArtifactType
+		if desc.ArtifactType == "" {
+			desc.ArtifactType = manifest.Config.MediaType
+		}
+		desc.Annotations = manifest.Annotations
+	}
+
+	processed, err := dst.putManifest(ctx, dstName, dstRef, desc.MediaType, body)
+	if err != nil {
+		return desc, err
+	}
+	if subject != nil && !processed {
+		// The destination lacks the referrers API, maintain the referrers
+		// tag schema instead.
+		if err := dst.addReferrerTag(ctx, dstName, subject.Digest, desc); err != nil {
+			return desc, err
+		}
+	}
+	return desc, nil
+}
+
+func (c *Client) copyBlob(ctx context.Context, srcName string, blob Descriptor, dst *Client, dstName string, opts CopyOptions) error {
+	if len(blob.URLs) > 0 {
+		// Non-distributable layers are fetched from their URLs by clients.
+		return nil
+	}
+	key := blobKey(dst, dstName, blob.Digest)
+	if opts.BlobCache.has(key) {
+		return nil
+	}
+	var from string
+	if c.url == dst.url {
+		from = srcName
+	}
+	err := dst.pushBlob(ctx, dstName, blob, func() (io.ReadCloser, error) {
+		r, _, err := c.openBlob(ctx, srcName, blob.Digest)
+		if err != nil {
+			return nil, err
+		}
+		return opts.Progress.reader(key, r), nil
+	}, from)
+	if err != nil {
+		opts.Progress.fail(key)
+		return err
+	}
+	opts.Progress.done(key, blob)
+	opts.BlobCache.add(key)
+	return nil
+}
+
+// addReferrerTag records desc in the referrers tag schema index of subject.
+func (c *Client) addReferrerTag(ctx context.Context, name, subject string, desc Descriptor) error {
+	tag := strings.Replace(subject, ":", "-", 1)
+	index := Index{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+	body, _, err := c.getManifest(ctx, name, tag)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if err == nil {
+		if err := unmarshalJSON(body, &index); err != nil {
+			return err
+		}
+	}
+	for _, m := range index.Manifests {
+		if m.Digest == desc.Digest {
+			return nil
+		}
+	}
+	index.Manifests = append(index.Manifests, desc)
+	b, err := marshalJSON(index)
+	if err != nil {
+		return err
+	}
+	_, err = c.putManifest(ctx, name, tag, MediaTypeOCIIndex, b)
+	return err
+}
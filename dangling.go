@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DanglingReferrer is a signature, SBOM, attestation or other artifact whose
+// subject manifest no longer exists.
+type DanglingReferrer struct {
+	Repository string `json:"repository"`
+	// Tag is the tag the artifact is known by, such as sha256-<hex>.sig. It
+	// is empty for artifacts only listed in a referrers tag index.
+	Tag     string `json:"tag,omitempty"`
+	Digest  string `json:"digest"`
+	Subject string `json:"subject"`
+	// Deleted is set when the artifact was deleted.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// DanglingReport lists the dangling referrers of a set of repositories.
+type DanglingReport struct {
+	Referrers []DanglingReferrer `json:"referrers"`
+	Errors    []string           `json:"errors,omitempty"`
+}
+
+// DanglingOptions configures DanglingReferrers.
+type DanglingOptions struct {
+	// Repositories are the repositories searched, all of them when empty.
+	Repositories []string
+	// Delete deletes the dangling referrers found.
+	Delete bool
+}
+
+// DanglingReferrers finds the artifacts whose subject manifest was deleted:
+// tags of the sha256-<hex>.sig, .att and .sbom conventions, referrers tag
+// indexes along with the artifacts they list, and tagged manifests whose
+// subject field names a missing manifest. Untagged artifacts only known to
+// the referrers API cannot be found once their subject is gone. Failures are
+// collected in the report and do not stop the search.
+func (c *Client) DanglingReferrers(ctx context.Context, opts DanglingOptions) (*DanglingReport, error) {
+	repos := opts.Repositories
+	if len(repos) == 0 {
+		var err error
+		if repos, err = c.queryRepositories(ctx); err != nil {
+			return nil, err
+		}
+	}
+	report := &DanglingReport{}
+	for _, repo := range repos {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		referrers, errs := c.danglingReferrers(ctx, repo)
+		report.Errors = append(report.Errors, errs...)
+		if opts.Delete {
+			// Artifacts listed in an index go before the index.
+			for i := len(referrers) - 1; i >= 0; i-- {
+				r := &referrers[i]
+				c.Info("delete dangling referrer.", "repo", repo, "digest", r.Digest, "subject", r.Subject)
+				if err := c.deleteTag(ctx, repo, r.Digest, nil, nil); err != nil {
+					report.Errors = append(report.Errors, fmt.Sprintf("%s@%s: %v", repo, r.Digest, err))
+					continue
+				}
+				r.Deleted = true
+			}
+		}
+		report.Referrers = append(report.Referrers, referrers...)
+	}
+	return report, nil
+}
+
+func (c *Client) danglingReferrers(ctx context.Context, repo string) ([]DanglingReferrer, []string) {
+	name := c.repoName(repo)
+	tags, err := c.queryTags(ctx, repo)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("%s: %v", repo, err)}
+	}
+	var referrers []DanglingReferrer
+	var errs []string
+	seen := make(map[string]bool)
+	add := func(tag, digest, subject string) {
+		if !seen[digest] {
+			seen[digest] = true
+			referrers = append(referrers, DanglingReferrer{Repository: repo, Tag: tag, Digest: digest, Subject: subject})
+		}
+	}
+	exists := make(map[string]bool)
+	missing := func(digest string) (bool, error) {
+		if ok, known := exists[digest]; known {
+			return !ok, nil
+		}
+		_, err := c.resolve(ctx, name, digest)
+		if err != nil && err != ErrNotFound {
+			return false, err
+		}
+		exists[digest] = err == nil
+		return err == ErrNotFound, nil
+	}
+
+	for _, tag := range tags {
+		var subject string
+		m := attachmentTag.FindStringSubmatch(tag)
+		if m != nil {
+			subject = strings.Replace(m[1], "-", ":", 1)
+			if gone, err := missing(subject); err != nil || !gone {
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s@%s: %v", repo, subject, err))
+				}
+				continue
+			}
+		}
+		body, desc, err := c.getManifest(ctx, name, tag)
+		if err != nil {
+			if err != ErrNotFound {
+				errs = append(errs, fmt.Sprintf("%s:%s: %v", repo, tag, err))
+			}
+			continue
+		}
+		if m == nil {
+			var manifest struct {
+				Subject struct {
+					Digest string `json:"digest"`
+				} `json:"subject"`
+			}
+			if err := unmarshalJSON(body, &manifest); err != nil {
+				errs = append(errs, fmt.Sprintf("%s:%s: %v", repo, tag, err))
+				continue
+			}
+			subject = manifest.Subject.Digest
+			if subject == "" {
+				continue
+			}
+			if gone, err := missing(subject); err != nil || !gone {
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s@%s: %v", repo, subject, err))
+				}
+				continue
+			}
+		}
+		add(tag, desc.Digest, subject)
+		if m != nil && m[2] == "" && isIndex(desc.MediaType) {
+			// The referrers tag schema lists the artifacts in an index.
+			var index Index
+			if err := unmarshalJSON(body, &index); err != nil {
+				errs = append(errs, fmt.Sprintf("%s:%s: %v", repo, tag, err))
+				continue
+			}
+			for _, d := range index.Manifests {
+				add("", d.Digest, subject)
+			}
+		}
+	}
+	return referrers, errs
+}
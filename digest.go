@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// digestAlgorithms are the digest algorithms content can be verified with,
+// along with the length of their hex encoding.
+var digestAlgorithms = map[string]struct {
+	new    func() hash.Hash
+	length int
+}{
+	"sha256": {sha256.New, 64},
+	"sha512": {sha512.New, 128},
+}
+
+// defaultAlgorithm is the algorithm of the digests the client computes.
+const defaultAlgorithm = "sha256"
+
+// digestAlgorithm returns the algorithm of digest, as in sha512:<hex>.
+func digestAlgorithm(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 {
+		return digest[:i]
+	}
+	return ""
+}
+
+// newDigester returns a hash computing digests of the algorithm of digest.
+func newDigester(digest string) (hash.Hash, error) {
+	if alg, ok := digestAlgorithms[digestAlgorithm(digest)]; ok {
+		return alg.new(), nil
+	}
+	return nil, fmt.Errorf("unsupported digest algorithm in %q", digest)
+}
+
+func digestString(digest string, h hash.Hash) string {
+	return fmt.Sprintf("%s:%x", digestAlgorithm(digest), h.Sum(nil))
+}
+
+// computeDigest returns the digest of b with the algorithm of like, or the
+// default algorithm if like is not a digest of a supported algorithm.
+func computeDigest(like string, b []byte) string {
+	h, err := newDigester(like)
+	if err != nil {
+		like = defaultAlgorithm + ":"
+		h = digestAlgorithms[defaultAlgorithm].new()
+	}
+	h.Write(b)
+	return digestString(like, h)
+}
+
+// verifyDigest checks that b has the given digest. Digests of unsupported
+// algorithms are not checked.
+func verifyDigest(digest string, b []byte) error {
+	if _, err := newDigester(digest); err != nil {
+		return nil
+	}
+	if got := computeDigest(digest, b); got != digest {
+		return fmt.Errorf("digest mismatch: got %s, expected %s", got, digest)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Response is the response to a request made with Do. The caller must close
+// its body.
+type Response struct {
+	*http.Response
+}
+
+// DoOption configures a request made with Do.
+type DoOption func(*doRequest)
+
+type doRequest struct {
+	scope  string
+	header http.Header
+	body   io.Reader
+	length int64
+}
+
+// WithScope sets the token scope the request is authorized for, such as
+// "repository:library/nginx:pull". By default the scope is derived from the
+// repository named in the path.
+func WithScope(scope string) DoOption {
+	return func(r *doRequest) {
+		r.scope = scope
+	}
+}
+
+// WithRequestHeader adds a header to the request.
+func WithRequestHeader(key, value string) DoOption {
+	return func(r *doRequest) {
+		r.header.Add(key, value)
+	}
+}
+
+// WithBody sets the request body and its length, -1 if unknown.
+func WithBody(body io.Reader, length int64) DoOption {
+	return func(r *doRequest) {
+		r.body = body
+		r.length = length
+	}
+}
+
+// repositoryPath matches the repository name in distribution API paths.
+var repositoryPath = regexp.MustCompile(`^/v2/(.+?)/(manifests|blobs|tags|referrers)/`)
+
+// Do sends a request for an endpoint the client does not model, such as a
+// vendor extension, handling authorization like other calls. The path is
+// relative to the registry URL, including the path prefix if any.
+func (c *Client) Do(ctx context.Context, method, path string, opts ...DoOption) (*Response, error) {
+	r := &doRequest{header: http.Header{}, length: -1}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.scope == "" {
+		r.scope = pathScope(path)
+	}
+	req, err := c.newRequest(ctx, method, path, r.body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if r.body != nil && r.length >= 0 {
+		req.ContentLength = r.length
+	}
+	resp, err := c.do(req, r.scope)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{resp}, nil
+}
+
+func pathScope(path string) string {
+	path = strings.SplitN(path, "?", 2)[0]
+	if strings.HasPrefix(path, "/v2/_catalog") {
+		return "registry:catalog:*"
+	}
+	if m := repositoryPath.FindStringSubmatch(path); m != nil {
+		return fmt.Sprintf("repository:%s:*", m[1])
+	}
+	return ""
+}
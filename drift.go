@@ -0,0 +1,122 @@
+package registry
+
+import (
+	"context"
+	"sort"
+)
+
+// TagDrift compares the floating tag of a repository with its highest
+// release.
+type TagDrift struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest"`
+	// Release is the highest version tag and ReleaseDigest its digest.
+	Release       string `json:"release"`
+	ReleaseDigest string `json:"releaseDigest"`
+	// Drifted is set when the floating tag does not point at the highest
+	// release.
+	Drifted bool `json:"drifted"`
+	// Versions are the version tags sharing the digest of the floating tag,
+	// highest first, showing which release it is stuck at.
+	Versions []string `json:"versions,omitempty"`
+}
+
+// DriftReport lists the floating tags of a set of repositories, drifted ones
+// first.
+type DriftReport struct {
+	Tags   []TagDrift `json:"tags"`
+	Errors []string   `json:"errors,omitempty"`
+}
+
+// Drifted returns the number of drifted tags of r.
+func (r *DriftReport) Drifted() int {
+	var n int
+	for _, t := range r.Tags {
+		if t.Drifted {
+			n++
+		}
+	}
+	return n
+}
+
+// DriftOptions configures Drift.
+type DriftOptions struct {
+	// Repositories are the repositories checked, all of them when empty.
+	Repositories []string
+	// Tag is the floating tag, latest when empty.
+	Tag string
+	// Versions restricts the releases to a semantic version range, such as
+	// ">=1.0 <2.0" for a tag following the 1.x line.
+	Versions string
+	// Prerelease counts prerelease versions as releases.
+	Prerelease bool
+}
+
+// Drift checks that the floating tag of every repository points at the
+// highest semantic version tag. Repositories without the floating tag or
+// without releases are left out.
+func (c *Client) Drift(ctx context.Context, opts DriftOptions) (*DriftReport, error) {
+	floating := opts.Tag
+	if floating == "" {
+		floating = "latest"
+	}
+	var r versionRange
+	if opts.Versions != "" {
+		var err error
+		if r, err = parseVersionRange(opts.Versions); err != nil {
+			return nil, err
+		}
+	}
+	release := func(tag string) bool {
+		v, ok := parseVersion(tag)
+		return ok && tag != floating && (opts.Prerelease || len(v.pre) == 0) && (r == nil || r.contains(v))
+	}
+	inv, err := c.Inventory(ctx, InventoryOptions{
+		Repositories: opts.Repositories,
+		Cached:       true,
+		SkipTag: func(repo, tag string) bool {
+			return tag != floating && !release(tag)
+		},
+		KeepRepository: func(repo string, tags []string) bool {
+			return contains(tags, floating)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DriftReport{Errors: inv.Errors}
+	for _, repo := range inv.Repositories {
+		drift := TagDrift{Repository: repo.Repository, Tag: floating}
+		digests := make(map[string]string)
+		var releases []string
+		for _, t := range repo.Tags {
+			if t.Tag == floating {
+				drift.Digest = t.Digest
+				continue
+			}
+			digests[t.Tag] = t.Digest
+			releases = append(releases, t.Tag)
+		}
+		if drift.Digest == "" || len(releases) == 0 {
+			continue
+		}
+		SortTagsBySemver(releases)
+		drift.Release, drift.ReleaseDigest = releases[0], digests[releases[0]]
+		drift.Drifted = drift.Digest != drift.ReleaseDigest
+		for _, tag := range releases {
+			if digests[tag] == drift.Digest {
+				drift.Versions = append(drift.Versions, tag)
+			}
+		}
+		if drift.Drifted {
+			c.Info("floating tag drifted.", "repo", repo.Repository, "tag", floating, "release", drift.Release)
+		}
+		report.Tags = append(report.Tags, drift)
+	}
+	sort.SliceStable(report.Tags, func(i, j int) bool {
+		return report.Tags[i].Drifted && !report.Tags[j].Drifted
+	})
+	return report, nil
+}
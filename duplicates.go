@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"context"
+	"sort"
+)
+
+// TagRef names a tag of a repository.
+type TagRef struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+}
+
+func (t TagRef) String() string {
+	return t.Repository + ":" + t.Tag
+}
+
+// DuplicateGroup lists the tags pointing at the same manifest.
+type DuplicateGroup struct {
+	Digest string   `json:"digest"`
+	Tags   []TagRef `json:"tags"`
+	// Repositories is the number of distinct repositories among Tags.
+	Repositories int `json:"repositories"`
+}
+
+// DuplicateReport lists the manifests referenced by more than one tag,
+// biggest groups first.
+type DuplicateReport struct {
+	Groups []DuplicateGroup `json:"groups"`
+	// Errors lists the repositories and tags that could not be resolved,
+	// whose tags are left out of the groups.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Duplicates groups the tags of repos, or of all repositories if none are
+// given, by the digest they point at and reports the digests with several
+// tags, such as latest aliasing a release tag.
+func (c *Client) Duplicates(ctx context.Context, repos ...string) (*DuplicateReport, error) {
+	inv, err := c.Inventory(ctx, InventoryOptions{Repositories: repos, Cached: true})
+	if err != nil {
+		return nil, err
+	}
+	report := &DuplicateReport{Errors: inv.Errors}
+	for digest, tags := range inv.tagsByDigest() {
+		if len(tags) < 2 {
+			continue
+		}
+		group := DuplicateGroup{Digest: digest, Tags: tags}
+		seen := make(map[string]bool)
+		for _, t := range tags {
+			if !seen[t.Repository] {
+				seen[t.Repository] = true
+				group.Repositories++
+			}
+		}
+		report.Groups = append(report.Groups, group)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if len(report.Groups[i].Tags) != len(report.Groups[j].Tags) {
+			return len(report.Groups[i].Tags) > len(report.Groups[j].Tags)
+		}
+		return report.Groups[i].Digest < report.Groups[j].Digest
+	})
+	return report, nil
+}
+
+// TagsForDigest returns the tags of repo currently pointing at the manifest
+// identified by digest.
+func (c *Client) TagsForDigest(repo, digest string) ([]string, error) {
+	ctx := context.Background()
+	tags, err := c.queryTags(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, tag := range tags {
+		desc, err := c.resolve(ctx, c.repoName(repo), tag)
+		if err == ErrNotFound {
+			// Deleted since listing.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if desc.Digest == digest {
+			matches = append(matches, tag)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,300 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeCSV writes header and the n rows returned by row to w. Reports are
+// written one row per item, with the columns named like their JSON fields.
+func writeCSV(w io.Writer, header []string, n int, row func(i int) []string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := cw.Write(row(i)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatInt(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// joinTags joins the tags of refs with spaces, naming them repo:tag unless
+// they belong to repo.
+func joinTags(refs []TagRef, repo string) string {
+	var tags []string
+	for _, t := range refs {
+		if t.Repository == repo {
+			tags = append(tags, t.Tag)
+		} else {
+			tags = append(tags, t.String())
+		}
+	}
+	return strings.Join(tags, " ")
+}
+
+// WriteCSV writes the deleted images of the run to w as CSV.
+func (r *CleanResult) WriteCSV(w io.Writer) error {
+	header := []string{"repository", "tag", "digest", "quarantined", "reclaimable"}
+	return writeCSV(w, header, len(r.Deleted), func(i int) []string {
+		d := r.Deleted[i]
+		return []string{d.Repository, d.Tag, d.Digest, d.Quarantined, formatInt(d.Reclaimable)}
+	})
+}
+
+// WriteCSV writes the images deleted in every registry to w as CSV.
+func (r *MultiCleanReport) WriteCSV(w io.Writer) error {
+	type row struct {
+		registry string
+		deleted  DeletedImage
+	}
+	var rows []row
+	for _, run := range r.Registries {
+		if run.Result == nil {
+			continue
+		}
+		for _, d := range run.Result.Deleted {
+			rows = append(rows, row{run.Registry, d})
+		}
+	}
+	header := []string{"registry", "repository", "tag", "digest", "quarantined", "reclaimable"}
+	return writeCSV(w, header, len(rows), func(i int) []string {
+		d := rows[i].deleted
+		return []string{rows[i].registry, d.Repository, d.Tag, d.Digest, d.Quarantined, formatInt(d.Reclaimable)}
+	})
+}
+
+// WriteCSV writes the tags of the report to w as CSV, with a column for each
+// of the annotations of the report.
+func (r *AgeReport) WriteCSV(w io.Writer) error {
+	header := append([]string{"repository", "tag", "kind", "digest", "created", "daysSinceCreated", "chart", "chartVersion"}, r.Annotations...)
+	return writeCSV(w, header, len(r.Tags), func(i int) []string {
+		t := r.Tags[i]
+		var chart, version string
+		if t.Chart != nil {
+			chart, version = t.Chart.Name, t.Chart.Version
+		}
+		row := []string{t.Repository, t.Tag, string(t.Kind), t.Digest, formatTime(t.Created), strconv.Itoa(t.DaysSinceCreated), chart, version}
+		for _, k := range r.Annotations {
+			row = append(row, t.Annotations[k])
+		}
+		return row
+	})
+}
+
+// WriteCSV writes the groups of the report to w as CSV, with the tags
+// named repo:tag and separated by spaces.
+func (r *DuplicateReport) WriteCSV(w io.Writer) error {
+	header := []string{"digest", "repositories", "tags"}
+	return writeCSV(w, header, len(r.Groups), func(i int) []string {
+		g := r.Groups[i]
+		return []string{g.Digest, strconv.Itoa(g.Repositories), joinTags(g.Tags, "")}
+	})
+}
+
+// WriteCSV writes the images of the snapshot to w as CSV, with the tags
+// separated by spaces.
+func (s *Snapshot) WriteCSV(w io.Writer) error {
+	type row struct {
+		repo  string
+		image *SnapshotImage
+	}
+	var rows []row
+	for i := range s.Repositories {
+		r := &s.Repositories[i]
+		for j := range r.Images {
+			rows = append(rows, row{r.Repository, &r.Images[j]})
+		}
+	}
+	header := []string{"repository", "digest", "mediaType", "kind", "tags", "created", "size"}
+	return writeCSV(w, header, len(rows), func(i int) []string {
+		image := rows[i].image
+		return []string{rows[i].repo, image.Digest, image.MediaType, string(image.Kind), strings.Join(image.Tags, " "), formatTime(image.Created), formatInt(image.Size)}
+	})
+}
+
+// WriteCSV writes the repositories of the delta to w as CSV.
+func (d *SnapshotDelta) WriteCSV(w io.Writer) error {
+	header := []string{"repository", "oldTags", "newTags", "oldSize", "newSize", "delta", "perDay", "added", "removed"}
+	return writeCSV(w, header, len(d.Repositories), func(i int) []string {
+		r := d.Repositories[i]
+		return []string{r.Repository, strconv.Itoa(r.OldTags), strconv.Itoa(r.NewTags), formatInt(r.OldSize), formatInt(r.NewSize),
+			formatInt(r.Delta), formatInt(r.PerDay), strconv.Itoa(r.Added), strconv.Itoa(r.Removed)}
+	})
+}
+
+// WriteCSV writes the decisions of the simulation to w as CSV, with the
+// tags separated by spaces.
+func (s *PolicySimulation) WriteCSV(w io.Writer) error {
+	header := []string{"repository", "digest", "tags", "delete", "rule", "reclaimable"}
+	return writeCSV(w, header, len(s.Decisions), func(i int) []string {
+		d := s.Decisions[i]
+		return []string{d.Repository, d.Digest, joinTags(d.Tags, d.Repository), strconv.FormatBool(d.Delete), d.Rule, formatInt(d.Reclaimable)}
+	})
+}
+
+// WriteCSV writes the verdicts of the evaluation to w as CSV, one row per
+// tag.
+func (e *PolicyEvaluation) WriteCSV(w io.Writer) error {
+	type row struct {
+		tag      TagRef
+		decision *PolicyDecision
+	}
+	var rows []row
+	for i := range e.Decisions {
+		d := &e.Decisions[i]
+		for _, t := range d.Tags {
+			rows = append(rows, row{t, d})
+		}
+	}
+	header := []string{"repository", "tag", "digest", "delete", "rule"}
+	return writeCSV(w, header, len(rows), func(i int) []string {
+		t, d := rows[i].tag, rows[i].decision
+		return []string{t.Repository, t.Tag, d.Digest, strconv.FormatBool(d.Delete), d.Rule}
+	})
+}
+
+// WriteCSV writes the decisions of the plan to w as CSV, with the tags
+// separated by spaces.
+func (p *Plan) WriteCSV(w io.Writer) error {
+	header := []string{"repository", "digest", "tags", "delete", "rule"}
+	return writeCSV(w, header, len(p.Decisions), func(i int) []string {
+		d := p.Decisions[i]
+		return []string{d.Repository, d.Digest, joinTags(d.Tags, d.Repository), strconv.FormatBool(d.Delete), d.Rule}
+	})
+}
+
+// WriteCSV writes the checks of the probe to w as CSV.
+func (r *ProbeReport) WriteCSV(w io.Writer) error {
+	header := []string{"check", "supported", "skipped", "detail"}
+	return writeCSV(w, header, len(r.Checks), func(i int) []string {
+		c := r.Checks[i]
+		return []string{c.Name, strconv.FormatBool(c.Supported), strconv.FormatBool(c.Skipped), c.Detail}
+	})
+}
+
+// inventoryRecord is a tag of an inventory as written by WriteNDJSON, flat
+// so data warehouses can load it as a table.
+type inventoryRecord struct {
+	Registry     string            `json:"registry"`
+	Repository   string            `json:"repository"`
+	Tag          string            `json:"tag"`
+	Digest       string            `json:"digest"`
+	MediaType    string            `json:"mediaType,omitempty"`
+	Kind         ArtifactKind      `json:"kind,omitempty"`
+	Platforms    []string          `json:"platforms,omitempty"`
+	Size         int64             `json:"size,omitempty"`
+	Created      string            `json:"created,omitempty"`
+	Chart        string            `json:"chart,omitempty"`
+	ChartVersion string            `json:"chartVersion,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Inventoried  string            `json:"inventoried"`
+}
+
+// WriteNDJSON writes the tags of the inventory to w as newline-delimited
+// JSON, one object per tag with the registry and the time of the inventory,
+// ready to be loaded into data warehouses. Repositories are named with the
+// path prefix of the inventory.
+func (inv *Inventory) WriteNDJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range inv.Repositories {
+		repo := r.Repository
+		if inv.Prefix != "" {
+			repo = inv.Prefix + "/" + repo
+		}
+		for _, t := range r.Tags {
+			rec := inventoryRecord{
+				Registry:    inv.Registry,
+				Repository:  repo,
+				Tag:         t.Tag,
+				Digest:      t.Digest,
+				Inventoried: formatTime(inv.Time),
+			}
+			if image := t.Image; image != nil {
+				rec.MediaType, rec.Kind, rec.Size = image.MediaType, image.Kind, image.Size
+				rec.Created = formatTime(image.Created)
+				for _, p := range image.Platforms {
+					rec.Platforms = append(rec.Platforms, p.String())
+				}
+				if image.Chart != nil {
+					rec.Chart, rec.ChartVersion = image.Chart.Name, image.Chart.Version
+				}
+				rec.Annotations = image.Annotations
+			}
+			b, err := marshalJSON(rec)
+			if err != nil {
+				return err
+			}
+			bw.Write(b)
+			if err := bw.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// inventoryPrefix is the prefix of the inventories kept in backup stores.
+const inventoryPrefix = "inventory/"
+
+// SaveInventory stores the inventory in store as newline-delimited JSON,
+// under inventory/dt=<date>/<time>.ndjson so warehouses can load it as a
+// table partitioned by day. It returns the key of the inventory.
+func SaveInventory(store BackupStore, inv *Inventory) (string, error) {
+	var buf bytes.Buffer
+	if err := inv.WriteNDJSON(&buf); err != nil {
+		return "", err
+	}
+	t := inv.Time.UTC()
+	key := inventoryPrefix + "dt=" + t.Format("2006-01-02") + "/" + t.Format(snapshotTimeFormat) + ".ndjson"
+	return key, store.Put(key, buf.Bytes())
+}
+
+// WriteCSV writes the dangling referrers of the report to w as CSV.
+func (r *DanglingReport) WriteCSV(w io.Writer) error {
+	header := []string{"repository", "tag", "digest", "subject", "deleted"}
+	return writeCSV(w, header, len(r.Referrers), func(i int) []string {
+		d := r.Referrers[i]
+		return []string{d.Repository, d.Tag, d.Digest, d.Subject, strconv.FormatBool(d.Deleted)}
+	})
+}
+
+// WriteCSV writes the signatures checked by VerifySignature as CSV.
+func (r *VerificationResult) WriteCSV(w io.Writer) error {
+	header := []string{"repository", "digest", "format", "manifest", "identity", "verified", "error", "rekorLogIndex", "rekorIntegratedTime"}
+	return writeCSV(w, header, len(r.Signatures), func(i int) []string {
+		s := r.Signatures[i]
+		var index, integrated string
+		if s.Rekor != nil {
+			index, integrated = strconv.FormatInt(s.Rekor.LogIndex, 10), s.Rekor.IntegratedTime.Format(time.RFC3339)
+		}
+		return []string{r.Repository, r.Digest, s.Format, s.Manifest, s.Identity, strconv.FormatBool(s.Verified), s.Error, index, integrated}
+	})
+}
+
+// WriteCSV writes the floating tags of the report as CSV.
+func (r *DriftReport) WriteCSV(w io.Writer) error {
+	header := []string{"repository", "tag", "digest", "release", "releaseDigest", "drifted", "versions"}
+	return writeCSV(w, header, len(r.Tags), func(i int) []string {
+		t := r.Tags[i]
+		return []string{t.Repository, t.Tag, t.Digest, t.Release, t.ReleaseDigest, strconv.FormatBool(t.Drifted), strings.Join(t.Versions, " ")}
+	})
+}
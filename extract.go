@@ -0,0 +1,331 @@
+package registry
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+
+	// maxSymlinks bounds the symlinks followed when resolving a path.
+	maxSymlinks = 255
+)
+
+// isBelow reports whether p is inside the directory dir, "" being the root.
+func isBelow(p, dir string) bool {
+	return dir == "" || strings.HasPrefix(p, dir+"/")
+}
+
+// ExtractLayer unpacks the uncompressed layer tar stream r into dir, on top
+// of the layers extracted before. Entry names and links are confined to dir,
+// even through symlinks extracted earlier: symlinks are rewritten to point
+// within dir, absolute targets naming paths of dir, and those reaching above
+// it fail the extraction. Overlay whiteouts remove the entries they hide,
+// never what a hidden symlink points at. Device nodes are skipped, ownership
+// is not restored and setuid bits are dropped.
+func ExtractLayer(r io.Reader, dir string) error {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	// Opaque whiteouts only hide what lower layers put in a directory.
+	written := make(map[string]bool)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		parent, base := path.Split(name)
+		if base == whiteoutOpaque {
+			if err := clearDir(root, parent, written); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			hidden := strings.TrimPrefix(base, whiteoutPrefix)
+			if hidden == "" || hidden == "." || hidden == ".." {
+				return fmt.Errorf("%s: invalid whiteout", hdr.Name)
+			}
+			dir, err := secureJoin(root, parent)
+			if err != nil {
+				return err
+			}
+			// Like entries, the hidden one itself is not resolved.
+			if err := os.RemoveAll(filepath.Join(dir, hidden)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := extractEntry(root, name, hdr, tr); err != nil {
+			return fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+		written[name] = true
+	}
+}
+
+func extractEntry(root, name string, hdr *tar.Header, r io.Reader) error {
+	parent, err := secureJoin(root, path.Dir(name))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	// The entry itself is not resolved, it replaces whatever is there.
+	target := filepath.Join(parent, path.Base(name))
+	mode := os.FileMode(hdr.Mode) & os.ModePerm
+
+	if hdr.Typeflag == tar.TypeDir {
+		if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		return os.Chmod(target, mode|0700)
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		dest, err := symlinkTarget(root, name, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(dest, target); err != nil {
+			return err
+		}
+		return nil
+	case tar.TypeLink:
+		source, err := secureJoin(root, path.Clean("/"+hdr.Linkname))
+		if err != nil {
+			return err
+		}
+		fi, err := os.Lstat(source)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("hard link to non-regular file %s", hdr.Linkname)
+		}
+		return os.Link(source, target)
+	default:
+		// Devices, FIFOs and the like are not needed to read an image.
+		return nil
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+// symlinkTarget returns the target of the symlink name to dest, resolved
+// lexically from the directory of name with absolute targets naming paths
+// of root, or fails if dest reaches above root. Targets are written as
+// absolute paths of the host within root, free of "..", so that no later
+// layer can make them escape by turning a directory they go through into a
+// symlink; the extracted tree cannot be moved without breaking them.
+func symlinkTarget(root, name, dest string) (string, error) {
+	if dest == "" {
+		return "", fmt.Errorf("empty symlink target")
+	}
+	if !strings.HasPrefix(dest, "/") {
+		rel := path.Join(strings.TrimPrefix(path.Dir(name), "/"), dest)
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", fmt.Errorf("symlink target %s escapes the root", dest)
+		}
+		dest = "/" + rel
+	}
+	return filepath.Join(root, filepath.FromSlash(path.Clean(dest))), nil
+}
+
+// clearDir removes the contents of dir that were not written by the current
+// layer. A symlink in place of dir is left alone, as is what it points at.
+func clearDir(root, dir string, written map[string]bool) error {
+	dir = path.Clean(dir)
+	target := root
+	if dir != "/" {
+		parent, err := secureJoin(root, path.Dir(dir))
+		if err != nil {
+			return err
+		}
+		target = filepath.Join(parent, path.Base(dir))
+	}
+	fi, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(target)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if written[path.Join(dir, e.Name())] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(target, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// secureJoin resolves the slash separated name within root, following
+// symlinks as if root were the file system root, so the result never
+// escapes root.
+func secureJoin(root, name string) (string, error) {
+	var resolved string
+	remaining := strings.TrimPrefix(path.Clean("/"+name), "/")
+	links := 0
+	for remaining != "" {
+		var part string
+		if i := strings.Index(remaining, "/"); i >= 0 {
+			part, remaining = remaining[:i], remaining[i+1:]
+		} else {
+			part, remaining = remaining, ""
+		}
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir("/" + resolved)[1:]
+			continue
+		}
+		candidate := path.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(root, filepath.FromSlash(candidate)))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = candidate
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks in %s", name)
+		}
+		dest, err := os.Readlink(filepath.Join(root, filepath.FromSlash(candidate)))
+		if err != nil {
+			return "", err
+		}
+		// Symlinks written by ExtractLayer name paths of root.
+		if rel, err := filepath.Rel(root, dest); err == nil && filepath.IsAbs(dest) && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			dest = "/" + rel
+		}
+		dest = filepath.ToSlash(dest)
+		if strings.HasPrefix(dest, "/") {
+			resolved = ""
+		}
+		remaining = strings.TrimPrefix(path.Join(dest, remaining), "/")
+	}
+	return filepath.Join(root, filepath.FromSlash(resolved)), nil
+}
+
+// ExtractImage unpacks the flattened file system of the image repo:ref into
+// dir, applying its layers in order. For multi-platform images the manifest
+// for platform is used, linux/amd64 if it is empty.
+func (c *Client) ExtractImage(ctx context.Context, repo, ref, dir string, platform Platform) error {
+	manifest, _, err := c.imageManifest(ctx, repo, ref, platform)
+	if err != nil {
+		return err
+	}
+	for _, layer := range manifest.Layers {
+		if err := c.extractLayer(ctx, repo, layer, dir); err != nil {
+			return fmt.Errorf("layer %s: %v", layer.Digest, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) extractLayer(ctx context.Context, repo string, layer Descriptor, dir string) error {
+	r, err := c.OpenLayer(ctx, repo, layer)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return ExtractLayer(r, dir)
+}
+
+// imageManifest returns the image manifest of repo:ref and its descriptor,
+// selecting the one for platform from an index.
+func (c *Client) imageManifest(ctx context.Context, repo, ref string, platform Platform) (*Manifest, Descriptor, error) {
+	name := c.repoName(repo)
+	body, desc, err := c.getManifest(ctx, name, ref)
+	if err != nil {
+		return nil, Descriptor{}, err
+	}
+	if isIndex(desc.MediaType) {
+		var index Index
+		if err := unmarshalJSON(body, &index); err != nil {
+			return nil, Descriptor{}, err
+		}
+		m, ok := selectPlatform(index.Manifests, platform)
+		if !ok {
+			return nil, Descriptor{}, fmt.Errorf("no manifest for platform %s/%s", platform.OS, platform.Architecture)
+		}
+		if body, desc, err = c.getManifest(ctx, name, m.Digest); err != nil {
+			return nil, Descriptor{}, err
+		}
+	}
+	var manifest Manifest
+	if err := unmarshalJSON(body, &manifest); err != nil {
+		return nil, Descriptor{}, err
+	}
+	return &manifest, desc, nil
+}
+
+// selectPlatform returns the manifest of an index for platform.
+func selectPlatform(manifests []Descriptor, platform Platform) (Descriptor, bool) {
+	if platform.OS == "" {
+		platform.OS = "linux"
+	}
+	if platform.Architecture == "" {
+		platform.Architecture = "amd64"
+	}
+	for _, m := range manifests {
+		if m.Platform == nil || m.Platform.OS != platform.OS || m.Platform.Architecture != platform.Architecture {
+			continue
+		}
+		if platform.Variant != "" && m.Platform.Variant != platform.Variant {
+			continue
+		}
+		return m, true
+	}
+	return Descriptor{}, false
+}
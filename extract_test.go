@@ -0,0 +1,187 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry is an entry of a test layer: a file with body, a directory when
+// name ends with a slash, or a symlink to link.
+type tarEntry struct {
+	name, body, link string
+}
+
+// tarLayer returns an uncompressed layer of entries.
+func tarLayer(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Mode = tar.TypeSymlink, e.link, 0777
+		case strings.HasSuffix(e.name, "/"):
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// extractTest extracts layers into the directory root of a temporary
+// directory, returning both.
+func extractTest(t *testing.T, layers ...[]byte) (string, string, error) {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	for _, layer := range layers {
+		if err := ExtractLayer(bytes.NewReader(layer), root); err != nil {
+			return base, root, err
+		}
+	}
+	return base, root, nil
+}
+
+// checkConfined fails the test if anything but root was written to base.
+func checkConfined(t *testing.T, base string) {
+	t.Helper()
+	entries, err := ioutil.ReadDir(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "root" {
+			t.Errorf("%s written outside of the extraction root", e.Name())
+		}
+	}
+}
+
+func TestExtractLayerDotDot(t *testing.T) {
+	base, root, err := extractTest(t, tarLayer(t,
+		tarEntry{name: "../evil", body: "x"},
+		tarEntry{name: "a/../../../evil2", body: "x"},
+		tarEntry{name: "/abs", body: "x"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkConfined(t, base)
+	for _, name := range []string{"evil", "evil2", "abs"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("%s not extracted within the root: %v", name, err)
+		}
+	}
+}
+
+func TestExtractLayerSymlinks(t *testing.T) {
+	base, root, err := extractTest(t, tarLayer(t,
+		tarEntry{name: "etc", link: "/"},
+		tarEntry{name: "a/"},
+		tarEntry{name: "a/up", link: "../x"},
+		tarEntry{name: "a/passwd", link: "/etc/passwd"},
+		// Written through the symlink etc, which points at the root of the
+		// image, not of the host.
+		tarEntry{name: "etc/hosts", body: "x"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkConfined(t, base)
+	for name, want := range map[string]string{"a/up": "x", "a/passwd": "etc/passwd"} {
+		dest, err := os.Readlink(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dest != filepath.Join(root, want) {
+			t.Errorf("%s links to %s, want %s", name, dest, filepath.Join(root, want))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "hosts")); err != nil {
+		t.Errorf("etc/hosts not written to the root of the image: %v", err)
+	}
+}
+
+func TestExtractLayerEscapingSymlink(t *testing.T) {
+	for _, link := range []string{"..", "../..", "a/../../x", "../root/x"} {
+		base, _, err := extractTest(t, tarLayer(t, tarEntry{name: "l", link: link}))
+		if err == nil {
+			t.Errorf("symlink to %s extracted", link)
+		}
+		checkConfined(t, base)
+	}
+}
+
+// A later layer turning a directory into a symlink must not let the
+// symlinks going through it escape.
+func TestExtractLayerReplacedDirectory(t *testing.T) {
+	base, root, err := extractTest(t,
+		tarLayer(t, tarEntry{name: "a/"}, tarEntry{name: "x/"}, tarEntry{name: "l", link: "a/../x"}),
+		tarLayer(t, tarEntry{name: "a", link: "."}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkConfined(t, base)
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, "l"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := filepath.EvalSymlinks(filepath.Join(root, "x")); resolved != want {
+		t.Errorf("l resolves to %s, want %s", resolved, want)
+	}
+}
+
+func TestExtractLayerWhiteouts(t *testing.T) {
+	base, root, err := extractTest(t,
+		tarLayer(t,
+			tarEntry{name: "a/1", body: "1"}, tarEntry{name: "a/2", body: "2"}, tarEntry{name: "b", body: "b"},
+			tarEntry{name: "etc/passwd", body: "x"}, tarEntry{name: "lnk", link: "/etc"},
+			tarEntry{name: "d/f", body: "f"}, tarEntry{name: "l", link: "/d"},
+		),
+		tarLayer(t,
+			tarEntry{name: ".wh.b"}, tarEntry{name: "a/.wh..wh..opq"}, tarEntry{name: "a/3", body: "3"},
+			// Whiteouts hide the symlink, not what it points at.
+			tarEntry{name: ".wh.lnk"}, tarEntry{name: "l/.wh..wh..opq"},
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkConfined(t, base)
+	for name, exists := range map[string]bool{
+		"a/1": false, "a/2": false, "a/3": true, "b": false,
+		"lnk": false, "etc/passwd": true, "l": true, "d/f": true,
+	} {
+		if _, err := os.Lstat(filepath.Join(root, name)); (err == nil) != exists {
+			t.Errorf("%s exists: %t, want %t", name, err == nil, exists)
+		}
+	}
+
+	for _, name := range []string{".wh.", ".wh..", ".wh...", "a/.wh.."} {
+		base, root, err := extractTest(t,
+			tarLayer(t, tarEntry{name: "a/f", body: "f"}),
+			tarLayer(t, tarEntry{name: name}),
+		)
+		if err == nil {
+			t.Errorf("whiteout %s extracted", name)
+		}
+		checkConfined(t, base)
+		if _, err := os.Lstat(filepath.Join(root, "a", "f")); err != nil {
+			t.Errorf("whiteout %s removed a/f: %v", name, err)
+		}
+	}
+}
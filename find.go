@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxGrepSize bounds the size of the files FindFiles searches the content of.
+const maxGrepSize = 64 << 20
+
+// FindOptions controls FindFiles.
+type FindOptions struct {
+	// Platform selects the manifest of multi-platform images, linux/amd64
+	// when empty.
+	Platform Platform
+	// Paths are path.Match patterns matched against absolute paths, like
+	// "/etc/os-release" or "/usr/lib/*/libssl.so.*", or against base names
+	// when they contain no slash. All files match when it is empty.
+	Paths []string
+	// Content, when set, restricts the matches to regular files whose
+	// content matches it. Files larger than 64MiB are not searched.
+	Content *regexp.Regexp
+	// MaxLines bounds the matching lines reported per file, 10 when zero.
+	MaxLines int
+}
+
+// FileMatch is a file found by FindFiles.
+type FileMatch struct {
+	Path string `json:"path"`
+	// Layer is the digest of the layer the file is in.
+	Layer    string `json:"layer"`
+	Size     int64  `json:"size"`
+	Mode     int64  `json:"mode"`
+	Linkname string `json:"linkname,omitempty"`
+	// Hidden is set when a later layer removes or replaces the file, which
+	// is still shipped with the image.
+	Hidden bool `json:"hidden,omitempty"`
+	// Lines are the lines matching the content pattern, with their numbers.
+	Lines []string `json:"lines,omitempty"`
+}
+
+// FindFiles lists the files of the layers of repo:ref matching opts, in
+// layer order. Files hidden by later layers are reported too, for audits
+// of everything an image ships.
+func (c *Client) FindFiles(ctx context.Context, repo, ref string, opts FindOptions) ([]FileMatch, error) {
+	for _, pattern := range opts.Paths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if opts.MaxLines == 0 {
+		opts.MaxLines = 10
+	}
+	manifest, _, err := c.imageManifest(ctx, repo, ref, opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+	var matches []FileMatch
+	for _, layer := range manifest.Layers {
+		if matches, err = c.findInLayer(ctx, repo, layer, opts, matches); err != nil {
+			return nil, fmt.Errorf("layer %s: %v", layer.Digest, err)
+		}
+	}
+	return matches, nil
+}
+
+// findInLayer appends the matches in layer to those of the lower layers,
+// marking the ones it hides.
+func (c *Client) findInLayer(ctx context.Context, repo string, layer Descriptor, opts FindOptions, lower []FileMatch) ([]FileMatch, error) {
+	r, err := c.OpenLayer(ctx, repo, layer)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	matches := lower
+	hidden := newLowerMatches(lower)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return matches, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		dir, base := path.Split(name)
+		switch {
+		case base == whiteoutOpaque:
+			hidden.hide(matches, dir, true)
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			hidden.hide(matches, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), false)
+			continue
+		case hdr.Typeflag != tar.TypeDir:
+			hidden.hide(matches, name, false)
+		}
+		if !matchPath(opts.Paths, name) {
+			continue
+		}
+		m := FileMatch{Path: name, Layer: layer.Digest, Size: hdr.Size, Mode: hdr.Mode, Linkname: hdr.Linkname}
+		if opts.Content != nil {
+			if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA || hdr.Size > maxGrepSize {
+				continue
+			}
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			if !opts.Content.Match(b) {
+				continue
+			}
+			m.Lines = grepLines(b, opts.Content, opts.MaxLines)
+		}
+		matches = append(matches, m)
+	}
+}
+
+// lowerMatches indexes the matches of the lower layers by path, for the
+// entries of a layer to hide them.
+type lowerMatches struct {
+	paths pathTree
+	at    map[string][]int
+}
+
+func newLowerMatches(matches []FileMatch) *lowerMatches {
+	l := &lowerMatches{paths: make(pathTree), at: make(map[string][]int)}
+	for i, m := range matches {
+		p := strings.TrimPrefix(m.Path, "/")
+		l.paths.add(p)
+		l.at[p] = append(l.at[p], i)
+	}
+	return l
+}
+
+// hide marks the lower matches at name, or below it, as hidden in matches,
+// which they start. Only the content of name is hidden when contents is set.
+func (l *lowerMatches) hide(matches []FileMatch, name string, contents bool) {
+	name = strings.Trim(name, "/")
+	mark := func(p string) {
+		for _, i := range l.at[p] {
+			matches[i].Hidden = true
+		}
+	}
+	if !contents {
+		mark(name)
+	}
+	l.paths.walk(name, mark)
+}
+
+// matchPath reports whether name matches one of patterns, or patterns is
+// empty.
+func matchPath(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// grepLines returns up to max lines of b matching re, prefixed by their
+// numbers.
+func grepLines(b []byte, re *regexp.Regexp, max int) []string {
+	var lines []string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(nil, len(b)+1)
+	for i := 1; s.Scan() && len(lines) < max; i++ {
+		if re.Match(s.Bytes()) {
+			lines = append(lines, fmt.Sprintf("%d:%s", i, s.Text()))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// flightGroup deduplicates identical requests in flight, so goroutines
+// asking for the same manifest at the same time share a single round trip.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	resp *http.Response
+	body []byte
+	err  error
+	// cancelled is set when the call failed because the context of the
+	// caller running it ended, which says nothing to the others.
+	cancelled bool
+}
+
+// do runs fn once for all concurrent callers with the same key. Every
+// caller gets its own copy of the response, with a body reading the shared
+// bytes. Callers stop waiting when their ctx ends, and run fn again
+// themselves when the caller running it gave up on its own ctx.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (*http.Response, []byte, error)) (*http.Response, []byte, error) {
+	for {
+		g.mu.Lock()
+		if g.calls == nil {
+			g.calls = make(map[string]*flightCall)
+		}
+		call, ok := g.calls[key]
+		if !ok {
+			call = &flightCall{done: make(chan struct{})}
+			g.calls[key] = call
+		}
+		g.mu.Unlock()
+
+		if !ok {
+			call.resp, call.body, call.err = fn()
+			call.cancelled = call.err != nil && ctx.Err() != nil
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(call.done)
+		} else {
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			}
+			if call.cancelled {
+				continue
+			}
+		}
+		return call.result()
+	}
+}
+
+func (call *flightCall) result() (*http.Response, []byte, error) {
+	if call.err != nil {
+		return nil, nil, call.err
+	}
+	resp := *call.resp
+	resp.Header = call.resp.Header.Clone()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(call.body))
+	return &resp, call.body, nil
+}
+
+// flightKey identifies a request by method, path, the scope it is
+// authorized for, the headers negotiating its content and its correlation
+// ID. Requests share a flight only with those of the same ID, so the response
+// a caller gets, and the errors quoting its ID, are those of a request sent
+// with its own ID.
+func flightKey(method, path, scope, requestID string, header http.Header) string {
+	return method + " " + path + " " + scope + " " + requestID + " " + header.Get("Accept") + " " + header.Get("Range")
+}
@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"context"
+	"sort"
+)
+
+const (
+	MediaTypeHelmConfig     = "application/vnd.cncf.helm.config.v1+json"
+	MediaTypeHelmChart      = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	MediaTypeHelmProvenance = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+)
+
+// ChartInfo is the metadata of a Helm chart stored as an OCI artifact.
+type ChartInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+}
+
+func parseChartInfo(config []byte) (*ChartInfo, error) {
+	var chart ChartInfo
+	if err := unmarshalJSON(config, &chart); err != nil {
+		return nil, err
+	}
+	return &chart, nil
+}
+
+// latestCharts returns the digests of the n highest chart versions of every
+// repository among the digest groups m. Digests that cannot be inspected are
+// included, erring on the side of keeping them.
+func (c *Client) latestCharts(ctx context.Context, m map[string][]TagRef, n int) map[string]bool {
+	keep := make(map[string]bool)
+	charts := make(map[string][]chartVersion)
+	for digest, tags := range m {
+		repo := tags[0].Repository
+		info, err := c.inspect(ctx, c.repoName(repo), digest)
+		if err != nil {
+			c.Warn("fail to inspect image.", "repo", repo, "digest", digest, "error", err)
+			keep[digest] = true
+			continue
+		}
+		if info.Chart == nil {
+			continue
+		}
+		v, ok := parseVersion(info.Chart.Version)
+		if !ok {
+			c.Warn("invalid chart version.", "repo", repo, "chart", info.Chart.Name, "version", info.Chart.Version)
+			keep[digest] = true
+			continue
+		}
+		charts[repo] = append(charts[repo], chartVersion{digest, v})
+	}
+	highestCharts(charts, n, keep)
+	return keep
+}
+
+// chartVersion is the version of the chart stored in a manifest.
+type chartVersion struct {
+	digest  string
+	version version
+}
+
+// highestCharts adds the digests of the n highest versions of the charts of
+// every repository to keep.
+func highestCharts(charts map[string][]chartVersion, n int, keep map[string]bool) {
+	for _, list := range charts {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].version.compare(list[j].version) > 0
+		})
+		for i := 0; i < n && i < len(list); i++ {
+			keep[list[i].digest] = true
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"net/http"
+	"time"
+)
+
+// ResponseInfo describes a finished request to the registry or its token
+// service.
+type ResponseInfo struct {
+	Method string
+	// URL is the request URL, without credentials.
+	URL      string
+	Path     string
+	Status   int
+	Duration time.Duration
+	// Header holds the response headers selected with WithResponseHook.
+	Header http.Header
+	// Err is set when no response was received.
+	Err error
+}
+
+// ResponseHook is called after every request.
+type ResponseHook func(ResponseInfo)
+
+// WithResponseHook registers a hook called after every request, passing
+// along the response headers listed, such as RateLimit-Remaining. Hooks run
+// in the goroutine that issued the request and must be safe for concurrent
+// use.
+func WithResponseHook(hook ResponseHook, headers ...string) Option {
+	return func(c *Client) {
+		c.hooks = append(c.hooks, responseHook{hook: hook, headers: headers})
+	}
+}
+
+type responseHook struct {
+	hook    ResponseHook
+	headers []string
+}
+
+func (c *Client) runHooks(req *http.Request, resp *http.Response, err error, started time.Time) {
+	if len(c.hooks) == 0 {
+		return
+	}
+	u := *req.URL
+	u.User = nil
+	info := ResponseInfo{
+		Method:   req.Method,
+		URL:      u.String(),
+		Path:     req.URL.Path,
+		Duration: time.Since(started),
+		Err:      err,
+	}
+	if resp != nil {
+		info.Status = resp.StatusCode
+	}
+	for _, h := range c.hooks {
+		info.Header = http.Header{}
+		if resp != nil {
+			for _, name := range h.headers {
+				if v := resp.Header.Values(name); len(v) > 0 {
+					info.Header[http.CanonicalHeaderKey(name)] = v
+				}
+			}
+		}
+		h.hook(info)
+	}
+}
@@ -0,0 +1,151 @@
+package registry
+
+import (
+	"context"
+	"io/ioutil"
+	"time"
+)
+
+const annotationCreated = "org.opencontainers.image.created"
+
+// The OCI annotations telling where an image came from.
+const (
+	AnnotationSource   = "org.opencontainers.image.source"
+	AnnotationRevision = "org.opencontainers.image.revision"
+	AnnotationURL      = "org.opencontainers.image.url"
+)
+
+// ImageInfo describes the image a reference resolves to.
+type ImageInfo struct {
+	Digest    string       `json:"digest"`
+	MediaType string       `json:"mediaType"`
+	Kind      ArtifactKind `json:"kind"`
+	// Size is the total size of the config and layers, summed over all
+	// images of an index.
+	Size int64 `json:"size"`
+	// Created is the creation time of the image, or of the newest image of
+	// an index.
+	Created   time.Time  `json:"created"`
+	Platforms []Platform `json:"platforms,omitempty"`
+	// Chart is set for Helm charts.
+	Chart *ChartInfo `json:"chart,omitempty"`
+	// Annotations are the annotations of the manifest, completed with the
+	// labels of the image config. Those of an index are completed with the
+	// annotations of its images.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Inspect describes the image repo:ref, where ref is a tag or digest.
+func (c *Client) Inspect(ctx context.Context, repo, ref string) (*ImageInfo, error) {
+	return c.inspect(ctx, c.repoName(repo), ref)
+}
+
+func (c *Client) inspect(ctx context.Context, name, ref string) (*ImageInfo, error) {
+	body, desc, err := c.getManifest(ctx, name, ref)
+	if err != nil {
+		return nil, err
+	}
+	info := &ImageInfo{Digest: desc.Digest, MediaType: desc.MediaType}
+	if isIndex(desc.MediaType) {
+		info.Kind = KindIndex
+		var index Index
+		if err := unmarshalJSON(body, &index); err != nil {
+			return nil, err
+		}
+		info.Annotations = mergeAnnotations(nil, index.Annotations)
+		for _, m := range index.Manifests {
+			child, err := c.inspect(ctx, name, m.Digest)
+			if err != nil {
+				return nil, err
+			}
+			info.Size += child.Size
+			if child.Created.After(info.Created) {
+				info.Created = child.Created
+			}
+			if m.Platform != nil {
+				info.Platforms = append(info.Platforms, *m.Platform)
+			}
+			info.Annotations = mergeAnnotations(info.Annotations, child.Annotations)
+		}
+		return info, nil
+	}
+
+	var manifest Manifest
+	if err := unmarshalJSON(body, &manifest); err != nil {
+		return nil, err
+	}
+	info.Kind = classify(desc.MediaType, &manifest)
+	info.Annotations = mergeAnnotations(nil, manifest.Annotations)
+	info.Size = manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		info.Size += layer.Size
+	}
+	b, err := c.getConfig(ctx, name, manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if created, err := time.Parse(time.RFC3339, manifest.Annotations[annotationCreated]); err == nil {
+		info.Created = created
+	}
+	if manifest.Config.MediaType == MediaTypeHelmConfig {
+		info.Chart, err = parseChartInfo(b)
+		if err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+	var config struct {
+		Created      time.Time `json:"created"`
+		Architecture string    `json:"architecture"`
+		OS           string    `json:"os"`
+		Variant      string    `json:"variant"`
+		Config       struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := unmarshalJSON(b, &config); err != nil {
+		// Artifacts may use configs that are no image configs.
+		c.Debug("fail to parse image config.", "repo", name, "digest", manifest.Config.Digest, "error", err)
+		return info, nil
+	}
+	if !config.Created.IsZero() {
+		info.Created = config.Created
+	}
+	if config.OS != "" {
+		info.Platforms = []Platform{{Architecture: config.Architecture, OS: config.OS, Variant: config.Variant}}
+	}
+	info.Annotations = mergeAnnotations(info.Annotations, config.Config.Labels)
+	return info, nil
+}
+
+// mergeAnnotations adds the annotations of from missing in to, and returns
+// to, nil when both are empty.
+func mergeAnnotations(to, from map[string]string) map[string]string {
+	for k, v := range from {
+		if _, ok := to[k]; ok {
+			continue
+		}
+		if to == nil {
+			to = make(map[string]string)
+		}
+		to[k] = v
+	}
+	return to
+}
+
+// getBlob downloads a small blob, such as an image config, into memory.
+func (c *Client) getBlob(ctx context.Context, name, digest string) ([]byte, error) {
+	r, _, err := c.openBlob(ctx, name, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyDigest(digest, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
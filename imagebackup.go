@@ -0,0 +1,240 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Full backup keys: content is stored once by digest, and every repository
+// has an index of its tags.
+const (
+	backupBlobsPrefix = "blobs/"
+	backupReposPrefix = "repositories/"
+	backupIndexFile   = "index.json"
+)
+
+// RepositoryBackup is the index of a repository in a full backup.
+type RepositoryBackup struct {
+	Repository string                `json:"repository"`
+	Time       time.Time             `json:"time"`
+	Tags       map[string]Descriptor `json:"tags"`
+}
+
+// BackupOptions controls Backup.
+type BackupOptions struct {
+	// Repositories lists the repositories backed up, all of the catalog
+	// when empty.
+	Repositories []string
+}
+
+// BackupResult summarizes a Backup run.
+type BackupResult struct {
+	Repositories []string `json:"repositories"`
+	Tags         int      `json:"tags"`
+	// Blobs and Bytes count the content stored by this run; Skipped counts
+	// the content the store already had.
+	Blobs   int      `json:"blobs"`
+	Bytes   int64    `json:"bytes"`
+	Skipped int      `json:"skipped"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// blobBackupKey returns the key of the content digest in a full backup.
+func blobBackupKey(digest string) string {
+	return backupBlobsPrefix + strings.Replace(digest, ":", "/", 1)
+}
+
+// repoBackupKey returns the key of the index of repo in a full backup.
+func repoBackupKey(repo string) string {
+	return backupReposPrefix + repo + "/" + backupIndexFile
+}
+
+// Backup copies the manifests and blobs of the tags of the selected
+// repositories to store, storing content shared by several images once and
+// skipping content a previous backup stored. Non-distributable layers are
+// not backed up. Failures are collected in the result and do not stop the
+// run; the index of a repository is only written once all of its tags are
+// backed up. Backups are restored with RestoreBackup.
+func (c *Client) Backup(ctx context.Context, store BackupStore, opts BackupOptions) (*BackupResult, error) {
+	repos := opts.Repositories
+	if len(repos) == 0 {
+		var err error
+		repos, err = c.queryRepositories(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	result := &BackupResult{}
+	done := make(map[string]bool)
+	for _, repo := range repos {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if err := c.backupRepository(ctx, store, repo, done, result); err != nil {
+			c.Warn("fail to back up repository.", "repo", repo, "error", err)
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", repo, err))
+			continue
+		}
+		result.Repositories = append(result.Repositories, repo)
+	}
+	return result, nil
+}
+
+func (c *Client) backupRepository(ctx context.Context, store BackupStore, repo string, done map[string]bool, result *BackupResult) error {
+	tags, err := c.queryTags(ctx, repo)
+	if err != nil {
+		return err
+	}
+	index := RepositoryBackup{Repository: repo, Time: time.Now(), Tags: make(map[string]Descriptor)}
+	r := c.Repository(repo)
+	for _, tag := range tags {
+		desc, err := r.Resolve(ctx, tag)
+		if err != nil {
+			return fmt.Errorf("%s: %v", tag, err)
+		}
+		if err := c.backupContent(ctx, store, r, desc, done, result); err != nil {
+			return fmt.Errorf("%s: %v", tag, err)
+		}
+		index.Tags[tag] = Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}
+		result.Tags++
+	}
+	b, err := marshalJSON(index)
+	if err != nil {
+		return err
+	}
+	c.Info("back up repository.", "repo", repo, "tags", len(index.Tags))
+	return store.Put(repoBackupKey(repo), b)
+}
+
+// backupContent stores desc and the content it references.
+func (c *Client) backupContent(ctx context.Context, store BackupStore, r *Repository, desc Descriptor, done map[string]bool, result *BackupResult) error {
+	if done[desc.Digest] || len(desc.URLs) > 0 {
+		return nil
+	}
+	key := blobBackupKey(desc.Digest)
+	if !isManifest(desc.MediaType) {
+		exists, err := hasKey(store, key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			result.Skipped++
+		} else {
+			body, err := r.Fetch(ctx, desc)
+			if err != nil {
+				return err
+			}
+			err = putStream(store, key, body, desc.Size)
+			body.Close()
+			if err != nil {
+				return err
+			}
+			result.Blobs++
+			result.Bytes += desc.Size
+		}
+		done[desc.Digest] = true
+		return nil
+	}
+
+	body, err := fetchAll(ctx, r, desc)
+	if err != nil {
+		return err
+	}
+	descs, err := children(desc, body)
+	if err != nil {
+		return err
+	}
+	for _, child := range descs {
+		if err := c.backupContent(ctx, store, r, child, done, result); err != nil {
+			return err
+		}
+	}
+	// Manifests are small, they are stored again to keep them consistent
+	// with their children.
+	if err := store.Put(key, body); err != nil {
+		return err
+	}
+	result.Blobs++
+	result.Bytes += int64(len(body))
+	done[desc.Digest] = true
+	return nil
+}
+
+// backupFetcher reads content from a full backup.
+type backupFetcher struct {
+	store BackupStore
+}
+
+func (f backupFetcher) Fetch(ctx context.Context, desc Descriptor) (io.ReadCloser, error) {
+	return openKey(f.store, blobBackupKey(desc.Digest))
+}
+
+// RestoreBackupOptions controls RestoreBackup.
+type RestoreBackupOptions struct {
+	// Repositories restricts the restore to the given repositories.
+	Repositories []string
+}
+
+// RestoreBackupResult summarizes a RestoreBackup run.
+type RestoreBackupResult struct {
+	Tags   []TagRef `json:"tags"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// RestoreBackup pushes the tags of a full backup made by Backup, uploading
+// the content the registry misses. Failures are collected in the result and
+// do not stop the run.
+func (c *Client) RestoreBackup(ctx context.Context, store BackupStore, opts RestoreBackupOptions) (*RestoreBackupResult, error) {
+	keys, err := store.List(backupReposPrefix)
+	if err != nil {
+		return nil, err
+	}
+	result := &RestoreBackupResult{}
+	src := backupFetcher{store}
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/"+backupIndexFile) {
+			continue
+		}
+		b, err := store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		var index RepositoryBackup
+		if err := unmarshalJSON(b, &index); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		if len(opts.Repositories) > 0 && !contains(opts.Repositories, index.Repository) {
+			continue
+		}
+		dst := c.Repository(index.Repository)
+		var tags []string
+		for tag := range index.Tags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			desc := index.Tags[tag]
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			ref := TagRef{index.Repository, tag}
+			if err := CopyGraph(ctx, src, dst, desc); err != nil {
+				c.Warn("fail to restore tag.", "tag", ref, "error", err)
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", ref, err))
+				continue
+			}
+			if err := dst.Tag(ctx, desc, tag); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", ref, err))
+				continue
+			}
+			c.Info("restore tag.", "tag", ref, "digest", desc.Digest)
+			result.Tags = append(result.Tags, ref)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,335 @@
+package registry
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ImageFS returns the flattened file system of the image repo:ref, selecting
+// the manifest for platform from multi-platform images. Building it reads
+// the tar headers of every layer; file contents are only downloaded when
+// read, with range requests for uncompressed layers.
+func (c *Client) ImageFS(ctx context.Context, repo, ref string, platform Platform) (fs.FS, error) {
+	manifest, _, err := c.imageManifest(ctx, repo, ref, platform)
+	if err != nil {
+		return nil, err
+	}
+	ifs := &imageFS{c: c, ctx: ctx, repo: repo, layers: manifest.Layers, entries: make(map[string]*fsEntry), paths: make(pathTree)}
+	for i, layer := range manifest.Layers {
+		if err := ifs.index(i, layer); err != nil {
+			return nil, fmt.Errorf("layer %s: %v", layer.Digest, err)
+		}
+	}
+	ifs.addParents()
+	return ifs, nil
+}
+
+type imageFS struct {
+	c       *Client
+	ctx     context.Context
+	repo    string
+	layers  []Descriptor
+	entries map[string]*fsEntry
+	// paths indexes the entries while the layers are read.
+	paths pathTree
+}
+
+type fsEntry struct {
+	hdr    *tar.Header
+	layer  int
+	offset int64
+	// children of directories, by base name.
+	children map[string]bool
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// index adds the entries of layer i on top of the lower layers.
+func (f *imageFS) index(i int, layer Descriptor) error {
+	r, err := f.c.OpenLayer(f.ctx, f.repo, layer)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	cr := &countingReader{r: r}
+	tr := tar.NewReader(cr)
+	written := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		switch {
+		case base == whiteoutOpaque:
+			f.paths.walk(dir, func(p string) {
+				if !written[p] {
+					delete(f.entries, p)
+				}
+			})
+		case strings.HasPrefix(base, whiteoutPrefix):
+			f.remove(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+		default:
+			if hdr.Typeflag != tar.TypeDir {
+				f.remove(name)
+			}
+			f.entries[name] = &fsEntry{hdr: hdr, layer: i, offset: cr.n}
+			f.paths.add(name)
+			written[name] = true
+		}
+	}
+}
+
+func (f *imageFS) remove(name string) {
+	delete(f.entries, name)
+	f.paths.walk(name, func(p string) {
+		delete(f.entries, p)
+	})
+	f.paths.remove(name)
+}
+
+// addParents creates the directories implied by entry names and links
+// every entry to its parent.
+func (f *imageFS) addParents() {
+	f.entries["."] = &fsEntry{hdr: &tar.Header{Name: ".", Typeflag: tar.TypeDir, Mode: 0755}}
+	names := make([]string, 0, len(f.entries))
+	for name := range f.entries {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		for name != "." {
+			dir := path.Dir(name)
+			parent, ok := f.entries[dir]
+			if !ok {
+				parent = &fsEntry{hdr: &tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755}}
+				f.entries[dir] = parent
+			}
+			if parent.children == nil {
+				parent.children = make(map[string]bool)
+			}
+			parent.children[path.Base(name)] = true
+			name = dir
+		}
+	}
+}
+
+// lookup resolves name, following symlinks within the image.
+func (f *imageFS) lookup(name string) (string, *fsEntry, error) {
+	links := 0
+	resolved := "."
+	remaining := name
+	for remaining != "" && remaining != "." {
+		var part string
+		if i := strings.Index(remaining, "/"); i >= 0 {
+			part, remaining = remaining[:i], remaining[i+1:]
+		} else {
+			part, remaining = remaining, ""
+		}
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+		candidate := path.Join(resolved, part)
+		e, ok := f.entries[candidate]
+		if !ok {
+			return "", nil, fs.ErrNotExist
+		}
+		if e.hdr.Typeflag != tar.TypeSymlink {
+			resolved = candidate
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", nil, fmt.Errorf("too many symlinks")
+		}
+		target := e.hdr.Linkname
+		if strings.HasPrefix(target, "/") {
+			resolved = "."
+		}
+		remaining = strings.TrimPrefix(path.Join(target, remaining), "/")
+	}
+	e, ok := f.entries[resolved]
+	if !ok {
+		return "", nil, fs.ErrNotExist
+	}
+	if e.hdr.Typeflag == tar.TypeLink {
+		target := strings.TrimPrefix(path.Clean("/"+e.hdr.Linkname), "/")
+		if t, ok := f.entries[target]; ok {
+			return resolved, &fsEntry{hdr: t.hdr, layer: t.layer, offset: t.offset}, nil
+		}
+		return "", nil, fs.ErrNotExist
+	}
+	return resolved, e, nil
+}
+
+func (f *imageFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	resolved, e, err := f.lookup(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	info := entryInfo{name: path.Base(name), e: e}
+	switch e.hdr.Typeflag {
+	case tar.TypeDir:
+		return &fsDir{f: f, name: resolved, info: info}, nil
+	case tar.TypeReg, tar.TypeRegA:
+		return &fsFile{f: f, info: info}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fmt.Errorf("unsupported file type")}
+}
+
+type entryInfo struct {
+	name string
+	e    *fsEntry
+}
+
+func (i entryInfo) Name() string       { return i.name }
+func (i entryInfo) Size() int64        { return i.e.hdr.Size }
+func (i entryInfo) ModTime() time.Time { return i.e.hdr.ModTime }
+func (i entryInfo) IsDir() bool        { return i.e.hdr.Typeflag == tar.TypeDir }
+func (i entryInfo) Sys() interface{}   { return i.e.hdr }
+
+func (i entryInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(i.e.hdr.Mode) & fs.ModePerm
+	switch i.e.hdr.Typeflag {
+	case tar.TypeDir:
+		mode |= fs.ModeDir
+	case tar.TypeSymlink:
+		mode |= fs.ModeSymlink
+	}
+	return mode
+}
+
+func (i entryInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i entryInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+type fsFile struct {
+	f    *imageFS
+	info entryInfo
+	r    io.ReadCloser
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		r, err := f.f.open(f.info.e)
+		if err != nil {
+			return 0, err
+		}
+		f.r = r
+	}
+	return f.r.Read(p)
+}
+
+func (f *fsFile) Close() error {
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return nil
+}
+
+// open reads the content of the file e, with a range request if its layer
+// is uncompressed and by skipping through the layer otherwise.
+func (f *imageFS) open(e *fsEntry) (io.ReadCloser, error) {
+	layer := f.layers[e.layer]
+	if e.hdr.Size == 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	if strings.HasSuffix(layer.MediaType, ".tar") {
+		return f.c.OpenBlobRange(f.ctx, f.repo, layer.Digest, e.offset, e.hdr.Size)
+	}
+	r, err := f.c.OpenLayer(f.ctx, f.repo, layer)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, e.offset); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(r, e.hdr.Size), r}, nil
+}
+
+type fsDir struct {
+	f       *imageFS
+	name    string
+	info    entryInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fmt.Errorf("is a directory")}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.read = true
+		var names []string
+		for name := range d.info.e.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			d.entries = append(d.entries, entryInfo{name: name, e: d.f.entries[path.Join(d.name, name)]})
+		}
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
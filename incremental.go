@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// cleanCacheKey is the cache key recording the last incremental clean of
+// repo.
+func cleanCacheKey(c *Client, repo string) string {
+	return cacheClean + c.host() + "/" + c.repoName(repo)
+}
+
+// tagsFingerprint identifies the tags of a repository as cleaned by the
+// policy of run.
+func tagsFingerprint(run *policyRun, tags []string) string {
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	h := sha256.New()
+	h.Write(run.fingerprint)
+	h.Write([]byte("\n" + strings.Join(sorted, "\n")))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// unchanged reports whether repo was cleaned by the policy of run with the
+// same tags.
+func (c *Client) unchanged(run *policyRun, repo string, tags []string) bool {
+	b, ok := c.cache.get(cleanCacheKey(c, repo))
+	return ok && string(b) == tagsFingerprint(run, tags)
+}
+
+// recordClean records the tags the repositories examined by run are left
+// with, so the next incremental run skips them unless they change.
+// Repositories where an image failed to resolve or clean are tried again.
+func (c *Client) recordClean(run *policyRun, result *CleanResult, failed map[string]bool) {
+	resolved := make(map[string]int)
+	for _, refs := range run.tags {
+		for _, t := range refs {
+			resolved[t.Repository]++
+		}
+	}
+	changed := make(map[string]bool)
+	for _, d := range result.Deleted {
+		changed[d.Repository] = true
+	}
+	for repo, tags := range run.repoTags {
+		if failed[repo] {
+			continue
+		}
+		n := 0
+		for _, tag := range tags {
+			if !run.skip(tag) {
+				n++
+			}
+		}
+		if resolved[repo] != n {
+			continue
+		}
+		if changed[repo] {
+			var err error
+			if tags, err = c.QueryTags(repo); err != nil {
+				c.Warn("fail to query tags.", "repo", repo, "error", err)
+				continue
+			}
+		}
+		c.cache.put(cleanCacheKey(c, repo), []byte(tagsFingerprint(run, tags)))
+	}
+}
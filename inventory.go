@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// InventoryOptions selects what Inventory records.
+type InventoryOptions struct {
+	// Repositories are the repositories walked, all of them when empty.
+	Repositories []string
+	// Images inspects the image of every tag for its media type, kind,
+	// platforms, size and creation time. Otherwise only the digests tags
+	// point at are resolved.
+	Images bool
+	// Blobs records the blobs of every image, as needed to account for
+	// storage. It implies Images.
+	Blobs bool
+	// Annotations are the annotations recorded for every image, like
+	// AnnotationRevision, which may also be set as labels of image configs.
+	// Other annotations are left out. It implies Images.
+	Annotations []string
+	// Cached resolves tags from the cache of the client. Clean never does,
+	// so images re-tagged meanwhile are not deleted by mistake.
+	Cached bool
+	// SkipTag leaves out the tags it returns true for.
+	SkipTag func(repo, tag string) bool
+	// KeepRepository is given the tags of every repository before they are
+	// resolved, and leaves out the repository if it returns false.
+	KeepRepository func(repo string, tags []string) bool
+}
+
+// Inventory is the content of a registry: its repositories, their tags and
+// the images they point at.
+type Inventory struct {
+	Registry string `json:"registry"`
+	// Prefix is the path prefix of the repositories, as set with
+	// WithPathPrefix.
+	Prefix       string                `json:"prefix,omitempty"`
+	Time         time.Time             `json:"time"`
+	Repositories []RepositoryInventory `json:"repositories"`
+	// Annotations are the annotations recorded for the images, as selected
+	// with InventoryOptions.Annotations.
+	Annotations []string `json:"annotations,omitempty"`
+	// Errors lists the repositories and images that could not be walked.
+	Errors []string `json:"errors,omitempty"`
+	// Failed are the repositories left out because their tags could not be
+	// listed or resolved, so that runs judging the images of an inventory
+	// leave them alone rather than act on part of their tags.
+	Failed []string `json:"failed,omitempty"`
+}
+
+// RepositoryInventory lists the tags of a repository, in the order the
+// registry lists them.
+type RepositoryInventory struct {
+	Repository string         `json:"repository"`
+	Tags       []InventoryTag `json:"tags"`
+}
+
+// InventoryTag is a tag and the image it points at.
+type InventoryTag struct {
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+	// Image is set with InventoryOptions.Images, unless the image could not
+	// be inspected. Tags pointing at the same digest share it.
+	Image *InventoryImage `json:"image,omitempty"`
+}
+
+// InventoryImage describes an image of an inventory.
+type InventoryImage struct {
+	ImageInfo
+	// Blobs are the sizes of the blobs of the image, by digest, set with
+	// InventoryOptions.Blobs.
+	Blobs map[string]int64 `json:"blobs,omitempty"`
+}
+
+// Inventory walks the repositories of the registry, resolving every tag and,
+// depending on opts, inspecting the images. Tags deleted since they were
+// listed are left out. Repositories whose tags cannot be listed or resolved
+// are left out as a whole and listed in Failed; failures are collected in
+// the result and do not stop the walk.
+func (c *Client) Inventory(ctx context.Context, opts InventoryOptions) (*Inventory, error) {
+	repos := opts.Repositories
+	if len(repos) == 0 {
+		var err error
+		if repos, err = c.queryRepositories(ctx); err != nil {
+			return nil, err
+		}
+	}
+	inv := &Inventory{Registry: c.host(), Prefix: c.prefix, Time: time.Now().UTC(), Annotations: opts.Annotations}
+	for _, repo := range repos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tags, err := c.queryTags(ctx, repo)
+		if err != nil {
+			c.Warn("fail to query tags.", "repo", repo, "error", err)
+			inv.Errors = append(inv.Errors, fmt.Sprintf("%s: %v", repo, err))
+			inv.Failed = append(inv.Failed, repo)
+			continue
+		}
+		if opts.KeepRepository != nil && !opts.KeepRepository(repo, tags) {
+			continue
+		}
+		r := RepositoryInventory{Repository: repo}
+		images := make(map[string]*InventoryImage)
+		failed := false
+		for _, tag := range tags {
+			if opts.SkipTag != nil && opts.SkipTag(repo, tag) {
+				continue
+			}
+			digest, err := c.tagInfo(ctx, repo, tag, opts.Cached)
+			if err == ErrNotFound {
+				// Deleted since listing.
+				continue
+			}
+			if err != nil {
+				c.Warn("fail to resolve tag.", "repo", repo, "tag", tag, "error", err)
+				inv.Errors = append(inv.Errors, fmt.Sprintf("%s:%s: %v", repo, tag, err))
+				inv.Failed = append(inv.Failed, repo)
+				failed = true
+				break
+			}
+			t := InventoryTag{Tag: tag, Digest: digest}
+			if opts.Images || opts.Blobs || len(opts.Annotations) > 0 {
+				image, ok := images[digest]
+				if !ok {
+					image, err = c.inventoryImage(ctx, repo, digest, opts)
+					if err != nil {
+						c.Warn("fail to inspect image.", "repo", repo, "digest", digest, "error", err)
+						inv.Errors = append(inv.Errors, fmt.Sprintf("%s@%s: %v", repo, digest, err))
+					}
+					images[digest] = image
+				}
+				t.Image = image
+			}
+			r.Tags = append(r.Tags, t)
+		}
+		if failed {
+			continue
+		}
+		c.Debug("walk repository.", "repo", repo, "tags", len(r.Tags), "images", len(images))
+		inv.Repositories = append(inv.Repositories, r)
+	}
+	return inv, nil
+}
+
+func (c *Client) inventoryImage(ctx context.Context, repo, digest string, opts InventoryOptions) (*InventoryImage, error) {
+	name := c.repoName(repo)
+	info, err := c.inspect(ctx, name, digest)
+	if err != nil {
+		return nil, err
+	}
+	image := &InventoryImage{ImageInfo: *info}
+	image.Annotations = nil
+	for _, k := range opts.Annotations {
+		if v, ok := info.Annotations[k]; ok {
+			if image.Annotations == nil {
+				image.Annotations = make(map[string]string)
+			}
+			image.Annotations[k] = v
+		}
+	}
+	if opts.Blobs {
+		if image.Blobs, err = c.manifestBlobs(ctx, name, digest); err != nil {
+			return nil, err
+		}
+	}
+	return image, nil
+}
+
+// Repository returns the inventory of repo, or nil if it was not walked.
+func (inv *Inventory) Repository(repo string) *RepositoryInventory {
+	for i := range inv.Repositories {
+		if inv.Repositories[i].Repository == repo {
+			return &inv.Repositories[i]
+		}
+	}
+	return nil
+}
+
+// tagsByDigest groups the tags of the inventory by the digest they point at.
+func (inv *Inventory) tagsByDigest() map[string][]TagRef {
+	m := make(map[string][]TagRef)
+	for _, r := range inv.Repositories {
+		for _, t := range r.Tags {
+			m[t.Digest] = append(m[t.Digest], TagRef{r.Repository, t.Tag})
+		}
+	}
+	return m
+}
@@ -0,0 +1,60 @@
+package registry
+
+import "strings"
+
+// ArtifactKind classifies the content a manifest describes.
+type ArtifactKind string
+
+const (
+	KindImage     ArtifactKind = "image"
+	KindIndex     ArtifactKind = "index"
+	KindHelmChart ArtifactKind = "helm-chart"
+	KindWasm      ArtifactKind = "wasm"
+	// KindCosign covers signatures, attestations and SBOMs attached by
+	// cosign or as referrers.
+	KindCosign  ArtifactKind = "cosign"
+	KindUnknown ArtifactKind = "unknown"
+)
+
+const (
+	mediaTypeDockerConfig     = "application/vnd.docker.container.image.v1+json"
+	mediaTypeOCIConfig        = "application/vnd.oci.image.config.v1+json"
+	mediaTypeCosignSimpleSign = "application/vnd.dev.cosign.simplesigning.v1+json"
+	mediaTypeDSSEEnvelope     = "application/vnd.dsse.envelope.v1+json"
+)
+
+// classify determines the kind of the manifest with the given media type,
+// which is parsed into manifest unless it is an index.
+func classify(mediaType string, manifest *Manifest) ArtifactKind {
+	if isIndex(mediaType) {
+		return KindIndex
+	}
+	if manifest == nil {
+		return KindUnknown
+	}
+	config := manifest.Config.MediaType
+	artifactType := manifest.ArtifactType
+	if artifactType == "" {
+		artifactType = config
+	}
+	switch {
+	case config == MediaTypeHelmConfig:
+		return KindHelmChart
+	case strings.HasPrefix(config, "application/vnd.wasm.config."):
+		return KindWasm
+	case artifactType == ArtifactTypeCosignSignature, sbomFormat(artifactType) != "", strings.Contains(artifactType, "in-toto"):
+		return KindCosign
+	}
+	for _, layer := range manifest.Layers {
+		switch {
+		case layer.MediaType == mediaTypeCosignSimpleSign, layer.MediaType == mediaTypeDSSEEnvelope, sbomFormat(layer.MediaType) != "":
+			return KindCosign
+		case layer.MediaType == "application/wasm", strings.HasSuffix(layer.MediaType, "+wasm"):
+			return KindWasm
+		}
+	}
+	if config == mediaTypeOCIConfig || config == mediaTypeDockerConfig {
+		return KindImage
+	}
+	return KindUnknown
+}
@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	MediaTypeOCILayer           = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip       = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCILayerZstd       = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignLayer = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Decompress wraps r, the content of a layer of mediaType, with the
+// decompressor its media type calls for, so reading it yields the
+// uncompressed tar stream. When the media type does not tell, as with
+// artifacts, the compression is detected from the content. Closing the
+// returned reader does not close r.
+func Decompress(mediaType string, r io.Reader) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(mediaType, "+gzip") || strings.HasSuffix(mediaType, ".gzip"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(mediaType, "+zstd"):
+		return newZstdReader(r)
+	case strings.HasSuffix(mediaType, ".tar") || strings.HasSuffix(mediaType, ".tar+encrypted"):
+		return nopCloser{r}, nil
+	}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return newZstdReader(br)
+	}
+	return nopCloser{br}, nil
+}
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zstdReader{d}, nil
+}
+
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (z zstdReader) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+type nopCloser struct {
+	io.Reader
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// OpenLayer downloads the layer desc of an image in repo and returns its
+// uncompressed tar stream. The caller must close the returned reader.
+func (c *Client) OpenLayer(ctx context.Context, repo string, desc Descriptor) (io.ReadCloser, error) {
+	r, _, err := c.OpenBlob(ctx, repo, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	d, err := Decompress(desc.MediaType, r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{d, multiCloser{d, r}}, nil
+}
+
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var first error
+	for _, c := range m {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
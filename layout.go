@@ -0,0 +1,186 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// annotationRefName is the annotation naming manifests in an OCI layout
+// index.
+const annotationRefName = "org.opencontainers.image.ref.name"
+
+// OCILayout is a Target storing content in a local directory following the
+// OCI image layout specification, as used by tools like skopeo and oras.
+type OCILayout struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewOCILayout opens the OCI layout in dir, creating it if needed.
+func NewOCILayout(dir string) (*OCILayout, error) {
+	l := &OCILayout{dir: dir}
+	if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0755); err != nil {
+		return nil, err
+	}
+	layoutFile := filepath.Join(dir, "oci-layout")
+	if _, err := os.Stat(layoutFile); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(layoutFile, []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+			return nil, err
+		}
+	}
+	indexFile := filepath.Join(dir, "index.json")
+	if _, err := os.Stat(indexFile); os.IsNotExist(err) {
+		if err := l.writeIndex(Index{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *OCILayout) blobPath(digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.ContainsAny(parts[1], `/\.`) || parts[1] == "" {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return filepath.Join(l.dir, "blobs", parts[0], parts[1]), nil
+}
+
+func (l *OCILayout) Fetch(ctx context.Context, desc Descriptor) (io.ReadCloser, error) {
+	path, err := l.blobPath(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Push stores content, verifying it matches desc.
+func (l *OCILayout) Push(ctx context.Context, desc Descriptor, r io.Reader) error {
+	path, err := l.blobPath(desc.Digest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	h, err := newDigester(desc.Digest)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if got := digestString(desc.Digest, h); got != desc.Digest {
+		return fmt.Errorf("digest mismatch: got %s, expected %s", got, desc.Digest)
+	}
+	if desc.Size > 0 && n != desc.Size {
+		return fmt.Errorf("%s: size %d, expected %d", desc.Digest, n, desc.Size)
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (l *OCILayout) Exists(ctx context.Context, desc Descriptor) (bool, error) {
+	path, err := l.blobPath(desc.Digest)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Resolve looks up ref among the names of the layout index, or by digest.
+func (l *OCILayout) Resolve(ctx context.Context, ref string) (Descriptor, error) {
+	index, err := l.readIndex()
+	if err != nil {
+		return Descriptor{}, err
+	}
+	for _, m := range index.Manifests {
+		if m.Annotations[annotationRefName] == ref || m.Digest == ref {
+			return m, nil
+		}
+	}
+	return Descriptor{}, ErrNotFound
+}
+
+// Tag names the manifest desc ref in the layout index, replacing a manifest
+// previously named so.
+func (l *OCILayout) Tag(ctx context.Context, desc Descriptor, ref string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	index, err := l.readIndex()
+	if err != nil {
+		return err
+	}
+	var manifests []Descriptor
+	for _, m := range index.Manifests {
+		if m.Annotations[annotationRefName] != ref {
+			manifests = append(manifests, m)
+		}
+	}
+	tagged := desc
+	tagged.Annotations = map[string]string{annotationRefName: ref}
+	for k, v := range desc.Annotations {
+		tagged.Annotations[k] = v
+	}
+	index.Manifests = append(manifests, tagged)
+	return l.writeIndex(index)
+}
+
+// Tags returns the names of the manifests in the layout.
+func (l *OCILayout) Tags() ([]string, error) {
+	index, err := l.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, m := range index.Manifests {
+		if name := m.Annotations[annotationRefName]; name != "" {
+			tags = append(tags, name)
+		}
+	}
+	return tags, nil
+}
+
+func (l *OCILayout) readIndex() (Index, error) {
+	var index Index
+	b, err := ioutil.ReadFile(filepath.Join(l.dir, "index.json"))
+	if err != nil {
+		return index, err
+	}
+	err = unmarshalJSON(b, &index)
+	return index, err
+}
+
+func (l *OCILayout) writeIndex(index Index) error {
+	b, err := marshalJSON(index)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(l.dir, "index.json.tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(l.dir, "index.json"))
+}
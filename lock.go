@@ -0,0 +1,419 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLocked is returned by cleaning runs while another process holds the
+// lock given with WithLock.
+var ErrLocked = errors.New("locked by another process")
+
+// ErrLockLost ends the context of the work done under a lock whose lease was
+// lost, taken over by another process after it could not be renewed in time.
+var ErrLockLost = errors.New("lock lost")
+
+// Locker is a lock shared by several processes, such as the replicas of a
+// cleaning daemon.
+type Locker interface {
+	// Lock acquires the lock, failing with ErrLocked if another process
+	// holds it. The lock is held until unlock is called, or until its lease
+	// is lost, which ends locked, a context derived from ctx, with
+	// ErrLockLost. The work done under the lock uses locked, so it stops
+	// once another process may hold the lock.
+	Lock(ctx context.Context) (locked context.Context, unlock func(), err error)
+}
+
+// WithLock makes cleaning runs hold locker while they delete images, so
+// only one of the processes sharing it cleans at a time.
+func WithLock(locker Locker) Option {
+	return func(c *Client) {
+		c.locker = locker
+	}
+}
+
+// WithTagLock makes cleaning runs hold the TagLock of the client named by
+// repo and tag, as WithLock does.
+func WithTagLock(repo, tag string) Option {
+	return func(c *Client) {
+		c.locker = c.TagLock(repo, tag)
+	}
+}
+
+// Default lease of FileLock and TagLock.
+const defaultLockTTL = 5 * time.Minute
+
+// lease is the state of a lock: its owner holds it until it expires, and
+// renews it while running so the locks of crashed processes expire.
+type lease struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// heldByOther reports whether the lease is held by another owner than owner.
+func (l lease) heldByOther(owner string) bool {
+	return l.Owner != "" && l.Owner != owner && time.Now().Before(l.Expires)
+}
+
+// lockDefaults returns owner and ttl, or their defaults when unset: the host
+// name and process ID, and defaultLockTTL.
+func lockDefaults(owner string, ttl time.Duration) (string, time.Duration) {
+	if owner == "" {
+		host, _ := os.Hostname()
+		owner = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	return owner, ttl
+}
+
+// renew calls refresh every interval, a third of ttl, until the returned
+// function is called. The lease is lost, ending ctx with ErrLockLost, when
+// refresh finds another owner with ErrLocked, or fails until the lease
+// expires.
+func renew(ctx *leaseContext, ttl time.Duration, refresh func() error) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		expires := time.Now().Add(ttl)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				renewed := time.Now().Add(ttl)
+				err := refresh()
+				if err == nil {
+					expires = renewed
+					continue
+				}
+				if err == ErrLocked || time.Now().After(expires) {
+					ctx.end(ErrLockLost)
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+		ctx.end(context.Canceled)
+	}
+}
+
+// leaseContext is the context of the work done under a lock. It ends with
+// its parent, or with ErrLockLost when the lease of the lock is lost.
+type leaseContext struct {
+	context.Context
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func newLeaseContext(parent context.Context) *leaseContext {
+	ctx := &leaseContext{Context: parent, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-parent.Done():
+			ctx.end(parent.Err())
+		case <-ctx.done:
+		}
+	}()
+	return ctx
+}
+
+func (ctx *leaseContext) Done() <-chan struct{} {
+	return ctx.done
+}
+
+func (ctx *leaseContext) Err() error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.err
+}
+
+// end ends the context with err, unless it already ended.
+func (ctx *leaseContext) end(err error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.err == nil {
+		ctx.err = err
+		close(ctx.done)
+	}
+}
+
+// FileLock is a Locker backed by a file, for processes sharing a file
+// system. The file holds the owner of the lock and when it expires.
+type FileLock struct {
+	Path string
+	// TTL is how long the lock outlives a crashed owner, 5 minutes when
+	// unset.
+	TTL time.Duration
+	// Owner identifies the process, by its host name and process ID when
+	// unset.
+	Owner string
+}
+
+func (l *FileLock) Lock(ctx context.Context) (context.Context, func(), error) {
+	owner, ttl := lockDefaults(l.Owner, l.TTL)
+	if err := l.acquire(owner, ttl); err != nil {
+		return nil, nil, err
+	}
+	locked := newLeaseContext(ctx)
+	stop := renew(locked, ttl, func() error {
+		current, err := l.read()
+		if err != nil {
+			return err
+		}
+		if current.Owner != owner {
+			return ErrLocked
+		}
+		return l.write(owner, ttl, false)
+	})
+	return locked, func() {
+		stop()
+		if current, err := l.read(); err == nil && current.Owner == owner {
+			os.Remove(l.Path)
+		}
+	}, nil
+}
+
+func (l *FileLock) acquire(owner string, ttl time.Duration) error {
+	for i := 0; i < 2; i++ {
+		err := l.write(owner, ttl, true)
+		if !os.IsExist(err) {
+			return err
+		}
+		current, err := l.read()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if current.heldByOther(owner) {
+			return ErrLocked
+		}
+		// The lease expired, or is left over by a former run of this owner.
+		if err := l.takeOver(current); err != nil {
+			return err
+		}
+	}
+	return ErrLocked
+}
+
+// takeOver removes the lock file holding the lease stale. Other processes
+// may be taking it over too, and one of them may already have replaced it
+// with a lock of its own, so the file is moved aside first and put back
+// unless it still holds stale.
+func (l *FileLock) takeOver(stale lease) error {
+	f, err := ioutil.TempFile(filepath.Dir(l.Path), ".lock-")
+	if err != nil {
+		return err
+	}
+	aside := f.Name()
+	f.Close()
+	defer os.Remove(aside)
+	if err := os.Rename(l.Path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	moved, err := readLease(aside)
+	if err == nil && moved.Owner == stale.Owner && moved.Expires.Equal(stale.Expires) {
+		return nil
+	}
+	// The lock of another process: once back, it holds the lock, and if
+	// a third one took it meanwhile the renewals of the former find out.
+	if err := os.Link(aside, l.Path); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return ErrLocked
+}
+
+func (l *FileLock) read() (lease, error) {
+	return readLease(l.Path)
+}
+
+func readLease(path string) (lease, error) {
+	var current lease
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return current, err
+	}
+	err = unmarshalJSON(b, &current)
+	return current, err
+}
+
+// write writes a new lease to a temporary file and moves it to the lock
+// path. With create, it fails if the lock file exists.
+func (l *FileLock) write(owner string, ttl time.Duration, create bool) error {
+	b, err := marshalJSON(lease{Owner: owner, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(l.Path), ".lock-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if create {
+		return os.Link(f.Name(), l.Path)
+	}
+	return os.Rename(f.Name(), l.Path)
+}
+
+// Annotations of the manifests of tag locks.
+const (
+	annotationLockOwner   = "io.github.caeret.registry.lock.owner"
+	annotationLockExpires = "io.github.caeret.registry.lock.expires"
+)
+
+const mediaTypeLockConfig = "application/vnd.caeret.registry.lock.v1+json"
+
+// tagLockSettle is how long TagLock waits before checking it won the lock.
+var tagLockSettle = time.Second
+
+// TagLock is a Locker kept in the registry itself, as a tag pointing at a
+// manifest annotated with the owner of the lock and when it expires.
+// Registries cannot update tags conditionally, so processes taking the lock
+// at the same time are told apart by reading the tag again after a while:
+// the lock is safe against runs started within seconds of each other, not
+// against simultaneous ones. Cleaning runs leave the repository of the
+// lock alone.
+type TagLock struct {
+	client *Client
+	repo   string
+	tag    string
+	// TTL is how long the lock outlives a crashed owner, 5 minutes when
+	// unset.
+	TTL time.Duration
+	// Owner identifies the process, by its host name and process ID when
+	// unset.
+	Owner string
+}
+
+// TagLock returns a lock held as tag of repo, which should not hold images.
+func (c *Client) TagLock(repo, tag string) *TagLock {
+	return &TagLock{client: c, repo: repo, tag: tag}
+}
+
+func (l *TagLock) Lock(ctx context.Context) (context.Context, func(), error) {
+	owner, ttl := lockDefaults(l.Owner, l.TTL)
+	current, err := l.read(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if current.heldByOther(owner) {
+		return nil, nil, ErrLocked
+	}
+	if err := l.write(ctx, lease{Owner: owner, Expires: time.Now().Add(ttl)}); err != nil {
+		return nil, nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	case <-time.After(tagLockSettle):
+	}
+	if current, err = l.read(ctx); err != nil {
+		return nil, nil, err
+	}
+	if current.Owner != owner {
+		return nil, nil, ErrLocked
+	}
+	l.client.Info("acquire lock.", "repo", l.repo, "tag", l.tag, "owner", owner)
+
+	// The lock is renewed and released regardless of ctx, which may end
+	// before unlock is called.
+	bg := detachContext(ctx)
+	locked := newLeaseContext(ctx)
+	stop := renew(locked, ttl, func() error {
+		current, err := l.read(bg)
+		if err != nil {
+			return err
+		}
+		if current.Owner != owner {
+			l.client.Warn("lose lock.", "repo", l.repo, "tag", l.tag, "owner", current.Owner)
+			return ErrLocked
+		}
+		return l.write(bg, lease{Owner: owner, Expires: time.Now().Add(ttl)})
+	})
+	return locked, func() {
+		stop()
+		if current, err := l.read(bg); err == nil && current.Owner == owner {
+			if err := l.write(bg, lease{Owner: owner, Expires: time.Now()}); err != nil {
+				l.client.Warn("fail to release lock.", "repo", l.repo, "tag", l.tag, "error", err)
+			}
+		}
+	}, nil
+}
+
+// read returns the lease of the lock tag, a zero lease if there is none.
+func (l *TagLock) read(ctx context.Context) (lease, error) {
+	var current lease
+	body, _, err := l.client.getManifest(ctx, l.client.repoName(l.repo), l.tag)
+	if err == ErrNotFound {
+		return current, nil
+	}
+	if err != nil {
+		return current, err
+	}
+	var manifest Manifest
+	if err := unmarshalJSON(body, &manifest); err != nil {
+		return current, err
+	}
+	current.Owner = manifest.Annotations[annotationLockOwner]
+	current.Expires, _ = time.Parse(time.RFC3339Nano, manifest.Annotations[annotationLockExpires])
+	return current, nil
+}
+
+func (l *TagLock) write(ctx context.Context, state lease) error {
+	name := l.client.repoName(l.repo)
+	config := []byte("{}")
+	desc := Descriptor{MediaType: mediaTypeLockConfig, Digest: fmt.Sprintf("sha256:%x", sha256.Sum256(config)), Size: int64(len(config))}
+	err := l.client.pushBlob(ctx, name, desc, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(config)), nil
+	}, "")
+	if err != nil {
+		return err
+	}
+	body, err := marshalJSON(Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        desc,
+		Layers:        []Descriptor{},
+		Annotations: map[string]string{
+			annotationLockOwner:   state.Owner,
+			annotationLockExpires: state.Expires.UTC().Format(time.RFC3339Nano),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = l.client.putManifest(ctx, name, l.tag, MediaTypeOCIManifest, body)
+	return err
+}
@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileLockContention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	a := &FileLock{Path: path, Owner: "a"}
+	_, unlock, err := a.Lock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &FileLock{Path: path, Owner: "b"}
+	if _, _, err := b.Lock(context.Background()); err != ErrLocked {
+		t.Fatalf("Lock while held = %v, want ErrLocked", err)
+	}
+	unlock()
+	_, unlock, err = b.Lock(context.Background())
+	if err != nil {
+		t.Fatalf("Lock after unlock: %v", err)
+	}
+	unlock()
+}
+
+func TestFileLockStaleTakeover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	for i := 0; i < 20; i++ {
+		stale := fmt.Sprintf(`{"owner":"crashed","expires":%q}`, time.Now().Add(-time.Minute).Format(time.RFC3339Nano))
+		if err := ioutil.WriteFile(path, []byte(stale), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var mu sync.Mutex
+		var holders []string
+		var unlocks []func()
+		var wg sync.WaitGroup
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func(owner string) {
+				defer wg.Done()
+				l := &FileLock{Path: path, Owner: owner}
+				_, unlock, err := l.Lock(context.Background())
+				if err == ErrLocked {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				holders = append(holders, owner)
+				unlocks = append(unlocks, unlock)
+			}(fmt.Sprint("owner", j))
+		}
+		wg.Wait()
+		if len(holders) != 1 {
+			t.Fatalf("lock taken over by %v, want a single owner", holders)
+		}
+		current, err := readLease(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current.Owner != holders[0] {
+			t.Fatalf("lock file held by %q, want %q", current.Owner, holders[0])
+		}
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}
+}
+
+// A process taking over a stale lock another one already took over must
+// leave the new lock alone.
+func TestFileLockTakeOverReplaced(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	stale := lease{Owner: "crashed", Expires: time.Now().Add(-time.Minute)}
+	a := &FileLock{Path: path, Owner: "a"}
+	if err := a.write("a", time.Minute, true); err != nil {
+		t.Fatal(err)
+	}
+	b := &FileLock{Path: path, Owner: "b"}
+	if err := b.takeOver(stale); err != ErrLocked {
+		t.Fatalf("takeOver of a replaced lock = %v, want ErrLocked", err)
+	}
+	if current, err := readLease(path); err != nil || current.Owner != "a" {
+		t.Errorf("lock file = %+v, %v, want the lease of a", current, err)
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files in the lock directory, want the lock file only", len(entries))
+	}
+}
+
+func TestFileLockLost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	l := &FileLock{Path: path, Owner: "a", TTL: 300 * time.Millisecond}
+	locked, unlock, err := l.Lock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := fmt.Sprintf(`{"owner":"b","expires":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339Nano))
+	if err := ioutil.WriteFile(path, []byte(other), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-locked.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not ended after the lock was taken over")
+	}
+	if err := locked.Err(); err != ErrLockLost {
+		t.Errorf("Err() = %v, want ErrLockLost", err)
+	}
+	unlock()
+	if current, err := readLease(path); err != nil || current.Owner != "b" {
+		t.Errorf("lock file after unlock = %+v, %v, want the lease of b", current, err)
+	}
+}
+
+func TestFileLockContextEnds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &FileLock{Path: filepath.Join(t.TempDir(), "lock")}
+	locked, unlock, err := l.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	cancel()
+	<-locked.Done()
+	if err := locked.Err(); err != context.Canceled {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,273 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MappingRule maps source repositories and tags to destination names. The
+// patterns are regular expressions matching whole names and the targets are
+// their replacements, which may refer to submatches like $1.
+type MappingRule struct {
+	Repository string `json:"repository"`
+	Target     string `json:"target"`
+	// Tag optionally restricts the rule to matching tags, which are renamed
+	// to TagTarget when it is set.
+	Tag       string `json:"tag,omitempty"`
+	TagTarget string `json:"tagTarget,omitempty"`
+
+	repo, tag *regexp.Regexp
+}
+
+// ParseMappingRule parses a rule written as "pattern -> target", such as
+// "team-a/(.*) -> mirror/a/$1". A tag mapping can be appended to both sides
+// after a colon, where tag targets refer to submatches of the tag pattern:
+// "app:v(.*) -> app:release-$1".
+func ParseMappingRule(s string) (MappingRule, error) {
+	parts := strings.Split(s, "->")
+	if len(parts) != 2 {
+		return MappingRule{}, fmt.Errorf("invalid mapping rule %q", s)
+	}
+	var rule MappingRule
+	rule.Repository, rule.Tag = splitTag(strings.TrimSpace(parts[0]))
+	rule.Target, rule.TagTarget = splitTag(strings.TrimSpace(parts[1]))
+	if err := rule.compile(); err != nil {
+		return MappingRule{}, err
+	}
+	return rule, nil
+}
+
+// splitTag splits a trailing ":tag" off a name, ignoring colons that are
+// followed by a slash.
+func splitTag(s string) (string, string) {
+	if i := strings.LastIndex(s, ":"); i >= 0 && !strings.Contains(s[i:], "/") {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+func (r *MappingRule) compile() error {
+	var err error
+	if r.repo, err = regexp.Compile("^(?:" + r.Repository + ")$"); err != nil {
+		return err
+	}
+	if r.Tag != "" {
+		if r.tag, err = regexp.Compile("^(?:" + r.Tag + ")$"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapRepository returns the destination of repo, if the rule applies.
+func (r *MappingRule) mapRepository(repo string) (string, bool) {
+	if !r.repo.MatchString(repo) {
+		return "", false
+	}
+	return r.repo.ReplaceAllString(repo, r.Target), true
+}
+
+// mapTag returns the destination of tag, if the rule applies.
+func (r *MappingRule) mapTag(tag string) (string, bool) {
+	if r.tag == nil {
+		return tag, true
+	}
+	if !r.tag.MatchString(tag) {
+		return "", false
+	}
+	if r.TagTarget == "" {
+		return tag, true
+	}
+	return r.tag.ReplaceAllString(tag, r.TagTarget), true
+}
+
+// MirrorOptions controls Mirror.
+type MirrorOptions struct {
+	CopyOptions
+	// Repositories lists the source repositories, all of the catalog when
+	// empty.
+	Repositories []string
+	// Rules map source names to destination names; the first rule matching
+	// both repository and tag applies. Tags matching no rule are skipped,
+	// and all are copied under their own names when there are no rules.
+	Rules []MappingRule
+	// Checkpoint, when set, records the repositories and tags mirrored, so
+	// an interrupted run started again skips them.
+	Checkpoint *Checkpoint
+}
+
+// MirroredTag is a tag copied by Mirror.
+type MirroredTag struct {
+	Source      TagRef `json:"source"`
+	Destination TagRef `json:"destination"`
+	Digest      string `json:"digest"`
+	// Skipped is set when the destination already had the image.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// MirrorResult summarizes a Mirror run.
+type MirrorResult struct {
+	Tags   []MirroredTag `json:"tags"`
+	Errors []string      `json:"errors,omitempty"`
+}
+
+// Mirror copies the tags of the selected repositories of c to dst, renaming
+// them according to the mapping rules. Tags already pointing at the same
+// digest in dst are skipped. Failures are collected in the result and do not
+// stop the run. With a Progress in the options, every tag is checked before
+// the first is copied, and the blobs of those to copy are totalled.
+func (c *Client) Mirror(ctx context.Context, dst *Client, opts MirrorOptions) (*MirrorResult, error) {
+	for i := range opts.Rules {
+		if err := opts.Rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	repos := opts.Repositories
+	if len(repos) == 0 {
+		var err error
+		repos, err = c.queryRepositories(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.BlobCache == nil {
+		opts.BlobCache = NewBlobCache()
+	}
+	cp := opts.Checkpoint
+	if cp != nil {
+		job, err := checkpointJob("mirror", struct {
+			Source, Destination string
+			Rules               []MappingRule
+			Referrers           bool
+		}{c.checkpointScope(), dst.host() + "/" + dst.prefix, opts.Rules, opts.Referrers})
+		if err != nil {
+			return nil, err
+		}
+		if n := cp.begin(job); n > 0 {
+			c.Info("resume mirror.", "done", n)
+		}
+	}
+	result := &MirrorResult{}
+	// failed are the repositories with tags that failed to mirror, which
+	// the checkpoint does not record as done.
+	failed := make(map[string]bool)
+	fail := func(mirrored MirroredTag, err error) {
+		c.Warn("fail to mirror tag.", "src", mirrored.Source, "dst", mirrored.Destination, "error", err)
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", mirrored.Source, err))
+		failed[mirrored.Source.Repository] = true
+	}
+	checkpoint := func(err error) {
+		if err != nil {
+			c.Warn("fail to save checkpoint.", "error", err)
+		}
+	}
+	// Tags are copied as they are checked unless the transfer is planned
+	// first.
+	plan := !opts.Progress.isStarted()
+	mirror := func(mirrored MirroredTag) {
+		if !mirrored.Skipped {
+			err := c.Copy(ctx, mirrored.Source.Repository, mirrored.Digest, dst, mirrored.Destination.Repository, mirrored.Destination.Tag, opts.CopyOptions)
+			if err != nil {
+				fail(mirrored, err)
+				return
+			}
+		}
+		result.Tags = append(result.Tags, mirrored)
+		checkpoint(cp.doneTag(mirrored.Source))
+	}
+	var pending []MirroredTag
+	for _, repo := range repos {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if cp.repoDone(repo) {
+			continue
+		}
+		tags, err := c.queryTags(ctx, repo)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", repo, err))
+			continue
+		}
+		for _, tag := range tags {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			dstRepo, dstTag, ok := mapName(opts.Rules, repo, tag)
+			if !ok || cp.tagDone(TagRef{repo, tag}) {
+				continue
+			}
+			mirrored := MirroredTag{Source: TagRef{repo, tag}, Destination: TagRef{dstRepo, dstTag}}
+			if err := c.checkMirrored(ctx, dst, &mirrored); err != nil {
+				fail(mirrored, err)
+				continue
+			}
+			if !plan {
+				mirror(mirrored)
+				continue
+			}
+			if !mirrored.Skipped {
+				if err := c.planCopy(ctx, repo, mirrored.Digest, dst, dstRepo, opts.CopyOptions); err != nil {
+					fail(mirrored, err)
+					continue
+				}
+			}
+			pending = append(pending, mirrored)
+		}
+		// Planned runs record their tags as they are copied.
+		if !plan && ctx.Err() == nil && !failed[repo] {
+			checkpoint(cp.doneRepos(repo))
+		}
+	}
+	if plan {
+		opts.Progress.start()
+		for _, mirrored := range pending {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			mirror(mirrored)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	checkpoint(cp.finish())
+	return result, nil
+}
+
+// checkMirrored resolves the source of mirrored, marking it skipped when the
+// destination tag already points at the same digest.
+func (c *Client) checkMirrored(ctx context.Context, dst *Client, mirrored *MirroredTag) error {
+	src, err := c.resolve(ctx, c.repoName(mirrored.Source.Repository), mirrored.Source.Tag)
+	if err != nil {
+		return err
+	}
+	mirrored.Digest = src.Digest
+	existing, err := dst.resolve(ctx, dst.repoName(mirrored.Destination.Repository), mirrored.Destination.Tag)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	mirrored.Skipped = err == nil && existing.Digest == src.Digest
+	return nil
+}
+
+// mapName applies the first matching rule to repo:tag.
+func mapName(rules []MappingRule, repo, tag string) (string, string, bool) {
+	if len(rules) == 0 {
+		return repo, tag, true
+	}
+	for i := range rules {
+		dstRepo, ok := rules[i].mapRepository(repo)
+		if !ok {
+			continue
+		}
+		dstTag, ok := rules[i].mapTag(tag)
+		if !ok {
+			continue
+		}
+		return dstRepo, dstTag, true
+	}
+	return "", "", false
+}
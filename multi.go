@@ -0,0 +1,280 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/inconshreveable/log15"
+)
+
+// RegistryConfig configures the connection to one registry of a Config.
+type RegistryConfig struct {
+	// Name identifies the registry in results and logs.
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// PasswordEnv names an environment variable holding the password, so
+	// configurations can be shared without secrets.
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+	// Prefix is the repository path prefix, as with WithPathPrefix.
+	Prefix    string            `json:"prefix,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	UserAgent string            `json:"userAgent,omitempty"`
+	// MaxConns limits the connections to the registry.
+	MaxConns          int  `json:"maxConns,omitempty"`
+	DisableKeepAlives bool `json:"disableKeepAlives,omitempty"`
+	// AllowHTTP falls back to plain HTTP, as with WithAllowHTTP.
+	AllowHTTP bool `json:"allowHTTP,omitempty"`
+	// Policy is the policy MultiClient.CleanWithPolicy applies to the
+	// registry.
+	Policy *Policy `json:"policy,omitempty"`
+	// Shard restricts the client to part of the repositories, as with
+	// WithShard.
+	Shard *Shard `json:"shard,omitempty"`
+}
+
+// Config lists the registries managed by a MultiClient.
+type Config struct {
+	Registries []RegistryConfig `json:"registries"`
+}
+
+// LoadConfig reads the JSON configuration file path.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := unmarshalJSON(b, &config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &config, nil
+}
+
+func (c *Config) validate() error {
+	seen := make(map[string]bool)
+	for i, r := range c.Registries {
+		if r.Name == "" {
+			return fmt.Errorf("registry %d has no name", i)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("duplicate registry %s", r.Name)
+		}
+		seen[r.Name] = true
+		if r.URL == "" {
+			return fmt.Errorf("registry %s has no url", r.Name)
+		}
+	}
+	return nil
+}
+
+// Options returns the client options configured for the registry.
+func (r RegistryConfig) Options() []Option {
+	var opts []Option
+	if r.Prefix != "" {
+		opts = append(opts, WithPathPrefix(r.Prefix))
+	}
+	if r.UserAgent != "" {
+		opts = append(opts, WithUserAgent(r.UserAgent))
+	}
+	for key, value := range r.Headers {
+		opts = append(opts, WithHeader(key, value))
+	}
+	if r.MaxConns > 0 {
+		opts = append(opts, WithMaxConnsPerHost(r.MaxConns), WithMaxIdleConnsPerHost(r.MaxConns))
+	}
+	if r.DisableKeepAlives {
+		opts = append(opts, WithDisableKeepAlives())
+	}
+	if r.AllowHTTP {
+		opts = append(opts, WithAllowHTTP())
+	}
+	if r.Shard != nil {
+		opts = append(opts, WithShard(*r.Shard))
+	}
+	return opts
+}
+
+// Connect creates a client of the registry. The options opts are applied
+// after those of the configuration.
+func (r RegistryConfig) Connect(logger log15.Logger, opts ...Option) (*Client, error) {
+	password := r.Password
+	if r.PasswordEnv != "" {
+		password = os.Getenv(r.PasswordEnv)
+	}
+	return NewClient(r.URL, r.Username, password, logger, append(r.Options(), opts...)...)
+}
+
+// MultiError holds the errors of the registries an operation of a
+// MultiClient failed on, by registry name.
+type MultiError map[string]error
+
+func (e MultiError) Error() string {
+	var names []string
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var msgs []string
+	for _, name := range names {
+		msgs = append(msgs, name+": "+e[name].Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// MultiClient runs operations across the named registries of a Config.
+// Registries are connected to on first use, so unreachable registries only
+// fail the operations using them.
+type MultiClient struct {
+	logger  log15.Logger
+	opts    []Option
+	configs []RegistryConfig
+
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+// NewMultiClient returns a client of the registries of config. The options
+// opts apply to every registry.
+func NewMultiClient(config *Config, logger log15.Logger, opts ...Option) (*MultiClient, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &MultiClient{
+		logger:  logger,
+		opts:    opts,
+		configs: config.Registries,
+		clients: make(map[string]*Client),
+	}, nil
+}
+
+// Names returns the names of the registries, in configuration order.
+func (m *MultiClient) Names() []string {
+	var names []string
+	for _, r := range m.configs {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+// Client returns the client of the registry name, connecting to it unless
+// done before.
+func (m *MultiClient) Client(name string) (*Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.clients[name]; ok {
+		return c, nil
+	}
+	for _, r := range m.configs {
+		if r.Name != name {
+			continue
+		}
+		c, err := r.Connect(m.logger.New("registry", name), m.opts...)
+		if err != nil {
+			return nil, err
+		}
+		m.clients[name] = c
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown registry %s", name)
+}
+
+// Each calls fn with the client of every registry in turn. Failures to
+// connect and errors returned by fn are collected in a MultiError and do not
+// stop the run.
+func (m *MultiClient) Each(ctx context.Context, fn func(name string, c *Client) error) error {
+	errs := make(MultiError)
+	for _, name := range m.Names() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c, err := m.Client(name)
+		if err == nil {
+			err = fn(name, c)
+		}
+		if err != nil {
+			m.logger.Warn("fail to run on registry.", "registry", name, "error", err)
+			errs[name] = err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// QueryRepositories lists the repositories of every registry, by registry
+// name. The repositories of the registries that could be listed are
+// returned along with a MultiError for the others.
+func (m *MultiClient) QueryRepositories(ctx context.Context) (map[string][]string, error) {
+	repos := make(map[string][]string)
+	err := m.Each(ctx, func(name string, c *Client) error {
+		r, err := c.queryRepositories(ctx)
+		if err != nil {
+			return err
+		}
+		repos[name] = r
+		return nil
+	})
+	return repos, err
+}
+
+// AgeReport reports the age of the tags of every registry, by registry
+// name, as Client.AgeReport does.
+func (m *MultiClient) AgeReport(ctx context.Context) (map[string]*AgeReport, error) {
+	reports := make(map[string]*AgeReport)
+	err := m.Each(ctx, func(name string, c *Client) error {
+		report, err := c.AgeReport(ctx)
+		if err != nil {
+			return err
+		}
+		reports[name] = report
+		return nil
+	})
+	return reports, err
+}
+
+// Snapshot records the content of every registry, by registry name.
+func (m *MultiClient) Snapshot(ctx context.Context) (map[string]*Snapshot, error) {
+	snapshots := make(map[string]*Snapshot)
+	err := m.Each(ctx, func(name string, c *Client) error {
+		s, err := c.Snapshot(ctx)
+		if err != nil {
+			return err
+		}
+		snapshots[name] = s
+		return nil
+	})
+	return snapshots, err
+}
+
+// CleanWithPolicy cleans every registry with the policy of its
+// configuration, or with policy for registries without one. Registries
+// without any policy are left alone. Results are returned by registry name,
+// including the partial results of runs ctx cancelled.
+func (m *MultiClient) CleanWithPolicy(ctx context.Context, policy *Policy) (map[string]*CleanResult, error) {
+	results := make(map[string]*CleanResult)
+	err := m.Each(ctx, func(name string, c *Client) error {
+		p := m.policy(name, policy)
+		if p == nil {
+			c.Info("skip registry without policy.")
+			return nil
+		}
+		result, err := c.CleanWithPolicy(ctx, *p)
+		if result != nil {
+			// Cancelled runs still report what they deleted.
+			results[name] = result
+		}
+		return err
+	})
+	return results, err
+}
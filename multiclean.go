@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MultiCleanOptions controls MultiClient.Clean.
+type MultiCleanOptions struct {
+	// Policy applies to the registries without a policy of their own.
+	Policy *Policy
+	// Policies replace the policies of the named registries.
+	Policies map[string]Policy
+	// Registries restricts the run to the named registries, all of them
+	// when empty.
+	Registries []string
+	// Parallelism is the number of registries cleaned at once, all of them
+	// when zero.
+	Parallelism int
+}
+
+// RegistryClean is the run of MultiClient.Clean on one registry.
+type RegistryClean struct {
+	Registry string `json:"registry"`
+	Policy   string `json:"policy,omitempty"`
+	// Skipped is set for registries without any policy, which are left
+	// alone.
+	Skipped bool         `json:"skipped,omitempty"`
+	Result  *CleanResult `json:"result,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// MultiCleanReport merges the runs of MultiClient.Clean.
+type MultiCleanReport struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Registries lists the runs in configuration order.
+	Registries  []RegistryClean `json:"registries"`
+	Deleted     int             `json:"deleted"`
+	Kept        int             `json:"kept"`
+	Reclaimable int64           `json:"reclaimable"`
+	// Failed is the number of registries whose runs failed.
+	Failed int `json:"failed"`
+}
+
+// Clean cleans the registries concurrently, each with its own policy, and
+// merges the results. Runs failing do not stop the others; their errors are
+// returned in a MultiError along with the report. Cancelling ctx stops every
+// run after the deletions in flight, as with Client.CleanWithPolicy.
+func (m *MultiClient) Clean(ctx context.Context, opts MultiCleanOptions) (*MultiCleanReport, error) {
+	known := make(map[string]bool)
+	for _, name := range m.Names() {
+		known[name] = true
+	}
+	selected := make(map[string]bool)
+	for _, name := range opts.Registries {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown registry %s", name)
+		}
+		selected[name] = true
+	}
+	var names []string
+	for _, name := range m.Names() {
+		if len(selected) == 0 || selected[name] {
+			names = append(names, name)
+		}
+	}
+	for name := range opts.Policies {
+		if !known[name] {
+			return nil, fmt.Errorf("policy of unknown registry %s", name)
+		}
+	}
+	workers := opts.Parallelism
+	if workers <= 0 || workers > len(names) {
+		workers = len(names)
+	}
+
+	report := &MultiCleanReport{Started: time.Now()}
+	runs := make([]RegistryClean, len(names))
+	errs := make(MultiError)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			run, err := m.cleanRegistry(ctx, name, opts)
+			runs[i] = run
+			if err != nil {
+				m.logger.Warn("fail to clean registry.", "registry", name, "error", err)
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(i, name)
+	}
+	wg.Wait()
+
+	for _, run := range runs {
+		if run.Error != "" {
+			report.Failed++
+		}
+		if run.Result != nil {
+			report.Deleted += len(run.Result.Deleted)
+			report.Kept += run.Result.Kept
+			report.Reclaimable += run.Result.Reclaimable
+		}
+	}
+	report.Registries, report.Finished = runs, time.Now()
+	m.logger.Info("clean registries.", "registries", len(names), "deleted", report.Deleted, "failed", report.Failed, "elapsed", report.Finished.Sub(report.Started))
+	if len(errs) > 0 {
+		return report, errs
+	}
+	return report, nil
+}
+
+// cleanRegistry runs the policy opts give the registry name.
+func (m *MultiClient) cleanRegistry(ctx context.Context, name string, opts MultiCleanOptions) (RegistryClean, error) {
+	run := RegistryClean{Registry: name}
+	p := m.policy(name, opts.Policy)
+	if policy, ok := opts.Policies[name]; ok {
+		p = &policy
+	}
+	if p == nil {
+		m.logger.Info("skip registry without policy.", "registry", name)
+		run.Skipped = true
+		return run, nil
+	}
+	run.Policy = p.Name
+	c, err := m.Client(name)
+	if err != nil {
+		run.Error = err.Error()
+		return run, err
+	}
+	run.Result, err = c.CleanWithPolicy(ctx, *p)
+	if err != nil {
+		run.Error = err.Error()
+	}
+	return run, err
+}
+
+// policy returns the policy of the configuration of the registry name, or
+// fallback if it has none.
+func (m *MultiClient) policy(name string, fallback *Policy) *Policy {
+	for _, r := range m.configs {
+		if r.Name == name && r.Policy != nil {
+			return r.Policy
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,215 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// history is an entry of the build history of an image config.
+type history struct {
+	Created    *time.Time `json:"created,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	Author     string     `json:"author,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	EmptyLayer bool       `json:"empty_layer,omitempty"`
+}
+
+// image is an image manifest and its config being modified. The config
+// fields other than its layers and history are kept as they are.
+type image struct {
+	name     string
+	desc     Descriptor
+	manifest *Manifest
+	config   map[string]json.RawMessage
+	diffIDs  []string
+	history  []history
+}
+
+// loadImage reads the image repo:ref, selecting the manifest for platform
+// from an index.
+func (c *Client) loadImage(ctx context.Context, repo, ref string, platform Platform) (*image, error) {
+	manifest, desc, err := c.imageManifest(ctx, repo, ref, platform)
+	if err != nil {
+		return nil, err
+	}
+	name := c.repoName(repo)
+	b, err := c.getConfig(ctx, name, manifest.Config.Digest)
+	if err != nil {
+		return nil, errors.Wrap(err, "get config")
+	}
+	img := &image{name: name, desc: desc, manifest: manifest}
+	if err := unmarshalJSON(b, &img.config); err != nil {
+		return nil, errors.Wrap(err, "parse config")
+	}
+	var rootfs struct {
+		DiffIDs []string `json:"diff_ids"`
+	}
+	if raw, ok := img.config["rootfs"]; ok {
+		if err := unmarshalJSON(raw, &rootfs); err != nil {
+			return nil, errors.Wrap(err, "parse config")
+		}
+	}
+	if len(rootfs.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("config lists %d layers, manifest %d", len(rootfs.DiffIDs), len(manifest.Layers))
+	}
+	img.diffIDs = rootfs.DiffIDs
+	if raw, ok := img.config["history"]; ok {
+		if err := unmarshalJSON(raw, &img.history); err != nil {
+			return nil, errors.Wrap(err, "parse config")
+		}
+	}
+	return img, nil
+}
+
+// historyIndex returns the index of the history entry of the layer at
+// index layer, or the length of the history if it has no such entry.
+func (img *image) historyIndex(layer int) int {
+	for i, h := range img.history {
+		if h.EmptyLayer {
+			continue
+		}
+		if layer == 0 {
+			return i
+		}
+		layer--
+	}
+	return len(img.history)
+}
+
+// layerMediaType returns the media type of gzip layers for the manifest.
+func (img *image) layerMediaType() string {
+	if img.manifest.MediaType == MediaTypeDockerManifest {
+		return MediaTypeDockerLayer
+	}
+	return MediaTypeOCILayerGzip
+}
+
+// pushImage uploads the config of img and pushes its manifest under ref,
+// which may be a tag or empty to push by digest.
+func (c *Client) pushImage(ctx context.Context, img *image, ref string) (Descriptor, error) {
+	rootfs, err := marshalJSON(map[string]interface{}{"type": "layers", "diff_ids": img.diffIDs})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	img.config["rootfs"] = rootfs
+	if len(img.history) > 0 {
+		if img.config["history"], err = marshalJSON(img.history); err != nil {
+			return Descriptor{}, err
+		}
+	}
+	config, err := marshalJSON(img.config)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	img.manifest.Config.Digest = fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	img.manifest.Config.Size = int64(len(config))
+	err = c.pushBlob(ctx, img.name, img.manifest.Config, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(config)), nil
+	}, "")
+	if err != nil {
+		return Descriptor{}, errors.Wrap(err, "push config")
+	}
+
+	body, err := marshalJSON(img.manifest)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	desc := Descriptor{
+		MediaType: img.manifest.MediaType,
+		Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(body)),
+		Size:      int64(len(body)),
+	}
+	if desc.MediaType == "" {
+		desc.MediaType = MediaTypeOCIManifest
+	}
+	if ref == "" {
+		ref = desc.Digest
+	}
+	if _, err := c.putManifest(ctx, img.name, ref, desc.MediaType, body); err != nil {
+		return Descriptor{}, errors.Wrap(err, "push manifest")
+	}
+	c.Info("push image.", "repo", img.name, "ref", ref, "digest", desc.Digest)
+	return desc, nil
+}
+
+// layerFile is a gzip layer built in a temporary file.
+type layerFile struct {
+	path   string
+	desc   Descriptor
+	diffID string
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// newLayerFile builds a layer of mediaType from the tar entries write adds.
+// The caller must remove the layer once pushed.
+func newLayerFile(mediaType string, write func(tw *tar.Writer) error) (*layerFile, error) {
+	f, err := ioutil.TempFile("", "layer-")
+	if err != nil {
+		return nil, err
+	}
+	l := &layerFile{path: f.Name()}
+	if err := l.write(f, write); err != nil {
+		f.Close()
+		os.Remove(l.path)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(l.path)
+		return nil, err
+	}
+	l.desc.MediaType = mediaType
+	return l, nil
+}
+
+func (l *layerFile) write(f *os.File, write func(tw *tar.Writer) error) error {
+	var digest, diffID hash.Hash = sha256.New(), sha256.New()
+	size := &countingWriter{}
+	gz := gzip.NewWriter(io.MultiWriter(f, digest, size))
+	tw := tar.NewWriter(io.MultiWriter(gz, diffID))
+	if err := write(tw); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	l.desc.Digest = fmt.Sprintf("sha256:%x", digest.Sum(nil))
+	l.desc.Size = size.n
+	l.diffID = fmt.Sprintf("sha256:%x", diffID.Sum(nil))
+	return nil
+}
+
+func (l *layerFile) remove() {
+	os.Remove(l.path)
+}
+
+// pushLayer uploads the layer l to the repository name.
+func (c *Client) pushLayer(ctx context.Context, name string, l *layerFile) error {
+	err := c.pushBlob(ctx, name, l.desc, func() (io.ReadCloser, error) {
+		return os.Open(l.path)
+	}, "")
+	return errors.Wrap(err, "push layer")
+}
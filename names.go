@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Grammar of the distribution spec.
+var (
+	repositoryRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:\.|_|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:\.|_|__|-+)[a-z0-9]+)*)*$`)
+	tagRegexp        = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+	digestRegexp     = regexp.MustCompile(`^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+)
+
+// maxRepositoryLength is the longest repository name registries accept.
+const maxRepositoryLength = 255
+
+// ValidateRepository checks that name is a valid repository name: path
+// components of lowercase letters and digits, separated by '.', '_', '__'
+// or dashes.
+func ValidateRepository(name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid repository name: empty")
+	}
+	if len(name) > maxRepositoryLength {
+		return fmt.Errorf("invalid repository name %q: longer than %d characters", name, maxRepositoryLength)
+	}
+	if !repositoryRegexp.MatchString(name) {
+		if strings.ToLower(name) != name {
+			return fmt.Errorf("invalid repository name %q: must be lowercase", name)
+		}
+		return fmt.Errorf("invalid repository name %q: components must be letters and digits separated by '.', '_', '__' or '-'", name)
+	}
+	return nil
+}
+
+// ValidateTag checks that tag is a valid tag: up to 128 letters, digits,
+// '_', '.' and '-', not starting with '.' or '-'.
+func ValidateTag(tag string) error {
+	if !tagRegexp.MatchString(tag) {
+		return fmt.Errorf("invalid tag %q: must be up to 128 letters, digits, '_', '.' or '-', not starting with '.' or '-'", tag)
+	}
+	return nil
+}
+
+// ValidateDigest checks that digest is written as algorithm:encoded, and
+// that the encoded part of sha256 and sha512 digests is hex of their length.
+// Other algorithms are only checked against the grammar.
+func ValidateDigest(digest string) error {
+	if !digestRegexp.MatchString(digest) {
+		return fmt.Errorf("invalid digest %q", digest)
+	}
+	if alg, ok := digestAlgorithms[digestAlgorithm(digest)]; ok {
+		encoded := digest[strings.Index(digest, ":")+1:]
+		if len(encoded) != alg.length || strings.Trim(encoded, "0123456789abcdef") != "" {
+			return fmt.Errorf("invalid digest %q: must be %d lowercase hex digits", digest, alg.length)
+		}
+	}
+	return nil
+}
+
+// validateReference checks that ref is a valid tag or digest.
+func validateReference(ref string) error {
+	if strings.Contains(ref, ":") {
+		return ValidateDigest(ref)
+	}
+	return ValidateTag(ref)
+}
+
+// apiPath matches the repository and reference of distribution API paths.
+var apiPath = regexp.MustCompile(`^/v2/(.+)/(manifests|blobs|tags|referrers)/([^/]*)$`)
+
+// validatePath checks the repository name and reference of the API path,
+// so invalid names fail with a clear error rather than a 4xx response.
+func validatePath(path string) error {
+	path = strings.SplitN(path, "?", 2)[0]
+	if path == "/v2/" || strings.HasPrefix(path, "/v2/_catalog") {
+		return nil
+	}
+	m := apiPath.FindStringSubmatch(path)
+	if m == nil {
+		// Uploads, and endpoints the client does not model.
+		if m := repositoryPath.FindStringSubmatch(path); m != nil {
+			return ValidateRepository(m[1])
+		}
+		return nil
+	}
+	if err := ValidateRepository(m[1]); err != nil {
+		return err
+	}
+	switch m[2] {
+	case "manifests":
+		return validateReference(m[3])
+	case "blobs", "referrers":
+		return ValidateDigest(m[3])
+	}
+	return nil
+}
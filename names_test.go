@@ -0,0 +1,60 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRepository(t *testing.T) {
+	for _, name := range []string{"app", "team/app", "team-a/app_b", "a__b", "a.b/c--d", "0/1"} {
+		if err := ValidateRepository(name); err != nil {
+			t.Errorf("ValidateRepository(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "App", "team//app", "/app", "app/", "a___b", "-app", "app-", "a b", "a:b", strings.Repeat("a", maxRepositoryLength+1)} {
+		if err := ValidateRepository(name); err == nil {
+			t.Errorf("ValidateRepository(%q) succeeded", name)
+		}
+	}
+}
+
+func TestValidateTag(t *testing.T) {
+	for _, tag := range []string{"v1", "latest", "V1.2.3-rc.1", "_x", strings.Repeat("a", 128)} {
+		if err := ValidateTag(tag); err != nil {
+			t.Errorf("ValidateTag(%q): %v", tag, err)
+		}
+	}
+	for _, tag := range []string{"", ".v1", "-v1", "v1/2", "v1:2", strings.Repeat("a", 129)} {
+		if err := ValidateTag(tag); err == nil {
+			t.Errorf("ValidateTag(%q) succeeded", tag)
+		}
+	}
+}
+
+func TestValidateDigest(t *testing.T) {
+	sha256 := "sha256:" + strings.Repeat("a", 64)
+	for _, digest := range []string{sha256, "sha512:" + strings.Repeat("0", 128), "multihash+base58:QmRZxt2b1FVZPNqd8hsiykDL3TdBDeTSPX9Kv46HmX4Gx8"} {
+		if err := ValidateDigest(digest); err != nil {
+			t.Errorf("ValidateDigest(%q): %v", digest, err)
+		}
+	}
+	for _, digest := range []string{"", "sha256", "sha256:", "sha256:" + strings.Repeat("a", 63), "sha256:" + strings.Repeat("A", 64), "SHA256:" + strings.Repeat("a", 64)} {
+		if err := ValidateDigest(digest); err == nil {
+			t.Errorf("ValidateDigest(%q) succeeded", digest)
+		}
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	for _, path := range []string{"/v2/", "/v2/_catalog?n=10", "/v2/team/app/manifests/v1", "/v2/app/manifests/" + digest, "/v2/app/blobs/" + digest, "/v2/app/tags/list", "/v2/app/blobs/uploads/"} {
+		if err := validatePath(path); err != nil {
+			t.Errorf("validatePath(%q): %v", path, err)
+		}
+	}
+	for _, path := range []string{"/v2/App/manifests/v1", "/v2/app/manifests/.v1", "/v2/app/blobs/sha256:x", "/v2/App/blobs/uploads/"} {
+		if err := validatePath(path); err == nil {
+			t.Errorf("validatePath(%q) succeeded", path)
+		}
+	}
+}
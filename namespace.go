@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Namespace is a view of the repositories below a name prefix, such as the
+// repositories of one team on a shared registry. Unlike WithPathPrefix,
+// repository names keep the prefix.
+type Namespace struct {
+	client *Client
+	prefix string
+}
+
+// Namespace returns a view restricted to the repositories named with prefix,
+// as in "team-a/".
+func (c *Client) Namespace(prefix string) *Namespace {
+	return &Namespace{client: c, prefix: strings.Trim(prefix, "/") + "/"}
+}
+
+// Contains reports whether repo belongs to the namespace.
+func (n *Namespace) Contains(repo string) bool {
+	return strings.HasPrefix(repo, n.prefix)
+}
+
+// QueryRepositories lists the repositories of the namespace.
+func (n *Namespace) QueryRepositories() ([]string, error) {
+	repos, err := n.client.QueryRepositories()
+	if err != nil {
+		return nil, err
+	}
+	var filtered []string
+	for _, repo := range repos {
+		if n.Contains(repo) {
+			filtered = append(filtered, repo)
+		}
+	}
+	return filtered, nil
+}
+
+// Repository returns a client for repo, which must belong to the namespace.
+func (n *Namespace) Repository(repo string) (*Repository, error) {
+	if !n.Contains(repo) {
+		return nil, fmt.Errorf("repository %s not in namespace %s", repo, n.prefix)
+	}
+	return n.client.Repository(repo), nil
+}
+
+// Clean deletes all images of the namespace except those with tags matching
+// keepTags.
+func (n *Namespace) Clean(keepTags ...string) error {
+	_, err := n.CleanWithPolicy(context.Background(), Policy{KeepTags: keepTags})
+	return err
+}
+
+// CleanWithPolicy applies policy to the repositories of the namespace only.
+func (n *Namespace) CleanWithPolicy(ctx context.Context, policy Policy) (*CleanResult, error) {
+	repos, err := n.QueryRepositories()
+	if err != nil {
+		return nil, err
+	}
+	return n.client.cleanRepositories(ctx, policy, repos)
+}
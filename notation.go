@@ -0,0 +1,342 @@
+package registry
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ArtifactTypeNotation = "application/vnd.cncf.notary.signature"
+
+	mediaTypeJWS = "application/jose+json"
+)
+
+// NotationSignatureVerifier is implemented by verifiers of Notation
+// signatures. VerifyNotation checks the signature envelope of mediaType over
+// the manifest digest of the image reference, host/repository, and returns
+// the identity of the signer.
+type NotationSignatureVerifier interface {
+	VerifyNotation(reference, digest, mediaType string, envelope []byte) (identity string, err error)
+}
+
+// NotationTrustPolicy is a Notation trust policy document, as found in
+// trustpolicy.json.
+type NotationTrustPolicy struct {
+	Version       string           `json:"version"`
+	TrustPolicies []NotationPolicy `json:"trustPolicies"`
+}
+
+// NotationPolicy is a trust policy applying to the repositories of its
+// registry scopes, "*" matching the repositories no other policy names.
+type NotationPolicy struct {
+	Name                  string   `json:"name"`
+	RegistryScopes        []string `json:"registryScopes"`
+	SignatureVerification struct {
+		// Level is strict, permissive, audit or skip. Strict enforces the
+		// integrity, authenticity and expiry of signatures, permissive does
+		// not enforce expiry and audit only enforces integrity.
+		Level string `json:"level"`
+	} `json:"signatureVerification"`
+	// TrustStores name the trust stores, like ca:acme.
+	TrustStores []string `json:"trustStores"`
+	// TrustedIdentities are "*" or the subjects of trusted signing
+	// certificates, like "x509.subject: C=US, O=Acme, CN=release".
+	TrustedIdentities []string `json:"trustedIdentities"`
+}
+
+// NotationVerifier verifies Notation signatures in the JWS envelope format
+// according to a trust policy. It implements SignatureVerifier so it can be
+// given to VerifySignature and Policy.ProtectSigned, but rejects cosign
+// signatures.
+type NotationVerifier struct {
+	Policy NotationTrustPolicy
+	// TrustStores are the certificates of the trust stores named by the
+	// policy, keyed by type and name like ca:acme.
+	TrustStores map[string]*x509.CertPool
+}
+
+// LoadNotationTrustStores loads the trust stores of a Notation trust store
+// directory, laid out as x509/<type>/<name>/<certificate files>.
+func LoadNotationTrustStores(dir string) (map[string]*x509.CertPool, error) {
+	stores := make(map[string]*x509.CertPool)
+	types, err := ioutil.ReadDir(filepath.Join(dir, "x509"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stores, nil
+		}
+		return nil, err
+	}
+	for _, t := range types {
+		names, err := ioutil.ReadDir(filepath.Join(dir, "x509", t.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			path := filepath.Join(dir, "x509", t.Name(), name.Name())
+			files, err := ioutil.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			for _, f := range files {
+				b, err := ioutil.ReadFile(filepath.Join(path, f.Name()))
+				if err != nil {
+					return nil, err
+				}
+				if !pool.AppendCertsFromPEM(b) {
+					cert, err := x509.ParseCertificate(b)
+					if err != nil {
+						return nil, errors.Wrap(err, f.Name())
+					}
+					pool.AddCert(cert)
+				}
+			}
+			stores[t.Name()+":"+name.Name()] = pool
+		}
+	}
+	return stores, nil
+}
+
+// errNotationOnly rejects the cosign signatures given to Notation verifiers,
+// which are then left out of verification results.
+var errNotationOnly = errors.New("not a Notation signature")
+
+func (v *NotationVerifier) Verify(payload, signature []byte, annotations map[string]string) error {
+	return errNotationOnly
+}
+
+// policy returns the trust policy of reference.
+func (v *NotationVerifier) policy(reference string) (*NotationPolicy, error) {
+	var wildcard *NotationPolicy
+	for i := range v.Policy.TrustPolicies {
+		p := &v.Policy.TrustPolicies[i]
+		for _, scope := range p.RegistryScopes {
+			if scope == reference {
+				return p, nil
+			}
+			if scope == "*" {
+				wildcard = p
+			}
+		}
+	}
+	if wildcard == nil {
+		return nil, fmt.Errorf("no trust policy for %s", reference)
+	}
+	return wildcard, nil
+}
+
+// jwsEnvelope is the JSON serialization of a JWS signature envelope.
+type jwsEnvelope struct {
+	Payload   string `json:"payload"`
+	Protected string `json:"protected"`
+	Header    struct {
+		X5C [][]byte `json:"x5c"`
+	} `json:"header"`
+	Signature string `json:"signature"`
+}
+
+func (v *NotationVerifier) VerifyNotation(reference, digest, mediaType string, envelope []byte) (string, error) {
+	p, err := v.policy(reference)
+	if err != nil {
+		return "", err
+	}
+	level := p.SignatureVerification.Level
+	switch level {
+	case "skip":
+		return "", nil
+	case "strict", "permissive", "audit":
+	default:
+		return "", fmt.Errorf("trust policy %s: unknown verification level %q", p.Name, level)
+	}
+	if mediaType != mediaTypeJWS {
+		return "", fmt.Errorf("unsupported signature envelope %s", mediaType)
+	}
+
+	// Integrity.
+	var env jwsEnvelope
+	if err := unmarshalJSON(envelope, &env); err != nil {
+		return "", errors.Wrap(err, "parse envelope")
+	}
+	if len(env.Header.X5C) == 0 {
+		return "", errors.New("no signing certificate")
+	}
+	var certs []*x509.Certificate
+	for _, der := range env.Header.X5C {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return "", errors.Wrap(err, "parse certificate")
+		}
+		certs = append(certs, cert)
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(env.Protected)
+	if err != nil {
+		return "", errors.Wrap(err, "decode protected header")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return "", errors.Wrap(err, "decode payload")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(env.Signature)
+	if err != nil {
+		return "", errors.Wrap(err, "decode signature")
+	}
+	var header struct {
+		Alg         string `json:"alg"`
+		SigningTime string `json:"io.cncf.notary.signingTime"`
+		Expiry      string `json:"io.cncf.notary.expiry"`
+	}
+	if err := unmarshalJSON(protected, &header); err != nil {
+		return "", errors.Wrap(err, "decode protected header")
+	}
+	if err := verifyJWS(certs[0].PublicKey, header.Alg, []byte(env.Protected+"."+env.Payload), signature); err != nil {
+		return "", err
+	}
+	var signed struct {
+		TargetArtifact Descriptor `json:"targetArtifact"`
+	}
+	if err := unmarshalJSON(payload, &signed); err != nil {
+		return "", errors.Wrap(err, "decode payload")
+	}
+	if target := signed.TargetArtifact.Digest; target != digest {
+		return "", fmt.Errorf("signature of %s", target)
+	}
+	identity := certs[0].Subject.String()
+	if level == "audit" {
+		return identity, nil
+	}
+
+	// Authenticity.
+	signingTime, err := time.Parse(time.RFC3339, header.SigningTime)
+	if err != nil {
+		return "", errors.Wrap(err, "signing time")
+	}
+	var verified bool
+	for _, name := range p.TrustStores {
+		roots := v.TrustStores[name]
+		if roots == nil {
+			continue
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   signingTime,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		})
+		if err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return identity, fmt.Errorf("certificate of %s not trusted by trust policy %s", identity, p.Name)
+	}
+	if !trustedIdentity(certs[0], p.TrustedIdentities) {
+		return identity, fmt.Errorf("%s not a trusted identity of trust policy %s", identity, p.Name)
+	}
+	if level == "permissive" {
+		return identity, nil
+	}
+
+	// Expiry.
+	if s := header.Expiry; s != "" {
+		expiry, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return identity, errors.Wrap(err, "expiry")
+		}
+		if time.Now().After(expiry) {
+			return identity, fmt.Errorf("signature expired at %s", s)
+		}
+	}
+	return identity, nil
+}
+
+// verifyJWS checks a JWS signature of the PS, RS or ES algorithms.
+func verifyJWS(key crypto.PublicKey, alg string, input, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	var h hash.Hash
+	var hashID crypto.Hash
+	switch alg[2:] {
+	case "256":
+		h, hashID = sha256.New(), crypto.SHA256
+	case "384":
+		h, hashID = sha512.New384(), crypto.SHA384
+	case "512":
+		h, hashID = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	h.Write(input)
+	digest := h.Sum(nil)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "PS":
+			return rsa.VerifyPSS(k, hashID, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hashID, digest, signature)
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] == "ES" {
+			// JWS encodes the r and s of ECDSA signatures side by side.
+			n := len(signature) / 2
+			r, s := new(big.Int).SetBytes(signature[:n]), new(big.Int).SetBytes(signature[n:])
+			if !ecdsa.Verify(k, digest, r, s) {
+				return errors.New("invalid signature")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("signature algorithm %q does not match key %T", alg, key)
+}
+
+// trustedIdentity reports whether cert matches one of the trusted identities
+// of a trust policy. An identity matches when the subject of cert has all of
+// its attributes.
+func trustedIdentity(cert *x509.Certificate, identities []string) bool {
+	attributes := map[string][]string{
+		"C":  cert.Subject.Country,
+		"ST": cert.Subject.Province,
+		"L":  cert.Subject.Locality,
+		"O":  cert.Subject.Organization,
+		"OU": cert.Subject.OrganizationalUnit,
+		"CN": {cert.Subject.CommonName},
+	}
+next:
+	for _, identity := range identities {
+		if identity == "*" {
+			return true
+		}
+		if !strings.HasPrefix(identity, "x509.subject:") {
+			continue
+		}
+		for _, attr := range strings.Split(strings.TrimPrefix(identity, "x509.subject:"), ",") {
+			parts := strings.SplitN(strings.TrimSpace(attr), "=", 2)
+			if len(parts) != 2 || !contains(attributes[parts[0]], parts[1]) {
+				continue next
+			}
+		}
+		return true
+	}
+	return false
+}
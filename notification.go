@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"sort"
+	"strings"
+)
+
+// PushedRepositories returns the repositories the pushes reported by a
+// registry notification went to, named as by the client. Both the event
+// envelopes of the distribution registry and the PUSH_ARTIFACT webhooks of
+// Harbor are understood; other events, and repositories outside the path
+// prefix or the shard of the client, are left out.
+func (c *Client) PushedRepositories(body []byte) ([]string, error) {
+	var notification struct {
+		Events []struct {
+			Action string `json:"action"`
+			Target struct {
+				Repository string `json:"repository"`
+			} `json:"target"`
+		} `json:"events"`
+		Type      string `json:"type"`
+		EventData struct {
+			Repository struct {
+				RepoFullName string `json:"repo_full_name"`
+			} `json:"repository"`
+		} `json:"event_data"`
+	}
+	if err := unmarshalJSON(body, &notification); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range notification.Events {
+		if e.Action == "push" {
+			names = append(names, e.Target.Repository)
+		}
+	}
+	if notification.Type == "PUSH_ARTIFACT" {
+		names = append(names, notification.EventData.Repository.RepoFullName)
+	}
+	seen := make(map[string]bool)
+	var repos []string
+	for _, name := range names {
+		repo := name
+		if c.prefix != "" {
+			if !strings.HasPrefix(name, c.prefix+"/") {
+				continue
+			}
+			repo = strings.TrimPrefix(name, c.prefix+"/")
+		}
+		if repo != "" && !seen[repo] && c.inShard(repo) {
+			seen[repo] = true
+			repos = append(repos, repo)
+		}
+	}
+	sort.Strings(repos)
+	return repos, nil
+}
@@ -0,0 +1,231 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// CleanNotification is the outcome of a Clean run, as sent to notifiers.
+type CleanNotification struct {
+	Registry string       `json:"registry"`
+	Policy   string       `json:"policy,omitempty"`
+	Result   *CleanResult `json:"result,omitempty"`
+	// Error is set when the run failed before completing.
+	Error string `json:"error,omitempty"`
+}
+
+// Summary describes the run in one line.
+func (n CleanNotification) Summary() string {
+	s := "Clean of " + n.Registry
+	if n.Policy != "" {
+		s += " by policy " + n.Policy
+	}
+	if n.Result == nil {
+		return s + " failed: " + n.Error
+	}
+	r := n.Result
+	s += fmt.Sprintf(": %d images deleted, %d kept, %s reclaimable", len(r.Deleted), r.Kept, formatBytes(r.Reclaimable))
+	if len(r.Errors) > 0 {
+		s += fmt.Sprintf(", %d errors", len(r.Errors))
+	}
+	if n.Error != "" {
+		s += ", failed: " + n.Error
+	}
+	return s + fmt.Sprintf(" (%s)", r.Finished.Sub(r.Started).Round(time.Second))
+}
+
+// formatBytes formats n in binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Notifier reports the outcome of Clean runs, for instance to a chat
+// channel.
+type Notifier interface {
+	Notify(ctx context.Context, n CleanNotification) error
+}
+
+// WithNotifier makes the client report the outcome of every Clean run to
+// the notifiers. Failures to notify are logged and do not fail the run.
+func WithNotifier(notifiers ...Notifier) Option {
+	return func(c *Client) {
+		c.notifiers = append(c.notifiers, notifiers...)
+	}
+}
+
+// notify reports the outcome of a Clean run by policy to the notifiers.
+func (c *Client) notify(ctx context.Context, policy Policy, result *CleanResult, err error) {
+	n := CleanNotification{Registry: c.host(), Policy: policy.Name, Result: result}
+	if err != nil {
+		n.Error = err.Error()
+	}
+	for _, notifier := range c.notifiers {
+		if err := notifier.Notify(ctx, n); err != nil {
+			c.Warn("fail to notify.", "notifier", fmt.Sprintf("%T", notifier), "error", err)
+		}
+	}
+}
+
+// WebhookNotifier posts notifications as JSON to a URL.
+type WebhookNotifier struct {
+	URL string
+	// Header is sent with every request, such as an Authorization header.
+	Header http.Header
+	Client *http.Client
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n CleanNotification) error {
+	b, err := marshalJSON(n)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, w.Client, w.URL, w.Header, b)
+}
+
+// SlackNotifier posts the summary of notifications to a Slack incoming
+// webhook.
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+func (s *SlackNotifier) Notify(ctx context.Context, n CleanNotification) error {
+	text := n.Summary()
+	if n.Result != nil && len(n.Result.Errors) > 0 {
+		errs := n.Result.Errors
+		if len(errs) > 10 {
+			errs = append(errs[:10:10], fmt.Sprintf("and %d more", len(n.Result.Errors)-10))
+		}
+		text += "\n• " + strings.Join(errs, "\n• ")
+	}
+	b, err := marshalJSON(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.Client, s.WebhookURL, nil, b)
+}
+
+func postJSON(ctx context.Context, client *http.Client, target string, header http.Header, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return redactError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("invalid response %d:%s", resp.StatusCode, Redact(string(b)))
+	}
+	return nil
+}
+
+// SMTPNotifier mails notifications. The summary is the subject, the body
+// lists the deleted images and the errors.
+type SMTPNotifier struct {
+	// Addr is the host:port of the mail server.
+	Addr string
+	// Username and Password authenticate with PLAIN auth when set.
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTPNotifier) Notify(ctx context.Context, n CleanNotification) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n",
+		s.From, strings.Join(s.To, ", "), n.Summary())
+	if n.Result != nil {
+		for _, d := range n.Result.Deleted {
+			fmt.Fprintf(&body, "deleted %s:%s %s\r\n", d.Repository, d.Tag, d.Digest)
+		}
+		for _, e := range n.Result.Errors {
+			fmt.Fprintf(&body, "error: %s\r\n", e)
+		}
+	}
+	if n.Error != "" {
+		fmt.Fprintf(&body, "error: %s\r\n", n.Error)
+	}
+	return s.send(ctx, []byte(body.String()))
+}
+
+// send is smtp.SendMail giving up when ctx is done, or after a minute.
+func (s *SMTPNotifier) send(ctx context.Context, msg []byte) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	conn.SetDeadline(deadline)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Option configures optional behaviour of a Client.
+type Option func(*Client)
+
+// WithPathPrefix makes the client operate below a fixed path prefix, such as
+// an Artifactory repository key or a Harbor project. Repository names passed
+// to and returned from the client are relative to the prefix.
+func WithPathPrefix(prefix string) Option {
+	return func(c *Client) {
+		c.prefix = strings.Trim(prefix, "/")
+	}
+}
+
+// WithUserAgent replaces the User-Agent header sent with every request.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.userAgent = ua
+	}
+}
+
+// WithHeader adds a header sent with every request, such as a token
+// required by a proxy in front of the registry.
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		c.header.Add(key, value)
+	}
+}
+
+// WithTransport sends requests through rt, such as a recording transport
+// from the registrytest package. The connection options only tune the
+// default transport.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		c.client.Transport = rt
+	}
+}
+
+// transport returns the transport of the client's HTTP client, replacing
+// the shared default transport with a private copy so it can be tuned.
+func (c *Client) transport() *http.Transport {
+	if t, ok := c.client.Transport.(*http.Transport); ok {
+		return t
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if c.client.Transport == nil {
+		c.client.Transport = t
+	}
+	return t
+}
+
+// WithMaxConnsPerHost limits the number of connections to the registry,
+// including those in use. Zero means no limit.
+func WithMaxConnsPerHost(n int) Option {
+	return func(c *Client) {
+		c.transport().MaxConnsPerHost = n
+	}
+}
+
+// WithMaxIdleConnsPerHost sets how many idle keep-alive connections to the
+// registry are kept for reuse.
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(c *Client) {
+		t := c.transport()
+		t.MaxIdleConnsPerHost = n
+		if t.MaxIdleConns != 0 && t.MaxIdleConns < n {
+			t.MaxIdleConns = n
+		}
+	}
+}
+
+// WithIdleConnTimeout sets how long idle keep-alive connections are kept
+// before being closed.
+func WithIdleConnTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.transport().IdleConnTimeout = d
+	}
+}
+
+// WithDisableKeepAlives makes the client open a new connection for every
+// request.
+func WithDisableKeepAlives() Option {
+	return func(c *Client) {
+		c.transport().DisableKeepAlives = true
+	}
+}
+
+// WithAllowHTTP lets the client fall back to plain HTTP when the registry
+// does not answer HTTPS, as local development registries such as
+// localhost:5000 often do not. Credentials are then sent in clear text, so
+// it should only be used with trusted networks.
+func WithAllowHTTP() Option {
+	return func(c *Client) {
+		c.allowHTTP = true
+	}
+}
+
+// WithIdempotentDeletes makes deleting a manifest that is already gone
+// succeed, as when another process deleted it since it was listed, instead
+// of failing with an error caused by ErrNotFound.
+func WithIdempotentDeletes() Option {
+	return func(c *Client) {
+		c.idempotentDeletes = true
+	}
+}
@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// PageOptions selects a page of a listing of the registry.
+type PageOptions struct {
+	// Next is the path of the page to get, as given by Page.Next. When
+	// empty, the page of the names after Last is asked for, of N names or as
+	// many as the registry lists by default when N is 0.
+	Next string
+	Last string
+	N    int
+}
+
+// Page is a page of the catalog or of the tags of a repository, along with
+// the pagination metadata of the response, for callers driving the paging
+// themselves.
+type Page struct {
+	// Names are the repositories or tags of the page as the registry lists
+	// them: catalog names include the path prefix of the client, and the
+	// shard of the client does not apply.
+	Names []string `json:"names"`
+	// Link is the Link header of the response, and Next the path of the
+	// next page it links to, empty on the last page.
+	Link string `json:"link,omitempty"`
+	Next string `json:"next,omitempty"`
+	// Last is the cursor the next page starts after, the last parameter of
+	// Next.
+	Last string `json:"last,omitempty"`
+}
+
+// CatalogPage returns a page of the catalog.
+func (c *Client) CatalogPage(ctx context.Context, opts PageOptions) (*Page, error) {
+	page, _, err := c.listPage(ctx, "/v2/_catalog", "registry:catalog:*", opts)
+	return page, err
+}
+
+// TagsPage returns a page of the tags of repo.
+func (c *Client) TagsPage(ctx context.Context, repo string, opts PageOptions) (*Page, error) {
+	name := c.repoName(repo)
+	page, _, err := c.listPage(ctx, fmt.Sprintf("/v2/%s/tags/list", name), fmt.Sprintf("repository:%s:*", name), opts)
+	return page, err
+}
+
+// listPage gets the page of the listing at base that opts select, returning
+// the path it was asked for too.
+func (c *Client) listPage(ctx context.Context, base, scope string, opts PageOptions) (*Page, string, error) {
+	path := opts.Next
+	if path == "" {
+		query := url.Values{}
+		if opts.Last != "" {
+			query.Set("last", opts.Last)
+		}
+		if opts.N > 0 {
+			query.Set("n", strconv.Itoa(opts.N))
+		}
+		path = base
+		if len(query) > 0 {
+			path += "?" + query.Encode()
+		}
+	}
+	resp, body, err := c.fetchWithHeader(ctx, path, scope, nil)
+	if err != nil {
+		return nil, path, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, path, statusError(resp, body)
+	}
+	var list struct {
+		Repositories []string `json:"repositories"`
+		Tags         []string `json:"tags"`
+	}
+	if err := decodeResponse(resp, body, &list); err != nil {
+		return nil, path, err
+	}
+	page := &Page{Names: list.Repositories, Link: resp.Header.Get("Link"), Next: nextLink(resp.Header)}
+	if list.Tags != nil {
+		page.Names = list.Tags
+	}
+	if u, err := url.Parse(page.Next); err == nil {
+		page.Last = u.Query().Get("last")
+	}
+	return page, path, nil
+}
+
+// listAll calls fn with the pages of the listing at base that opts select
+// and those following them, until the last page or fn returns false.
+func (c *Client) listAll(ctx context.Context, base, scope string, opts PageOptions, fn func(*Page) bool) error {
+	for {
+		page, path, err := c.listPage(ctx, base, scope, opts)
+		if err != nil {
+			return err
+		}
+		if !fn(page) || page.Next == "" || page.Next == path {
+			return nil
+		}
+		opts = PageOptions{Next: page.Next}
+	}
+}
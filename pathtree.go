@@ -0,0 +1,56 @@
+package registry
+
+import "path"
+
+// pathTree indexes slash separated paths, "" being the root, by directory,
+// so that the paths below a directory are found without scanning them all.
+// Directories implied by the paths added are indexed too.
+type pathTree map[string]map[string]bool
+
+// parentDir returns the directory of name, "" for the root.
+func parentDir(name string) string {
+	if dir := path.Dir(name); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// add indexes name and its parent directories.
+func (t pathTree) add(name string) {
+	for name != "" {
+		dir := parentDir(name)
+		children := t[dir]
+		if children == nil {
+			children = make(map[string]bool)
+			t[dir] = children
+		}
+		base := path.Base(name)
+		if children[base] {
+			return
+		}
+		children[base] = true
+		name = dir
+	}
+}
+
+// walk calls fn with the indexed paths below dir.
+func (t pathTree) walk(dir string, fn func(p string)) {
+	for base := range t[dir] {
+		p := path.Join(dir, base)
+		fn(p)
+		t.walk(p, fn)
+	}
+}
+
+// remove drops name and the paths below it.
+func (t pathTree) remove(name string) {
+	t.prune(name)
+	delete(t[parentDir(name)], path.Base(name))
+}
+
+func (t pathTree) prune(dir string) {
+	for base := range t[dir] {
+		t.prune(path.Join(dir, base))
+	}
+	delete(t, dir)
+}
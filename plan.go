@@ -0,0 +1,291 @@
+package registry
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPlanExpiry is how long plans can be applied after they are made.
+const DefaultPlanExpiry = 24 * time.Hour
+
+// ErrPlanExpired is the cause of the errors of Apply for expired plans.
+var ErrPlanExpired = errors.New("plan expired")
+
+// Plan is what a Clean run of a policy deletes, judged by Client.Plan and
+// deleted by Client.Apply, so that it can be reviewed, or saved as JSON and
+// approved, in between. Kept images are listed too; Apply needs them to tell
+// the blobs the deletions reclaim.
+//
+// Decisions pin the digests their tags pointed at when planned: Apply leaves
+// alone the tags pushed again since, so approving a plan never approves the
+// deletion of content it did not show.
+type Plan struct {
+	Policy Policy    `json:"policy"`
+	Time   time.Time `json:"time"`
+	// Expires is when the plan can no longer be applied, DefaultPlanExpiry
+	// after it is made. Plans without expiry can be applied at any time.
+	Expires   time.Time        `json:"expires"`
+	Decisions []PolicyDecision `json:"decisions"`
+	Deleted   int              `json:"deleted"`
+	Kept      int              `json:"kept"`
+	// Unchanged is the number of repositories incremental runs skipped.
+	Unchanged int `json:"unchanged,omitempty"`
+	// Errors lists the images the policy could not be applied to; Apply
+	// leaves them alone and reports them again.
+	Errors []string `json:"errors,omitempty"`
+
+	// run is the run the plan was made in, which decoded plans lack; they
+	// are applied without recording incremental runs.
+	run    *policyRun
+	failed map[string]bool
+}
+
+// Plan judges the images of repos, all repositories if none is given, as
+// CleanWithPolicy would, without deleting any.
+func (c *Client) Plan(ctx context.Context, policy Policy, repos ...string) (*Plan, error) {
+	if len(repos) == 0 {
+		var err error
+		if repos, err = c.queryRepositories(ctx); err != nil {
+			return nil, err
+		}
+	}
+	plan, err := c.plan(ctx, policy, repos)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Apply deletes the images plan dooms, as CleanWithPolicy would have when
+// it was made. The limits of its policy are checked again first, and the
+// tags of every image resolved again: tags pointing elsewhere are left
+// alone, and so are images left without any of their planned tags. Expired
+// plans are refused with an error caused by ErrPlanExpired.
+func (c *Client) Apply(ctx context.Context, plan *Plan) (*CleanResult, error) {
+	if !plan.Expires.IsZero() && time.Now().After(plan.Expires) {
+		return nil, errors.Wrapf(ErrPlanExpired, "plan of %s expired at %s", plan.Time.Format(time.RFC3339), plan.Expires.Format(time.RFC3339))
+	}
+	// Runs losing the lock stop with ErrLockLost.
+	locked := ctx
+	if c.locker != nil {
+		var unlock func()
+		var err error
+		if locked, unlock, err = c.locker.Lock(ctx); err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	result, err := c.apply(locked, plan, true)
+	c.notify(detachContext(ctx), plan.Policy, result, err)
+	return result, err
+}
+
+// plan judges the images of repos. When ctx ends the run the plan made so
+// far is returned with the error of ctx.
+func (c *Client) plan(ctx context.Context, policy Policy, repos []string) (*Plan, error) {
+	now := time.Now()
+	plan := &Plan{Policy: policy, Time: now, Expires: now.Add(DefaultPlanExpiry), failed: make(map[string]bool)}
+	var lockRepo string
+	if l, ok := c.locker.(*TagLock); ok && l.client == c {
+		lockRepo = l.repo
+	}
+	if policy.Quarantine != "" || lockRepo != "" {
+		var filtered []string
+		for _, repo := range repos {
+			if repo != policy.Quarantine && repo != lockRepo {
+				filtered = append(filtered, repo)
+			}
+		}
+		repos = filtered
+	}
+	run, err := c.newPolicyRun(ctx, policy, repos)
+	if err != nil {
+		if ctx.Err() != nil {
+			return plan, err
+		}
+		return nil, err
+	}
+	plan.run, plan.Unchanged = run, run.unchanged
+	plan.Errors = append(plan.Errors, run.errors...)
+	for _, repo := range run.failed {
+		plan.failed[repo] = true
+	}
+
+	var mu sync.Mutex
+	var all []string
+	for digest := range run.tags {
+		all = append(all, digest)
+	}
+	forEachDigest(ctx, run.workers(), all, func(digest string) {
+		rule, err := c.keptBy(ctx, run, digest)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			// Images interrupted by the cancellation are judged again on
+			// the next run.
+			if ctx.Err() == nil {
+				plan.Errors = append(plan.Errors, err.Error())
+				markFailed(plan.failed, run.tags[digest])
+			}
+			return
+		}
+		v := run.tags[digest]
+		d := PolicyDecision{Repository: v[0].Repository, Digest: digest, Tags: v, Delete: rule == "", Rule: rule}
+		if v[0].Tag == "" {
+			d.Tags = nil
+		}
+		if d.Delete {
+			plan.Deleted++
+		} else {
+			plan.Kept++
+		}
+		plan.Decisions = append(plan.Decisions, d)
+	})
+	sort.Slice(plan.Decisions, func(i, j int) bool {
+		a, b := plan.Decisions[i], plan.Decisions[j]
+		if a.Repository != b.Repository {
+			return a.Repository < b.Repository
+		}
+		return a.Digest < b.Digest
+	})
+	return plan, ctx.Err()
+}
+
+// apply deletes the images doomed by plan, first checking that their tags
+// did not change when pin is set. Once ctx is cancelled no new deletion is
+// started and the partial result is returned with its error.
+func (c *Client) apply(ctx context.Context, plan *Plan, pin bool) (*CleanResult, error) {
+	result := &CleanResult{Started: time.Now(), Kept: plan.Kept, Unchanged: plan.Unchanged}
+	result.Errors = append(result.Errors, plan.Errors...)
+	run := &policyRun{tags: make(map[string][]TagRef)}
+	if plan.run != nil {
+		*run = *plan.run
+	} else {
+		for _, d := range plan.Decisions {
+			if len(d.Tags) == 0 {
+				run.tags[d.Digest] = []TagRef{{Repository: d.Repository}}
+				continue
+			}
+			run.tags[d.Digest] = d.Tags
+		}
+	}
+	// The policy may have been changed since, such as to force the run.
+	run.policy = plan.Policy
+	var doomed []string
+	for _, d := range plan.Decisions {
+		if d.Delete {
+			doomed = append(doomed, d.Digest)
+		}
+	}
+	if err := run.checkDeletions(doomed); err != nil {
+		c.Error("refuse to clean.", "policy", plan.Policy.Name, "deletes", len(doomed), "error", err)
+		return nil, err
+	}
+	if len(doomed) > 0 {
+		if err := c.checkDelete(ctx, c.repoName(run.tags[doomed[0]][0].Repository)); err != nil {
+			c.Error("refuse to clean.", "policy", plan.Policy.Name, "deletes", len(doomed), "error", err)
+			return nil, err
+		}
+	}
+
+	failed := make(map[string]bool)
+	for repo := range plan.failed {
+		failed[repo] = true
+	}
+	// The first deletion refused as disabled stops the run.
+	dctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var disabled error
+	var mu sync.Mutex
+	forEachDigest(dctx, run.workers(), doomed, func(digest string) {
+		refs := run.tags[digest]
+		var err error
+		if pin {
+			refs, err = c.pinned(dctx, refs, digest)
+		}
+		var deleted *DeletedImage
+		if err == nil && refs != nil {
+			deleted, err = c.deleteDigest(dctx, run.policy, digest, refs)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case errors.Cause(err) == ErrDeleteDisabled:
+			if disabled == nil {
+				disabled = err
+				cancel()
+			}
+		case err != nil:
+			result.Errors = append(result.Errors, err.Error())
+			markFailed(failed, run.tags[digest])
+		case deleted == nil:
+			result.Changed++
+		default:
+			result.Deleted = append(result.Deleted, *deleted)
+		}
+	})
+	if disabled != nil {
+		result.Finished = time.Now()
+		c.Error("stop cleaning.", "deleted", len(result.Deleted), "error", disabled)
+		return result, disabled
+	}
+	if err := ctx.Err(); err != nil {
+		result.Cancelled, result.Finished = true, time.Now()
+		c.Warn("clean cancelled.", "deleted", len(result.Deleted), "error", err)
+		return result, err
+	}
+	c.estimateReclaimable(ctx, result, run.tags)
+	if plan.Policy.Incremental {
+		c.recordClean(run, result, failed)
+	}
+
+	result.Finished = time.Now()
+	return result, nil
+}
+
+// pinned returns refs, the tags of the image digest, without the tags of the
+// repository it is deleted from that no longer point at it, or nil when none
+// does. Untagged manifests are deleted by digest and need no check.
+func (c *Client) pinned(ctx context.Context, refs []TagRef, digest string) ([]TagRef, error) {
+	repo := refs[0].Repository
+	if refs[0].Tag == "" {
+		return refs, nil
+	}
+	var tags, others []TagRef
+	for _, ref := range refs {
+		if ref.Repository != repo {
+			others = append(others, ref)
+			continue
+		}
+		desc, err := c.resolve(ctx, c.repoName(repo), ref.Tag)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if desc.Digest != digest {
+			c.Warn("skip tag changed since planned.", "repo", repo, "tag", ref.Tag, "planned", digest, "digest", desc.Digest)
+			continue
+		}
+		tags = append(tags, ref)
+	}
+	if len(tags) == 0 {
+		c.Warn("skip image changed since planned.", "repo", repo, "digest", digest)
+		return nil, nil
+	}
+	// The image is deleted from the repository of the first tag.
+	return append(tags, others...), nil
+}
+
+// markFailed marks the repositories of refs in failed, so they are not
+// recorded as cleaned.
+func markFailed(failed map[string]bool, refs []TagRef) {
+	for _, t := range refs {
+		failed[t.Repository] = true
+	}
+}
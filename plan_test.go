@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caeret/registry/registrytest"
+)
+
+// newKeepTagsServer serves repository a, whose kept tag fails to resolve,
+// and repository b, whose only image is doomed.
+func newKeepTagsServer(t *testing.T) (*registrytest.Server, *Client) {
+	t.Helper()
+	s := registrytest.NewServer()
+	t.Cleanup(s.Close)
+	old := time.Now().Add(-time.Hour)
+	s.Image("a", "keep", old, []byte("a1"))
+	s.Image("a", "old", old, []byte("a2"))
+	s.Image("b", "old", old, []byte("b1"))
+	front := failingServer(t, s, http.MethodGet, "/v2/a/manifests/keep")
+	return s, newTestClient(t, front.URL)
+}
+
+var keepTagsPolicy = Policy{KeepTags: []string{"^keep$"}, Force: true}
+
+func TestCleanLeavesRepositoriesWithUnresolvedTags(t *testing.T) {
+	s, c := newKeepTagsServer(t)
+	result, err := c.CleanWithPolicy(context.Background(), keepTagsPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) == 0 {
+		t.Error("no error reported for the tag that failed to resolve")
+	}
+	if tags := s.Tags("a"); len(tags) != 2 {
+		t.Errorf("tags of a = %v, want keep and old left alone", tags)
+	}
+	if tags := s.Tags("b"); len(tags) != 0 {
+		t.Errorf("tags of b = %v, want none", tags)
+	}
+}
+
+func TestPlanApplyLeavesRepositoriesWithUnresolvedTags(t *testing.T) {
+	s, c := newKeepTagsServer(t)
+	ctx := context.Background()
+	plan, err := c.Plan(ctx, keepTagsPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Errors) == 0 {
+		t.Error("no error planned for the tag that failed to resolve")
+	}
+	for _, d := range plan.Decisions {
+		if d.Repository == "a" {
+			t.Errorf("decision %+v for the repository with an unresolved tag", d)
+		}
+	}
+
+	// Applying the plan after a round trip through JSON, as an approved
+	// plan would be.
+	b, err := marshalJSON(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Plan
+	if err := unmarshalJSON(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*Plan{&decoded, plan} {
+		result, err := c.Apply(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Errors) == 0 {
+			t.Error("no error reported by Apply for the tag that failed to resolve")
+		}
+	}
+	if tags := s.Tags("a"); len(tags) != 2 {
+		t.Errorf("tags of a = %v, want keep and old left alone", tags)
+	}
+	if tags := s.Tags("b"); len(tags) != 0 {
+		t.Errorf("tags of b = %v, want none", tags)
+	}
+}
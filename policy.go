@@ -0,0 +1,621 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Policy decides which images Clean deletes. Images are grouped by manifest
+// digest; a digest is kept if any rule protects it, and deleted otherwise
+// unless a restriction rule excludes it.
+//
+// Policies can be encoded as JSON, except for the verifier and scanner.
+// Durations are encoded as strings such as "720h".
+type Policy struct {
+	// Name identifies the policy in audit records.
+	Name string `json:"name,omitempty"`
+	// KeepTags are regular expressions protecting every digest with a
+	// matching tag.
+	KeepTags []string `json:"keepTags,omitempty"`
+	// KeepVersions is a range of semantic versions, such as ">=1.2 <2.0",
+	// protecting every digest with a tag in the range.
+	KeepVersions string `json:"keepVersions,omitempty"`
+	// ProtectSigned protects digests carrying a signature accepted by the
+	// verifier.
+	ProtectSigned SignatureVerifier `json:"-"`
+	// OnlyUnsigned restricts deletion to digests without any signature.
+	OnlyUnsigned bool `json:"onlyUnsigned,omitempty"`
+	// OlderThan restricts deletion to images created at least this long ago.
+	OlderThan time.Duration `json:"-"`
+	// Scanner and MinSeverity restrict deletion to images with known
+	// vulnerabilities of at least MinSeverity.
+	Scanner     Scanner  `json:"-"`
+	MinSeverity Severity `json:"minSeverity,omitempty"`
+	// KeepLastCharts protects the given number of highest Helm chart
+	// versions of every repository. It does not apply to container images.
+	KeepLastCharts int `json:"keepLastCharts,omitempty"`
+	// MinTags leaves alone repositories with fewer tags, sparing the lookup
+	// of their manifests when there is little to prune.
+	MinTags int `json:"minTags,omitempty"`
+	// Incremental skips repositories whose tags did not change since they
+	// were last cleaned with the same policy, as recorded in the cache of the
+	// client, which it requires. Images re-pushed under an existing tag, or
+	// going out of use, are only reconsidered once the record expires; see
+	// CacheOptions.CleanTTL.
+	Incremental bool `json:"incremental,omitempty"`
+	// Kinds restricts deletion to artifacts of the given kinds.
+	Kinds []ArtifactKind `json:"kinds,omitempty"`
+	// Protect lists sources of images in use, such as Kubernetes clusters.
+	// Digests in use, or having a tag in use, are protected.
+	Protect []InUseLister `json:"-"`
+	// Quarantine names a repository doomed images are moved to instead of
+	// being deleted, tagged as returned by QuarantineTag. It is left alone by
+	// the run, so a later Clean of it can delete them for good.
+	Quarantine string `json:"quarantine,omitempty"`
+	// Workers is the number of digests evaluated and deleted concurrently,
+	// one at a time when unset.
+	Workers int `json:"workers,omitempty"`
+	// MaxDeletes refuses runs that would delete more images, before
+	// deleting any, as a safeguard against policy mistakes. Runs are not
+	// limited when unset.
+	MaxDeletes int `json:"maxDeletes,omitempty"`
+	// MaxRepositoryPercent and MaxRegistryPercent refuse runs that would
+	// delete more than the given percentage of the tags of a repository, or
+	// of all the tags of the run, catching misconfigured policies before
+	// they delete anything. Force overrides them for runs meant to empty
+	// repositories.
+	MaxRepositoryPercent float64 `json:"maxRepositoryPercent,omitempty"`
+	MaxRegistryPercent   float64 `json:"maxRegistryPercent,omitempty"`
+	Force                bool    `json:"force,omitempty"`
+	// UntaggedOnly restricts deletion to the manifests no tag references,
+	// directly, from a tagged index or as a referrer of a referenced
+	// manifest, leaving every tag alone. Manifests are listed with
+	// Manifests, or with the Harbor artifact API of the registry when it is
+	// nil. Tag rules do not apply to untagged manifests, and such runs cannot
+	// be incremental.
+	UntaggedOnly bool           `json:"untaggedOnly,omitempty"`
+	Manifests    ManifestLister `json:"-"`
+
+	// Checkpoint, when set, records the repositories a run is done with
+	// when it stops short, so the run started again leaves them alone. The
+	// limits on deletions then apply to what is left.
+	Checkpoint *Checkpoint `json:"-"`
+}
+
+type policyJSON struct {
+	policyFields
+	OlderThan string `json:"olderThan,omitempty"`
+}
+
+// policyFields has the fields of Policy without its JSON methods.
+type policyFields Policy
+
+func (p Policy) MarshalJSON() ([]byte, error) {
+	v := policyJSON{policyFields: policyFields(p)}
+	if p.OlderThan > 0 {
+		v.OlderThan = p.OlderThan.String()
+	}
+	return marshalJSON(v)
+}
+
+func (p *Policy) UnmarshalJSON(b []byte) error {
+	var v policyJSON
+	if err := unmarshalJSON(b, &v); err != nil {
+		return err
+	}
+	*p = Policy(v.policyFields)
+	if v.OlderThan != "" {
+		d, err := time.ParseDuration(v.OlderThan)
+		if err != nil {
+			return err
+		}
+		p.OlderThan = d
+	}
+	return nil
+}
+
+// ErrDeletionLimit is the cause of the errors of runs refused because they
+// would delete more than the policy allows.
+var ErrDeletionLimit = errors.New("deletion limit exceeded")
+
+// CleanResult summarizes a Clean run.
+type CleanResult struct {
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Deleted  []DeletedImage `json:"deleted"`
+	// Kept is the number of digests the policy kept.
+	Kept int `json:"kept"`
+	// Unchanged is the number of repositories incremental runs skipped.
+	Unchanged int `json:"unchanged,omitempty"`
+	// Changed is the number of images Apply left alone because their tags
+	// were pushed again since planned.
+	Changed int `json:"changed,omitempty"`
+	// Reclaimable estimates the bytes of the blobs the deleted images leave
+	// unreferenced in their repositories, once garbage collected.
+	Reclaimable int64    `json:"reclaimable"`
+	Errors      []string `json:"errors,omitempty"`
+	// Cancelled is set when the context ended the run before every image
+	// was judged. The result then lists the deletions made until then,
+	// without estimating the bytes they reclaim.
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+// DeletedImage is a manifest deleted by Clean, along with the tag it was
+// deleted through.
+type DeletedImage struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest"`
+	// Quarantined is where the image was moved in quarantine mode.
+	Quarantined string `json:"quarantined,omitempty"`
+	// Reclaimable estimates the bytes of the blobs of the image no kept
+	// image of the repository references. Blobs shared by several deleted
+	// images count for each of them.
+	Reclaimable int64 `json:"reclaimable"`
+
+	// blobs are the sizes of the blobs of the image, by digest.
+	blobs map[string]int64
+}
+
+func (p Policy) signatureAware() bool {
+	return p.ProtectSigned != nil || p.OnlyUnsigned
+}
+
+// selective reports whether the policy restricts which unprotected images
+// get deleted.
+func (p Policy) selective() bool {
+	return p.signatureAware() || p.OlderThan > 0 || len(p.Kinds) > 0 || (p.Scanner != nil && p.MinSeverity > SeverityUnknown)
+}
+
+// CleanWithPolicy deletes the images of all repositories the policy does
+// not protect. Once ctx is cancelled no new deletion is started; those in
+// flight complete and the partial result is returned, marked Cancelled,
+// along with the error of ctx.
+func (c *Client) CleanWithPolicy(ctx context.Context, policy Policy) (*CleanResult, error) {
+	repos, err := c.queryRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.cleanRepositories(ctx, policy, repos)
+}
+
+// CleanRepositories applies policy to repos only, such as the repositories
+// just pushed to.
+func (c *Client) CleanRepositories(ctx context.Context, policy Policy, repos ...string) (*CleanResult, error) {
+	return c.cleanRepositories(ctx, policy, repos)
+}
+
+func (c *Client) cleanRepositories(ctx context.Context, policy Policy, repos []string) (*CleanResult, error) {
+	// Runs losing the lock stop with ErrLockLost.
+	locked := ctx
+	if c.locker != nil {
+		var unlock func()
+		var err error
+		if locked, unlock, err = c.locker.Lock(ctx); err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	result, err := c.clean(locked, policy, repos)
+	// The outcome is reported even when ctx ended the run.
+	c.notify(detachContext(ctx), policy, result, err)
+	return result, err
+}
+
+func (c *Client) clean(ctx context.Context, policy Policy, repos []string) (*CleanResult, error) {
+	started := time.Now()
+	cp := policy.Checkpoint
+	if cp != nil {
+		job, err := checkpointJob("clean", struct {
+			Registry string
+			Policy   Policy
+		}{c.checkpointScope(), policy})
+		if err != nil {
+			return nil, err
+		}
+		if n := cp.begin(job); n > 0 {
+			var left []string
+			for _, repo := range repos {
+				if !cp.repoDone(repo) {
+					left = append(left, repo)
+				}
+			}
+			c.Info("resume clean.", "policy", policy.Name, "done", n, "left", len(left))
+			repos = left
+		}
+	}
+	plan, err := c.plan(ctx, policy, repos)
+	if err != nil {
+		if plan != nil {
+			return &CleanResult{Started: started, Finished: time.Now(), Kept: plan.Kept, Unchanged: plan.Unchanged, Errors: plan.Errors, Cancelled: true}, err
+		}
+		return nil, err
+	}
+	// The plan was just made, its tags need no check.
+	result, err := c.apply(ctx, plan, false)
+	if result != nil {
+		result.Started = started
+	}
+	if cp != nil {
+		if cerr := c.checkpointClean(cp, plan, repos, result, err); cerr != nil {
+			c.Warn("fail to save checkpoint.", "error", cerr)
+		}
+	}
+	return result, err
+}
+
+// checkpointClean records in cp the repositories of the run of plan on
+// repos that are done: those where no doomed image is left and nothing
+// failed. Completed runs clear cp.
+func (c *Client) checkpointClean(cp *Checkpoint, plan *Plan, repos []string, result *CleanResult, err error) error {
+	if err == nil {
+		return cp.finish()
+	}
+	if result == nil {
+		return nil
+	}
+	left := make(map[string]bool)
+	for repo := range plan.failed {
+		left[repo] = true
+	}
+	deleted := make(map[string]bool)
+	for _, d := range result.Deleted {
+		deleted[d.Digest] = true
+	}
+	for _, d := range plan.Decisions {
+		if !d.Delete || deleted[d.Digest] {
+			continue
+		}
+		left[d.Repository] = true
+		for _, t := range d.Tags {
+			left[t.Repository] = true
+		}
+	}
+	var done []string
+	for _, repo := range repos {
+		if !left[repo] {
+			done = append(done, repo)
+		}
+	}
+	return cp.doneRepos(done...)
+}
+
+// policyRun holds what the rules of a policy need to judge the images of a
+// set of repositories.
+type policyRun struct {
+	policy    Policy
+	regs      []*regexp.Regexp
+	versions  versionRange
+	charts    map[string]bool
+	protected []Reference
+	// tags are the tags of the repositories, by digest.
+	tags map[string][]TagRef
+	// skip reports the tags left out of tags.
+	skip func(tag string) bool
+	// errors are the failures to walk the repositories, and failed the
+	// repositories left out of tags because of them.
+	errors []string
+	failed []string
+
+	// fingerprint is the encoded policy of incremental runs, and repoTags
+	// the tags of the repositories they examine.
+	fingerprint []byte
+	repoTags    map[string][]string
+	unchanged   int
+}
+
+// workers returns the number of digests the run handles concurrently.
+func (run *policyRun) workers() int {
+	if run.policy.Workers < 1 {
+		return 1
+	}
+	return run.policy.Workers
+}
+
+func (c *Client) newPolicyRun(ctx context.Context, policy Policy, repos []string) (*policyRun, error) {
+	run := &policyRun{policy: policy}
+	for _, tag := range policy.KeepTags {
+		reg, err := regexp.Compile(tag)
+		if err != nil {
+			return nil, err
+		}
+		run.regs = append(run.regs, reg)
+	}
+	if policy.KeepVersions != "" {
+		versions, err := parseVersionRange(policy.KeepVersions)
+		if err != nil {
+			return nil, err
+		}
+		run.versions = versions
+	}
+	protected, err := inUse(ctx, policy.Protect)
+	if err != nil {
+		return nil, err
+	}
+	run.protected = protected
+	if policy.Incremental {
+		if c.cache == nil {
+			return nil, errors.New("incremental clean needs a cache")
+		}
+		if policy.UntaggedOnly {
+			return nil, errors.New("incremental clean cannot find untagged manifests")
+		}
+		b, err := marshalJSON(policy)
+		if err != nil {
+			return nil, err
+		}
+		run.fingerprint = b
+		run.repoTags = make(map[string][]string)
+	}
+	run.skip = func(tag string) bool {
+		// Signatures and attachments go along with their images.
+		return policy.signatureAware() && attachmentTag.MatchString(tag)
+	}
+	opts := InventoryOptions{Repositories: repos}
+	opts.SkipTag = func(repo, tag string) bool {
+		return run.skip(tag)
+	}
+	opts.KeepRepository = func(repo string, tags []string) bool {
+		if len(tags) < policy.MinTags {
+			c.Info("skip repository with few tags.", "repo", repo, "tags", len(tags))
+			return false
+		}
+		if policy.Incremental {
+			if c.unchanged(run, repo, tags) {
+				c.Info("skip unchanged repository.", "repo", repo)
+				run.unchanged++
+				return false
+			}
+			run.repoTags[repo] = tags
+		}
+		return true
+	}
+	run.tags = make(map[string][]TagRef)
+	if policy.UntaggedOnly {
+		lister := c.manifestLister(policy)
+		for _, repo := range repos {
+			digests, err := c.untagged(ctx, lister, repo)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				c.Warn("fail to find untagged manifests.", "repo", repo, "error", err)
+				continue
+			}
+			for _, digest := range digests {
+				// Untagged manifests are known by an empty tag.
+				run.tags[digest] = append(run.tags[digest], TagRef{Repository: repo})
+			}
+		}
+	} else if len(repos) > 0 {
+		inv, err := c.Inventory(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		run.tags = inv.tagsByDigest()
+		run.errors, run.failed = inv.Errors, inv.Failed
+	}
+	if policy.KeepLastCharts > 0 {
+		run.charts = c.latestCharts(ctx, run.tags, policy.KeepLastCharts)
+	}
+	return run, nil
+}
+
+// keptBy returns the rule keeping the image digest, named as in
+// PolicyDecision, or an empty string if the policy deletes it.
+func (c *Client) keptBy(ctx context.Context, run *policyRun, digest string) (string, error) {
+	policy, v := run.policy, run.tags[digest]
+	for _, e := range v {
+		if e.Tag == "" {
+			continue
+		}
+		for i, reg := range run.regs {
+			if reg.MatchString(e.Tag) {
+				return "keepTags=" + policy.KeepTags[i], nil
+			}
+		}
+		if v, ok := parseVersion(e.Tag); ok && run.versions != nil && run.versions.contains(v) {
+			return "keepVersions=" + policy.KeepVersions, nil
+		}
+	}
+	if run.charts[digest] {
+		return "keepLastCharts=" + strconv.Itoa(policy.KeepLastCharts), nil
+	}
+	if ref, ok := c.inUseBy(run.protected, digest, v); ok {
+		c.Info("protect image in use.", "digest", digest, "ref", ref)
+		return "inUse=" + ref.String(), nil
+	}
+	if policy.selective() {
+		rule, err := c.selected(ctx, policy, v[0].Repository, v[0].Tag, digest)
+		if err != nil {
+			c.Warn("fail to apply policy.", "repo", v[0].Repository, "digest", digest, "error", err)
+		}
+		return rule, err
+	}
+	return "", nil
+}
+
+// checkDeletions returns an error caused by ErrDeletionLimit if deleting the
+// images doomed by the policy of run exceeds its limits. Deleting an image
+// deletes its tags in the repository it is deleted from.
+func (run *policyRun) checkDeletions(doomed []string) error {
+	policy := run.policy
+	if policy.MaxDeletes > 0 && len(doomed) > policy.MaxDeletes {
+		return errors.Wrapf(ErrDeletionLimit, "policy would delete %d images, more than maxDeletes %d", len(doomed), policy.MaxDeletes)
+	}
+	if policy.Force || (policy.MaxRepositoryPercent <= 0 && policy.MaxRegistryPercent <= 0) {
+		return nil
+	}
+	tags := make(map[string]int)
+	var total int
+	for _, refs := range run.tags {
+		for _, ref := range refs {
+			if ref.Tag != "" {
+				tags[ref.Repository]++
+				total++
+			}
+		}
+	}
+	deleted := make(map[string]int)
+	var n int
+	for _, digest := range doomed {
+		v := run.tags[digest]
+		for _, tag := range repoTags(v, v[0].Repository) {
+			if tag != "" {
+				deleted[v[0].Repository]++
+				n++
+			}
+		}
+	}
+	if limit := policy.MaxRegistryPercent; limit > 0 && percent(n, total) > limit {
+		return errors.Wrapf(ErrDeletionLimit, "policy would delete %.1f%% of the tags, more than maxRegistryPercent %g", percent(n, total), limit)
+	}
+	if limit := policy.MaxRepositoryPercent; limit > 0 {
+		var repos []string
+		for repo, d := range deleted {
+			if percent(d, tags[repo]) > limit {
+				repos = append(repos, fmt.Sprintf("%s (%.1f%%)", repo, percent(d, tags[repo])))
+			}
+		}
+		if len(repos) > 0 {
+			sort.Strings(repos)
+			return errors.Wrapf(ErrDeletionLimit, "policy would delete more than maxRepositoryPercent %g of the tags of %s", limit, strings.Join(repos, ", "))
+		}
+	}
+	return nil
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// forEachDigest calls fn for every digest with the given number of workers.
+// Once ctx is cancelled no new call is started; it returns when those in
+// flight complete.
+func forEachDigest(ctx context.Context, workers int, digests []string, fn func(digest string)) {
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for digest := range ch {
+				if ctx.Err() == nil {
+					fn(digest)
+				}
+			}
+		}()
+	}
+feed:
+	for _, digest := range digests {
+		select {
+		case ch <- digest:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// deleteDigest deletes the image digest the policy doomed, or moves it to
+// quarantine, from the repository of the first of its tags v.
+func (c *Client) deleteDigest(ctx context.Context, policy Policy, digest string, v []TagRef) (*DeletedImage, error) {
+	deleted := &DeletedImage{Repository: v[0].Repository, Tag: v[0].Tag, Digest: digest}
+	blobs, err := c.manifestBlobs(ctx, c.repoName(deleted.Repository), digest)
+	if err != nil {
+		c.Warn("fail to list blobs.", "repo", deleted.Repository, "digest", digest, "error", err)
+	}
+	deleted.blobs = blobs
+	if policy.Quarantine != "" {
+		target, err := c.quarantine(ctx, policy, v[0].Repository, digest, v)
+		if err != nil {
+			return nil, err
+		}
+		deleted.Quarantined = target
+		return deleted, nil
+	}
+	if v[0].Tag == "" {
+		err = c.deleteTag(ctx, v[0].Repository, digest, &policy, nil)
+	} else {
+		err = c.deleteTag(ctx, v[0].Repository, v[0].Tag, &policy, repoTags(v, v[0].Repository))
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The config of the image is one of its blobs.
+	for blob := range blobs {
+		c.configs.remove(blob)
+	}
+	return deleted, nil
+}
+
+// selected checks the unprotected image digest against the deletion
+// restrictions of policy, cheap rules first. It returns the restriction
+// keeping the image, or an empty string if it satisfies them all.
+func (c *Client) selected(ctx context.Context, policy Policy, repo, tag, digest string) (string, error) {
+	if policy.OlderThan > 0 || len(policy.Kinds) > 0 {
+		info, err := c.inspect(ctx, c.repoName(repo), digest)
+		if err != nil {
+			return "", err
+		}
+		if policy.OlderThan > 0 && time.Since(info.Created) < policy.OlderThan {
+			return "olderThan=" + policy.OlderThan.String(), nil
+		}
+		if len(policy.Kinds) > 0 && !hasKind(policy.Kinds, info.Kind) {
+			return "kinds", nil
+		}
+	}
+	if policy.ProtectSigned != nil {
+		signed, err := c.signed(ctx, repo, digest, policy.ProtectSigned)
+		if err != nil || signed {
+			return "protectSigned", err
+		}
+	}
+	if policy.OnlyUnsigned {
+		signed, err := c.signed(ctx, repo, digest, nil)
+		if err != nil || signed {
+			return "onlyUnsigned", err
+		}
+	}
+	if policy.Scanner != nil && policy.MinSeverity > SeverityUnknown {
+		image := Reference{Registry: c.host(), Repository: c.repoName(repo), Tag: tag, Digest: digest}
+		severity, err := policy.Scanner.Scan(ctx, image)
+		if err != nil || severity < policy.MinSeverity {
+			return "minSeverity=" + policy.MinSeverity.String(), err
+		}
+		c.Info("select vulnerable image.", "image", image, "severity", severity)
+	}
+	return "", nil
+}
+
+// repoTags returns the tags of refs in repo.
+func repoTags(refs []TagRef, repo string) []string {
+	var tags []string
+	for _, ref := range refs {
+		if ref.Repository == repo {
+			tags = append(tags, ref.Tag)
+		}
+	}
+	return tags
+}
+
+func hasKind(kinds []ArtifactKind, kind ArtifactKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
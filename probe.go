@@ -0,0 +1,355 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The checks of Probe.
+const (
+	ProbeCatalog           = "catalog"
+	ProbeCatalogPagination = "catalog pagination"
+	ProbeManifestHead      = "manifest HEAD"
+	ProbeChunkedUpload     = "chunked upload"
+	ProbeReferrers         = "referrers API"
+	ProbeDelete            = "manifest delete"
+)
+
+// ArtifactTypeProbe is the type of the artifacts Probe pushes.
+const ArtifactTypeProbe = "application/vnd.caeret.registry.probe.v1+txt"
+
+// ProbeOptions controls Probe.
+type ProbeOptions struct {
+	// Repository is a scratch repository the checks writing to the registry
+	// push to and delete from. They are skipped when it is empty.
+	Repository string
+}
+
+// ProbeCheck is the outcome of a check of Probe.
+type ProbeCheck struct {
+	Name      string `json:"name"`
+	Supported bool   `json:"supported"`
+	// Skipped is set when the check could not run, Detail tells why.
+	Skipped bool   `json:"skipped,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// ProbeReport lists the capabilities of a registry.
+type ProbeReport struct {
+	Registry string       `json:"registry"`
+	Time     time.Time    `json:"time"`
+	Checks   []ProbeCheck `json:"checks"`
+}
+
+// Check returns the check of the report named name.
+func (r *ProbeReport) Check(name string) (ProbeCheck, bool) {
+	for _, check := range r.Checks {
+		if check.Name == name {
+			return check, true
+		}
+	}
+	return ProbeCheck{}, false
+}
+
+// Probe exercises the registry and reports which parts of the distribution
+// API it supports, to tell in advance how the other calls of the client
+// behave against a new registry. The catalog and the manifests are only
+// read; the upload, referrers and delete checks push a small artifact to
+// opts.Repository, then delete it again when the registry allows. Failed
+// checks are reported, not returned; only the end of ctx is.
+func (c *Client) Probe(ctx context.Context, opts ProbeOptions) (*ProbeReport, error) {
+	report := &ProbeReport{Registry: c.host(), Time: time.Now()}
+	add := func(check ProbeCheck) {
+		report.Checks = append(report.Checks, check)
+	}
+	skip := func(name, detail string) {
+		add(ProbeCheck{Name: name, Skipped: true, Detail: detail})
+	}
+
+	repos, check := c.probeCatalog(ctx)
+	add(check)
+	if len(repos) < 2 {
+		skip(ProbeCatalogPagination, "fewer than two repositories")
+	} else {
+		add(c.probeCatalogPagination(ctx))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.Repository == "" {
+		if len(repos) == 0 {
+			skip(ProbeManifestHead, "no repository")
+		} else {
+			add(c.probeManifestHead(ctx, repos[0], ""))
+		}
+		for _, name := range []string{ProbeChunkedUpload, ProbeReferrers, ProbeDelete} {
+			skip(name, "no scratch repository given")
+		}
+		return report, ctx.Err()
+	}
+
+	name := c.repoName(opts.Repository)
+	data := []byte("registryctl probe " + report.Time.UTC().Format(time.RFC3339Nano))
+	add(c.probeChunkedUpload(ctx, name, data))
+	tag := "probe-" + strconv.FormatInt(report.Time.Unix(), 10)
+	image, err := c.PushArtifact(ctx, opts.Repository, Artifact{
+		ArtifactType: ArtifactTypeProbe,
+		Layers:       []ArtifactLayer{{MediaType: "text/plain", Data: data}},
+		Tag:          tag,
+	})
+	if err != nil {
+		detail := "push artifact: " + err.Error()
+		if len(repos) > 0 {
+			add(c.probeManifestHead(ctx, repos[0], ""))
+		} else {
+			skip(ProbeManifestHead, detail)
+		}
+		skip(ProbeReferrers, detail)
+		skip(ProbeDelete, detail)
+		return report, ctx.Err()
+	}
+	add(c.probeManifestHead(ctx, opts.Repository, tag))
+	// The referrer is deleted first, leaving nothing referring to a deleted
+	// manifest.
+	digests := []string{image.Digest}
+	referrer, err := c.PushArtifact(ctx, opts.Repository, Artifact{ArtifactType: ArtifactTypeProbe, Subject: &image})
+	if err != nil {
+		add(ProbeCheck{Name: ProbeReferrers, Detail: "push referrer: " + err.Error()})
+	} else {
+		add(c.probeReferrers(ctx, name, image.Digest))
+		digests = []string{referrer.Digest, image.Digest}
+	}
+	add(c.probeDelete(ctx, name, digests))
+	return report, ctx.Err()
+}
+
+// probeCatalog lists the first page of the catalog.
+func (c *Client) probeCatalog(ctx context.Context) ([]string, ProbeCheck) {
+	check := ProbeCheck{Name: ProbeCatalog}
+	resp, body, err := c.fetchWithHeader(ctx, "/v2/_catalog", "registry:catalog:*", nil)
+	if err != nil {
+		check.Detail = err.Error()
+		return nil, check
+	}
+	if resp.StatusCode != http.StatusOK {
+		check.Detail = fmt.Sprintf("status %d", resp.StatusCode)
+		return nil, check
+	}
+	var page catalogPage
+	if err := decodeResponse(resp, body, &page); err != nil {
+		check.Detail = err.Error()
+		return nil, check
+	}
+	names := page.Repositories
+	var repos []string
+	for _, name := range names {
+		if c.prefix == "" {
+			repos = append(repos, name)
+		} else if strings.HasPrefix(name, c.prefix+"/") {
+			repos = append(repos, strings.TrimPrefix(name, c.prefix+"/"))
+		}
+	}
+	check.Supported = true
+	check.Detail = fmt.Sprintf("%d repositories on the first page", len(names))
+	return repos, check
+}
+
+// probeCatalogPagination asks for a catalog page of a single repository and
+// checks that the next one is linked.
+func (c *Client) probeCatalogPagination(ctx context.Context) ProbeCheck {
+	check := ProbeCheck{Name: ProbeCatalogPagination}
+	resp, body, err := c.fetchWithHeader(ctx, "/v2/_catalog?n=1", "registry:catalog:*", nil)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	if resp.StatusCode != http.StatusOK {
+		check.Detail = fmt.Sprintf("status %d", resp.StatusCode)
+		return check
+	}
+	var page catalogPage
+	if err := decodeResponse(resp, body, &page); err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	names := page.Repositories
+	switch {
+	case len(names) > 1:
+		check.Detail = fmt.Sprintf("n=1 ignored, %d repositories returned", len(names))
+	case nextLink(resp.Header) == "":
+		check.Detail = "no Link header to the next page"
+	default:
+		check.Supported = true
+	}
+	return check
+}
+
+// probeManifestHead checks that HEAD requests of a manifest report its
+// digest, for the tag given or the first tag of repo.
+func (c *Client) probeManifestHead(ctx context.Context, repo, tag string) ProbeCheck {
+	check := ProbeCheck{Name: ProbeManifestHead}
+	if tag == "" {
+		tags, err := c.queryTags(ctx, repo)
+		if err != nil {
+			check.Detail = "list tags: " + err.Error()
+			return check
+		}
+		if len(tags) == 0 {
+			check.Skipped, check.Detail = true, "no tag in "+repo
+			return check
+		}
+		tag = tags[0]
+	}
+	name := c.repoName(repo)
+	header := http.Header{}
+	header.Set("Accept", strings.Join(manifestAccept, ", "))
+	resp, err := c.head(ctx, fmt.Sprintf("/v2/%s/manifests/%s", name, tag), fmt.Sprintf("repository:%s:*", name), header)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		check.Detail = fmt.Sprintf("status %d for %s:%s", resp.StatusCode, repo, tag)
+	case resp.Header.Get("Docker-Content-Digest") == "":
+		check.Detail = "no Docker-Content-Digest header"
+	default:
+		check.Supported = true
+	}
+	return check
+}
+
+// probeChunkedUpload uploads b to repository name in two PATCH requests.
+func (c *Client) probeChunkedUpload(ctx context.Context, name string, b []byte) ProbeCheck {
+	check := ProbeCheck{Name: ProbeChunkedUpload}
+	if err := c.uploadChunked(ctx, name, b, (len(b)+1)/2); err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	check.Supported = true
+	return check
+}
+
+// uploadChunked uploads b to repository name in chunks of size bytes.
+func (c *Client) uploadChunked(ctx context.Context, name string, b []byte, size int) error {
+	scope := fmt.Sprintf("repository:%s:*", name)
+	resp, err := c.send(ctx, http.MethodPost, fmt.Sprintf("/v2/%s/blobs/uploads/", name), scope, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("start upload: status %d", resp.StatusCode)
+	}
+	for start := 0; start < len(b); start += size {
+		end := start + size
+		if end > len(b) {
+			end = len(b)
+		}
+		location, err := c.uploadLocation(resp)
+		if err != nil {
+			return err
+		}
+		req, err := c.newRequest(ctx, http.MethodPatch, location.String(), bytes.NewReader(b[start:end]))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = int64(end - start)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", start, end-1))
+		if resp, err = c.do(req, scope); err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			return fmt.Errorf("upload chunk %d-%d: status %d", start, end-1, resp.StatusCode)
+		}
+	}
+	location, err := c.uploadLocation(resp)
+	if err != nil {
+		return err
+	}
+	query := location.Query()
+	query.Set("digest", fmt.Sprintf("sha256:%x", sha256.Sum256(b)))
+	location.RawQuery = query.Encode()
+	req, err := c.newRequest(ctx, http.MethodPut, location.String(), nil)
+	if err != nil {
+		return err
+	}
+	if resp, err = c.do(req, scope); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("complete upload: status %d:%s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// probeReferrers checks that the referrers API lists the referrer just
+// pushed for digest.
+func (c *Client) probeReferrers(ctx context.Context, name, digest string) ProbeCheck {
+	check := ProbeCheck{Name: ProbeReferrers}
+	header := http.Header{}
+	header.Set("Accept", MediaTypeOCIIndex)
+	resp, body, err := c.fetchWithHeader(ctx, fmt.Sprintf("/v2/%s/referrers/%s", name, digest), fmt.Sprintf("repository:%s:*", name), header)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	if resp.StatusCode != http.StatusOK {
+		check.Detail = fmt.Sprintf("status %d, referrers are kept with the tag schema", resp.StatusCode)
+		return check
+	}
+	var index Index
+	if err := decodeResponse(resp, body, &index); err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	if len(filterArtifactTypes(index.Manifests, []string{ArtifactTypeProbe})) == 0 {
+		check.Detail = "referrer not listed"
+		return check
+	}
+	check.Supported = true
+	return check
+}
+
+// probeDelete deletes the manifests digests of repository name, along with
+// the referrers tag registries without the referrers API keep for the last
+// one.
+func (c *Client) probeDelete(ctx context.Context, name string, digests []string) ProbeCheck {
+	check := ProbeCheck{Name: ProbeDelete}
+	last := digests[len(digests)-1]
+	if desc, err := c.resolve(ctx, name, strings.Replace(last, ":", "-", 1)); err == nil {
+		digests = append(digests[:len(digests)-1], desc.Digest, last)
+	}
+	scope := fmt.Sprintf("repository:%s:*", name)
+	for _, digest := range digests {
+		resp, err := c.send(ctx, http.MethodDelete, fmt.Sprintf("/v2/%s/manifests/%s", name, digest), scope, nil, nil)
+		if err != nil {
+			check.Detail = err.Error()
+			return check
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusAccepted, http.StatusOK:
+		case http.StatusMethodNotAllowed:
+			check.Detail = "deletion disabled (status 405)"
+			return check
+		default:
+			check.Detail = fmt.Sprintf("status %d for %s", resp.StatusCode, digest)
+			return check
+		}
+	}
+	check.Supported = true
+	return check
+}
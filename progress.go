@@ -0,0 +1,249 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress tracks the blobs a Copy or Mirror run transfers. Runs given one
+// plan the transfer before copying anything: the blobs the destinations lack
+// are totalled first, so the report can estimate when the run ends. It is
+// safe for concurrent use, and reports can be taken while the run goes on.
+type Progress struct {
+	mu          sync.Mutex
+	started     time.Time
+	blobs       int
+	bytes       int64
+	copiedBlobs int
+	copiedBytes int64
+	// planned are the keys of the blobs to copy, and copying the bytes read
+	// so far of those being copied.
+	planned map[string]bool
+	copying map[string]int64
+}
+
+// NewProgress returns a tracker for a run to be started.
+func NewProgress() *Progress {
+	return &Progress{planned: make(map[string]bool), copying: make(map[string]int64)}
+}
+
+// ProgressReport is the state of a run at the time of the report.
+type ProgressReport struct {
+	// Planned is set once the transfer is totalled; until then Blobs and
+	// Bytes only count what the planning found so far.
+	Planned     bool  `json:"planned"`
+	Blobs       int   `json:"blobs"`
+	Bytes       int64 `json:"bytes"`
+	CopiedBlobs int   `json:"copiedBlobs"`
+	CopiedBytes int64 `json:"copiedBytes"`
+	// Elapsed is the time since the copying started.
+	Elapsed time.Duration `json:"elapsed"`
+	// Remaining is estimated from the rate so far, zero until bytes were
+	// copied.
+	Remaining time.Duration `json:"remaining"`
+}
+
+// Report returns the current state of the run.
+func (p *Progress) Report() ProgressReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := ProgressReport{Planned: !p.started.IsZero(), Blobs: p.blobs, Bytes: p.bytes, CopiedBlobs: p.copiedBlobs, CopiedBytes: p.copiedBytes}
+	for _, n := range p.copying {
+		r.CopiedBytes += n
+	}
+	if !r.Planned {
+		return r
+	}
+	r.Elapsed = time.Since(p.started)
+	if r.CopiedBytes > 0 && r.Bytes > r.CopiedBytes {
+		r.Remaining = time.Duration(float64(r.Elapsed) * float64(r.Bytes-r.CopiedBytes) / float64(r.CopiedBytes))
+	}
+	return r
+}
+
+// String summarizes r, such as "1.2 GiB of 3.4 GiB (35%), 2 of 9 blobs,
+// 5m0s left".
+func (r ProgressReport) String() string {
+	s := fmt.Sprintf("%s of %s", formatBytes(r.CopiedBytes), formatBytes(r.Bytes))
+	if r.Bytes > 0 {
+		s += fmt.Sprintf(" (%.0f%%)", float64(r.CopiedBytes)*100/float64(r.Bytes))
+	}
+	s += fmt.Sprintf(", %d of %d blobs", r.CopiedBlobs, r.Blobs)
+	switch {
+	case !r.Planned:
+		s += ", planning"
+	case r.Remaining > 0:
+		s += fmt.Sprintf(", %s left", r.Remaining.Round(time.Second))
+	}
+	return s
+}
+
+// isStarted reports whether the run was planned. Nil trackers need no plan.
+func (p *Progress) isStarted() bool {
+	if p == nil {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.started.IsZero()
+}
+
+// start ends the planning and starts the clock.
+func (p *Progress) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started = time.Now()
+}
+
+// plan adds the blob desc, identified by key, to the transfer unless it is
+// part of it already.
+func (p *Progress) plan(key string, desc Descriptor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.planned[key] {
+		return
+	}
+	p.planned[key] = true
+	p.blobs++
+	p.bytes += desc.Size
+}
+
+// isPlanned reports whether the blob key is part of the transfer.
+func (p *Progress) isPlanned(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.planned[key]
+}
+
+// reader counts what is read from r as copied bytes of the blob key.
+func (p *Progress) reader(key string, r io.ReadCloser) io.ReadCloser {
+	if p == nil {
+		return r
+	}
+	return &progressReader{ReadCloser: r, progress: p, key: key}
+}
+
+// done records the blob desc, identified by key, as copied. Blobs the plan
+// missed, such as those of tags pushed after it, are added to the transfer.
+func (p *Progress) done(key string, desc Descriptor) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.copying, key)
+	if !p.planned[key] {
+		p.planned[key] = true
+		p.blobs++
+		p.bytes += desc.Size
+	}
+	p.copiedBlobs++
+	p.copiedBytes += desc.Size
+}
+
+// fail forgets the bytes read of the blob key.
+func (p *Progress) fail(key string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.copying, key)
+}
+
+type progressReader struct {
+	io.ReadCloser
+	progress *Progress
+	key      string
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if n > 0 {
+		r.progress.mu.Lock()
+		r.progress.copying[r.key] += int64(n)
+		r.progress.mu.Unlock()
+	}
+	return n, err
+}
+
+// planCopy adds the blobs of srcRepo:srcRef, and of its referrers when
+// opts asks for them, that the repository dstRepo of dst lacks to the
+// transfer of opts.Progress. Blobs found in the destination are recorded in
+// opts.BlobCache, so the copy does not check them again.
+func (c *Client) planCopy(ctx context.Context, srcRepo, srcRef string, dst *Client, dstRepo string, opts CopyOptions) error {
+	desc, err := c.planManifest(ctx, srcRepo, srcRef, dst, dstRepo, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Referrers {
+		return c.planReferrers(ctx, srcRepo, desc.Digest, dst, dstRepo, opts)
+	}
+	return nil
+}
+
+func (c *Client) planReferrers(ctx context.Context, srcRepo, digest string, dst *Client, dstRepo string, opts CopyOptions) error {
+	referrers, err := c.Referrers(ctx, srcRepo, digest)
+	if err != nil {
+		return err
+	}
+	for _, referrer := range referrers {
+		if _, err := c.planManifest(ctx, srcRepo, referrer.Digest, dst, dstRepo, opts); err != nil {
+			return err
+		}
+		if err := c.planReferrers(ctx, srcRepo, referrer.Digest, dst, dstRepo, opts); err != nil {
+			return err
+		}
+	}
+	for _, suffix := range cosignSuffixes {
+		tag := strings.Replace(digest, ":", "-", 1) + suffix
+		if _, err := c.planManifest(ctx, srcRepo, tag, dst, dstRepo, opts); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) planManifest(ctx context.Context, srcRepo, srcRef string, dst *Client, dstRepo string, opts CopyOptions) (Descriptor, error) {
+	body, desc, err := c.getManifest(ctx, c.repoName(srcRepo), srcRef)
+	if err != nil {
+		return desc, err
+	}
+	if isIndex(desc.MediaType) {
+		var index Index
+		if err := unmarshalJSON(body, &index); err != nil {
+			return desc, err
+		}
+		for _, m := range index.Manifests {
+			if _, err := c.planManifest(ctx, srcRepo, m.Digest, dst, dstRepo, opts); err != nil {
+				return desc, err
+			}
+		}
+		return desc, nil
+	}
+	var manifest Manifest
+	if err := unmarshalJSON(body, &manifest); err != nil {
+		return desc, err
+	}
+	dstName := dst.repoName(dstRepo)
+	for _, blob := range append([]Descriptor{manifest.Config}, manifest.Layers...) {
+		key := blobKey(dst, dstName, blob.Digest)
+		if len(blob.URLs) > 0 || opts.BlobCache.has(key) || opts.Progress.isPlanned(key) {
+			continue
+		}
+		exists, err := dst.blobExists(ctx, dstName, blob.Digest)
+		if err != nil {
+			return desc, err
+		}
+		if exists {
+			opts.BlobCache.add(key)
+			continue
+		}
+		opts.Progress.plan(key, blob)
+	}
+	return desc, nil
+}
@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The annotations Promote records on promoted images.
+const (
+	AnnotationPromotedBy   = "io.github.caeret.registry.promoted-by"
+	AnnotationPromotedAt   = "io.github.caeret.registry.promoted-at"
+	AnnotationPromotedFrom = "io.github.caeret.registry.promoted-from"
+)
+
+// PromoteOptions controls Promote. The copy options apply to the copy of the
+// source image; their Signer signs the promoted image.
+type PromoteOptions struct {
+	CopyOptions
+	// PromotedBy names who or what promoted the image, like a CI job.
+	PromotedBy string
+	// Annotations are added to the promoted manifest, such as the
+	// environment it was promoted to.
+	Annotations map[string]string
+	// Plain keeps the manifest as it is, only copying and tagging the image
+	// so its digest stays the same.
+	Plain bool
+}
+
+// PromoteResult describes a promoted image.
+type PromoteResult struct {
+	Source      Reference `json:"source"`
+	Destination Reference `json:"destination"`
+	// Annotated is set when the promoted manifest was annotated, its digest
+	// then differs from the source digest.
+	Annotated bool `json:"annotated"`
+	Signed    bool `json:"signed"`
+}
+
+// Promote promotes the image srcRepo:srcRef to dstRepo:dstTag in the
+// registry of dst, which may be c itself to retag within a registry. The
+// image is copied by digest, along with its referrers when asked, and unless
+// opts.Plain is set its manifest is pushed under dstTag annotated with who
+// promoted it, when, from where, and opts.Annotations. Annotating changes the
+// digest, the signatures of the source digest stay attached to the unmodified
+// copy; set opts.Signer to sign the promoted image itself.
+func (c *Client) Promote(ctx context.Context, srcRepo, srcRef string, dst *Client, dstRepo, dstTag string, opts PromoteOptions) (*PromoteResult, error) {
+	if err := ValidateTag(dstTag); err != nil {
+		return nil, err
+	}
+	src, err := c.resolve(ctx, c.repoName(srcRepo), srcRef)
+	if err != nil {
+		return nil, err
+	}
+	copyOpts := opts.CopyOptions
+	copyOpts.Signer = nil
+	desc, err := c.copyManifest(ctx, srcRepo, src.Digest, dst, dstRepo, src.Digest, copyOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "copy")
+	}
+	if copyOpts.Referrers {
+		if err := c.copyReferrers(ctx, srcRepo, desc.Digest, dst, dstRepo, copyOpts); err != nil {
+			return nil, errors.Wrap(err, "copy referrers")
+		}
+	}
+	result := &PromoteResult{
+		Source:      Reference{Registry: c.host(), Repository: srcRepo, Digest: desc.Digest},
+		Destination: Reference{Registry: dst.host(), Repository: dstRepo, Tag: dstTag, Digest: desc.Digest},
+	}
+	if !strings.Contains(srcRef, ":") {
+		result.Source.Tag = srcRef
+	}
+
+	dstName := dst.repoName(dstRepo)
+	body, _, err := dst.getManifest(ctx, dstName, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Plain {
+		annotations := map[string]string{
+			AnnotationPromotedAt:   time.Now().UTC().Format(time.RFC3339),
+			AnnotationPromotedFrom: result.Source.String(),
+		}
+		if opts.PromotedBy != "" {
+			annotations[AnnotationPromotedBy] = opts.PromotedBy
+		}
+		for k, v := range opts.Annotations {
+			annotations[k] = v
+		}
+		if body, err = annotateManifest(body, annotations); err != nil {
+			return nil, err
+		}
+		result.Annotated = true
+	}
+	if _, err := dst.putManifest(ctx, dstName, dstTag, desc.MediaType, body); err != nil {
+		return nil, errors.Wrap(err, "tag")
+	}
+	if result.Annotated {
+		result.Destination.Digest = computeDigest(desc.Digest, body)
+	}
+	if opts.Signer != nil {
+		if err := dst.SignImage(ctx, dstRepo, result.Destination.Digest, opts.Signer); err != nil {
+			return nil, err
+		}
+		result.Signed = true
+	}
+	c.Info("promote image.", "src", result.Source, "dst", result.Destination, "annotated", result.Annotated, "signed", result.Signed)
+	return result, nil
+}
+
+// annotateManifest adds annotations to the manifest or index body, keeping
+// its other fields as they are.
+func annotateManifest(body []byte, annotations map[string]string) ([]byte, error) {
+	var m map[string]interface{}
+	if err := unmarshalJSON(body, &m); err != nil {
+		return nil, err
+	}
+	merged, _ := m["annotations"].(map[string]interface{})
+	if merged == nil {
+		merged = make(map[string]interface{})
+	}
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	m["annotations"] = merged
+	return marshalJSON(m)
+}
@@ -0,0 +1,280 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// InUseLister lists images that are in use somewhere and must survive
+// Clean, such as the images of running containers.
+type InUseLister interface {
+	InUse(ctx context.Context) ([]Reference, error)
+}
+
+// inUse collects the images reported by listers. Failing listers fail the
+// whole collection, since cleaning with an incomplete view is unsafe.
+func inUse(ctx context.Context, listers []InUseLister) ([]Reference, error) {
+	var refs []Reference
+	for _, lister := range listers {
+		r, err := lister.InUse(ctx)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, r...)
+	}
+	return refs, nil
+}
+
+// inUseBy returns the first of refs naming a tag of tags or their digest in
+// the registry of c.
+func (c *Client) inUseBy(refs []Reference, digest string, tags []TagRef) (Reference, bool) {
+	for _, ref := range refs {
+		if !sameRegistry(ref.Registry, c.host()) {
+			continue
+		}
+		for _, t := range tags {
+			if ref.Repository != c.repoName(t.Repository) {
+				continue
+			}
+			if ref.Digest == digest || (ref.Digest == "" && ref.Tag == t.Tag) {
+				return ref, true
+			}
+		}
+	}
+	return Reference{}, false
+}
+
+// KubernetesLister lists the images referenced by the pods and workloads of
+// a Kubernetes cluster, talking to its API server directly. Workload
+// templates are included so scaled-down deployments and rollback targets
+// stay protected.
+type KubernetesLister struct {
+	// Host is the URL of the API server.
+	Host  string
+	Token string
+	// Client is used for requests to the API server, http.DefaultClient
+	// when nil.
+	Client *http.Client
+	// Namespaces restricts the lookup, all namespaces are searched when
+	// empty.
+	Namespaces []string
+}
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// NewInClusterKubernetesLister returns a lister using the service account of
+// the pod it runs in.
+func NewInClusterKubernetesLister() (*KubernetesLister, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a kubernetes cluster")
+	}
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid cluster CA certificate")
+	}
+	return &KubernetesLister{
+		Host:   "https://" + net.JoinHostPort(host, port),
+		Token:  strings.TrimSpace(string(token)),
+		Client: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+	}, nil
+}
+
+// kubernetesResources are the resources whose pod specs reference images,
+// along with the path from a list item to its pod spec.
+var kubernetesResources = []struct {
+	path string
+	spec []string
+}{
+	{"/api/v1/pods", []string{"spec"}},
+	{"/apis/apps/v1/deployments", []string{"spec", "template", "spec"}},
+	{"/apis/apps/v1/replicasets", []string{"spec", "template", "spec"}},
+	{"/apis/apps/v1/statefulsets", []string{"spec", "template", "spec"}},
+	{"/apis/apps/v1/daemonsets", []string{"spec", "template", "spec"}},
+	{"/apis/batch/v1/jobs", []string{"spec", "template", "spec"}},
+	{"/apis/batch/v1/cronjobs", []string{"spec", "jobTemplate", "spec", "template", "spec"}},
+}
+
+func (k *KubernetesLister) InUse(ctx context.Context) ([]Reference, error) {
+	namespaces := k.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	var refs []Reference
+	for _, ns := range namespaces {
+		for _, res := range kubernetesResources {
+			path := res.path
+			if ns != "" {
+				parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 4)
+				// Insert the namespace before the resource name.
+				path = "/" + strings.Join(parts[:len(parts)-1], "/") + "/namespaces/" + url.PathEscape(ns) + "/" + parts[len(parts)-1]
+			}
+			r, err := k.list(ctx, path, res.spec)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, r...)
+		}
+	}
+	return refs, nil
+}
+
+func (k *KubernetesLister) list(ctx context.Context, path string, spec []string) ([]Reference, error) {
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var refs []Reference
+	cont := ""
+	for {
+		target := fmt.Sprintf("%s%s?limit=500", strings.TrimSuffix(k.Host, "/"), path)
+		if cont != "" {
+			target += "&continue=" + url.QueryEscape(cont)
+		}
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		if k.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+k.Token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, redactError(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			// The resource is not served by this cluster version.
+			return refs, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("kubernetes %s: invalid response %d:%s", path, resp.StatusCode, Redact(string(body)))
+		}
+
+		var list struct {
+			Metadata struct {
+				Continue string `json:"continue"`
+			} `json:"metadata"`
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := decodeResponse(resp, body, &list); err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			r, err := podImages(item, spec)
+			if err != nil {
+				return nil, fmt.Errorf("kubernetes %s: %v", path, err)
+			}
+			refs = append(refs, r...)
+		}
+		cont = list.Metadata.Continue
+		if cont == "" {
+			return refs, nil
+		}
+	}
+}
+
+// podObject has the parts of Kubernetes objects naming images: the pod
+// spec, found at the path of the kind of object, and for pods the status.
+type podObject struct {
+	InitContainers      []struct{ Image string } `json:"initContainers"`
+	Containers          []struct{ Image string } `json:"containers"`
+	EphemeralContainers []struct{ Image string } `json:"ephemeralContainers"`
+	Status              struct {
+		InitContainerStatuses      []struct{ ImageID string } `json:"initContainerStatuses"`
+		ContainerStatuses          []struct{ ImageID string } `json:"containerStatuses"`
+		EphemeralContainerStatuses []struct{ ImageID string } `json:"ephemeralContainerStatuses"`
+	} `json:"status"`
+}
+
+// podImages returns the images of the pod spec found in the object item at
+// the path spec.
+func podImages(item json.RawMessage, spec []string) ([]Reference, error) {
+	body := item
+	for _, key := range spec {
+		var fields map[string]json.RawMessage
+		if err := unmarshalJSON(body, &fields); err != nil {
+			return nil, err
+		}
+		if body = fields[key]; body == nil {
+			return nil, nil
+		}
+	}
+	var pod podObject
+	if err := unmarshalJSON(body, &pod); err != nil {
+		return nil, err
+	}
+	refs, err := podSpecImages(pod)
+	if err != nil {
+		return nil, err
+	}
+	if len(spec) == 1 {
+		// Pods themselves, whose status is next to their spec.
+		if err := unmarshalJSON(item, &pod); err != nil {
+			return nil, err
+		}
+		refs = append(refs, podStatusImages(pod)...)
+	}
+	return refs, nil
+}
+
+// podSpecImages returns the images of the containers of a pod spec. Images
+// that do not parse fail it, as they could name any image; templates may
+// leave images empty, to be set on admission.
+func podSpecImages(pod podObject) ([]Reference, error) {
+	var refs []Reference
+	for _, containers := range [][]struct{ Image string }{pod.InitContainers, pod.Containers, pod.EphemeralContainers} {
+		for _, container := range containers {
+			if container.Image == "" {
+				continue
+			}
+			ref, err := ParseReference(container.Image)
+			if err != nil {
+				return nil, fmt.Errorf("image %q: %v", container.Image, err)
+			}
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+// podStatusImages returns the digests the kubelet resolved the images of a
+// pod to, which pin floating tags.
+func podStatusImages(pod podObject) []Reference {
+	var refs []Reference
+	status := pod.Status
+	for _, statuses := range [][]struct{ ImageID string }{status.InitContainerStatuses, status.ContainerStatuses, status.EphemeralContainerStatuses} {
+		for _, s := range statuses {
+			id := s.ImageID
+			if i := strings.Index(id, "://"); i >= 0 {
+				id = id[i+3:]
+			}
+			if ref, err := ParseReference(id); err == nil && ref.Digest != "" {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// DockerLister lists the images used by the containers, running or stopped,
+// of a Docker host through the Docker Engine API.
+type DockerLister struct {
+	// Host is the daemon address, such as unix:///var/run/docker.sock or
+	// tcp://10.0.0.5:2375. The local socket is used when empty.
+	Host string
+	// Client overrides the HTTP client, for example to use TLS.
+	Client *http.Client
+}
+
+func (d *DockerLister) InUse(ctx context.Context) ([]Reference, error) {
+	var containers []struct {
+		Image   string `json:"Image"`
+		ImageID string `json:"ImageID"`
+	}
+	if err := d.get(ctx, "/containers/json?all=1", &containers); err != nil {
+		return nil, err
+	}
+	var refs []Reference
+	seen := make(map[string]bool)
+	for _, container := range containers {
+		if ref, err := ParseReference(container.Image); err == nil && !strings.HasPrefix(container.Image, "sha256:") {
+			refs = append(refs, ref)
+		}
+		if seen[container.ImageID] {
+			continue
+		}
+		seen[container.ImageID] = true
+		var image struct {
+			RepoTags    []string `json:"RepoTags"`
+			RepoDigests []string `json:"RepoDigests"`
+		}
+		if err := d.get(ctx, "/images/"+url.PathEscape(container.ImageID)+"/json", &image); err != nil {
+			return nil, err
+		}
+		for _, s := range append(image.RepoTags, image.RepoDigests...) {
+			if ref, err := ParseReference(s); err == nil {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs, nil
+}
+
+func (d *DockerLister) get(ctx context.Context, path string, v interface{}) error {
+	client, base := d.Client, "http://docker"
+	host := d.Host
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+	switch {
+	case strings.HasPrefix(host, "unix://"):
+		if client == nil {
+			socket := strings.TrimPrefix(host, "unix://")
+			client = &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			}}
+		}
+	case strings.HasPrefix(host, "tcp://"):
+		base = "http://" + strings.TrimPrefix(host, "tcp://")
+	default:
+		base = strings.TrimSuffix(host, "/")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequest(http.MethodGet, base+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker %s: invalid response %d:%s", path, resp.StatusCode, Redact(string(body)))
+	}
+	return decodeResponse(resp, body, v)
+}
+
+// ContainerdLister lists the images used by the containers of a containerd
+// host using the ctr command line tool, which must be able to reach the
+// containerd socket.
+type ContainerdLister struct {
+	// Path of the ctr binary, looked up in PATH when empty.
+	Path string
+	// Address of the containerd socket, the ctr default when empty.
+	Address string
+	// Namespace to look in, such as k8s.io for Kubernetes nodes. The ctr
+	// default namespace is used when empty.
+	Namespace string
+}
+
+func (c *ContainerdLister) InUse(ctx context.Context) ([]Reference, error) {
+	out, err := c.ctr(ctx, "containers", "list")
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for _, fields := range tableRows(out) {
+		if len(fields) >= 2 {
+			used[fields[1]] = true
+		}
+	}
+
+	out, err = c.ctr(ctx, "images", "list")
+	if err != nil {
+		return nil, err
+	}
+	var refs []Reference
+	for _, fields := range tableRows(out) {
+		if len(fields) < 3 || !used[fields[0]] {
+			continue
+		}
+		ref, err := ParseReference(fields[0])
+		if err != nil {
+			continue
+		}
+		refs = append(refs, ref)
+		if strings.HasPrefix(ref.Repository, "sha256:") || ref.Digest != "" {
+			continue
+		}
+		pinned := ref
+		pinned.Tag, pinned.Digest = "", fields[2]
+		refs = append(refs, pinned)
+	}
+	return refs, nil
+}
+
+func (c *ContainerdLister) ctr(ctx context.Context, args ...string) ([]byte, error) {
+	path := c.Path
+	if path == "" {
+		path = "ctr"
+	}
+	var global []string
+	if c.Address != "" {
+		global = append(global, "--address", c.Address)
+	}
+	if c.Namespace != "" {
+		global = append(global, "--namespace", c.Namespace)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, append(global, args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ctr: %v: %s", err, stderr.String())
+	}
+	return out, nil
+}
+
+// tableRows splits the rows of a ctr table, skipping the header.
+func tableRows(out []byte) [][]string {
+	var rows [][]string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	header := true
+	for scanner.Scan() {
+		if header {
+			header = false
+			continue
+		}
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			rows = append(rows, fields)
+		}
+	}
+	return rows
+}
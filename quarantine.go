@@ -0,0 +1,64 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+)
+
+// maxTagLength is the longest tag the distribution spec allows.
+const maxTagLength = 128
+
+// QuarantineTag returns the tag repo:tag is kept under in the quarantine
+// repository, such as "team-a_app__v1" for team-a/app:v1. Repositories whose
+// name has an underscore, which their tags could not be told apart by, and
+// tags too long get a hash of repo:tag appended instead, such as
+// "team_a_app__v1-0123456789ab".
+func QuarantineTag(repo, tag string) string {
+	t := strings.Replace(repo, "/", "_", -1) + "__" + tag
+	if strings.Contains(repo, "_") || len(t) > maxTagLength {
+		sum := fmt.Sprintf("%x", sha256.Sum256([]byte(repo+":"+tag)))[:12]
+		if len(t) > maxTagLength-len(sum)-1 {
+			t = t[:maxTagLength-len(sum)-1]
+		}
+		t += "-" + sum
+	}
+	return t
+}
+
+// quarantine moves the image digest out of repo into the quarantine
+// repository, keeping every tag of it repo had under its quarantine tag, and
+// then deletes it from repo.
+func (c *Client) quarantine(ctx context.Context, policy Policy, repo, digest string, tags []TagRef) (string, error) {
+	var target, tag string
+	for _, t := range tags {
+		if t.Repository != repo {
+			continue
+		}
+		qtag := QuarantineTag(t.Repository, t.Tag)
+		if t.Tag == "" {
+			// Untagged manifests are kept under their digest.
+			qtag = QuarantineTag(t.Repository, strings.Replace(digest, ":", "-", 1))
+		}
+		if target == "" {
+			if err := c.Copy(ctx, repo, digest, c, policy.Quarantine, qtag, CopyOptions{Referrers: true}); err != nil {
+				return "", err
+			}
+			target, tag = policy.Quarantine+":"+qtag, t.Tag
+			if tag == "" {
+				tag = digest
+			}
+			continue
+		}
+		body, desc, err := c.getManifest(ctx, c.repoName(policy.Quarantine), digest)
+		if err != nil {
+			return "", err
+		}
+		if _, err := c.putManifest(ctx, c.repoName(policy.Quarantine), qtag, desc.MediaType, body); err != nil {
+			return "", err
+		}
+	}
+	c.Info("quarantine image.", "repo", repo, "digest", digest, "target", target)
+	return target, c.deleteTag(ctx, repo, tag, &policy, repoTags(tags, repo))
+}
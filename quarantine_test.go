@@ -0,0 +1,46 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuarantineTag(t *testing.T) {
+	for _, test := range []struct {
+		repo, tag, want string
+	}{
+		{"app", "v1", "app__v1"},
+		{"team-a/app", "v1", "team-a_app__v1"},
+		{"team.a/app", "v1.2.3", "team.a_app__v1.2.3"},
+	} {
+		if got := QuarantineTag(test.repo, test.tag); got != test.want {
+			t.Errorf("QuarantineTag(%q, %q) = %q, want %q", test.repo, test.tag, got, test.want)
+		}
+	}
+}
+
+func TestQuarantineTagUnique(t *testing.T) {
+	long := strings.Repeat("x", maxTagLength)
+	refs := [][2]string{
+		{"a/b_c", "v1"},
+		{"a_b/c", "v1"},
+		{"a_b_c", "v1"},
+		{"a/b/c", "v1"},
+		{"a", "b__v1"},
+		{"a__b", "v1"},
+		{"a", long},
+		{"a", long + "y"},
+		{"a/b", long},
+	}
+	seen := make(map[string][2]string)
+	for _, ref := range refs {
+		tag := QuarantineTag(ref[0], ref[1])
+		if other, ok := seen[tag]; ok {
+			t.Errorf("%s:%s and %s:%s are both quarantined as %q", other[0], other[1], ref[0], ref[1], tag)
+		}
+		seen[tag] = ref
+		if err := ValidateTag(tag); err != nil {
+			t.Errorf("QuarantineTag(%q, %q): %v", ref[0], ref[1], err)
+		}
+	}
+}
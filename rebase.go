@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	annotationBaseName   = "org.opencontainers.image.base.name"
+	annotationBaseDigest = "org.opencontainers.image.base.digest"
+)
+
+// RebaseOptions controls Rebase.
+type RebaseOptions struct {
+	// Platform selects the manifest of multi-platform images, linux/amd64
+	// when empty. It applies to the image and both bases.
+	Platform Platform
+	// OldBase is the base image the image was built on.
+	OldBase TagRef
+	// NewBase is the base image to build it on instead.
+	NewBase TagRef
+}
+
+// Rebase replaces the layers of OldBase at the bottom of the image repo:ref
+// with those of NewBase and pushes the result under tag. The history of the
+// base is swapped too, and the base annotations point at NewBase. The layers
+// of NewBase are mounted into repo when they are missing there. The image
+// must have been built on OldBase, with its layers and history.
+func (c *Client) Rebase(ctx context.Context, repo, ref, tag string, opts RebaseOptions) (Descriptor, error) {
+	img, err := c.loadImage(ctx, repo, ref, opts.Platform)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	oldBase, err := c.loadImage(ctx, opts.OldBase.Repository, opts.OldBase.Tag, opts.Platform)
+	if err != nil {
+		return Descriptor{}, errors.Wrap(err, "old base")
+	}
+	newBase, err := c.loadImage(ctx, opts.NewBase.Repository, opts.NewBase.Tag, opts.Platform)
+	if err != nil {
+		return Descriptor{}, errors.Wrap(err, "new base")
+	}
+	n := len(oldBase.manifest.Layers)
+	if n > len(img.manifest.Layers) {
+		return Descriptor{}, fmt.Errorf("image has fewer layers than its base %s", opts.OldBase)
+	}
+	for i, layer := range oldBase.manifest.Layers {
+		if img.manifest.Layers[i].Digest != layer.Digest {
+			return Descriptor{}, fmt.Errorf("image is not based on %s: layer %d is %s, not %s", opts.OldBase, i, img.manifest.Layers[i].Digest, layer.Digest)
+		}
+	}
+	if len(oldBase.history) > len(img.history) {
+		return Descriptor{}, fmt.Errorf("image has a shorter history than its base %s", opts.OldBase)
+	}
+	c.Info("rebase image.", "repo", img.name, "ref", ref, "old", opts.OldBase, "new", opts.NewBase)
+
+	for _, layer := range newBase.manifest.Layers {
+		if err := c.mountLayer(ctx, img.name, newBase.name, layer); err != nil {
+			return Descriptor{}, fmt.Errorf("layer %s: %v", layer.Digest, err)
+		}
+	}
+	img.manifest.Layers = append(newBase.manifest.Layers, img.manifest.Layers[n:]...)
+	img.diffIDs = append(newBase.diffIDs, img.diffIDs[n:]...)
+	img.history = append(newBase.history, img.history[len(oldBase.history):]...)
+	if img.manifest.Annotations == nil {
+		img.manifest.Annotations = make(map[string]string)
+	}
+	base := Reference{Registry: c.host(), Repository: newBase.name, Tag: opts.NewBase.Tag}
+	if strings.Contains(base.Tag, ":") {
+		base.Tag, base.Digest = "", opts.NewBase.Tag
+	}
+	img.manifest.Annotations[annotationBaseName] = base.String()
+	img.manifest.Annotations[annotationBaseDigest] = newBase.desc.Digest
+	return c.pushImage(ctx, img, tag)
+}
+
+// mountLayer makes the layer of the repository from available in the
+// repository name, skipping non-distributable layers.
+func (c *Client) mountLayer(ctx context.Context, name, from string, layer Descriptor) error {
+	if name == from || len(layer.URLs) > 0 {
+		return nil
+	}
+	return c.pushBlob(ctx, name, layer, func() (io.ReadCloser, error) {
+		r, _, err := c.openBlob(ctx, from, layer.Digest)
+		return r, err
+	}, from)
+}
@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"context"
+)
+
+// manifestBlobs returns the sizes of the blobs the manifest digest of the
+// repository name references, including those of the manifests of an index.
+func (c *Client) manifestBlobs(ctx context.Context, name, digest string) (map[string]int64, error) {
+	blobs := make(map[string]int64)
+	if err := c.addManifestBlobs(ctx, name, digest, blobs); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+func (c *Client) addManifestBlobs(ctx context.Context, name, digest string, blobs map[string]int64) error {
+	body, desc, err := c.getManifest(ctx, name, digest)
+	if err != nil {
+		return err
+	}
+	descs, err := children(desc, body)
+	if err != nil {
+		return err
+	}
+	for _, d := range descs {
+		if isManifest(d.MediaType) {
+			if err := c.addManifestBlobs(ctx, name, d.Digest, blobs); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(d.URLs) == 0 {
+			blobs[d.Digest] = d.Size
+		}
+	}
+	return nil
+}
+
+// repoBlob identifies a manifest or blob of a repository.
+type repoBlob struct {
+	repo, digest string
+}
+
+// estimateReclaimable sets the bytes the images deleted by a clean run leave
+// unreferenced, given the images of the run by digest. The images of the
+// repositories with deletions that were not deleted are read to find the
+// blobs they still reference.
+func (c *Client) estimateReclaimable(ctx context.Context, result *CleanResult, m map[string][]TagRef) {
+	deleted := make(map[repoBlob]bool)
+	kept := make(map[string]map[string]bool)
+	for _, d := range result.Deleted {
+		deleted[repoBlob{d.Repository, d.Digest}] = true
+		kept[d.Repository] = make(map[string]bool)
+	}
+	for digest, refs := range m {
+		for _, repo := range uniqueRepos(refs) {
+			blobs, ok := kept[repo]
+			if !ok || deleted[repoBlob{repo, digest}] {
+				continue
+			}
+			sizes, err := c.manifestBlobs(ctx, c.repoName(repo), digest)
+			if err != nil {
+				// Without its blobs, nothing can be told reclaimable.
+				c.Warn("fail to list blobs.", "repo", repo, "digest", digest, "error", err)
+				delete(kept, repo)
+				continue
+			}
+			for blob := range sizes {
+				blobs[blob] = true
+			}
+		}
+	}
+
+	counted := make(map[repoBlob]bool)
+	for i := range result.Deleted {
+		d := &result.Deleted[i]
+		blobs, ok := kept[d.Repository]
+		if !ok {
+			continue
+		}
+		for blob, size := range d.blobs {
+			if blobs[blob] {
+				continue
+			}
+			d.Reclaimable += size
+			if key := (repoBlob{d.Repository, blob}); !counted[key] {
+				counted[key] = true
+				result.Reclaimable += size
+			}
+		}
+	}
+}
+
+// uniqueRepos returns the repositories of refs, once each.
+func uniqueRepos(refs []TagRef) []string {
+	var repos []string
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if !seen[ref.Repository] {
+			seen[ref.Repository] = true
+			repos = append(repos, ref.Repository)
+		}
+	}
+	return repos
+}
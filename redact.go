@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/inconshreveable/log15"
+)
+
+// redacted replaces the secrets removed from logs and errors.
+const redacted = "REDACTED"
+
+// secretPatterns match the secrets of log lines and errors, replaced by
+// their replacement.
+var secretPatterns = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	// Values of Authorization headers.
+	{regexp.MustCompile(`(?i)(authorization["']?\s*[:=]\s*["']?(?:(?:bearer|basic)\s+)?)[^\s"',}]+`), "${1}" + redacted},
+	// Passwords of URLs.
+	{regexp.MustCompile(`(://[^/\s:@]*:)[^/\s@]+@`), "${1}" + redacted + "@"},
+	// Tokens, passwords and signatures in query strings, such as those of
+	// presigned blob URLs.
+	{regexp.MustCompile(`(?i)([?&](?:access_token|refresh_token|id_token|token|password|secret|client_secret|sig|signature|x-amz-signature|x-amz-credential|x-amz-security-token)=)[^&\s"']+`), "${1}" + redacted},
+	// Tokens and passwords of JSON bodies, such as token responses, which
+	// may be cut short.
+	{regexp.MustCompile(`(?i)("(?:access_token|refresh_token|id_token|token|password|secret|client_secret)"\s*:\s*")(?:[^"\\]|\\.)*`), "${1}" + redacted},
+	// Slack webhooks, whose path is their secret.
+	{regexp.MustCompile(`(hooks\.slack\.com/services/)[A-Za-z0-9/]+`), "${1}" + redacted},
+}
+
+// credentials matches the credentials of Authorization headers quoted
+// without the header. Words, as in "basic auth", and challenges such as
+// `Bearer realm="..."` are left alone.
+var credentials = regexp.MustCompile(`(?i)\b(bearer|basic)\s+([a-z0-9._~+/-]{8,}=*)`)
+
+// Redact returns s with the credentials it holds replaced: Authorization
+// header values, passwords of URLs, tokens and signatures of query strings,
+// tokens and passwords of JSON bodies, and Slack webhook URLs.
+func Redact(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.replacement)
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range credentials.FindAllStringSubmatchIndex(s, -1) {
+		if m[1] < len(s) && s[m[1]] == '"' || !strings.ContainsAny(s[m[4]:m[5]], "0123456789._~+/-=") {
+			continue
+		}
+		b.WriteString(s[last:m[0]])
+		b.WriteString(s[m[2]:m[3]] + " " + redacted)
+		last = m[1]
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// RedactHandler returns a handler writing the records of h with the
+// credentials of their message and values redacted as by Redact, along with
+// the given secrets, such as passwords.
+func RedactHandler(h log15.Handler, secrets ...string) log15.Handler {
+	var known []string
+	for _, s := range secrets {
+		// Short secrets would hide unrelated text.
+		if len(s) >= 4 {
+			known = append(known, s)
+		}
+	}
+	redact := func(s string) string {
+		for _, secret := range known {
+			s = strings.Replace(s, secret, redacted, -1)
+		}
+		return Redact(s)
+	}
+	return log15.FuncHandler(func(r *log15.Record) error {
+		r.Msg = redact(r.Msg)
+		ctx := make([]interface{}, len(r.Ctx))
+		copy(ctx, r.Ctx)
+		for i := 1; i < len(ctx); i += 2 {
+			switch v := ctx[i].(type) {
+			case string:
+				ctx[i] = redact(v)
+			case []byte:
+				ctx[i] = redact(string(v))
+			case error:
+				if s := v.Error(); redact(s) != s {
+					ctx[i] = redact(s)
+				}
+			case fmt.Stringer:
+				if s := v.String(); redact(s) != s {
+					ctx[i] = redact(s)
+				}
+			}
+		}
+		r.Ctx = ctx
+		return h.Log(r)
+	})
+}
+
+// redactError returns err with the URL of a failed request redacted, as the
+// errors of http.Client quote it.
+func redactError(err error) error {
+	if uerr, ok := err.(*url.Error); ok {
+		redactedErr := *uerr
+		redactedErr.URL = Redact(uerr.URL)
+		return &redactedErr
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+const dockerHub = "docker.io"
+
+// Reference identifies an image in a registry by tag, digest or both.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses an image reference such as
+// registry.example.com/app/api:v1.2.3 or app@sha256:..., applying the same
+// defaults as docker: images without a registry live on docker.io,
+// single-component names there belong to library/, and images with neither a
+// tag nor a digest are tagged latest.
+func ParseReference(s string) (Reference, error) {
+	var ref Reference
+	if i := strings.Index(s, "@"); i >= 0 {
+		s, ref.Digest = s[:i], s[i+1:]
+		if !strings.Contains(ref.Digest, ":") {
+			return ref, fmt.Errorf("invalid digest %q", ref.Digest)
+		}
+	}
+	if i := strings.LastIndex(s, ":"); i >= 0 && !strings.Contains(s[i:], "/") {
+		s, ref.Tag = s[:i], s[i+1:]
+	}
+	if i := strings.Index(s, "/"); i >= 0 && (strings.ContainsAny(s[:i], ".:") || s[:i] == "localhost") {
+		ref.Registry, s = s[:i], s[i+1:]
+	} else {
+		ref.Registry = dockerHub
+	}
+	if s == "" {
+		return ref, fmt.Errorf("no repository in reference")
+	}
+	if ref.Registry == dockerHub && !strings.Contains(s, "/") {
+		s = "library/" + s
+	}
+	ref.Repository = s
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, nil
+}
+
+func (r Reference) String() string {
+	s := r.Repository
+	if r.Registry != "" {
+		s = r.Registry + "/" + s
+	}
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// host returns the registry host of c, as used in image references.
+func (c *Client) host() string {
+	host := c.url
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	return strings.TrimSuffix(host, "/")
+}
+
+// sameRegistry reports whether the registry hosts a and b are the same,
+// treating the aliases of Docker Hub as equal.
+func sameRegistry(a, b string) bool {
+	normalize := func(host string) string {
+		switch host {
+		case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+			return dockerHub
+		}
+		return strings.ToLower(host)
+	}
+	return normalize(a) == normalize(b)
+}
@@ -0,0 +1,112 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Referrers lists the descriptors of the artifacts referring to the manifest
+// identified by digest, such as signatures, SBOMs and attestations. When
+// artifact types are given only referrers of those types are returned.
+//
+// Registries without the referrers API are served from the referrers tag
+// schema (the "sha256-<hex>" tag holding an index).
+func (c *Client) Referrers(ctx context.Context, repo, digest string, artifactTypes ...string) ([]Descriptor, error) {
+	name := c.repoName(repo)
+	scope := fmt.Sprintf("repository:%s:*", name)
+
+	path := fmt.Sprintf("/v2/%s/referrers/%s", name, digest)
+	if len(artifactTypes) == 1 {
+		path += "?artifactType=" + url.QueryEscape(artifactTypes[0])
+	}
+
+	var referrers []Descriptor
+	filtered := false
+	for path != "" {
+		resp, body, err := c.fetch(ctx, path, scope, MediaTypeOCIIndex)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound && len(referrers) == 0 {
+			return c.referrersByTag(ctx, repo, digest, artifactTypes)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, statusError(resp, body)
+		}
+		var index Index
+		if err := decodeResponse(resp, body, &index); err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, index.Manifests...)
+		filtered = strings.Contains(resp.Header.Get("OCI-Filters-Applied"), "artifactType")
+		path = nextLink(resp.Header)
+	}
+	if filtered && len(artifactTypes) == 1 {
+		return referrers, nil
+	}
+	return filterArtifactTypes(referrers, artifactTypes), nil
+}
+
+func (c *Client) referrersByTag(ctx context.Context, repo, digest string, artifactTypes []string) ([]Descriptor, error) {
+	name := c.repoName(repo)
+	scope := fmt.Sprintf("repository:%s:*", name)
+	tag := strings.Replace(digest, ":", "-", 1)
+	resp, body, err := c.fetch(ctx, fmt.Sprintf("/v2/%s/manifests/%s", name, tag), scope, MediaTypeOCIIndex)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, statusError(resp, body)
+	}
+	var index Index
+	if err := decodeResponse(resp, body, &index); err != nil {
+		return nil, err
+	}
+	return filterArtifactTypes(index.Manifests, artifactTypes), nil
+}
+
+func filterArtifactTypes(descs []Descriptor, artifactTypes []string) []Descriptor {
+	if len(artifactTypes) == 0 {
+		return descs
+	}
+	var filtered []Descriptor
+	for _, desc := range descs {
+		for _, t := range artifactTypes {
+			if desc.ArtifactType == t {
+				filtered = append(filtered, desc)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// nextLink returns the path of the next page announced in the Link header,
+// or an empty string if there is none.
+func nextLink(header http.Header) string {
+	for _, link := range header["Link"] {
+		for _, part := range strings.Split(link, ",") {
+			part = strings.TrimSpace(part)
+			if !strings.Contains(part, `rel="next"`) {
+				continue
+			}
+			start, end := strings.Index(part, "<"), strings.Index(part, ">")
+			if start < 0 || end < start {
+				continue
+			}
+			u, err := url.Parse(part[start+1 : end])
+			if err != nil {
+				continue
+			}
+			return u.RequestURI()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,181 @@
+package registrytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// scrubbed replaces secrets in recorded interactions.
+const scrubbed = "REDACTED"
+
+// Recorder is a transport recording the interactions with a real registry,
+// so tests can replay them later with NewReplayer.
+type Recorder struct {
+	Transport http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+// NewRecorder returns a recorder sending requests through rt, or the
+// default transport when rt is nil.
+func NewRecorder(rt http.RoundTripper) *Recorder {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &Recorder{Transport: rt}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	u := *req.URL
+	u.User = nil
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	r.mu.Lock()
+	r.interactions = append(r.interactions, Interaction{
+		Method: req.Method,
+		URL:    u.String(),
+		Status: resp.StatusCode,
+		Header: header,
+		Body:   scrubBody(body),
+	})
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// scrubBody removes the tokens of token service responses.
+func scrubBody(body []byte) []byte {
+	var v map[string]interface{}
+	if json.Unmarshal(body, &v) != nil {
+		return body
+	}
+	changed := false
+	for _, key := range []string{"token", "access_token", "refresh_token"} {
+		if _, ok := v[key]; ok {
+			v[key] = scrubbed
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+// Interactions returns the interactions recorded so far.
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.interactions...)
+}
+
+// Save writes the recorded interactions to the fixture file path.
+func (r *Recorder) Save(path string) error {
+	b, err := json.MarshalIndent(r.Interactions(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// Replayer is a transport answering requests from recorded interactions
+// without network access.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayer loads the fixture file path written by Recorder.Save.
+func NewReplayer(path string) (*Replayer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var interactions []Interaction
+	if err := json.Unmarshal(b, &interactions); err != nil {
+		return nil, err
+	}
+	return &Replayer{interactions: interactions, used: make([]bool, len(interactions))}, nil
+}
+
+// RoundTrip answers req with the first unused interaction recorded for the
+// same method and URL, repeating the last one once all have been used.
+// Requests are never sent anywhere.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	u := *req.URL
+	u.User = nil
+	target := u.String()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last := -1
+	for i, interaction := range r.interactions {
+		if interaction.Method != req.Method || interaction.URL != target {
+			continue
+		}
+		last = i
+		if !r.used[i] {
+			break
+		}
+	}
+	if last >= 0 {
+		r.used[last] = true
+		interaction := r.interactions[last]
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+			StatusCode:    interaction.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        interaction.Header.Clone(),
+			Body:          ioutil.NopCloser(bytes.NewReader(interaction.Body)),
+			ContentLength: int64(len(interaction.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("registrytest: no recorded interaction for %s %s", req.Method, target)
+}
+
+// Unused returns the recorded interactions that were not replayed.
+func (r *Replayer) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var unused []Interaction
+	for i, interaction := range r.interactions {
+		if !r.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
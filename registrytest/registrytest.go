@@ -0,0 +1,546 @@
+// Package registrytest provides an in-memory registry implementing the
+// parts of the distribution API used by the registry client, for tests that
+// should not depend on Docker.
+package registrytest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer    = "application/vnd.oci.image.layer.v1.tar"
+)
+
+// Server is an in-memory registry served over HTTP. Its contents can be
+// set up directly with the Put methods and are shared by all repositories,
+// except for manifests and tags.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string]map[string]manifest
+	tags      map[string]map[string]string
+	uploads   map[string][]byte
+	tokens    map[string]bool
+	requests  []string
+
+	username  string
+	password  string
+	bearer    bool
+	referrers bool
+	noDelete  bool
+}
+
+type manifest struct {
+	mediaType string
+	body      []byte
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithTokenAuth requires bearer tokens issued by the server's token
+// endpoint to the given user.
+func WithTokenAuth(username, password string) Option {
+	return func(s *Server) {
+		s.username, s.password, s.bearer = username, password, true
+	}
+}
+
+// WithBasicAuth requires basic authentication as the given user.
+func WithBasicAuth(username, password string) Option {
+	return func(s *Server) {
+		s.username, s.password = username, password
+	}
+}
+
+// WithReferrers enables the OCI referrers API.
+func WithReferrers() Option {
+	return func(s *Server) {
+		s.referrers = true
+	}
+}
+
+// WithoutDelete makes the server refuse manifest deletion, like registries
+// with deletion disabled.
+func WithoutDelete() Option {
+	return func(s *Server) {
+		s.noDelete = true
+	}
+}
+
+// NewServer starts a server. It must be closed when done.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string]map[string]manifest),
+		tags:      make(map[string]map[string]string),
+		uploads:   make(map[string][]byte),
+		tokens:    make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func digestOf(b []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+}
+
+// PutBlob stores a blob and returns its digest.
+func (s *Server) PutBlob(b []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	digest := digestOf(b)
+	s.blobs[digest] = b
+	return digest
+}
+
+// PutManifest stores a manifest in repo, tagging it unless tag is empty,
+// and returns its digest.
+func (s *Server) PutManifest(repo, tag, mediaType string, b []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.putManifest(repo, tag, mediaType, b)
+}
+
+func (s *Server) putManifest(repo, tag, mediaType string, b []byte) string {
+	digest := digestOf(b)
+	if s.manifests[repo] == nil {
+		s.manifests[repo] = make(map[string]manifest)
+		s.tags[repo] = make(map[string]string)
+	}
+	s.manifests[repo][digest] = manifest{mediaType: mediaType, body: b}
+	if tag != "" {
+		s.tags[repo][tag] = digest
+	}
+	return digest
+}
+
+// Image pushes an OCI image made of the given layers, created at created,
+// and returns its manifest digest.
+func (s *Server) Image(repo, tag string, created time.Time, layers ...[]byte) string {
+	var diffIDs []string
+	var descs []map[string]interface{}
+	for _, layer := range layers {
+		digest := s.PutBlob(layer)
+		diffIDs = append(diffIDs, digest)
+		descs = append(descs, map[string]interface{}{"mediaType": mediaTypeOCILayer, "digest": digest, "size": len(layer)})
+	}
+	config, _ := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"created":      created.UTC().Format(time.RFC3339),
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+	})
+	m, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeOCIManifest,
+		"config":        map[string]interface{}{"mediaType": mediaTypeOCIConfig, "digest": s.PutBlob(config), "size": len(config)},
+		"layers":        descs,
+	})
+	return s.PutManifest(repo, tag, mediaTypeOCIManifest, m)
+}
+
+// Tags returns the tags of repo and the digests they point at.
+func (s *Server) Tags(repo string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := make(map[string]string)
+	for tag, digest := range s.tags[repo] {
+		tags[tag] = digest
+	}
+	return tags
+}
+
+// HasBlob reports whether the blob identified by digest is stored.
+func (s *Server) HasBlob(digest string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.blobs[digest]
+	return ok
+}
+
+// Requests returns the requests served so far, as "METHOD /path?query".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+
+	if r.URL.Path == "/token" {
+		s.serveToken(w, r)
+		return
+	}
+	if !s.authorized(r) {
+		if s.bearer {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registrytest"`, s.URL))
+		} else {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registrytest"`)
+		}
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/v2/" || path == "/v2":
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		return
+	case path == "/v2/_catalog":
+		var repos []string
+		for repo := range s.tags {
+			repos = append(repos, repo)
+		}
+		s.writeList(w, r, "repositories", repos, nil)
+		return
+	}
+
+	path = strings.TrimPrefix(path, "/v2/")
+	for _, route := range []string{"/tags/list", "/manifests/", "/blobs/uploads/", "/blobs/", "/referrers/"} {
+		i := strings.LastIndex(path, route)
+		if i <= 0 {
+			continue
+		}
+		repo, rest := path[:i], path[i+len(route):]
+		switch route {
+		case "/tags/list":
+			if _, ok := s.tags[repo]; !ok {
+				writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known")
+				return
+			}
+			var tags []string
+			for tag := range s.tags[repo] {
+				tags = append(tags, tag)
+			}
+			s.writeList(w, r, "tags", tags, map[string]interface{}{"name": repo})
+		case "/manifests/":
+			s.serveManifest(w, r, repo, rest)
+		case "/blobs/uploads/":
+			s.serveUpload(w, r, repo, rest)
+		case "/blobs/":
+			s.serveBlob(w, r, rest)
+		case "/referrers/":
+			s.serveReferrers(w, r, repo, rest)
+		}
+		return
+	}
+	writeError(w, http.StatusNotFound, "NOT_FOUND", "not found")
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.username == "" {
+		return true
+	}
+	if !s.bearer {
+		username, password, ok := r.BasicAuth()
+		return ok && username == s.username && password == s.password
+	}
+	return s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !s.bearer || !ok || username != s.username || password != s.password {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+		return
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	s.tokens[token] = true
+	writeJSON(w, map[string]interface{}{"token": token, "expires_in": 300})
+}
+
+// writeList writes a paginated list, honoring the n and last parameters.
+func (s *Server) writeList(w http.ResponseWriter, r *http.Request, key string, items []string, extra map[string]interface{}) {
+	sort.Strings(items)
+	if last := r.URL.Query().Get("last"); last != "" {
+		i := sort.SearchStrings(items, last)
+		if i < len(items) && items[i] == last {
+			i++
+		}
+		items = items[i:]
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n > 0 && n < len(items) {
+		items = items[:n]
+		next := url.Values{"n": {strconv.Itoa(n)}, "last": {items[n-1]}}
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	}
+	v := map[string]interface{}{key: items}
+	for k, e := range extra {
+		v[k] = e
+	}
+	writeJSON(w, v)
+}
+
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request, repo, ref string) {
+	digest := ref
+	if !strings.Contains(ref, ":") {
+		digest = s.tags[repo][ref]
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		m, ok := s.manifests[repo][digest]
+		if !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.body)))
+		w.Header().Set("Docker-Content-Digest", digest)
+		if r.Method == http.MethodGet {
+			w.Write(m.body)
+		}
+	case http.MethodPut:
+		b, _ := ioutil.ReadAll(r.Body)
+		type descriptor struct {
+			Digest string   `json:"digest"`
+			URLs   []string `json:"urls"`
+		}
+		var m struct {
+			Config  descriptor   `json:"config"`
+			Layers  []descriptor `json:"layers"`
+			Subject *descriptor  `json:"subject"`
+		}
+		if err := json.Unmarshal(b, &m); err != nil {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		for _, blob := range append(m.Layers, m.Config) {
+			if blob.Digest == "" || len(blob.URLs) > 0 {
+				continue
+			}
+			if _, ok := s.blobs[blob.Digest]; !ok {
+				writeError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", blob.Digest)
+				return
+			}
+		}
+		tag := ref
+		if strings.Contains(ref, ":") {
+			if digestOf(b) != ref {
+				writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "digest mismatch")
+				return
+			}
+			tag = ""
+		}
+		digest := s.putManifest(repo, tag, r.Header.Get("Content-Type"), b)
+		if m.Subject != nil && s.referrers {
+			w.Header().Set("OCI-Subject", m.Subject.Digest)
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", repo, digest))
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if s.noDelete {
+			writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "deletion disabled")
+			return
+		}
+		if _, ok := s.manifests[repo][digest]; !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		if digest != ref {
+			delete(s.tags[repo], ref)
+		} else {
+			delete(s.manifests[repo], digest)
+			for tag, d := range s.tags[repo] {
+				if d == digest {
+					delete(s.tags[repo], tag)
+				}
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, repo, id string) {
+	switch r.Method {
+	case http.MethodPost:
+		query := r.URL.Query()
+		if mount := query.Get("mount"); mount != "" {
+			if _, ok := s.blobs[mount]; ok {
+				w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, mount))
+				w.Header().Set("Docker-Content-Digest", mount)
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		b := make([]byte, 8)
+		rand.Read(b)
+		id := hex.EncodeToString(b)
+		s.uploads[id] = nil
+		if digest := query.Get("digest"); digest != "" {
+			body, _ := ioutil.ReadAll(r.Body)
+			s.finishUpload(w, repo, id, digest, body)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, id))
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch:
+		data, ok := s.uploads[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload unknown")
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		data = append(data, body...)
+		s.uploads[id] = data
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, id))
+		w.Header().Set("Range", fmt.Sprintf("0-%d", len(data)-1))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		if _, ok := s.uploads[id]; !ok {
+			writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload unknown")
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		s.finishUpload(w, repo, id, r.URL.Query().Get("digest"), body)
+	case http.MethodDelete:
+		delete(s.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) finishUpload(w http.ResponseWriter, repo, id, digest string, body []byte) {
+	data := append(s.uploads[id], body...)
+	delete(s.uploads, id)
+	if digestOf(data) != digest {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "digest mismatch")
+		return
+	}
+	s.blobs[digest] = data
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, digest))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request, digest string) {
+	b, ok := s.blobs[digest]
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Accept-Ranges", "bytes")
+		start, end := 0, len(b)-1
+		status := http.StatusOK
+		if h := r.Header.Get("Range"); h != "" {
+			var first, last int
+			n, _ := fmt.Sscanf(h, "bytes=%d-%d", &first, &last)
+			if n == 0 || first >= len(b) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(b)))
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			start = first
+			if n == 2 && last < end {
+				end = last
+			}
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(b)))
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(b[start : end+1])
+		}
+	case http.MethodDelete:
+		delete(s.blobs, digest)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveReferrers(w http.ResponseWriter, r *http.Request, repo, digest string) {
+	if !s.referrers {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "not found")
+		return
+	}
+	artifactType := r.URL.Query().Get("artifactType")
+	manifests := []map[string]interface{}{}
+	for d, m := range s.manifests[repo] {
+		var v struct {
+			ArtifactType string `json:"artifactType"`
+			Config       struct {
+				MediaType string `json:"mediaType"`
+			} `json:"config"`
+			Subject *struct {
+				Digest string `json:"digest"`
+			} `json:"subject"`
+			Annotations map[string]string `json:"annotations"`
+		}
+		json.Unmarshal(m.body, &v)
+		if v.Subject == nil || v.Subject.Digest != digest {
+			continue
+		}
+		if v.ArtifactType == "" {
+			v.ArtifactType = v.Config.MediaType
+		}
+		if artifactType != "" && v.ArtifactType != artifactType {
+			continue
+		}
+		manifests = append(manifests, map[string]interface{}{
+			"mediaType":    m.mediaType,
+			"digest":       d,
+			"size":         len(m.body),
+			"artifactType": v.ArtifactType,
+			"annotations":  v.Annotations,
+		})
+	}
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", mediaTypeOCIIndex)
+	writeJSON(w, map[string]interface{}{"schemaVersion": 2, "mediaType": mediaTypeOCIIndex, "manifests": manifests})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	b, _ := json.Marshal(v)
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	b, _ := json.Marshal(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+	w.Write(b)
+}
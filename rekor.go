@@ -0,0 +1,296 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const cosignBundleAnnotation = "dev.sigstore.cosign/bundle"
+
+// RekorLog is a Rekor transparency log keyless signatures are checked
+// against.
+type RekorLog struct {
+	// URL is the address of the log, https://rekor.sigstore.dev when empty.
+	URL string
+	// PublicKey verifies the signed entry timestamps of the log, which
+	// vouch for entries and when they were integrated. It is required: the
+	// inclusion proofs served with entries are against a root hash the log
+	// does not sign, so they prove nothing on their own.
+	PublicKey crypto.PublicKey
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// RekorEntry is the entry of a signature in a Rekor transparency log.
+type RekorEntry struct {
+	UUID           string    `json:"uuid,omitempty"`
+	LogIndex       int64     `json:"logIndex"`
+	LogID          string    `json:"logID"`
+	IntegratedTime time.Time `json:"integratedTime"`
+}
+
+// RekorEntryVerifier is implemented by verifiers that check signatures
+// against a transparency log. VerifyWithRekor verifies a signature as Verify
+// does and returns its log entry, nil when the log is not checked.
+type RekorEntryVerifier interface {
+	VerifyWithRekor(ctx context.Context, payload, signature []byte, annotations map[string]string) (*RekorEntry, error)
+}
+
+// rekorPayload is the part of a log entry covered by its signed entry
+// timestamp. The fields are in the order of the canonical JSON encoding.
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+type rekorLogEntry struct {
+	rekorPayload
+	Verification struct {
+		InclusionProof *struct {
+			Hashes   []string `json:"hashes"`
+			LogIndex int64    `json:"logIndex"`
+			RootHash string   `json:"rootHash"`
+			TreeSize int64    `json:"treeSize"`
+		} `json:"inclusionProof"`
+		SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+	} `json:"verification"`
+}
+
+// NewRekorPublicKey parses the PEM encoded public key of a Rekor log, as
+// served at /api/v1/log/publicKey.
+func NewRekorPublicKey(pemKey []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// Entry returns the log entry of a signature made with the key of cert. The
+// cosign bundle of the signature layer is used when there is one, the log is
+// queried otherwise; either way the signed entry timestamp of the entry is
+// verified with the public key of the log. The entry must have been
+// integrated while cert was valid.
+func (r *RekorLog) Entry(ctx context.Context, payload, signature []byte, cert *x509.Certificate, annotations map[string]string) (*RekorEntry, error) {
+	if r.PublicKey == nil {
+		return nil, errors.New("rekor: no public key of the log")
+	}
+	var entry *RekorEntry
+	var body []byte
+	var err error
+	if bundle := annotations[cosignBundleAnnotation]; bundle != "" {
+		entry, body, err = r.bundleEntry([]byte(bundle))
+	} else {
+		entry, body, err = r.lookup(ctx, payload, signature)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "rekor")
+	}
+	if err := matchRekorBody(body, payload, signature); err != nil {
+		return nil, errors.Wrap(err, "rekor")
+	}
+	if entry.IntegratedTime.Before(cert.NotBefore) || entry.IntegratedTime.After(cert.NotAfter) {
+		return nil, fmt.Errorf("rekor: entry integrated at %s, outside of the validity of the certificate", entry.IntegratedTime.Format(time.RFC3339))
+	}
+	return entry, nil
+}
+
+// bundleEntry returns the entry of a cosign bundle, checking its signed
+// entry timestamp.
+func (r *RekorLog) bundleEntry(bundle []byte) (*RekorEntry, []byte, error) {
+	var b struct {
+		SignedEntryTimestamp []byte       `json:"SignedEntryTimestamp"`
+		Payload              rekorPayload `json:"Payload"`
+	}
+	if err := unmarshalJSON(bundle, &b); err != nil {
+		return nil, nil, errors.Wrap(err, "parse bundle")
+	}
+	if err := r.verifySET(b.Payload, b.SignedEntryTimestamp); err != nil {
+		return nil, nil, err
+	}
+	body, err := base64.StdEncoding.DecodeString(b.Payload.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newRekorEntry("", b.Payload), body, nil
+}
+
+// lookup searches the log for the entries of the payload digest and returns
+// the one of signature, checking its signed entry timestamp and inclusion
+// proof.
+func (r *RekorLog) lookup(ctx context.Context, payload, signature []byte) (*RekorEntry, []byte, error) {
+	digest := sha256.Sum256(payload)
+	query, err := marshalJSON(map[string]string{"hash": fmt.Sprintf("sha256:%x", digest)})
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := r.do(ctx, http.MethodPost, "/api/v1/index/retrieve", query)
+	if err != nil {
+		return nil, nil, err
+	}
+	var uuids []string
+	if err := unmarshalJSON(b, &uuids); err != nil {
+		return nil, nil, err
+	}
+	for _, uuid := range uuids {
+		b, err := r.do(ctx, http.MethodGet, "/api/v1/log/entries/"+uuid, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		var entries map[string]rekorLogEntry
+		if err := unmarshalJSON(b, &entries); err != nil {
+			return nil, nil, err
+		}
+		for id, e := range entries {
+			body, err := base64.StdEncoding.DecodeString(e.Body)
+			if err != nil {
+				return nil, nil, err
+			}
+			if matchRekorBody(body, payload, signature) != nil {
+				continue
+			}
+			proof := e.Verification.InclusionProof
+			if proof == nil {
+				return nil, nil, fmt.Errorf("entry %s: no inclusion proof", id)
+			}
+			if err := verifyInclusion(proof.LogIndex, proof.TreeSize, body, proof.Hashes, proof.RootHash); err != nil {
+				return nil, nil, errors.Wrapf(err, "entry %s", id)
+			}
+			if err := r.verifySET(e.rekorPayload, e.Verification.SignedEntryTimestamp); err != nil {
+				return nil, nil, errors.Wrapf(err, "entry %s", id)
+			}
+			return newRekorEntry(id, e.rekorPayload), body, nil
+		}
+	}
+	return nil, nil, errors.New("no log entry of the signature")
+}
+
+func (r *RekorLog) do(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	u := r.URL
+	if u == "" {
+		u = "https://rekor.sigstore.dev"
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(u, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return callService(ctx, r.Client, req)
+}
+
+// verifySET verifies the signed entry timestamp of a log entry.
+func (r *RekorLog) verifySET(p rekorPayload, set []byte) error {
+	if len(set) == 0 {
+		return errors.New("no signed entry timestamp")
+	}
+	b, err := marshalJSON(p)
+	if err != nil {
+		return err
+	}
+	key, ok := r.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported log key %T", r.PublicKey)
+	}
+	digest := sha256.Sum256(b)
+	if !ecdsa.VerifyASN1(key, digest[:], set) {
+		return errors.New("invalid signed entry timestamp")
+	}
+	return nil
+}
+
+func newRekorEntry(uuid string, p rekorPayload) *RekorEntry {
+	return &RekorEntry{UUID: uuid, LogIndex: p.LogIndex, LogID: p.LogID, IntegratedTime: time.Unix(p.IntegratedTime, 0).UTC()}
+}
+
+// matchRekorBody checks that the hashedrekord entry body records signature
+// over payload.
+func matchRekorBody(body, payload, signature []byte) error {
+	var entry struct {
+		Kind string `json:"kind"`
+		Spec struct {
+			Data struct {
+				Hash struct {
+					Value string `json:"value"`
+				} `json:"hash"`
+			} `json:"data"`
+			Signature struct {
+				Content string `json:"content"`
+			} `json:"signature"`
+		} `json:"spec"`
+	}
+	if err := unmarshalJSON(body, &entry); err != nil {
+		return fmt.Errorf("invalid entry: %v", err)
+	}
+	if entry.Kind != "hashedrekord" {
+		return fmt.Errorf("unsupported entry kind %q", entry.Kind)
+	}
+	digest := sha256.Sum256(payload)
+	if entry.Spec.Data.Hash.Value != hex.EncodeToString(digest[:]) {
+		return errors.New("entry of another payload")
+	}
+	if entry.Spec.Signature.Content != base64.StdEncoding.EncodeToString(signature) {
+		return errors.New("entry of another signature")
+	}
+	return nil
+}
+
+// verifyInclusion checks the RFC 6962 inclusion proof of the leaf at index
+// in a tree of size leaves with the root hash.
+func verifyInclusion(index, size int64, leaf []byte, proof []string, root string) error {
+	if index < 0 || index >= size {
+		return fmt.Errorf("invalid inclusion proof of leaf %d in tree of size %d", index, size)
+	}
+	h := sha256.Sum256(append([]byte{0}, leaf...))
+	r := h[:]
+	fn, sn := index, size-1
+	for _, s := range proof {
+		p, err := hex.DecodeString(s)
+		if err != nil {
+			return err
+		}
+		if sn == 0 {
+			return errors.New("inclusion proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = hashChildren(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = hashChildren(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || hex.EncodeToString(r) != root {
+		return errors.New("inclusion proof does not match the root hash")
+	}
+	return nil
+}
+
+func hashChildren(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
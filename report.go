@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// TagAge is the age of the image a tag points to.
+type TagAge struct {
+	Repository       string       `json:"repository"`
+	Tag              string       `json:"tag"`
+	Digest           string       `json:"digest"`
+	Created          time.Time    `json:"created"`
+	DaysSinceCreated int          `json:"daysSinceCreated"`
+	Kind             ArtifactKind `json:"kind"`
+	// Chart is set for Helm charts.
+	Chart *ChartInfo `json:"chart,omitempty"`
+	// Annotations are the annotations selected with
+	// InventoryOptions.Annotations.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// RepositoryAge summarizes the image ages of a repository.
+type RepositoryAge struct {
+	Repository string `json:"repository"`
+	Tags       int    `json:"tags"`
+	Oldest     TagAge `json:"oldest"`
+	Newest     TagAge `json:"newest"`
+}
+
+// AgeReport lists tags by image creation date, oldest first.
+type AgeReport struct {
+	GeneratedAt  time.Time       `json:"generatedAt"`
+	Tags         []TagAge        `json:"tags"`
+	Repositories []RepositoryAge `json:"repositories"`
+	// Annotations are the annotations selected for the tags, as set with
+	// InventoryOptions.Annotations.
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+// AgeReport reports the age of every tag in repos, or in all repositories if
+// none are given. Tags whose image cannot be inspected are skipped.
+func (c *Client) AgeReport(ctx context.Context, repos ...string) (*AgeReport, error) {
+	inv, err := c.Inventory(ctx, InventoryOptions{Repositories: repos, Images: true, Cached: true})
+	if err != nil {
+		return nil, err
+	}
+	return inv.AgeReport(), nil
+}
+
+// AgeReport returns the age report of the inventory, which must have been
+// walked with InventoryOptions.Images.
+func (inv *Inventory) AgeReport() *AgeReport {
+	report := &AgeReport{GeneratedAt: time.Now(), Annotations: inv.Annotations}
+	for _, r := range inv.Repositories {
+		stats := RepositoryAge{Repository: r.Repository}
+		for _, t := range r.Tags {
+			if t.Image == nil {
+				continue
+			}
+			created := t.Image.Created
+			age := TagAge{
+				Repository:       r.Repository,
+				Tag:              t.Tag,
+				Digest:           t.Digest,
+				Created:          created,
+				DaysSinceCreated: int(report.GeneratedAt.Sub(created).Hours() / 24),
+				Kind:             t.Image.Kind,
+				Chart:            t.Image.Chart,
+				Annotations:      t.Image.Annotations,
+			}
+			report.Tags = append(report.Tags, age)
+			if stats.Tags == 0 || created.Before(stats.Oldest.Created) {
+				stats.Oldest = age
+			}
+			if stats.Tags == 0 || created.After(stats.Newest.Created) {
+				stats.Newest = age
+			}
+			stats.Tags++
+		}
+		if stats.Tags > 0 {
+			report.Repositories = append(report.Repositories, stats)
+		}
+	}
+	sort.SliceStable(report.Tags, func(i, j int) bool {
+		return report.Tags[i].Created.Before(report.Tags[j].Created)
+	})
+	return report
+}
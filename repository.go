@@ -0,0 +1,52 @@
+package registry
+
+import (
+	"context"
+	"io"
+)
+
+// Repository is a client bound to a single repository.
+type Repository struct {
+	client *Client
+	repo   string
+	name   string
+}
+
+// Repository returns a client for the repository repo, relative to the path
+// prefix like other repository names.
+func (c *Client) Repository(repo string) *Repository {
+	return &Repository{client: c, repo: repo, name: c.repoName(repo)}
+}
+
+// Name returns the name of the repository.
+func (r *Repository) Name() string {
+	return r.repo
+}
+
+// Tags lists the tags of the repository.
+func (r *Repository) Tags() ([]string, error) {
+	return r.client.QueryTags(r.repo)
+}
+
+// Manifest fetches the manifest identified by the tag or digest ref,
+// returning its raw bytes and descriptor.
+func (r *Repository) Manifest(ref string) ([]byte, Descriptor, error) {
+	return r.client.getManifest(context.Background(), r.name, ref)
+}
+
+// Resolve returns the descriptor of the manifest identified by ref.
+func (r *Repository) Resolve(ctx context.Context, ref string) (Descriptor, error) {
+	return r.client.resolve(ctx, r.name, ref)
+}
+
+// Blob starts downloading the blob identified by digest, returning its size.
+// The caller must close the returned reader.
+func (r *Repository) Blob(digest string) (io.ReadCloser, int64, error) {
+	return r.client.openBlob(context.Background(), r.name, digest)
+}
+
+// Delete deletes the manifest identified by the tag or digest ref, along
+// with all tags pointing at it.
+func (r *Repository) Delete(ref string) error {
+	return r.client.deleteTag(context.Background(), r.repo, ref, nil, nil)
+}
@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/inconshreveable/log15"
+	"github.com/pkg/errors"
+)
+
+// RequestIDHeader is the header carrying the correlation ID of the requests
+// of clients, for the access logs of registries and proxies.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx whose requests carry the
+// correlation ID id instead of that of the client, such as the ID of the
+// notification that triggered a run. Identical requests in flight at
+// the same time share a round trip only when they carry the same ID, so the
+// requests of the calls of other IDs are still sent, and logged by the
+// registry, with theirs.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the correlation ID of ctx, empty if it has
+// none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random correlation ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID makes id the correlation ID of the client instead of a
+// random one.
+func WithRequestID(id string) Option {
+	return func(c *Client) {
+		c.requestID = id
+	}
+}
+
+// RequestID returns the correlation ID of the client. It is sent in the
+// RequestIDHeader of its requests made without one in their context, and
+// included in its log lines and in the errors of the responses to them.
+func (c *Client) RequestID() string {
+	return c.requestID
+}
+
+// contextRequestID returns the correlation ID of the requests made with ctx.
+func (c *Client) contextRequestID(ctx context.Context) string {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return id
+	}
+	return c.requestID
+}
+
+// statusError returns the error of the response resp of the registry,
+// served with an unexpected status, naming the correlation ID of the request
+// so it can be found in the logs of the registry.
+func statusError(resp *http.Response, body []byte) error {
+	msg := fmt.Sprintf("invalid response %d", resp.StatusCode)
+	if len(body) > 0 {
+		msg += ":" + Redact(string(body))
+	}
+	if resp.Request != nil {
+		if id := resp.Request.Header.Get(RequestIDHeader); id != "" {
+			msg += fmt.Sprintf(" (request %s)", id)
+		}
+	}
+	return errors.New(msg)
+}
+
+// contextLogger returns the logger of the client for work done with ctx,
+// naming the correlation ID of ctx.
+func (c *Client) contextLogger(ctx context.Context) log15.Logger {
+	if id := RequestIDFromContext(ctx); id != "" && id != c.requestID {
+		return c.logger.New("request", id)
+	}
+	return c.Logger
+}
+
+// detachContext returns a context that never ends, carrying the correlation
+// ID of ctx.
+func detachContext(ctx context.Context) context.Context {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return ContextWithRequestID(context.Background(), id)
+	}
+	return context.Background()
+}
@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// RestoreOptions selects the backups Restore re-pushes.
+type RestoreOptions struct {
+	// Repositories restricts the restore to the given repositories.
+	Repositories []string
+	// Digests restricts the restore to the given manifests.
+	Digests []string
+	// Since restricts the restore to manifests backed up after it.
+	Since time.Time
+}
+
+// RestoreResult summarizes a Restore run.
+type RestoreResult struct {
+	Restored []BackupRecord `json:"restored"`
+	Errors   []string       `json:"errors,omitempty"`
+}
+
+// Restore re-pushes manifests backed up before their deletion under their
+// original tags. The blobs they reference must still exist, except for
+// config blobs included in the backup. Failures are collected in the result
+// and do not stop the run.
+func (c *Client) Restore(ctx context.Context, store BackupStore, opts RestoreOptions) (*RestoreResult, error) {
+	var prefixes []string
+	for _, repo := range opts.Repositories {
+		prefixes = append(prefixes, repo+"/")
+	}
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	result := &RestoreResult{}
+	for _, prefix := range prefixes {
+		keys, err := store.List(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if !strings.HasSuffix(key, "/"+backupRecordFile) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			b, err := store.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			var record BackupRecord
+			if err := unmarshalJSON(b, &record); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", key, err))
+				continue
+			}
+			if prefix != "" && record.Repository+"/" != prefix {
+				// A nested repository sharing the prefix.
+				continue
+			}
+			if !opts.Since.IsZero() && record.Time.Before(opts.Since) {
+				continue
+			}
+			if len(opts.Digests) > 0 && !contains(opts.Digests, record.Digest) {
+				continue
+			}
+			if err := c.restoreManifest(ctx, store, record); err != nil {
+				c.Warn("fail to restore manifest.", "repo", record.Repository, "digest", record.Digest, "error", err)
+				result.Errors = append(result.Errors, fmt.Sprintf("%s@%s: %v", record.Repository, record.Digest, err))
+				continue
+			}
+			result.Restored = append(result.Restored, record)
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) restoreManifest(ctx context.Context, store BackupStore, record BackupRecord) error {
+	name := c.repoName(record.Repository)
+	body, err := store.Get(backupKey(record.Repository, record.Digest, backupManifestFile))
+	if err != nil {
+		return err
+	}
+	if isIndex(record.MediaType) {
+		var index Index
+		if err := unmarshalJSON(body, &index); err != nil {
+			return err
+		}
+		for _, m := range index.Manifests {
+			if _, err := c.resolve(ctx, name, m.Digest); err != nil {
+				return fmt.Errorf("manifest %s: %v", m.Digest, err)
+			}
+		}
+	} else {
+		var manifest Manifest
+		if err := unmarshalJSON(body, &manifest); err != nil {
+			return err
+		}
+		if err := c.restoreConfig(ctx, store, record, manifest.Config); err != nil {
+			return err
+		}
+		for _, layer := range manifest.Layers {
+			if len(layer.URLs) > 0 {
+				continue
+			}
+			exists, err := c.blobExists(ctx, name, layer.Digest)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("blob %s no longer exists", layer.Digest)
+			}
+		}
+	}
+
+	refs := record.Tags
+	if len(refs) == 0 {
+		refs = []string{record.Digest}
+	}
+	for _, ref := range refs {
+		if _, err := c.putManifest(ctx, name, ref, record.MediaType, body); err != nil {
+			return err
+		}
+		c.Info("restore manifest.", "repo", record.Repository, "ref", ref, "digest", record.Digest)
+	}
+	return nil
+}
+
+// restoreConfig makes sure the config blob exists, pushing it from the
+// backup if needed.
+func (c *Client) restoreConfig(ctx context.Context, store BackupStore, record BackupRecord, config Descriptor) error {
+	if config.Digest == "" {
+		return nil
+	}
+	name := c.repoName(record.Repository)
+	exists, err := c.blobExists(ctx, name, config.Digest)
+	if err != nil || exists {
+		return err
+	}
+	if record.Config != config.Digest {
+		return fmt.Errorf("config blob %s no longer exists", config.Digest)
+	}
+	b, err := store.Get(backupKey(record.Repository, record.Digest, backupConfigFile))
+	if err != nil {
+		return err
+	}
+	return c.pushBlob(ctx, name, config, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}, "")
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
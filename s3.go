@@ -0,0 +1,236 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Store is a BackupStore keeping backups as objects of a bucket of S3 or
+// of a compatible service such as MinIO. Objects are addressed path-style,
+// so they are limited to the 5GiB of single part uploads.
+type S3Store struct {
+	// Endpoint is the URL of the service, like https://s3.eu-west-1.amazonaws.com.
+	Endpoint string
+	Bucket   string
+	// Prefix is prepended to the keys, like "backups/".
+	Prefix    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+func (s *S3Store) Put(key string, data []byte) error {
+	return s.PutStream(key, bytes.NewReader(data), int64(len(data)))
+}
+
+func (s *S3Store) PutStream(key string, r io.Reader, size int64) error {
+	resp, err := s.send(http.MethodPut, key, nil, r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(key string) ([]byte, error) {
+	r, err := s.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (s *S3Store) Open(key string) (io.ReadCloser, error) {
+	resp, err := s.send(http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Store) Exists(key string) (bool, error) {
+	resp, err := s.send(http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid response %d", resp.StatusCode)
+	}
+}
+
+func (s *S3Store) List(prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {s.Prefix + prefix}}
+	for {
+		resp, err := s.send(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(object.Key, s.Prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// send issues a request for the object key, or for the bucket when key is
+// empty, signed with AWS signature version 4.
+func (s *S3Store) send(method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	path := "/" + s.Bucket
+	if key != "" {
+		path += "/" + s.Prefix + key
+	}
+	u := strings.TrimSuffix(s.Endpoint, "/") + s3Escape(path, false)
+	if len(query) > 0 {
+		u += "?" + s3Query(query)
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now().UTC())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// sign adds the AWS signature version 4 headers to req, leaving the payload
+// unsigned so it can be streamed.
+func (s *S3Store) sign(req *http.Request, now time.Time) {
+	signAWS(req, "UNSIGNED-PAYLOAD", s.Region, "s3", s.AccessKey, s.SecretKey, now)
+}
+
+// signAWS adds the AWS signature version 4 headers to req for service, with
+// payload the hex SHA-256 of the body or UNSIGNED-PAYLOAD.
+func signAWS(req *http.Request, payload, region, service, accessKey, secretKey string, now time.Time) {
+	date := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" + "x-amz-content-sha256:" + payload + "\n" + "x-amz-date:" + stamp + "\n",
+		"host;x-amz-content-sha256;x-amz-date",
+		payload,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=%s", accessKey, scope, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes s as signature version 4 expects, keeping
+// slashes unless slash is set.
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9', ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !slash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// s3Query encodes query sorted by key, as signature version 4 expects.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Error(resp *http.Response) error {
+	var e struct {
+		Code    string
+		Message string
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3: %s: %s", e.Code, e.Message)
+	}
+	return fmt.Errorf("s3: invalid response %d", resp.StatusCode)
+}
@@ -0,0 +1,169 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	ArtifactTypeSPDX      = "application/spdx+json"
+	ArtifactTypeCycloneDX = "application/vnd.cyclonedx+json"
+
+	SBOMFormatSPDX      = "spdx"
+	SBOMFormatCycloneDX = "cyclonedx"
+)
+
+// SBOM is a software bill of materials attached to an image.
+type SBOM struct {
+	Format   string
+	Packages []Package
+	// Raw is the SBOM document as stored in the registry.
+	Raw []byte
+}
+
+// Package is a software package listed in an SBOM.
+type Package struct {
+	Name    string
+	Version string
+	PURL    string
+	License string
+}
+
+// GetSBOM locates the SPDX or CycloneDX SBOM attached to the image repo:ref,
+// either as a referrer or as a cosign ".sbom" tag, and parses its package
+// list. ErrNotFound is returned if the image has no SBOM.
+func (c *Client) GetSBOM(ctx context.Context, repo, ref string) (*SBOM, error) {
+	name := c.repoName(repo)
+	_, desc, err := c.getManifest(ctx, name, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []string
+	referrers, err := c.Referrers(ctx, repo, desc.Digest, ArtifactTypeSPDX, ArtifactTypeCycloneDX)
+	if err != nil {
+		return nil, err
+	}
+	for _, referrer := range referrers {
+		manifests = append(manifests, referrer.Digest)
+	}
+	manifests = append(manifests, strings.Replace(desc.Digest, ":", "-", 1)+".sbom")
+
+	for _, m := range manifests {
+		body, _, err := c.getManifest(ctx, name, m)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var manifest Manifest
+		if err := unmarshalJSON(body, &manifest); err != nil {
+			return nil, err
+		}
+		for _, layer := range manifest.Layers {
+			format := sbomFormat(layer.MediaType)
+			if format == "" {
+				format = sbomFormat(manifest.ArtifactType)
+			}
+			if format == "" {
+				continue
+			}
+			r, _, err := c.openBlob(ctx, name, layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+			b, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+			return ParseSBOM(format, b)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func sbomFormat(mediaType string) string {
+	switch {
+	case strings.Contains(mediaType, "spdx"):
+		return SBOMFormatSPDX
+	case strings.Contains(mediaType, "cyclonedx"):
+		return SBOMFormatCycloneDX
+	}
+	return ""
+}
+
+// ParseSBOM parses an SPDX or CycloneDX JSON document.
+func ParseSBOM(format string, b []byte) (*SBOM, error) {
+	sbom := &SBOM{Format: format, Raw: b}
+	switch format {
+	case SBOMFormatSPDX:
+		var doc struct {
+			Packages []struct {
+				Name             string `json:"name"`
+				VersionInfo      string `json:"versionInfo"`
+				LicenseConcluded string `json:"licenseConcluded"`
+				LicenseDeclared  string `json:"licenseDeclared"`
+				ExternalRefs     []struct {
+					ReferenceType    string `json:"referenceType"`
+					ReferenceLocator string `json:"referenceLocator"`
+				} `json:"externalRefs"`
+			} `json:"packages"`
+		}
+		if err := unmarshalJSON(b, &doc); err != nil {
+			return nil, err
+		}
+		for _, p := range doc.Packages {
+			pkg := Package{Name: p.Name, Version: p.VersionInfo, License: p.LicenseConcluded}
+			if pkg.License == "" || pkg.License == "NOASSERTION" {
+				pkg.License = p.LicenseDeclared
+			}
+			for _, ref := range p.ExternalRefs {
+				if ref.ReferenceType == "purl" {
+					pkg.PURL = ref.ReferenceLocator
+				}
+			}
+			sbom.Packages = append(sbom.Packages, pkg)
+		}
+	case SBOMFormatCycloneDX:
+		var doc struct {
+			Components []struct {
+				Name     string `json:"name"`
+				Version  string `json:"version"`
+				PURL     string `json:"purl"`
+				Licenses []struct {
+					Expression string `json:"expression"`
+					License    struct {
+						ID   string `json:"id"`
+						Name string `json:"name"`
+					} `json:"license"`
+				} `json:"licenses"`
+			} `json:"components"`
+		}
+		if err := unmarshalJSON(b, &doc); err != nil {
+			return nil, err
+		}
+		for _, comp := range doc.Components {
+			pkg := Package{Name: comp.Name, Version: comp.Version, PURL: comp.PURL}
+			var licenses []string
+			for _, l := range comp.Licenses {
+				switch {
+				case l.Expression != "":
+					licenses = append(licenses, l.Expression)
+				case l.License.ID != "":
+					licenses = append(licenses, l.License.ID)
+				case l.License.Name != "":
+					licenses = append(licenses, l.License.Name)
+				}
+			}
+			pkg.License = strings.Join(licenses, " AND ")
+			sbom.Packages = append(sbom.Packages, pkg)
+		}
+	default:
+		return nil, fmt.Errorf("unknown SBOM format %q", format)
+	}
+	return sbom, nil
+}
@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Severity is the severity of a vulnerability. The zero value is
+// SeverityUnknown, which never satisfies a severity threshold.
+type Severity int
+
+const (
+	SeverityUnknown Severity = iota
+	SeverityNone
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = []string{"unknown", "none", "low", "medium", "high", "critical"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return severityNames[0]
+	}
+	return severityNames[s]
+}
+
+// ParseSeverity parses a severity name case-insensitively, as reported by
+// Trivy and Harbor. Unrecognized names yield SeverityUnknown.
+func ParseSeverity(name string) Severity {
+	for i, n := range severityNames {
+		if strings.EqualFold(n, name) {
+			return Severity(i)
+		}
+	}
+	return SeverityUnknown
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	*s = ParseSeverity(string(text))
+	if *s == SeverityUnknown && !strings.EqualFold(string(text), "unknown") {
+		return fmt.Errorf("invalid severity %q", text)
+	}
+	return nil
+}
+
+// Scanner reports the highest severity of the vulnerabilities found in an
+// image.
+type Scanner interface {
+	Scan(ctx context.Context, image Reference) (Severity, error)
+}
+
+// ScannerFunc adapts a function to the Scanner interface.
+type ScannerFunc func(ctx context.Context, image Reference) (Severity, error)
+
+func (f ScannerFunc) Scan(ctx context.Context, image Reference) (Severity, error) {
+	return f(ctx, image)
+}
+
+// TrivyScanner scans images by invoking the trivy command line tool.
+type TrivyScanner struct {
+	// Path of the trivy binary, looked up in PATH when empty.
+	Path string
+	// Username and Password are passed to trivy to pull from the registry.
+	Username string
+	Password string
+	// Args are extra arguments, such as "--skip-db-update".
+	Args []string
+}
+
+func (s *TrivyScanner) Scan(ctx context.Context, image Reference) (Severity, error) {
+	path := s.Path
+	if path == "" {
+		path = "trivy"
+	}
+	args := append([]string{"image", "--quiet", "--format", "json"}, s.Args...)
+	cmd := exec.CommandContext(ctx, path, append(args, image.String())...)
+	cmd.Env = os.Environ()
+	if s.Username != "" {
+		cmd.Env = append(cmd.Env, "TRIVY_USERNAME="+s.Username, "TRIVY_PASSWORD="+s.Password)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return SeverityUnknown, fmt.Errorf("trivy: %v: %s", err, stderr.String())
+	}
+	var report struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				Severity string
+			}
+		}
+	}
+	if err := unmarshalJSON(out, &report); err != nil {
+		return SeverityUnknown, err
+	}
+	max := SeverityNone
+	for _, result := range report.Results {
+		for _, vuln := range result.Vulnerabilities {
+			if s := ParseSeverity(vuln.Severity); s > max {
+				max = s
+			}
+		}
+	}
+	return max, nil
+}
+
+// HarborScanner reads scan results from the Harbor API. Images that have not
+// been scanned yet get a scan triggered and are reported as unknown, so they
+// satisfy no severity threshold until their scan completes.
+type HarborScanner struct {
+	// URL of the Harbor instance, such as https://harbor.example.com.
+	URL      string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+func (s *HarborScanner) Scan(ctx context.Context, image Reference) (Severity, error) {
+	parts := strings.SplitN(image.Repository, "/", 2)
+	if len(parts) != 2 {
+		return SeverityUnknown, fmt.Errorf("no harbor project in %s", image.Repository)
+	}
+	path := fmt.Sprintf("%s/api/v2.0/projects/%s/repositories/%s/artifacts/%s",
+		strings.TrimSuffix(s.URL, "/"), parts[0], url.PathEscape(url.PathEscape(parts[1])), image.Digest)
+
+	resp, body, err := s.request(ctx, http.MethodGet, path+"?with_scan_overview=true")
+	if err != nil {
+		return SeverityUnknown, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return SeverityUnknown, fmt.Errorf("invalid response %d:%s", resp.StatusCode, Redact(string(body)))
+	}
+	var artifact struct {
+		ScanOverview map[string]struct {
+			ScanStatus string `json:"scan_status"`
+			Severity   string `json:"severity"`
+		} `json:"scan_overview"`
+	}
+	if err := decodeResponse(resp, body, &artifact); err != nil {
+		return SeverityUnknown, err
+	}
+	for _, overview := range artifact.ScanOverview {
+		if overview.ScanStatus == "Success" {
+			return ParseSeverity(overview.Severity), nil
+		}
+	}
+
+	resp, body, err = s.request(ctx, http.MethodPost, path+"/scan")
+	if err != nil {
+		return SeverityUnknown, err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return SeverityUnknown, fmt.Errorf("invalid response %d:%s", resp.StatusCode, Redact(string(body)))
+	}
+	return SeverityUnknown, nil
+}
+
+func (s *HarborScanner) request(ctx context.Context, method, target string) (*http.Response, []byte, error) {
+	return harborRequest(ctx, s.Client, s.Username, s.Password, method, target)
+}
+
+// harborRequest calls the Harbor API with basic authentication.
+func harborRequest(ctx context.Context, client *http.Client, username, password, method, target string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(username, password)
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, redactError(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp, body, err
+}
@@ -0,0 +1,134 @@
+package registry
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// namePattern matches names against a glob or a regular expression.
+type namePattern struct {
+	match func(name string) bool
+	// prefix is a literal prefix of every matching name.
+	prefix string
+}
+
+// compilePattern compiles s as a regular expression if it contains any of
+// ^$()|+\{ or .*, and as a glob as in path.Match otherwise, where * does not
+// match slashes. Regular expressions match anywhere in names unless
+// anchored.
+func compilePattern(s string) (*namePattern, error) {
+	if strings.ContainsAny(s, `^$()|+\{`) || strings.Contains(s, ".*") {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, err
+		}
+		p := &namePattern{match: re.MatchString}
+		if strings.HasPrefix(s, "^") && !strings.Contains(s, "|") {
+			p.prefix = literalPrefix(s[1:], `\.+*?()|[]{}^$`)
+		}
+		return p, nil
+	}
+	if _, err := path.Match(s, ""); err != nil {
+		return nil, err
+	}
+	return &namePattern{
+		match: func(name string) bool {
+			ok, _ := path.Match(s, name)
+			return ok
+		},
+		prefix: literalPrefix(s, `*?[\`),
+	}, nil
+}
+
+// literalPrefix returns the characters of s before the first of meta,
+// leaving out the last one if a quantifier follows it.
+func literalPrefix(s, meta string) string {
+	i := strings.IndexAny(s, meta)
+	if i < 0 {
+		return s
+	}
+	if i > 0 && strings.ContainsRune("*?{", rune(s[i])) {
+		i--
+	}
+	return s[:i]
+}
+
+// SearchRepositories returns the repositories whose name matches pattern,
+// a glob such as prod/* or a regular expression such as .*-cache$. Patterns
+// containing any of ^$()|+\{ or .* are regular expressions, matching
+// anywhere in names unless anchored; others are globs as in path.Match.
+// When the pattern starts with a literal prefix, only the part of the
+// catalog with that prefix is listed, as registries list it in lexical
+// order.
+func (c *Client) SearchRepositories(ctx context.Context, pattern string) ([]string, error) {
+	p, err := compilePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	prefix := p.prefix
+	if c.prefix != "" {
+		prefix = c.prefix + "/" + prefix
+	}
+	var repos []string
+	err = c.catalog(ctx, catalogBefore(prefix), 0, func(name string) bool {
+		if !strings.HasPrefix(name, prefix) {
+			return name < prefix
+		}
+		repo := name
+		if c.prefix != "" {
+			repo = strings.TrimPrefix(name, c.prefix+"/")
+		}
+		if p.match(repo) && c.inShard(repo) {
+			repos = append(repos, repo)
+		}
+		return true
+	})
+	return repos, err
+}
+
+// SearchTags returns the tags of repo matching pattern, a glob or regular
+// expression as in SearchRepositories, or all tags if pattern is empty.
+// Unless versions is empty, only tags holding a semantic version in the
+// range versions are returned, such as ">=1.2 <2.0" or "^1.2 || ~2.0.1".
+func (c *Client) SearchTags(repo, pattern, versions string) ([]string, error) {
+	match, err := tagMatcher(pattern, versions)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := c.QueryTags(repo)
+	if err != nil {
+		return nil, err
+	}
+	var matched []string
+	for _, tag := range tags {
+		if match(tag) {
+			matched = append(matched, tag)
+		}
+	}
+	return matched, nil
+}
+
+// tagMatcher returns a function matching the tags selected by pattern and
+// versions, as in SearchTags.
+func tagMatcher(pattern, versions string) (func(tag string) bool, error) {
+	p := &namePattern{match: func(string) bool { return true }}
+	if pattern != "" {
+		var err error
+		if p, err = compilePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+	if versions == "" {
+		return p.match, nil
+	}
+	r, err := parseVersionRange(versions)
+	if err != nil {
+		return nil, err
+	}
+	return func(tag string) bool {
+		v, ok := parseVersion(tag)
+		return ok && r.contains(v) && p.match(tag)
+	}, nil
+}
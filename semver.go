@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// version is a parsed semantic version. Missing minor and patch numbers are
+// zero and a leading "v" is accepted, as tags commonly carry one.
+type version struct {
+	major, minor, patch int
+	pre                 []string
+}
+
+func parseVersion(s string) (version, bool) {
+	var v version
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		v.pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, false
+	}
+	nums := []*int{&v.major, &v.minor, &v.patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		*nums[i] = n
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1 as v is lower than, equal to or higher than w,
+// following the semver precedence rules.
+func (v version) compare(w version) int {
+	for _, d := range []int{v.major - w.major, v.minor - w.minor, v.patch - w.patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case len(v.pre) == 0 && len(w.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(w.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(w.pre); i++ {
+		a, aerr := strconv.Atoi(v.pre[i])
+		b, berr := strconv.Atoi(w.pre[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if a != b {
+				return cmpInt(a, b)
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		default:
+			if v.pre[i] != w.pre[i] {
+				return cmpInt(strings.Compare(v.pre[i], w.pre[i]), 0)
+			}
+		}
+	}
+	return cmpInt(len(v.pre), len(w.pre))
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// versionRange is a range of versions such as ">=1.2 <2.0": alternatives
+// separated by "||", each a set of comparators versions must all satisfy.
+type versionRange [][]comparator
+
+type comparator struct {
+	op string
+	v  version
+}
+
+// parseVersionRange parses a range of comparators with the operators =, !=,
+// <, <=, > and >=, along with ~1.2 (at least 1.2, below 1.3) and ^1.2 (at
+// least 1.2, below 2.0).
+func parseVersionRange(s string) (versionRange, error) {
+	var r versionRange
+	for _, alt := range strings.Split(s, "||") {
+		var set []comparator
+		fields := strings.Fields(alt)
+		for i := 0; i < len(fields); i++ {
+			f := fields[i]
+			op := strings.TrimRight(f, "0123456789.v-+abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+			if op == f && i+1 < len(fields) {
+				// An operator separated from its version.
+				i++
+				f += fields[i]
+			}
+			spec := f[len(op):]
+			v, ok := parseVersion(spec)
+			if !ok {
+				return nil, fmt.Errorf("invalid version range %q: invalid version %q", s, spec)
+			}
+			switch op {
+			case "", "=", "!=", "<", "<=", ">", ">=":
+				if op == "" {
+					op = "="
+				}
+				set = append(set, comparator{op, v})
+			case "~", "^":
+				set = append(set, comparator{">=", v}, comparator{"<", upperBound(op, spec, v)})
+			default:
+				return nil, fmt.Errorf("invalid version range %q: unknown operator %q", s, op)
+			}
+		}
+		if len(set) == 0 {
+			return nil, fmt.Errorf("invalid version range %q: empty alternative", s)
+		}
+		r = append(r, set)
+	}
+	return r, nil
+}
+
+// upperBound returns the exclusive upper bound of the tilde or caret range
+// of v, written spec.
+func upperBound(op, spec string, v version) version {
+	spec = strings.TrimPrefix(spec, "v")
+	if i := strings.IndexAny(spec, "-+"); i >= 0 {
+		spec = spec[:i]
+	}
+	parts := strings.Count(spec, ".") + 1
+	switch {
+	case op == "~" && parts == 1, op == "^" && (v.major > 0 || parts == 1):
+		return version{major: v.major + 1}
+	case op == "~", v.minor > 0 || parts == 2:
+		return version{major: v.major, minor: v.minor + 1}
+	}
+	return version{major: v.major, minor: v.minor, patch: v.patch + 1}
+}
+
+// contains reports whether v is in the range. Pre-releases only match the
+// alternatives with a comparator on a pre-release of the same version, so
+// <2.0 does not include 2.0.0-rc.1.
+func (r versionRange) contains(v version) bool {
+	for _, set := range r {
+		ok, pre := true, len(v.pre) == 0
+		for _, c := range set {
+			if !c.matches(v) {
+				ok = false
+				break
+			}
+			if len(c.v.pre) > 0 && c.v.major == v.major && c.v.minor == v.minor && c.v.patch == v.patch {
+				pre = true
+			}
+		}
+		if ok && pre {
+			return true
+		}
+	}
+	return false
+}
+
+func (c comparator) matches(v version) bool {
+	d := v.compare(c.v)
+	switch c.op {
+	case "=":
+		return d == 0
+	case "!=":
+		return d != 0
+	case "<":
+		return d < 0
+	case "<=":
+		return d <= 0
+	case ">":
+		return d > 0
+	}
+	return d >= 0
+}
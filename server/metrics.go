@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/caeret/registry"
+)
+
+// readyTimeout bounds the registry check of /readyz, below the default
+// timeout of Kubernetes probes.
+const readyTimeout = 3 * time.Second
+
+// Outcomes of clean runs, as labelled in the metrics.
+const (
+	outcomeSuccess   = "success"
+	outcomeFailure   = "failure"
+	outcomeCancelled = "cancelled"
+)
+
+// metrics are the counters of the server since it started.
+type metrics struct {
+	runs        map[string]int
+	deleted     int
+	reclaimable int64
+	events      int
+	lastSuccess time.Time
+	lastRun     time.Duration
+}
+
+// record counts the clean run that ended with result and err.
+func (m *metrics) record(result *registry.CleanResult, err error) {
+	outcome := outcomeSuccess
+	switch {
+	case result != nil && result.Cancelled:
+		outcome = outcomeCancelled
+	case err != nil:
+		outcome = outcomeFailure
+	}
+	if m.runs == nil {
+		m.runs = make(map[string]int)
+	}
+	m.runs[outcome]++
+	if result == nil {
+		return
+	}
+	m.deleted += len(result.Deleted)
+	m.reclaimable += result.Reclaimable
+	m.lastRun = result.Finished.Sub(result.Started)
+	if outcome == outcomeSuccess {
+		m.lastSuccess = result.Finished
+	}
+}
+
+// handleHealth reports that the server is up, whatever the state of the
+// registry.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// handleReady reports whether the registry can be reached, so traffic and
+// notifications only go to servers able to act on them.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	if err := s.client.Ping(ctx); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// handleMetrics writes the metrics in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	m, running, pending := s.metrics, s.running, len(s.pending)
+	runs := make(map[string]int)
+	for outcome, n := range m.runs {
+		runs[outcome] = n
+	}
+	s.mu.Unlock()
+
+	var b bytes.Buffer
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("registryctl_clean_runs_total", "counter", "Clean runs by outcome.")
+	for _, outcome := range []string{outcomeSuccess, outcomeFailure, outcomeCancelled} {
+		fmt.Fprintf(&b, "registryctl_clean_runs_total{outcome=%q} %d\n", outcome, runs[outcome])
+	}
+	metric("registryctl_clean_running", "gauge", "Whether a clean is running.")
+	fmt.Fprintf(&b, "registryctl_clean_running %d\n", boolValue(running))
+	metric("registryctl_clean_deleted_images_total", "counter", "Images deleted by clean runs.")
+	fmt.Fprintf(&b, "registryctl_clean_deleted_images_total %d\n", m.deleted)
+	metric("registryctl_clean_reclaimable_bytes_total", "counter", "Estimated bytes the deleted images leave unreferenced.")
+	fmt.Fprintf(&b, "registryctl_clean_reclaimable_bytes_total %d\n", m.reclaimable)
+	metric("registryctl_clean_last_duration_seconds", "gauge", "Duration of the last clean run.")
+	fmt.Fprintf(&b, "registryctl_clean_last_duration_seconds %g\n", m.lastRun.Seconds())
+	metric("registryctl_clean_last_success_timestamp_seconds", "gauge", "Time the last successful clean run finished.")
+	var last int64
+	if !m.lastSuccess.IsZero() {
+		last = m.lastSuccess.Unix()
+	}
+	fmt.Fprintf(&b, "registryctl_clean_last_success_timestamp_seconds %d\n", last)
+	metric("registryctl_events_total", "counter", "Registry notifications received.")
+	fmt.Fprintf(&b, "registryctl_events_total %d\n", m.events)
+	metric("registryctl_pending_repositories", "gauge", "Repositories pushed to waiting for a clean.")
+	fmt.Fprintf(&b, "registryctl_pending_repositories %d\n", pending)
+
+	breakers := s.client.Breakers()
+	if len(breakers) > 0 {
+		var endpoints []string
+		for endpoint := range breakers {
+			endpoints = append(endpoints, endpoint)
+		}
+		sort.Strings(endpoints)
+		metric("registryctl_circuit_breaker_open", "gauge", "Whether the circuit breaker of the endpoint is open.")
+		for _, endpoint := range endpoints {
+			fmt.Fprintf(&b, "registryctl_circuit_breaker_open{endpoint=%q} %d\n", endpoint, boolValue(breakers[endpoint].State == registry.BreakerOpen))
+		}
+		metric("registryctl_circuit_breaker_trips_total", "counter", "Times the circuit breaker of the endpoint opened.")
+		for _, endpoint := range endpoints {
+			fmt.Fprintf(&b, "registryctl_circuit_breaker_trips_total{endpoint=%q} %d\n", endpoint, breakers[endpoint].Trips)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(b.Bytes())
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
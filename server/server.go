@@ -0,0 +1,359 @@
+// Package server exposes registry inspection and cleaning over HTTP.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/caeret/registry"
+)
+
+// Server serves the REST API. Every request must carry the configured token
+// as a bearer token, except for the probes and metrics:
+//
+//	GET    /healthz                               whether the server is up
+//	GET    /readyz                                whether the registry can be reached
+//	GET    /metrics                               metrics in the Prometheus text format
+//
+//	GET    /v1/repositories                       list repositories
+//	GET    /v1/tags?repository=<repo>             list tags
+//	GET    /v1/inspect?repository=<repo>&ref=<r>  inspect a tag or digest
+//	POST   /v1/clean                              run Clean with the JSON policy in the body
+//	POST   /v1/clean?repository=<repo>            run it on repo only, may be repeated
+//	DELETE /v1/clean                              cancel the running Clean
+//	POST   /v1/events                             clean the repositories of push notifications
+//	GET    /v1/reports/last                       result of the last Clean run
+//
+// Without a body, POST /v1/clean runs the policy given to SetPolicy, which
+// is also run on the repositories registry notifications report pushes to.
+// Repositories pushed to while a clean runs are cleaned once it completes.
+// Shutdown stops the running clean; with a checkpoint, Resume starts it
+// again after a restart.
+type Server struct {
+	client *registry.Client
+	token  string
+	mux    *http.ServeMux
+	public *http.ServeMux
+
+	mu      sync.Mutex
+	running bool
+	cancel  context.CancelFunc
+	last    *report
+	policy  *registry.Policy
+	pending map[string]bool
+	metrics metrics
+	// done is closed when the running clean ends, and new runs are refused
+	// once closing is set.
+	done       chan struct{}
+	closing    bool
+	checkpoint *registry.Checkpoint
+}
+
+type report struct {
+	Policy registry.Policy       `json:"policy"`
+	Result *registry.CleanResult `json:"result,omitempty"`
+	Error  string                `json:"error,omitempty"`
+	// Repositories are the repositories cleaned, all of them when empty.
+	Repositories []string `json:"repositories,omitempty"`
+	// RequestID is the correlation ID of the requests of the run.
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// New returns a server operating on client, accepting requests carrying
+// token.
+func New(client *registry.Client, token string) *Server {
+	s := &Server{client: client, token: token, mux: http.NewServeMux(), public: http.NewServeMux(), pending: make(map[string]bool)}
+	s.public.HandleFunc("/healthz", s.get(s.handleHealth))
+	s.public.HandleFunc("/readyz", s.get(s.handleReady))
+	s.public.HandleFunc("/metrics", s.get(s.handleMetrics))
+	s.mux.HandleFunc("/v1/repositories", s.get(s.handleRepositories))
+	s.mux.HandleFunc("/v1/tags", s.get(s.handleTags))
+	s.mux.HandleFunc("/v1/inspect", s.get(s.handleInspect))
+	s.mux.HandleFunc("/v1/clean", s.handleClean)
+	s.mux.HandleFunc("/v1/events", s.handleEvents)
+	s.mux.HandleFunc("/v1/reports/last", s.get(s.handleLastReport))
+	return s
+}
+
+// SetPolicy sets the policy run on the repositories pushed to, and by
+// POST /v1/clean without a body.
+func (s *Server) SetPolicy(policy registry.Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = &policy
+	s.startPending()
+}
+
+// SetCheckpoint makes the clean runs of the server record their progress in
+// cp, so that a run interrupted by a shutdown can be resumed.
+func (s *Server) SetCheckpoint(cp *registry.Checkpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoint = cp
+}
+
+// Resume starts again, on every repository, the clean the checkpoint records
+// as interrupted, with the policy given to SetPolicy. It reports whether a
+// clean was started.
+func (s *Server) Resume() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkpoint == nil || !s.checkpoint.Pending() || s.policy == nil || s.running || s.closing {
+		return false
+	}
+	s.client.Info("resume clean.")
+	s.start(*s.policy, nil, "")
+	return true
+}
+
+// Shutdown refuses new clean runs, cancels the running one and waits until
+// it stopped, recording its progress in the checkpoint, or until ctx ends.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	done := s.done
+	if s.running {
+		s.cancel()
+	}
+	s.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := s.public.Handler(r); pattern != "" {
+		s.public.ServeHTTP(w, r)
+		return
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(s.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) get(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) handleRepositories(w http.ResponseWriter, r *http.Request) {
+	repos, err := s.client.QueryRepositories()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"repositories": repos})
+}
+
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	repo := r.URL.Query().Get("repository")
+	if repo == "" {
+		writeError(w, http.StatusBadRequest, "no repository given")
+		return
+	}
+	tags, err := s.client.QueryTags(repo)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"repository": repo, "tags": tags})
+}
+
+func (s *Server) handleInspect(w http.ResponseWriter, r *http.Request) {
+	repo, ref := r.URL.Query().Get("repository"), r.URL.Query().Get("ref")
+	if repo == "" || ref == "" {
+		writeError(w, http.StatusBadRequest, "repository and ref are required")
+		return
+	}
+	info, err := s.client.Inspect(r.Context(), repo, ref)
+	if err == registry.ErrNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) handleClean(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.handleCancel(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var policy registry.Policy
+	err := json.NewDecoder(r.Body).Decode(&policy)
+	if err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == io.EOF {
+		if s.policy == nil {
+			writeError(w, http.StatusBadRequest, "no policy given")
+			return
+		}
+		policy = *s.policy
+	}
+	if s.closing {
+		writeError(w, http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+	if s.running {
+		writeError(w, http.StatusConflict, "clean already running")
+		return
+	}
+	id := s.start(policy, r.URL.Query()["repository"], r.Header.Get(registry.RequestIDHeader))
+	w.Header().Set(registry.RequestIDHeader, id)
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "started", "requestId": id})
+}
+
+// handleEvents cleans the repositories registry notifications report pushes
+// to. Notifications are always accepted, lest the registry retry them, and
+// ignored without a policy.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	repos, err := s.client.PushedRepositories(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics.events++
+	if s.policy == nil {
+		if len(repos) > 0 {
+			s.client.Warn("ignore pushes without policy.", "repos", repos)
+		}
+		repos = nil
+	}
+	for _, repo := range repos {
+		s.pending[repo] = true
+	}
+	s.startPending()
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"queued": repos})
+}
+
+// handleCancel cancels the running clean, which stops once the deletions in
+// flight complete and reports what it deleted.
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		writeError(w, http.StatusConflict, "no clean running")
+		return
+	}
+	s.cancel()
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "cancelling"})
+}
+
+// start runs policy on repos, all repositories when empty, with the
+// correlation ID id, or a new one it returns when empty. s.mu must be held.
+func (s *Server) start(policy registry.Policy, repos []string, id string) string {
+	if id == "" {
+		id = registry.NewRequestID()
+	}
+	s.running = true
+	ctx, cancel := context.WithCancel(registry.ContextWithRequestID(context.Background(), id))
+	s.cancel, s.done = cancel, make(chan struct{})
+	policy.Checkpoint = s.checkpoint
+	go s.clean(ctx, policy, repos)
+	return id
+}
+
+// startPending starts cleaning the repositories pushed to unless a clean is
+// running. s.mu must be held.
+func (s *Server) startPending() {
+	if s.running || s.closing || s.policy == nil || len(s.pending) == 0 {
+		return
+	}
+	var repos []string
+	for repo := range s.pending {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	s.pending = make(map[string]bool)
+	s.client.Info("clean pushed repositories.", "repos", repos)
+	s.start(*s.policy, repos, "")
+}
+
+func (s *Server) clean(ctx context.Context, policy registry.Policy, repos []string) {
+	var result *registry.CleanResult
+	var err error
+	if len(repos) > 0 {
+		result, err = s.client.CleanRepositories(ctx, policy, repos...)
+	} else {
+		result, err = s.client.CleanWithPolicy(ctx, policy)
+	}
+	last := &report{Policy: policy, Repositories: repos, Result: result, RequestID: registry.RequestIDFromContext(ctx)}
+	if err != nil {
+		s.client.Error("fail to clean images.", "error", err)
+		last.Error = err.Error()
+	}
+	s.mu.Lock()
+	s.running = false
+	s.cancel()
+	s.last = last
+	s.metrics.record(result, err)
+	close(s.done)
+	s.startPending()
+	s.mu.Unlock()
+}
+
+func (s *Server) handleLastReport(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	last, running := s.last, s.running
+	s.mu.Unlock()
+	if last == nil {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"running": running, "error": "no clean run yet"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"running": running, "report": last})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]interface{}{"error": msg})
+}
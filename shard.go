@@ -0,0 +1,122 @@
+package registry
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// Shard is the part of the repositories one of several instances works on,
+// so that they split the inventory and cleanup of a very large registry.
+// Repositories are split by a hash of their name, Index of Count, by a range
+// of the catalog, or both.
+type Shard struct {
+	Index int          `json:"index,omitempty"`
+	Count int          `json:"count,omitempty"`
+	Range CatalogRange `json:"range,omitempty"`
+}
+
+// ParseShard parses shards written as by Shard.String: "i/n" for the
+// repositories hashing to i of n, "from:to" for a range of the catalog with
+// either bound left empty, or both separated by a comma.
+func ParseShard(s string) (Shard, error) {
+	var shard Shard
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if i := strings.IndexByte(part, ':'); i >= 0 {
+			shard.Range = CatalogRange{From: part[:i], To: part[i+1:]}
+			continue
+		}
+		parts := strings.SplitN(part, "/", 2)
+		if len(parts) != 2 {
+			return Shard{}, fmt.Errorf("invalid shard %q", s)
+		}
+		index, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return Shard{}, fmt.Errorf("invalid shard %q", s)
+		}
+		count, err := strconv.Atoi(parts[1])
+		if err != nil || count <= 0 {
+			return Shard{}, fmt.Errorf("invalid shard %q", s)
+		}
+		shard.Index, shard.Count = index, count
+	}
+	if err := shard.validate(); err != nil {
+		return Shard{}, fmt.Errorf("invalid shard %q: %v", s, err)
+	}
+	return shard, nil
+}
+
+func (s Shard) validate() error {
+	if s.Count < 0 || s.Count > 0 && (s.Index < 0 || s.Index >= s.Count) {
+		return fmt.Errorf("index %d out of %d", s.Index, s.Count)
+	}
+	if s.Range.To != "" && s.Range.From >= s.Range.To {
+		return fmt.Errorf("empty range %s:%s", s.Range.From, s.Range.To)
+	}
+	return nil
+}
+
+// String returns the shard as parsed by ParseShard.
+func (s Shard) String() string {
+	var parts []string
+	if s.Range != (CatalogRange{}) {
+		parts = append(parts, s.Range.From+":"+s.Range.To)
+	}
+	if s.Count > 0 {
+		parts = append(parts, fmt.Sprintf("%d/%d", s.Index, s.Count))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Contains reports whether the repository repo, named as by the client, is
+// in the shard. The hash of names does not depend on the instance, so
+// instances configured with the shards 0/n to n-1/n each get a distinct part
+// of the repositories and together all of them.
+func (s Shard) Contains(repo string) bool {
+	if !s.Range.Contains(repo) {
+		return false
+	}
+	if s.Count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(repo))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index
+}
+
+// WithShard restricts the client to the repositories of the shard s. The
+// repositories listed, by QueryRepositories, SearchRepositories,
+// WalkCatalog and PushedRepositories, are those of the shard, so every run
+// going through the catalog, such as Clean, Mirror or Inventory, only works
+// on them. Ranges of the catalog are listed alone. Repositories named
+// explicitly are not checked.
+func WithShard(s Shard) Option {
+	return func(c *Client) {
+		c.shard = &s
+	}
+}
+
+// inShard reports whether repo is in the shard of the client.
+func (c *Client) inShard(repo string) bool {
+	return c.shard == nil || c.shard.Contains(repo)
+}
+
+// shardRange returns the range of the catalog of the shard of the client.
+func (c *Client) shardRange() CatalogRange {
+	if c.shard == nil {
+		return CatalogRange{}
+	}
+	return c.shard.Range
+}
+
+// checkpointScope identifies the repositories of the registry the client
+// works on in the jobs of checkpoints.
+func (c *Client) checkpointScope() string {
+	scope := c.host() + "/" + c.prefix
+	if c.shard != nil {
+		scope += " " + c.shard.String()
+	}
+	return scope
+}
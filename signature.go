@@ -0,0 +1,393 @@
+package registry
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+
+	ArtifactTypeCosignSignature = "application/vnd.dev.cosign.artifact.sig.v1+json"
+)
+
+var (
+	// oidIssuer and oidIssuerV2 are the Fulcio certificate extensions
+	// carrying the OIDC issuer of the signing identity.
+	oidIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+
+	attachmentTag = regexp.MustCompile(`^(sha256-[a-f0-9]{64}|sha512-[a-f0-9]{128})(\.sig|\.att|\.sbom)?$`)
+)
+
+// SignatureVerifier checks a cosign signature over its payload. The
+// annotations of the signature layer are passed along so verifiers can use
+// embedded certificates.
+type SignatureVerifier interface {
+	Verify(payload, signature []byte, annotations map[string]string) error
+}
+
+// Signature is a cosign signature attached to an image.
+type Signature struct {
+	// Manifest is the digest of the manifest carrying the signature.
+	Manifest    string
+	Payload     []byte
+	Signature   []byte
+	Annotations map[string]string
+}
+
+type publicKeyVerifier struct {
+	key crypto.PublicKey
+}
+
+// NewPublicKeyVerifier returns a verifier accepting signatures made with the
+// private key of the PEM encoded public key, as produced by cosign
+// generate-key-pair.
+func NewPublicKeyVerifier(pemKey []byte) (SignatureVerifier, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &publicKeyVerifier{key: key}, nil
+}
+
+func (v *publicKeyVerifier) Verify(payload, signature []byte, annotations map[string]string) error {
+	return verifyWithKey(v.key, payload, signature)
+}
+
+// KeylessVerifier accepts keyless signatures whose Fulcio certificate was
+// issued to Identity (an email address or URI) by the OIDC Issuer and chains
+// up to one of Roots. When Rekor is set the signature must be recorded in the
+// transparency log while the certificate was valid.
+type KeylessVerifier struct {
+	Identity string
+	Issuer   string
+	// Roots are the Fulcio CA certificates. They are required: anyone can
+	// make a certificate naming Identity, and the transparency log records
+	// signatures of any certificate.
+	Roots *x509.CertPool
+	Rekor *RekorLog
+}
+
+func (v *KeylessVerifier) Verify(payload, signature []byte, annotations map[string]string) error {
+	_, err := v.VerifyWithRekor(context.Background(), payload, signature, annotations)
+	return err
+}
+
+func (v *KeylessVerifier) VerifyWithRekor(ctx context.Context, payload, signature []byte, annotations map[string]string) (*RekorEntry, error) {
+	block, _ := pem.Decode([]byte(annotations[cosignCertificateAnnotation]))
+	if block == nil {
+		return nil, errors.New("no signing certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if v.Roots == nil {
+		return nil, errors.New("no Fulcio roots to verify the signing certificate")
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM([]byte(annotations[cosignChainAnnotation]))
+	// Fulcio certificates are only valid for minutes, check the chain at the
+	// time the certificate was issued.
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         v.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   cert.NotBefore,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !certHasIdentity(cert, v.Identity) {
+		return nil, fmt.Errorf("certificate not issued to %s", v.Identity)
+	}
+	if v.Issuer != "" && certIssuer(cert) != v.Issuer {
+		return nil, fmt.Errorf("certificate not issued by %s", v.Issuer)
+	}
+	if err := verifyWithKey(cert.PublicKey, payload, signature); err != nil {
+		return nil, err
+	}
+	if v.Rekor == nil {
+		return nil, nil
+	}
+	return v.Rekor.Entry(ctx, payload, signature, cert, annotations)
+}
+
+func certHasIdentity(cert *x509.Certificate, identity string) bool {
+	for _, email := range cert.EmailAddresses {
+		if email == identity {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if uri.String() == identity {
+			return true
+		}
+	}
+	return false
+}
+
+func certIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuerV2) {
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		}
+		if ext.Id.Equal(oidIssuer) {
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+func verifyWithKey(key crypto.PublicKey, payload, signature []byte) error {
+	digest := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// Signatures returns the cosign signatures attached to the manifest
+// identified by digest, looking at both the ".sig" tag and referrers.
+func (c *Client) Signatures(ctx context.Context, repo, digest string) ([]Signature, error) {
+	var manifests []string
+	if _, desc, err := c.getManifest(ctx, c.repoName(repo), strings.Replace(digest, ":", "-", 1)+".sig"); err == nil {
+		manifests = append(manifests, desc.Digest)
+	} else if err != ErrNotFound {
+		return nil, err
+	}
+	referrers, err := c.Referrers(ctx, repo, digest, ArtifactTypeCosignSignature)
+	if err != nil {
+		return nil, err
+	}
+	for _, referrer := range referrers {
+		manifests = append(manifests, referrer.Digest)
+	}
+
+	var signatures []Signature
+	for _, m := range manifests {
+		body, _, err := c.getManifest(ctx, c.repoName(repo), m)
+		if err != nil {
+			return nil, err
+		}
+		var manifest Manifest
+		if err := unmarshalJSON(body, &manifest); err != nil {
+			return nil, err
+		}
+		for _, layer := range manifest.Layers {
+			sig, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+			if err != nil || len(sig) == 0 {
+				continue
+			}
+			r, _, err := c.openBlob(ctx, c.repoName(repo), layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+			payload, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+			signatures = append(signatures, Signature{Manifest: m, Payload: payload, Signature: sig, Annotations: layer.Annotations})
+		}
+	}
+	return signatures, nil
+}
+
+// signed reports whether the manifest identified by digest carries a
+// signature. With a verifier, only signatures it accepts for this very
+// manifest count.
+func (c *Client) signed(ctx context.Context, repo, digest string, verifier SignatureVerifier) (bool, error) {
+	if verifier == nil {
+		signatures, err := c.Signatures(ctx, repo, digest)
+		return len(signatures) > 0, err
+	}
+	result, err := c.verifySignatures(ctx, repo, digest, verifier)
+	if err != nil {
+		return false, err
+	}
+	for _, sig := range result.Signatures {
+		if !sig.Verified {
+			c.Debug("reject signature.", "repo", repo, "digest", digest, "manifest", sig.Manifest, "error", sig.Error)
+		}
+	}
+	return result.Verified, nil
+}
+
+// The formats of signatures.
+const (
+	SignatureFormatCosign   = "cosign"
+	SignatureFormatNotation = "notation"
+)
+
+// VerificationResult is the outcome of VerifySignature.
+type VerificationResult struct {
+	Repository string `json:"repository"`
+	Digest     string `json:"digest"`
+	// Verified is set when at least one signature was accepted.
+	Verified   bool                    `json:"verified"`
+	Signatures []SignatureVerification `json:"signatures"`
+}
+
+// SignatureVerification is the outcome of the verification of a signature.
+type SignatureVerification struct {
+	Format string `json:"format"`
+	// Manifest is the digest of the manifest carrying the signature.
+	Manifest string `json:"manifest"`
+	// Identity is the subject of the signing certificate, when there is
+	// one.
+	Identity string `json:"identity,omitempty"`
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+	// Rekor is the transparency log entry of the signature, when the
+	// verifier checks the log.
+	Rekor *RekorEntry `json:"rekor,omitempty"`
+}
+
+// VerifySignature checks the signatures of the image repo:ref with verifier.
+// Cosign signatures are checked by its Verify method, or VerifyWithRekor for
+// a RekorEntryVerifier such as KeylessVerifier; when verifier is also a
+// NotationSignatureVerifier, such as NotationVerifier, the Notation
+// signatures among the referrers of the image are checked as well. Rejected
+// signatures are listed in the result along with the reason, only failures
+// to read the signatures are returned as errors.
+func (c *Client) VerifySignature(ctx context.Context, repo, ref string, verifier SignatureVerifier) (*VerificationResult, error) {
+	desc, err := c.resolve(ctx, c.repoName(repo), ref)
+	if err != nil {
+		return nil, err
+	}
+	return c.verifySignatures(ctx, repo, desc.Digest, verifier)
+}
+
+func (c *Client) verifySignatures(ctx context.Context, repo, digest string, verifier SignatureVerifier) (*VerificationResult, error) {
+	result := &VerificationResult{Repository: repo, Digest: digest, Signatures: []SignatureVerification{}}
+	add := func(v SignatureVerification, err error) {
+		if err != nil {
+			v.Error = err.Error()
+		} else {
+			v.Verified = true
+			result.Verified = true
+		}
+		result.Signatures = append(result.Signatures, v)
+	}
+
+	signatures, err := c.Signatures(ctx, repo, digest)
+	if err != nil {
+		return nil, err
+	}
+	for _, sig := range signatures {
+		v := SignatureVerification{Format: SignatureFormatCosign, Manifest: sig.Manifest, Identity: certIdentity(sig.Annotations[cosignCertificateAnnotation])}
+		var payload simpleSigning
+		if err := unmarshalJSON(sig.Payload, &payload); err != nil {
+			add(v, fmt.Errorf("invalid payload: %v", err))
+			continue
+		}
+		if signed := payload.Critical.Image.DockerManifestDigest; signed != digest {
+			add(v, fmt.Errorf("signature of %s", signed))
+			continue
+		}
+		var err error
+		if rv, ok := verifier.(RekorEntryVerifier); ok {
+			v.Rekor, err = rv.VerifyWithRekor(ctx, sig.Payload, sig.Signature, sig.Annotations)
+		} else {
+			err = verifier.Verify(sig.Payload, sig.Signature, sig.Annotations)
+		}
+		if err == errNotationOnly {
+			continue
+		}
+		add(v, err)
+	}
+
+	notation, ok := verifier.(NotationSignatureVerifier)
+	if !ok {
+		return result, nil
+	}
+	name := c.repoName(repo)
+	referrers, err := c.Referrers(ctx, repo, digest, ArtifactTypeNotation)
+	if err != nil {
+		return nil, err
+	}
+	for _, referrer := range referrers {
+		body, _, err := c.getManifest(ctx, name, referrer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		var manifest Manifest
+		if err := unmarshalJSON(body, &manifest); err != nil {
+			return nil, err
+		}
+		v := SignatureVerification{Format: SignatureFormatNotation, Manifest: referrer.Digest}
+		if len(manifest.Layers) != 1 {
+			add(v, fmt.Errorf("%d signature envelopes", len(manifest.Layers)))
+			continue
+		}
+		r, _, err := c.openBlob(ctx, name, manifest.Layers[0].Digest)
+		if err != nil {
+			return nil, err
+		}
+		envelope, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		v.Identity, err = notation.VerifyNotation(c.host()+"/"+name, digest, manifest.Layers[0].MediaType, envelope)
+		add(v, err)
+	}
+	return result, nil
+}
+
+// certIdentity returns the email address or URI of the PEM encoded
+// certificate of a keyless signature.
+func certIdentity(certificate string) string {
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return ""
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return ""
+}
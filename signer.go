@@ -0,0 +1,290 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Signer signs the payloads of cosign signatures, such as with a private key
+// or a key management service.
+type Signer interface {
+	Sign(ctx context.Context, payload []byte) ([]byte, error)
+}
+
+type privateKeySigner struct {
+	key crypto.Signer
+}
+
+// NewPrivateKeySigner returns a signer using the PEM encoded ECDSA, RSA or
+// Ed25519 private key, in PKCS #8, SEC 1 or PKCS #1 form. Encrypted cosign
+// keys are not supported; use an unencrypted key or a KMS signer.
+func NewPrivateKeySigner(pemKey []byte) (Signer, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported private key %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return &privateKeySigner{key: signer}, nil
+}
+
+func (s *privateKeySigner) Sign(ctx context.Context, payload []byte) ([]byte, error) {
+	digest := sha256.Sum256(payload)
+	switch k := s.key.(type) {
+	case *ecdsa.PrivateKey:
+		return ecdsa.SignASN1(rand.Reader, k, digest[:])
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, payload), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", k)
+	}
+}
+
+// VaultSigner signs with a key of the transit secrets engine of HashiCorp
+// Vault, as cosign does for hashivault:// keys.
+type VaultSigner struct {
+	// Address is the URL of Vault, like https://vault.example.com:8200.
+	Address string
+	Token   string
+	// Mount is the path of the transit engine, "transit" when empty.
+	Mount string
+	Key   string
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+func (s *VaultSigner) Sign(ctx context.Context, payload []byte) ([]byte, error) {
+	mount := s.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	body, err := marshalJSON(map[string]string{
+		"input":                base64.StdEncoding.EncodeToString(payload),
+		"marshaling_algorithm": "asn1",
+		"signature_algorithm":  "pkcs1v15",
+	})
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("%s/v1/%s/sign/%s/sha2-256", strings.TrimSuffix(s.Address, "/"), mount, s.Key)
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", s.Token)
+	req.Header.Set("Content-Type", "application/json")
+	b, err := callService(ctx, s.Client, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "vault")
+	}
+	var resp struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+	if err := unmarshalJSON(b, &resp); err != nil {
+		return nil, errors.Wrap(err, "vault")
+	}
+	sig := resp.Data.Signature
+	parts := strings.Split(sig, ":")
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, fmt.Errorf("vault: invalid signature %q", sig)
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
+
+// AWSKMSSigner signs with an asymmetric key of AWS KMS, as cosign does for
+// awskms:// keys.
+type AWSKMSSigner struct {
+	// Endpoint is the URL of the service, https://kms.<region>.amazonaws.com
+	// when empty.
+	Endpoint string
+	Region   string
+	// KeyID is the ID, ARN or alias of the key, like alias/cosign.
+	KeyID     string
+	AccessKey string
+	SecretKey string
+	// Algorithm is the signing algorithm matching the key, ECDSA_SHA_256
+	// when empty.
+	Algorithm string
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+func (s *AWSKMSSigner) Sign(ctx context.Context, payload []byte) ([]byte, error) {
+	algorithm := s.Algorithm
+	if algorithm == "" {
+		algorithm = "ECDSA_SHA_256"
+	}
+	digest := sha256.Sum256(payload)
+	body, err := marshalJSON(map[string]string{
+		"KeyId":            s.KeyID,
+		"Message":          base64.StdEncoding.EncodeToString(digest[:]),
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": algorithm,
+	})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://kms." + s.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Sign")
+	hash := sha256.Sum256(body)
+	signAWS(req, hex.EncodeToString(hash[:]), s.Region, "kms", s.AccessKey, s.SecretKey, time.Now().UTC())
+	b, err := callService(ctx, s.Client, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "kms")
+	}
+	var resp struct {
+		Signature string
+	}
+	if err := unmarshalJSON(b, &resp); err != nil {
+		return nil, errors.Wrap(err, "kms")
+	}
+	return base64.StdEncoding.DecodeString(resp.Signature)
+}
+
+// signRequest sends a request of a KMS and returns the response body.
+func callService(ctx context.Context, client *http.Client, req *http.Request) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, redactError(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invalid response %d:%s", resp.StatusCode, Redact(string(b)))
+	}
+	return b, nil
+}
+
+// simpleSigning is the payload of cosign signatures.
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+// SignImage signs the manifest digest of repo with signer and attaches the
+// signature under the sha256-<hex>.sig tag, next to the signatures already
+// there, as cosign sign does.
+func (c *Client) SignImage(ctx context.Context, repo, digest string, signer Signer) error {
+	if err := ValidateDigest(digest); err != nil {
+		return err
+	}
+	name := c.repoName(repo)
+	var p simpleSigning
+	p.Critical.Identity.DockerReference = c.host() + "/" + name
+	p.Critical.Image.DockerManifestDigest = digest
+	p.Critical.Type = "cosign container image signature"
+	payload, err := marshalJSON(p)
+	if err != nil {
+		return err
+	}
+	sig, err := signer.Sign(ctx, payload)
+	if err != nil {
+		return errors.Wrap(err, "sign")
+	}
+	layer, err := c.pushArtifactBlob(ctx, name, mediaTypeCosignSimpleSign, payload, map[string]string{
+		cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig),
+	})
+	if err != nil {
+		return errors.Wrap(err, "push signature")
+	}
+
+	tag := strings.Replace(digest, ":", "-", 1) + ".sig"
+	manifest := Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest}
+	body, _, err := c.getManifest(ctx, name, tag)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if err == nil {
+		if err := unmarshalJSON(body, &manifest); err != nil {
+			return err
+		}
+	}
+	manifest.Layers = append(manifest.Layers, layer)
+	var diffIDs []string
+	for _, l := range manifest.Layers {
+		diffIDs = append(diffIDs, l.Digest)
+	}
+	config, err := marshalJSON(map[string]interface{}{
+		"architecture": "",
+		"os":           "",
+		"config":       map[string]interface{}{},
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+	})
+	if err != nil {
+		return err
+	}
+	if manifest.Config, err = c.pushArtifactBlob(ctx, name, mediaTypeOCIConfig, config, nil); err != nil {
+		return errors.Wrap(err, "push config")
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = MediaTypeOCIManifest
+	}
+	if body, err = marshalJSON(manifest); err != nil {
+		return err
+	}
+	if _, err := c.putManifest(ctx, name, tag, manifest.MediaType, body); err != nil {
+		return errors.Wrap(err, "push signature manifest")
+	}
+	c.Info("sign image.", "repo", repo, "digest", digest)
+	return nil
+}
@@ -0,0 +1,316 @@
+package registry
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PolicyDecision is the verdict of a policy on an image.
+type PolicyDecision struct {
+	Repository string   `json:"repository"`
+	Digest     string   `json:"digest"`
+	Tags       []TagRef `json:"tags"`
+	Delete     bool     `json:"delete"`
+	// Rule names the rule the verdict comes from, such as "keepTags=^v"
+	// for a kept image, or the rules selecting a deleted one.
+	Rule string `json:"rule"`
+	// Reclaimable estimates the bytes the deletion of the image frees, as
+	// in DeletedImage.
+	Reclaimable int64 `json:"reclaimable,omitempty"`
+}
+
+// PolicySimulation is the plan of a policy for the images of a snapshot.
+type PolicySimulation struct {
+	Policy      string           `json:"policy,omitempty"`
+	Snapshot    time.Time        `json:"snapshot"`
+	Decisions   []PolicyDecision `json:"decisions"`
+	Deleted     int              `json:"deleted"`
+	Kept        int              `json:"kept"`
+	Reclaimable int64            `json:"reclaimable"`
+}
+
+// PolicyEvaluation is the verdict of a policy on the images of a registry.
+type PolicyEvaluation struct {
+	Policy    string           `json:"policy,omitempty"`
+	Time      time.Time        `json:"time"`
+	Decisions []PolicyDecision `json:"decisions"`
+	Deleted   int              `json:"deleted"`
+	Kept      int              `json:"kept"`
+	// Errors lists the images the policy could not be applied to.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// EvaluatePolicy returns the verdict of policy on every image of repos, all
+// repositories if none is given, as a Clean run would apply it now. Nothing
+// is deleted. Decisions are sorted by repository and digest.
+func (c *Client) EvaluatePolicy(ctx context.Context, policy Policy, repos ...string) (*PolicyEvaluation, error) {
+	if len(repos) == 0 {
+		var err error
+		if repos, err = c.queryRepositories(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if policy.Quarantine != "" {
+		var filtered []string
+		for _, repo := range repos {
+			if repo != policy.Quarantine {
+				filtered = append(filtered, repo)
+			}
+		}
+		repos = filtered
+	}
+	// Evaluations judge every image, changed or not.
+	policy.Incremental = false
+	run, err := c.newPolicyRun(ctx, policy, repos)
+	if err != nil {
+		return nil, err
+	}
+
+	eval := &PolicyEvaluation{Policy: policy.Name, Time: time.Now()}
+	var mu sync.Mutex
+	digests := make(chan string)
+	var wg sync.WaitGroup
+	workers := policy.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for digest := range digests {
+				v := run.tags[digest]
+				d := PolicyDecision{Repository: v[0].Repository, Digest: digest, Tags: v}
+				if v[0].Tag == "" {
+					d.Tags = nil
+				}
+				rule, err := c.keptBy(ctx, run, digest)
+				mu.Lock()
+				switch {
+				case err != nil:
+					eval.Errors = append(eval.Errors, err.Error())
+				case rule == "":
+					d.Delete = true
+					d.Rule = strings.Join(policy.rules(), ",")
+					eval.Decisions = append(eval.Decisions, d)
+					eval.Deleted++
+				default:
+					d.Rule = rule
+					eval.Decisions = append(eval.Decisions, d)
+					eval.Kept++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for digest := range run.tags {
+		digests <- digest
+	}
+	close(digests)
+	wg.Wait()
+
+	sort.Slice(eval.Decisions, func(i, j int) bool {
+		a, b := eval.Decisions[i], eval.Decisions[j]
+		if a.Repository != b.Repository {
+			return a.Repository < b.Repository
+		}
+		return a.Digest < b.Digest
+	})
+	return eval, nil
+}
+
+// SimulatePolicy evaluates policy against the snapshot s without contacting
+// the registry, returning the images a Clean run would have deleted and
+// kept when the snapshot was taken. Ages are taken at the time of the
+// snapshot, and images are told signed by their signature tags. Policies
+// verifying signatures, scanning images or deleting untagged manifests need
+// the registry and cannot be simulated; the in-use listers of the policy are
+// queried.
+func SimulatePolicy(ctx context.Context, s *Snapshot, policy Policy) (*PolicySimulation, error) {
+	if policy.ProtectSigned != nil || (policy.Scanner != nil && policy.MinSeverity > SeverityUnknown) {
+		return nil, errors.New("policies verifying signatures or scanning images cannot be simulated")
+	}
+	if policy.UntaggedOnly {
+		return nil, errors.New("snapshots do not record untagged manifests")
+	}
+	protected, err := inUse(ctx, policy.Protect)
+	if err != nil {
+		return nil, err
+	}
+	var regs []*regexp.Regexp
+	for _, tag := range policy.KeepTags {
+		reg, err := regexp.Compile(tag)
+		if err != nil {
+			return nil, err
+		}
+		regs = append(regs, reg)
+	}
+	var versions versionRange
+	if policy.KeepVersions != "" {
+		if versions, err = parseVersionRange(policy.KeepVersions); err != nil {
+			return nil, err
+		}
+	}
+
+	// Group the tags by digest as Clean does, in the order of the snapshot.
+	var digests []string
+	m := make(map[string][]TagRef)
+	images := make(map[repoBlob]*SnapshotImage)
+	tags := make(map[TagRef]bool)
+	for i := range s.Repositories {
+		r := &s.Repositories[i]
+		if policy.Quarantine != "" && r.Repository == policy.Quarantine {
+			continue
+		}
+		if r.Tags < policy.MinTags {
+			continue
+		}
+		for j := range r.Images {
+			image := &r.Images[j]
+			images[repoBlob{r.Repository, image.Digest}] = image
+			for _, tag := range image.Tags {
+				tags[TagRef{r.Repository, tag}] = true
+				if policy.signatureAware() && attachmentTag.MatchString(tag) {
+					continue
+				}
+				if _, ok := m[image.Digest]; !ok {
+					digests = append(digests, image.Digest)
+				}
+				m[image.Digest] = append(m[image.Digest], TagRef{r.Repository, tag})
+			}
+		}
+	}
+	var charts map[string]bool
+	if policy.KeepLastCharts > 0 {
+		charts = snapshotCharts(m, images, policy.KeepLastCharts)
+	}
+
+	sim := &PolicySimulation{Policy: policy.Name, Snapshot: s.Time}
+	for _, digest := range digests {
+		v := m[digest]
+		d := PolicyDecision{Repository: v[0].Repository, Digest: digest, Tags: v}
+		d.Rule = simulateRules(s, policy, regs, versions, charts, protected, images[repoBlob{d.Repository, digest}], tags, v)
+		if d.Rule == "" {
+			d.Delete = true
+			d.Rule = strings.Join(policy.rules(), ",")
+			sim.Deleted++
+		} else {
+			sim.Kept++
+		}
+		sim.Decisions = append(sim.Decisions, d)
+	}
+	sim.estimateReclaimable(images)
+	return sim, nil
+}
+
+// simulateRules returns the rule keeping the image of the tags v, or an empty
+// string if the policy deletes it.
+func simulateRules(s *Snapshot, policy Policy, regs []*regexp.Regexp, versions versionRange, charts map[string]bool, protected []Reference, image *SnapshotImage, tags map[TagRef]bool, v []TagRef) string {
+	for _, e := range v {
+		for i, reg := range regs {
+			if reg.MatchString(e.Tag) {
+				return "keepTags=" + policy.KeepTags[i]
+			}
+		}
+		if v, ok := parseVersion(e.Tag); ok && versions != nil && versions.contains(v) {
+			return "keepVersions=" + policy.KeepVersions
+		}
+	}
+	if charts[image.Digest] {
+		return "keepLastCharts=" + strconv.Itoa(policy.KeepLastCharts)
+	}
+	for _, ref := range protected {
+		if !sameRegistry(ref.Registry, s.Registry) {
+			continue
+		}
+		for _, t := range v {
+			if ref.Repository != s.repoName(t.Repository) {
+				continue
+			}
+			if ref.Digest == image.Digest || (ref.Digest == "" && ref.Tag == t.Tag) {
+				return "inUse=" + ref.String()
+			}
+		}
+	}
+	if policy.OlderThan > 0 && s.Time.Sub(image.Created) < policy.OlderThan {
+		return "olderThan=" + policy.OlderThan.String()
+	}
+	if len(policy.Kinds) > 0 && !hasKind(policy.Kinds, image.Kind) {
+		return "kinds"
+	}
+	if policy.OnlyUnsigned {
+		sig := strings.Replace(image.Digest, ":", "-", 1) + ".sig"
+		if tags[TagRef{v[0].Repository, sig}] {
+			return "onlyUnsigned"
+		}
+	}
+	return ""
+}
+
+// snapshotCharts is latestCharts for the images of a snapshot.
+func snapshotCharts(m map[string][]TagRef, images map[repoBlob]*SnapshotImage, n int) map[string]bool {
+	keep := make(map[string]bool)
+	charts := make(map[string][]chartVersion)
+	for digest, tags := range m {
+		repo := tags[0].Repository
+		image := images[repoBlob{repo, digest}]
+		if image.Chart == nil {
+			continue
+		}
+		v, ok := parseVersion(image.Chart.Version)
+		if !ok {
+			keep[digest] = true
+			continue
+		}
+		charts[repo] = append(charts[repo], chartVersion{digest, v})
+	}
+	highestCharts(charts, n, keep)
+	return keep
+}
+
+// estimateReclaimable sets the bytes the deleted images leave unreferenced
+// in their repositories, given all images of the snapshot.
+func (sim *PolicySimulation) estimateReclaimable(images map[repoBlob]*SnapshotImage) {
+	deleted := make(map[repoBlob]bool)
+	kept := make(map[string]map[string]bool)
+	for _, d := range sim.Decisions {
+		if d.Delete {
+			deleted[repoBlob{d.Repository, d.Digest}] = true
+			kept[d.Repository] = make(map[string]bool)
+		}
+	}
+	for key, image := range images {
+		blobs, ok := kept[key.repo]
+		if !ok || deleted[key] {
+			continue
+		}
+		for blob := range image.Blobs {
+			blobs[blob] = true
+		}
+	}
+
+	counted := make(map[repoBlob]bool)
+	for i := range sim.Decisions {
+		d := &sim.Decisions[i]
+		if !d.Delete {
+			continue
+		}
+		for blob, size := range images[repoBlob{d.Repository, d.Digest}].Blobs {
+			if kept[d.Repository][blob] {
+				continue
+			}
+			d.Reclaimable += size
+			if key := (repoBlob{d.Repository, blob}); !counted[key] {
+				counted[key] = true
+				sim.Reclaimable += size
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package registry
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshots are kept in backup stores below this prefix, named by the time
+// they were taken so their keys sort chronologically.
+const (
+	snapshotPrefix     = "snapshots/"
+	snapshotTimeFormat = "20060102T150405Z"
+)
+
+// SnapshotImage is an image of a repository at the time of a snapshot.
+type SnapshotImage struct {
+	Digest    string       `json:"digest"`
+	MediaType string       `json:"mediaType"`
+	Kind      ArtifactKind `json:"kind"`
+	Tags      []string     `json:"tags"`
+	Created   time.Time    `json:"created"`
+	Size      int64        `json:"size"`
+	// Blobs are the sizes of the blobs of the image, by digest.
+	Blobs map[string]int64 `json:"blobs,omitempty"`
+	// Chart is set for Helm charts.
+	Chart *ChartInfo `json:"chart,omitempty"`
+}
+
+// RepositorySnapshot is the content of a repository at the time of a
+// snapshot.
+type RepositorySnapshot struct {
+	Repository string          `json:"repository"`
+	Tags       int             `json:"tags"`
+	Images     []SnapshotImage `json:"images"`
+	// Size is the total size of the blobs of the images, counting blobs
+	// shared by several images once.
+	Size int64 `json:"size"`
+}
+
+// Snapshot records the tags, images and storage used by the repositories of
+// a registry at some point in time. Snapshots taken over time are compared
+// with CompareSnapshots to follow the growth of the registry.
+type Snapshot struct {
+	Registry string `json:"registry"`
+	// Prefix is the path prefix of the repositories, as set with
+	// WithPathPrefix.
+	Prefix       string               `json:"prefix,omitempty"`
+	Time         time.Time            `json:"time"`
+	Repositories []RepositorySnapshot `json:"repositories"`
+	// Size is the total size of the blobs of the registry, counting blobs
+	// shared by several repositories once.
+	Size int64 `json:"size"`
+}
+
+// Snapshot records the content of repos, or of all repositories if none are
+// given. Images that cannot be inspected are left out.
+func (c *Client) Snapshot(ctx context.Context, repos ...string) (*Snapshot, error) {
+	inv, err := c.Inventory(ctx, InventoryOptions{Repositories: repos, Blobs: true, Cached: true})
+	if err != nil {
+		return nil, err
+	}
+	return inv.Snapshot(), nil
+}
+
+// Snapshot returns the snapshot of the inventory, which must have been
+// walked with InventoryOptions.Blobs.
+func (inv *Inventory) Snapshot() *Snapshot {
+	s := &Snapshot{Registry: inv.Registry, Prefix: inv.Prefix, Time: inv.Time}
+	blobs := make(map[string]int64)
+	for _, ri := range inv.Repositories {
+		r := RepositorySnapshot{Repository: ri.Repository}
+		images := make(map[string]*SnapshotImage)
+		repoBlobs := make(map[string]int64)
+		for _, t := range ri.Tags {
+			if t.Image == nil {
+				continue
+			}
+			image, ok := images[t.Digest]
+			if !ok {
+				image = &SnapshotImage{
+					Digest:    t.Digest,
+					MediaType: t.Image.MediaType,
+					Kind:      t.Image.Kind,
+					Created:   t.Image.Created,
+					Size:      t.Image.Size,
+					Blobs:     t.Image.Blobs,
+					Chart:     t.Image.Chart,
+				}
+				images[t.Digest] = image
+				for blob, size := range image.Blobs {
+					repoBlobs[blob] = size
+					blobs[blob] = size
+				}
+			}
+			image.Tags = append(image.Tags, t.Tag)
+			r.Tags++
+		}
+		for _, image := range images {
+			sort.Strings(image.Tags)
+			r.Images = append(r.Images, *image)
+		}
+		sort.Slice(r.Images, func(i, j int) bool {
+			return r.Images[i].Digest < r.Images[j].Digest
+		})
+		for _, size := range repoBlobs {
+			r.Size += size
+		}
+		s.Repositories = append(s.Repositories, r)
+	}
+	for _, size := range blobs {
+		s.Size += size
+	}
+	return s
+}
+
+// Repository returns the snapshot of repo, or nil if it was not recorded.
+func (s *Snapshot) Repository(repo string) *RepositorySnapshot {
+	for i := range s.Repositories {
+		if s.Repositories[i].Repository == repo {
+			return &s.Repositories[i]
+		}
+	}
+	return nil
+}
+
+// repoName returns the path of repo in the registry.
+func (s *Snapshot) repoName(repo string) string {
+	if s.Prefix == "" {
+		return repo
+	}
+	return s.Prefix + "/" + repo
+}
+
+// SaveSnapshot stores s in store, next to the snapshots saved before.
+func SaveSnapshot(store BackupStore, s *Snapshot) error {
+	b, err := marshalJSON(s)
+	if err != nil {
+		return err
+	}
+	return store.Put(snapshotPrefix+s.Time.UTC().Format(snapshotTimeFormat)+".json", b)
+}
+
+// LoadSnapshots returns the snapshots of store taken since the given time,
+// oldest first.
+func LoadSnapshots(store BackupStore, since time.Time) ([]*Snapshot, error) {
+	keys, err := store.List(snapshotPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	var snapshots []*Snapshot
+	for _, key := range keys {
+		name := strings.TrimSuffix(strings.TrimPrefix(key, snapshotPrefix), ".json")
+		t, err := time.Parse(snapshotTimeFormat, name)
+		if err != nil || t.Before(since) {
+			continue
+		}
+		b, err := store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		var s Snapshot
+		if err := unmarshalJSON(b, &s); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, &s)
+	}
+	return snapshots, nil
+}
+
+// RepositoryDelta is the change of a repository between two snapshots.
+type RepositoryDelta struct {
+	Repository string `json:"repository"`
+	OldTags    int    `json:"oldTags"`
+	NewTags    int    `json:"newTags"`
+	OldSize    int64  `json:"oldSize"`
+	NewSize    int64  `json:"newSize"`
+	Delta      int64  `json:"delta"`
+	// PerDay is the average growth of the repository per day, in bytes.
+	PerDay int64 `json:"perDay"`
+	// Added and Removed count the images pushed and deleted in between.
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// SnapshotDelta is the change of a registry between two snapshots.
+type SnapshotDelta struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	OldSize int64     `json:"oldSize"`
+	NewSize int64     `json:"newSize"`
+	Delta   int64     `json:"delta"`
+	PerDay  int64     `json:"perDay"`
+	// Repositories lists the repositories of either snapshot, fastest
+	// growing first.
+	Repositories []RepositoryDelta `json:"repositories"`
+}
+
+// CompareSnapshots reports how the registry changed from the snapshot from
+// to the later snapshot to.
+func CompareSnapshots(from, to *Snapshot) *SnapshotDelta {
+	days := to.Time.Sub(from.Time).Hours() / 24
+	perDay := func(delta int64) int64 {
+		if days <= 0 {
+			return 0
+		}
+		return int64(float64(delta) / days)
+	}
+	d := &SnapshotDelta{
+		From:    from.Time,
+		To:      to.Time,
+		OldSize: from.Size,
+		NewSize: to.Size,
+		Delta:   to.Size - from.Size,
+	}
+	d.PerDay = perDay(d.Delta)
+
+	repos := make(map[string]bool)
+	for _, r := range from.Repositories {
+		repos[r.Repository] = true
+	}
+	for _, r := range to.Repositories {
+		repos[r.Repository] = true
+	}
+	for repo := range repos {
+		var before, after RepositorySnapshot
+		if r := from.Repository(repo); r != nil {
+			before = *r
+		}
+		if r := to.Repository(repo); r != nil {
+			after = *r
+		}
+		rd := RepositoryDelta{
+			Repository: repo,
+			OldTags:    before.Tags,
+			NewTags:    after.Tags,
+			OldSize:    before.Size,
+			NewSize:    after.Size,
+			Delta:      after.Size - before.Size,
+		}
+		rd.PerDay = perDay(rd.Delta)
+		rd.Added, rd.Removed = imageChanges(before.Images, after.Images)
+		d.Repositories = append(d.Repositories, rd)
+	}
+	sort.Slice(d.Repositories, func(i, j int) bool {
+		if d.Repositories[i].Delta != d.Repositories[j].Delta {
+			return d.Repositories[i].Delta > d.Repositories[j].Delta
+		}
+		return d.Repositories[i].Repository < d.Repositories[j].Repository
+	})
+	return d
+}
+
+// imageChanges counts the images of after missing from before, and those of
+// before missing from after.
+func imageChanges(before, after []SnapshotImage) (added, removed int) {
+	digests := make(map[string]int)
+	for _, image := range before {
+		digests[image.Digest]--
+	}
+	for _, image := range after {
+		digests[image.Digest]++
+	}
+	for _, n := range digests {
+		switch {
+		case n > 0:
+			added++
+		case n < 0:
+			removed++
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// buildNumber matches the trailing build number of tags like "build-123".
+var buildNumber = regexp.MustCompile(`(\d+)$`)
+
+// SortTagsBySemver sorts tags by semantic version, highest first. Tags that
+// are not versions come last, in lexical order.
+func SortTagsBySemver(tags []string) {
+	sort.SliceStable(tags, func(i, j int) bool {
+		v, vok := parseVersion(tags[i])
+		w, wok := parseVersion(tags[j])
+		switch {
+		case vok && wok:
+			return v.compare(w) > 0
+		case vok != wok:
+			return vok
+		}
+		return tags[i] < tags[j]
+	})
+}
+
+// SortTagsByBuildNumber sorts tags by their trailing number, highest first.
+// Tags without one come last, in lexical order.
+func SortTagsByBuildNumber(tags []string) {
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, aok := tagNumber(tags[i])
+		b, bok := tagNumber(tags[j])
+		switch {
+		case aok && bok:
+			return a > b
+		case aok != bok:
+			return aok
+		}
+		return tags[i] < tags[j]
+	})
+}
+
+func tagNumber(tag string) (uint64, bool) {
+	m := buildNumber.FindString(tag)
+	if m == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(m, 10, 64)
+	return n, err == nil
+}
+
+// SortTagsByCreated sorts the tags of repo by the creation time of their
+// images, newest first. Tags whose images have no creation time come last.
+func (c *Client) SortTagsByCreated(ctx context.Context, repo string, tags []string) error {
+	created := make(map[string]time.Time)
+	for _, tag := range tags {
+		info, err := c.inspect(ctx, c.repoName(repo), tag)
+		if err != nil {
+			return err
+		}
+		created[tag] = info.Created
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, b := created[tags[i]], created[tags[j]]
+		if a.IsZero() != b.IsZero() {
+			return b.IsZero()
+		}
+		return a.After(b)
+	})
+	return nil
+}
@@ -0,0 +1,277 @@
+package registry
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SquashOptions controls Squash.
+type SquashOptions struct {
+	// Platform selects the manifest of multi-platform images, linux/amd64
+	// when empty.
+	Platform Platform
+	// Layers is the number of top layers merged, all of them when zero.
+	Layers int
+	// Comment is recorded in the history entry of the merged layer.
+	Comment string
+}
+
+// squashEntry is a file of the merged layer, identified by the layer it
+// comes from and its position there.
+type squashEntry struct {
+	hdr   *tar.Header
+	layer int
+	seq   int
+}
+
+// squash merges a range of layers, keeping the whiteouts needed to hide the
+// content of the layers below the range.
+type squash struct {
+	entries   map[string]squashEntry
+	whiteouts map[string]bool
+	opaque    map[string]bool
+	// paths indexes the entries, and markers the whiteouts and opaque
+	// directories.
+	paths   pathTree
+	markers pathTree
+	// base is set when there are layers below the range.
+	base bool
+}
+
+// Squash merges the top layers of the image repo:ref into a single layer and
+// pushes the resulting image under tag, updating the config layers and
+// history. The merged layers are downloaded twice, once to find the files
+// that survive and once to copy them. For multi-platform images only the
+// selected manifest is squashed and tag then points at a single-platform
+// image.
+func (c *Client) Squash(ctx context.Context, repo, ref, tag string, opts SquashOptions) (Descriptor, error) {
+	img, err := c.loadImage(ctx, repo, ref, opts.Platform)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	n := opts.Layers
+	if n <= 0 || n > len(img.manifest.Layers) {
+		n = len(img.manifest.Layers)
+	}
+	if n < 2 {
+		return Descriptor{}, fmt.Errorf("nothing to squash in %d layers", n)
+	}
+	start := len(img.manifest.Layers) - n
+	layers := img.manifest.Layers[start:]
+	for _, layer := range layers {
+		if len(layer.URLs) > 0 {
+			return Descriptor{}, fmt.Errorf("layer %s is non-distributable", layer.Digest)
+		}
+	}
+	c.Info("squash image.", "repo", img.name, "ref", ref, "layers", n)
+
+	s := &squash{
+		entries:   make(map[string]squashEntry),
+		whiteouts: make(map[string]bool),
+		opaque:    make(map[string]bool),
+		paths:     make(pathTree),
+		markers:   make(pathTree),
+		base:      start > 0,
+	}
+	for i, layer := range layers {
+		if err := c.walkLayer(ctx, repo, layer, func(seq int, hdr *tar.Header, r io.Reader) error {
+			s.add(i, seq, hdr)
+			return nil
+		}); err != nil {
+			return Descriptor{}, fmt.Errorf("layer %s: %v", layer.Digest, err)
+		}
+	}
+	l, err := newLayerFile(img.layerMediaType(), func(tw *tar.Writer) error {
+		return c.writeSquash(ctx, repo, layers, s, tw)
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer l.remove()
+	if err := c.pushLayer(ctx, img.name, l); err != nil {
+		return Descriptor{}, err
+	}
+
+	comment := opts.Comment
+	if comment == "" {
+		comment = fmt.Sprintf("squashed %d layers", n)
+	}
+	if len(img.history) > 0 {
+		now := time.Now().UTC()
+		img.history = append(img.history[:img.historyIndex(start)], history{Created: &now, CreatedBy: "registry squash", Comment: comment})
+	}
+	img.manifest.Layers = append(img.manifest.Layers[:start:start], l.desc)
+	img.diffIDs = append(img.diffIDs[:start:start], l.diffID)
+	return c.pushImage(ctx, img, tag)
+}
+
+// walkLayer calls fn with the entries of layer, numbered in order.
+func (c *Client) walkLayer(ctx context.Context, repo string, layer Descriptor, fn func(seq int, hdr *tar.Header, r io.Reader) error) error {
+	r, err := c.OpenLayer(ctx, repo, layer)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	for seq := 0; ; seq++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(seq, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// add applies the entry seq of layer i to the merged layer.
+func (s *squash) add(i, seq int, hdr *tar.Header) {
+	name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+	if name == "" {
+		return
+	}
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	switch {
+	case base == whiteoutOpaque:
+		s.paths.walk(dir, func(p string) {
+			if e, ok := s.entries[p]; ok && e.layer < i {
+				delete(s.entries, p)
+			}
+		})
+		if s.base {
+			s.removeMarkers(dir)
+			s.opaque[dir] = true
+			s.markers.add(dir)
+		}
+		return
+	case strings.HasPrefix(base, whiteoutPrefix):
+		target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+		s.remove(target)
+		if s.base {
+			s.removeMarkers(target)
+			s.whiteouts[target] = true
+			s.markers.add(target)
+		}
+		return
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		s.remove(name)
+		// The file hides what is below name in the layers below by itself.
+		s.removeMarkers(name)
+	} else if e, ok := s.entries[name]; ok && e.hdr.Typeflag != tar.TypeDir {
+		delete(s.entries, name)
+	}
+	s.entries[name] = squashEntry{hdr: hdr, layer: i, seq: seq}
+	s.paths.add(name)
+	// A whited out path created again must still hide the content of the
+	// layers below, as a directory when something is created inside it.
+	for p := name; p != "."; p = path.Dir(p) {
+		if !s.whiteouts[p] {
+			continue
+		}
+		delete(s.whiteouts, p)
+		if p != name || hdr.Typeflag == tar.TypeDir {
+			s.opaque[p] = true
+		}
+	}
+}
+
+// remove drops name and what is below it from the merged layer.
+func (s *squash) remove(name string) {
+	delete(s.entries, name)
+	s.paths.walk(name, func(p string) {
+		delete(s.entries, p)
+	})
+	s.paths.remove(name)
+}
+
+// removeMarkers drops the whiteouts of dir and below it, superseded by a
+// new whiteout of dir.
+func (s *squash) removeMarkers(dir string) {
+	delete(s.whiteouts, dir)
+	delete(s.opaque, dir)
+	s.markers.walk(dir, func(p string) {
+		delete(s.whiteouts, p)
+		delete(s.opaque, p)
+	})
+	s.markers.remove(dir)
+}
+
+// writeSquash writes the merged layer: directories first, so opaque
+// directories replacing files of the layers below exist before their
+// markers, then whiteouts, then the other files in layer order.
+func (c *Client) writeSquash(ctx context.Context, repo string, layers []Descriptor, s *squash, tw *tar.Writer) error {
+	headers := make(map[string]*tar.Header)
+	var dirs []string
+	for p, e := range s.entries {
+		if e.hdr.Typeflag == tar.TypeDir {
+			headers[p] = e.hdr
+			dirs = append(dirs, p)
+		}
+	}
+	// Opaque directories only implied by the files created in them.
+	for p := range s.opaque {
+		if _, ok := s.entries[p]; !ok && p != "" {
+			headers[p] = &tar.Header{Name: p + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Now()}
+			dirs = append(dirs, p)
+		}
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if err := tw.WriteHeader(headers[dir]); err != nil {
+			return err
+		}
+	}
+
+	var markers []string
+	for p := range s.opaque {
+		markers = append(markers, path.Join(p, whiteoutOpaque))
+	}
+	for p := range s.whiteouts {
+		markers = append(markers, path.Join(path.Dir(p), whiteoutPrefix+path.Base(p)))
+	}
+	sort.Strings(markers)
+	for _, marker := range markers {
+		if err := tw.WriteHeader(&tar.Header{Name: marker, Typeflag: tar.TypeReg, Mode: 0644}); err != nil {
+			return err
+		}
+	}
+
+	for i, layer := range layers {
+		err := c.walkLayer(ctx, repo, layer, func(seq int, hdr *tar.Header, r io.Reader) error {
+			p := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+			e, ok := s.entries[p]
+			if !ok || e.layer != i || e.seq != seq || hdr.Typeflag == tar.TypeDir {
+				return nil
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				// Links to files outside the merged entries point into the
+				// layers below.
+				target, ok := s.entries[strings.TrimPrefix(path.Clean("/"+hdr.Linkname), "/")]
+				if ok && target.hdr.Typeflag != tar.TypeReg && target.hdr.Typeflag != tar.TypeRegA || !ok && !s.base {
+					c.Warn("drop hard link to removed file.", "name", hdr.Name, "link", hdr.Linkname)
+					return nil
+				}
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err := io.Copy(tw, r)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("layer %s: %v", layer.Digest, err)
+		}
+	}
+	return nil
+}
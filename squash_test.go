@@ -0,0 +1,146 @@
+package registry
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caeret/registry/registrytest"
+)
+
+// treeOf returns the files below dir and their contents, directories with
+// a trailing slash.
+func treeOf(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	tree := make(map[string]string)
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		name := filepath.ToSlash(strings.TrimPrefix(p, dir+string(filepath.Separator)))
+		if fi.IsDir() {
+			tree[name+"/"] = ""
+			return nil
+		}
+		b, err := ioutil.ReadFile(p)
+		tree[name] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// layerNames returns the entry names of the top layer of repo:ref.
+func layerNames(t *testing.T, c *Client, repo, ref string) []string {
+	t.Helper()
+	ctx := context.Background()
+	manifest, _, err := c.imageManifest(ctx, repo, ref, Platform{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.OpenLayer(ctx, repo, manifest.Layers[len(manifest.Layers)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			sort.Strings(names)
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func TestSquashWhiteouts(t *testing.T) {
+	s := registrytest.NewServer()
+	defer s.Close()
+	s.Image("app", "v1", time.Now(),
+		tarLayer(t, tarEntry{name: "a/1", body: "1"}, tarEntry{name: "a/2", body: "2"}, tarEntry{name: "b", body: "b"}, tarEntry{name: "c/d", body: "d"}),
+		tarLayer(t, tarEntry{name: ".wh.b"}, tarEntry{name: "a/.wh..wh..opq"}, tarEntry{name: "a/3", body: "3"}, tarEntry{name: "c/.wh.d"}),
+		// b comes back as a directory, c/d as a file, and the opaque
+		// directory a is replaced by a file.
+		tarLayer(t, tarEntry{name: "b/e", body: "e"}, tarEntry{name: "c/d", body: "d2"}, tarEntry{name: "a", body: "a"}),
+	)
+	c := newTestClient(t, s.URL)
+	ctx := context.Background()
+	for _, layers := range []int{2, 3} {
+		if _, err := c.Squash(ctx, "app", "v1", "squashed", SquashOptions{Layers: layers}); err != nil {
+			t.Fatal(err)
+		}
+		want, got := t.TempDir(), t.TempDir()
+		if err := c.ExtractImage(ctx, "app", "v1", want, Platform{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.ExtractImage(ctx, "app", "squashed", got, Platform{}); err != nil {
+			t.Fatal(err)
+		}
+		if w, g := treeOf(t, want), treeOf(t, got); !reflect.DeepEqual(w, g) {
+			t.Errorf("squashing %d layers: files %v, want %v", layers, g, w)
+		}
+		names := layerNames(t, c, "app", "squashed")
+		markers := 0
+		for _, name := range names {
+			if !strings.HasPrefix(path.Base(name), whiteoutPrefix) {
+				continue
+			}
+			markers++
+			if path.Dir(name) == "a" {
+				t.Errorf("squashing %d layers kept the marker %s below the file a", layers, name)
+			}
+		}
+		if layers == 3 && markers > 0 {
+			t.Errorf("squashing all layers kept whiteouts: %v", names)
+		}
+		if layers == 2 && markers == 0 {
+			t.Errorf("squashing 2 layers dropped the whiteouts hiding the base layer: %v", names)
+		}
+	}
+}
+
+func TestFindFilesHidden(t *testing.T) {
+	s := registrytest.NewServer()
+	defer s.Close()
+	s.Image("app", "v1", time.Now(),
+		tarLayer(t, tarEntry{name: "a/1", body: "1"}, tarEntry{name: "a/2", body: "2"}, tarEntry{name: "b", body: "b"}, tarEntry{name: "c", body: "c"}),
+		// The matches of d grow the slice of matches before any is hidden.
+		tarLayer(t, tarEntry{name: "d", body: "d"}, tarEntry{name: "a/.wh..wh..opq"}, tarEntry{name: ".wh.b"}, tarEntry{name: "c", body: "c2"}, tarEntry{name: "a/3", body: "3"}),
+	)
+	c := newTestClient(t, s.URL)
+	matches, err := c.FindFiles(context.Background(), "app", "v1", FindOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string][]bool)
+	for _, m := range matches {
+		got[m.Path] = append(got[m.Path], m.Hidden)
+	}
+	want := map[string][]bool{
+		"/a/1": {true},
+		"/a/2": {true},
+		"/a/3": {false},
+		"/b":   {true},
+		"/c":   {true, false},
+		"/d":   {false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindFiles: %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Fetcher reads content identified by its descriptor.
+type Fetcher interface {
+	Fetch(ctx context.Context, desc Descriptor) (io.ReadCloser, error)
+}
+
+// Pusher writes content identified by its descriptor.
+type Pusher interface {
+	Push(ctx context.Context, desc Descriptor, r io.Reader) error
+	Exists(ctx context.Context, desc Descriptor) (bool, error)
+}
+
+// Resolver looks up the descriptor of a tagged or digest reference.
+type Resolver interface {
+	Resolve(ctx context.Context, ref string) (Descriptor, error)
+}
+
+// Tagger points a tag at a manifest already pushed.
+type Tagger interface {
+	Tag(ctx context.Context, desc Descriptor, ref string) error
+}
+
+// Target is a content store images can be copied from and to, such as a
+// registry Repository or an OCILayout.
+type Target interface {
+	Fetcher
+	Pusher
+	Resolver
+	Tagger
+}
+
+// isManifest reports whether content of mediaType is a manifest rather than
+// a blob.
+func isManifest(mediaType string) bool {
+	switch mediaType {
+	case MediaTypeOCIManifest, MediaTypeOCIIndex, MediaTypeDockerManifest, MediaTypeDockerManifestList:
+		return true
+	}
+	return false
+}
+
+// fetchAll reads the whole content of desc, checking its size.
+func fetchAll(ctx context.Context, f Fetcher, desc Descriptor) ([]byte, error) {
+	r, err := f.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if desc.Size > 0 && int64(len(b)) != desc.Size {
+		return nil, fmt.Errorf("%s: size %d, expected %d", desc.Digest, len(b), desc.Size)
+	}
+	return b, nil
+}
+
+// children returns the descriptors a manifest or index references.
+func children(desc Descriptor, body []byte) ([]Descriptor, error) {
+	if isIndex(desc.MediaType) {
+		var index Index
+		if err := unmarshalJSON(body, &index); err != nil {
+			return nil, err
+		}
+		return index.Manifests, nil
+	}
+	var manifest Manifest
+	if err := unmarshalJSON(body, &manifest); err != nil {
+		return nil, err
+	}
+	var descs []Descriptor
+	if manifest.Config.Digest != "" {
+		descs = append(descs, manifest.Config)
+	}
+	return append(descs, manifest.Layers...), nil
+}
+
+// CopyGraph copies the content root references from src to dst, children
+// first, skipping content dst already has and non-distributable layers.
+func CopyGraph(ctx context.Context, src Fetcher, dst Pusher, root Descriptor) error {
+	exists, err := dst.Exists(ctx, root)
+	if err != nil || exists {
+		return err
+	}
+	if !isManifest(root.MediaType) {
+		if len(root.URLs) > 0 {
+			return nil
+		}
+		r, err := src.Fetch(ctx, root)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return dst.Push(ctx, root, r)
+	}
+	body, err := fetchAll(ctx, src, root)
+	if err != nil {
+		return err
+	}
+	descs, err := children(root, body)
+	if err != nil {
+		return err
+	}
+	for _, desc := range descs {
+		if err := CopyGraph(ctx, src, dst, desc); err != nil {
+			return err
+		}
+	}
+	return dst.Push(ctx, root, bytes.NewReader(body))
+}
+
+// CopyRef copies the image srcRef of src to dst, tagging it dstRef unless
+// it is empty, and returns its descriptor.
+func CopyRef(ctx context.Context, src interface {
+	Fetcher
+	Resolver
+}, srcRef string, dst interface {
+	Pusher
+	Tagger
+}, dstRef string) (Descriptor, error) {
+	desc, err := src.Resolve(ctx, srcRef)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := CopyGraph(ctx, src, dst, desc); err != nil {
+		return Descriptor{}, err
+	}
+	if dstRef == "" {
+		return desc, nil
+	}
+	return desc, dst.Tag(ctx, desc, dstRef)
+}
+
+// Fetch downloads the manifest or blob desc.
+func (r *Repository) Fetch(ctx context.Context, desc Descriptor) (io.ReadCloser, error) {
+	if isManifest(desc.MediaType) {
+		body, _, err := r.client.getManifest(ctx, r.name, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	rc, _, err := r.client.openBlob(ctx, r.name, desc.Digest)
+	return rc, err
+}
+
+// Push uploads the manifest or blob desc, pushing manifests by digest and
+// skipping blobs the repository already has.
+func (r *Repository) Push(ctx context.Context, desc Descriptor, content io.Reader) error {
+	if isManifest(desc.MediaType) {
+		body, err := ioutil.ReadAll(content)
+		if err != nil {
+			return err
+		}
+		_, err = r.client.putManifest(ctx, r.name, desc.Digest, desc.MediaType, body)
+		return err
+	}
+	return r.client.pushBlob(ctx, r.name, desc, func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(content), nil
+	}, "")
+}
+
+// Exists reports whether the manifest or blob desc exists.
+func (r *Repository) Exists(ctx context.Context, desc Descriptor) (bool, error) {
+	if isManifest(desc.MediaType) {
+		_, err := r.client.resolve(ctx, r.name, desc.Digest)
+		if err == ErrNotFound {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	return r.client.blobExists(ctx, r.name, desc.Digest)
+}
+
+// Tag points ref at the manifest desc.
+func (r *Repository) Tag(ctx context.Context, desc Descriptor, ref string) error {
+	body, _, err := r.client.getManifest(ctx, r.name, desc.Digest)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.putManifest(ctx, r.name, ref, desc.MediaType, body)
+	return err
+}
@@ -0,0 +1,56 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Transport returns an http.RoundTripper authorizing requests to the
+// registry the way the client does, including its headers, circuit breakers
+// and response hooks. Requests to other hosts, such as blob storage the
+// registry redirects to, are sent unchanged.
+//
+// It lets other libraries reuse the client's authentication, for instance
+// go-containerregistry:
+//
+//	remote.Image(ref, remote.WithTransport(c.Transport()))
+//
+// or the containerd resolver:
+//
+//	docker.NewResolver(docker.ResolverOptions{
+//		Hosts: docker.ConfigureDefaultRegistries(docker.WithClient(&http.Client{Transport: c.Transport()})),
+//	})
+func (c *Client) Transport() http.RoundTripper {
+	return &clientTransport{c}
+}
+
+type clientTransport struct {
+	c *Client
+}
+
+func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.c.client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.URL.Host != t.c.host() {
+		return base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for k, v := range t.c.header {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = v
+		}
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.c.userAgent)
+	}
+	if req.Header.Get("Authorization") == "" {
+		scope := pathScope(req.URL.Path)
+		if from := req.URL.Query().Get("from"); from != "" && scope != "" {
+			scope += fmt.Sprintf(" repository:%s:pull", from)
+		}
+		t.c.authorize(req, scope)
+	}
+	return t.c.guard(req, "registry", base.RoundTrip)
+}
@@ -0,0 +1,147 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ManifestLister lists every manifest of a repository, tagged or not, which
+// the distribution API has no call for. Repositories are named as the
+// registry knows them, including the path prefix of the client.
+type ManifestLister interface {
+	ListManifests(ctx context.Context, repo string) ([]ListedManifest, error)
+}
+
+// ListedManifest is a manifest of a repository and the tags pointing at it.
+type ListedManifest struct {
+	Digest string   `json:"digest"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+const harborPageSize = 100
+
+// HarborArtifacts lists manifests with the artifact API of Harbor, where the
+// first path element of repositories is the project.
+type HarborArtifacts struct {
+	// URL of the Harbor instance, such as https://harbor.example.com.
+	URL      string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+func (h *HarborArtifacts) ListManifests(ctx context.Context, repo string) ([]ListedManifest, error) {
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("no harbor project in %s", repo)
+	}
+	base := fmt.Sprintf("%s/api/v2.0/projects/%s/repositories/%s/artifacts",
+		strings.TrimSuffix(h.URL, "/"), parts[0], url.PathEscape(url.PathEscape(parts[1])))
+	var manifests []ListedManifest
+	for page := 1; ; page++ {
+		target := fmt.Sprintf("%s?with_tag=true&page=%d&page_size=%d", base, page, harborPageSize)
+		resp, body, err := harborRequest(ctx, h.Client, h.Username, h.Password, http.MethodGet, target)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("invalid response %d:%s", resp.StatusCode, body)
+		}
+		var artifacts []struct {
+			Digest string `json:"digest"`
+			Tags   []struct {
+				Name string `json:"name"`
+			} `json:"tags"`
+		}
+		if err := decodeResponse(resp, body, &artifacts); err != nil {
+			return nil, err
+		}
+		for _, a := range artifacts {
+			m := ListedManifest{Digest: a.Digest}
+			for _, t := range a.Tags {
+				m.Tags = append(m.Tags, t.Name)
+			}
+			manifests = append(manifests, m)
+		}
+		if len(artifacts) < harborPageSize {
+			return manifests, nil
+		}
+	}
+}
+
+// manifestLister returns the lister of the untagged runs of policy, the
+// Harbor artifact API of the registry unless the policy names another.
+func (c *Client) manifestLister(policy Policy) ManifestLister {
+	if policy.Manifests != nil {
+		return policy.Manifests
+	}
+	return &HarborArtifacts{URL: c.url, Username: c.username, Password: c.password, Client: c.client}
+}
+
+// untagged returns the manifests of repo no tag references, directly, as a
+// manifest of a tagged index or as a referrer of a referenced manifest.
+// Manifests are listed with lister and fetched to follow their index
+// entries and subjects; any failure fails the whole repository, so nothing
+// referenced is mistaken for dangling.
+func (c *Client) untagged(ctx context.Context, lister ManifestLister, repo string) ([]string, error) {
+	name := c.repoName(repo)
+	listed, err := lister.ListManifests(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	// refs are the manifests each manifest keeps referenced.
+	var queue []string
+	refs := make(map[string][]string)
+	gone := make(map[string]bool)
+	for _, m := range listed {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if len(m.Tags) > 0 {
+			queue = append(queue, m.Digest)
+		}
+		body, _, err := c.getManifest(ctx, name, m.Digest)
+		if err == ErrNotFound {
+			// Deleted since listed.
+			gone[m.Digest] = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var manifest struct {
+			Manifests []Descriptor `json:"manifests"`
+			Subject   *Descriptor  `json:"subject"`
+		}
+		if err := unmarshalJSON(body, &manifest); err != nil {
+			return nil, fmt.Errorf("%s: %v", m.Digest, err)
+		}
+		for _, d := range manifest.Manifests {
+			refs[m.Digest] = append(refs[m.Digest], d.Digest)
+		}
+		if manifest.Subject != nil {
+			refs[manifest.Subject.Digest] = append(refs[manifest.Subject.Digest], m.Digest)
+		}
+	}
+	reachable := make(map[string]bool)
+	for len(queue) > 0 {
+		digest := queue[0]
+		queue = queue[1:]
+		if reachable[digest] {
+			continue
+		}
+		reachable[digest] = true
+		queue = append(queue, refs[digest]...)
+	}
+	var digests []string
+	for _, m := range listed {
+		if !reachable[m.Digest] && !gone[m.Digest] {
+			digests = append(digests, m.Digest)
+		}
+	}
+	c.Debug("find untagged manifests.", "repo", repo, "manifests", len(listed), "untagged", len(digests))
+	return digests, nil
+}
@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"context"
+	"time"
+)
+
+// EventType is the kind of change a Watcher observed.
+type EventType string
+
+const (
+	EventAdded   EventType = "added"
+	EventUpdated EventType = "updated"
+	EventRemoved EventType = "removed"
+)
+
+// Event reports a tag that was added, moved to another digest or removed.
+type Event struct {
+	Type           EventType
+	Repository     string
+	Tag            string
+	Digest         string
+	PreviousDigest string
+	Time           time.Time
+}
+
+// Watcher polls repositories and emits an event for every tag change
+// between two polls. The first poll only records the initial state.
+type Watcher struct {
+	client   *Client
+	interval time.Duration
+	repos    []string
+	events   chan Event
+	snapshot map[string]map[string]string
+}
+
+// NewWatcher returns a watcher polling repos, or all repositories if none are
+// given, every interval.
+func NewWatcher(c *Client, interval time.Duration, repos ...string) *Watcher {
+	return &Watcher{
+		client:   c,
+		interval: interval,
+		repos:    repos,
+		events:   make(chan Event, 64),
+	}
+}
+
+// Events returns the channel events are delivered on. It is closed when Run
+// returns.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Run polls until ctx is done.
+func (w *Watcher) Run(ctx context.Context) error {
+	defer close(w.events)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if err := w.poll(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			w.client.Warn("fail to poll repositories.", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Watcher) poll(ctx context.Context) error {
+	repos := w.repos
+	if len(repos) == 0 {
+		var err error
+		repos, err = w.client.queryRepositories(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshot := make(map[string]map[string]string)
+	for _, repo := range repos {
+		tags, err := w.client.queryTags(ctx, repo)
+		if err != nil {
+			if prev, ok := w.snapshot[repo]; ok {
+				// Keep the previous state rather than reporting removals.
+				snapshot[repo] = prev
+			}
+			w.client.Warn("fail to query tags.", "repo", repo, "error", err)
+			continue
+		}
+		snapshot[repo] = make(map[string]string)
+		for _, tag := range tags {
+			desc, err := w.client.resolve(ctx, w.client.repoName(repo), tag)
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				if digest, ok := w.snapshot[repo][tag]; ok {
+					snapshot[repo][tag] = digest
+				}
+				w.client.Warn("fail to resolve tag.", "repo", repo, "tag", tag, "error", err)
+				continue
+			}
+			snapshot[repo][tag] = desc.Digest
+		}
+	}
+
+	if w.snapshot != nil {
+		now := time.Now()
+		for repo, tags := range snapshot {
+			for tag, digest := range tags {
+				prev, ok := w.snapshot[repo][tag]
+				switch {
+				case !ok:
+					if err := w.emit(ctx, Event{Type: EventAdded, Repository: repo, Tag: tag, Digest: digest, Time: now}); err != nil {
+						return err
+					}
+				case prev != digest:
+					if err := w.emit(ctx, Event{Type: EventUpdated, Repository: repo, Tag: tag, Digest: digest, PreviousDigest: prev, Time: now}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		for repo, tags := range w.snapshot {
+			for tag, digest := range tags {
+				if _, ok := snapshot[repo][tag]; !ok {
+					if err := w.emit(ctx, Event{Type: EventRemoved, Repository: repo, Tag: tag, PreviousDigest: digest, Time: now}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	w.snapshot = snapshot
+	return nil
+}
+
+func (w *Watcher) emit(ctx context.Context, event Event) error {
+	select {
+	case w.events <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}